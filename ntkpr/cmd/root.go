@@ -34,6 +34,9 @@ var rootCmd = &cobra.Command{
 		if err != nil {
 			log.Fatal("Failed to connect to database:", err)
 		}
+		if !globalDB.SearchEnabled() {
+			log.Printf("Warning: this binary was built without the sqlite_fts5 tag, search falls back to slower substring matching. See Local Build in the README.")
+		}
 	},
 	Run: func(cmd *cobra.Command, args []string) {
 		// Get state (can be nil if first run)
@@ -52,9 +55,17 @@ var rootCmd = &cobra.Command{
 
 		// Run Bubble Tea program
 		p := tea.NewProgram(model)
-		if _, err := p.Run(); err != nil {
+		final, err := p.Run()
+		if err != nil {
 			log.Fatal(err)
 		}
+
+		// Save contexts and cursors for the next run
+		if fm, ok := final.(ui.Model); ok {
+			if err := state.SaveState(globalCfg.StateFilePath, fm.CollectState()); err != nil {
+				log.Printf("Failed to save state: %v", err)
+			}
+		}
 	},
 }
 
@@ -75,4 +86,6 @@ func init() {
 	rootCmd.AddCommand(ExportNoteCmd)
 	rootCmd.AddCommand(LaunchGUICmd)
 	rootCmd.AddCommand(DataBackupCmd)
+	rootCmd.AddCommand(SearchCmd)
+	rootCmd.AddCommand(TrashCmd)
 }
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/haochend413/ntkpr/internal/app"
+	"github.com/haochend413/ntkpr/internal/db"
+	"github.com/haochend413/ntkpr/internal/vault"
+	"github.com/spf13/cobra"
+)
+
+var searchKind string
+var searchLimit int
+
+var SearchCmd = &cobra.Command{
+	Use:   "search QUERY...",
+	Short: "Search notes, branches and threads",
+	Long: `Search notes, branches and threads with the same query syntax as the TUI search bar.
+
+Free text is matched against the full-text index; "quoted text" is a phrase and word* a prefix.
+Filters:
+  is:highlight, is:private     flags, negate with -is:private
+  thread:NAME, branch:NAME     part of the thread / branch name, quote names with spaces
+  after:DATE, before:DATE      creation date, YYYY-MM-DD
+  edited:<7d, edited:>2w       time since the last edit (m, h, d, w)
+  freq:>5                      edit count (> >= < <= =)
+
+Example: ntkpr search 'is:highlight thread:"Work log" edited:<7d deploy'`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		switch searchKind {
+		case "", db.SearchKindNote, db.SearchKindBranch, db.SearchKindThread:
+		default:
+			fmt.Fprintf(os.Stderr, "Unknown kind %q, use note, branch or thread\n", searchKind)
+			return
+		}
+
+		a := app.NewApp(globalDB, nil)
+		hits, err := a.GlobalSearch(strings.Join(args, " "))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Bad query: %v\n", err)
+			return
+		}
+
+		plain := strings.NewReplacer(db.MatchStart, "", db.MatchEnd, "", "\n", " ")
+		count := 0
+		for _, hit := range hits {
+			if searchLimit > 0 && count >= searchLimit {
+				break
+			}
+			// the vault is not unlocked here, so private text is shown as vault.Placeholder
+			kind, id, text := db.SearchKindThread, hit.Thread.ID, vault.Show(hit.Thread.Name)
+			place := text
+			if hit.Branch != nil {
+				kind, id, text = db.SearchKindBranch, hit.Branch.ID, vault.Show(hit.Branch.Name)
+				place += " / " + text
+			}
+			if hit.Note != nil {
+				kind, id, text = db.SearchKindNote, hit.Note.ID, vault.Show(hit.Note.Content)
+			}
+			if searchKind != "" && kind != searchKind {
+				continue
+			}
+			if hit.Snippet != "" {
+				text = hit.Snippet
+			} else if r := []rune(text); len(r) > 80 {
+				text = string(r[:77]) + "..."
+			}
+			fmt.Printf("%-6s #%-5d %s: %s\n", kind, id, place, plain.Replace(text))
+			count++
+		}
+		if count == 0 {
+			fmt.Println("No matches.")
+		}
+	},
+}
+
+func init() {
+	SearchCmd.Flags().StringVarP(&searchKind, "kind", "k", "", "only list one kind: note, branch or thread")
+	SearchCmd.Flags().IntVarP(&searchLimit, "limit", "n", 50, "maximum number of results, 0 for no limit")
+}
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/haochend413/ntkpr/internal/query"
+	"github.com/haochend413/ntkpr/internal/vault"
+	"github.com/spf13/cobra"
+)
+
+var (
+	trashOlderThan string
+	trashAll       bool
+)
+
+var TrashCmd = &cobra.Command{
+	Use:   "trash",
+	Short: "List or purge deleted threads, branches and notes",
+	Long: `List the threads, branches and notes in the trash, or purge them for good.
+
+Deleted items stay in the trash until they are purged. In the TUI, T opens the trash,
+where items can be restored too.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		items, err := globalDB.LoadTrash()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading the trash: %v\n", err)
+			return
+		}
+		for _, item := range items {
+			// private titles stay sealed, the vault is not unlocked here
+			title := []rune(vault.Show(item.Title))
+			if len(title) > 60 {
+				title = append(title[:57], []rune("...")...)
+			}
+			fmt.Printf("%-6s #%-5d %s  %s\n", item.Kind, item.ID, item.DeletedAt.Format("2006-01-02 15:04"), string(title))
+		}
+		if len(items) == 0 {
+			fmt.Println("The trash is empty.")
+		}
+	},
+}
+
+var TrashPurgeCmd = &cobra.Command{
+	Use:   "purge",
+	Short: "Delete what is in the trash for good",
+	Long: `Delete the threads, branches and notes in the trash for good, with their revisions.
+Purging a thread purges its branches and notes. Purging a branch purges the trashed notes no other branch lists,
+and moves the live ones to the trash.
+
+Either --older-than or --all is required.
+
+Example: ntkpr trash purge --older-than 30d`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if trashOlderThan == "" && !trashAll {
+			fmt.Fprintln(os.Stderr, "Give --older-than, e.g. --older-than 30d, or --all to empty the whole trash.")
+			return
+		}
+		cutoff := time.Now()
+		if trashOlderThan != "" {
+			age, err := query.ParseAge(trashOlderThan)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Bad --older-than: %v\n", err)
+				return
+			}
+			cutoff = cutoff.Add(-age)
+		}
+		n, err := globalDB.PurgeTrash(cutoff)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error purging the trash: %v\n", err)
+			return
+		}
+		fmt.Printf("Purged %d items deleted before %s.\n", n, cutoff.Format("2006-01-02 15:04"))
+	},
+}
+
+func init() {
+	TrashPurgeCmd.Flags().StringVar(&trashOlderThan, "older-than", "", "only purge items deleted longer ago than this, e.g. 30d or 2w")
+	TrashPurgeCmd.Flags().BoolVar(&trashAll, "all", false, "purge everything in the trash")
+	TrashPurgeCmd.MarkFlagsMutuallyExclusive("older-than", "all")
+	TrashCmd.AddCommand(TrashPurgeCmd)
+}
@@ -12,6 +12,26 @@ type Config struct {
 	// program state storage
 	StateFilePath string
 	DataFilePath  string
+
+	// named queries shown as contexts next to Default and Search
+	SavedSearches []SavedSearch
+
+	// background syncs, on top of ctrl+q
+	AutoSync AutoSync
+}
+
+// AutoSync configures background syncs. A zero value turns each trigger off.
+type AutoSync struct {
+	Interval   int  // seconds between syncs
+	Idle       int  // seconds without a keypress before a sync
+	OnExitEdit bool // sync when leaving edit mode with changes
+}
+
+// SavedSearch is a query saved under a name. Table is "note" (default), "branch" or "thread".
+type SavedSearch struct {
+	Name  string
+	Query string
+	Table string
 }
 
 func generateDefault() Config {
@@ -21,6 +41,11 @@ func generateDefault() Config {
 	cfg := Config{
 		StateFilePath: stateFilePath,
 		DataFilePath:  dataFilePath,
+		SavedSearches: []SavedSearch{
+			{Name: "highlighted this week", Query: "is:highlight edited:<7d", Table: "note"},
+			{Name: "private journal", Query: "is:private", Table: "note"},
+		},
+		AutoSync: AutoSync{Interval: 300, Idle: 30, OnExitEdit: true},
 	}
 	return cfg
 }
@@ -57,7 +82,8 @@ func LoadOrCreateConfig() Config {
 		return generateDefault()
 	}
 
-	var cfg Config
+	// settings missing from the file, like ones added since it was written, keep their defaults
+	cfg := generateDefault()
 	if err := yaml.Unmarshal(data, &cfg); err != nil {
 		fmt.Fprintf(os.Stderr, "Error parsing config file: %v, using default\n", err)
 		return generateDefault()
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadOrCreateConfigDefaults(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		want func(*Config)
+	}{
+		{"empty file", "", func(*Config) {}},
+		{"older file", "statefilepath: /tmp/state.json\n", func(c *Config) {
+			c.StateFilePath = "/tmp/state.json"
+		}},
+		{"saved searches replace the defaults", "savedsearches:\n  - name: todo\n    query: is:highlight\n", func(c *Config) {
+			c.SavedSearches = []SavedSearch{{Name: "todo", Query: "is:highlight"}}
+		}},
+		{"auto sync fields are kept one by one", "autosync:\n  interval: 0\n", func(c *Config) {
+			c.AutoSync.Interval = 0
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("HOME", t.TempDir())
+			t.Setenv("APPDATA", t.TempDir())
+			path := ConfigPath()
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(tt.file), 0644); err != nil {
+				t.Fatal(err)
+			}
+			want := generateDefault()
+			tt.want(&want)
+			if got := LoadOrCreateConfig(); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+}
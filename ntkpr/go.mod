@@ -6,10 +6,12 @@ toolchain go1.24.4
 
 require (
 	charm.land/bubbletea/v2 v2.0.1
+	github.com/atotto/clipboard v0.1.4
 	github.com/haochend413/bubbles/v2 v2.102.0
 	github.com/haochend413/lipgloss/v2 v2.100.0
 	github.com/sergi/go-diff v1.4.0
 	github.com/spf13/cobra v1.10.2
+	golang.org/x/crypto v0.48.0
 	gopkg.in/yaml.v3 v3.0.1
 	gorm.io/driver/sqlite v1.6.0
 	gorm.io/gorm v1.31.1
@@ -18,7 +20,6 @@ require (
 // require github.com/charmbracelet/bubbletea v1.3.10
 
 require (
-	github.com/atotto/clipboard v0.1.4 // indirect
 	github.com/charmbracelet/colorprofile v0.4.2 // indirect
 	github.com/charmbracelet/ultraviolet v0.0.0-20260303162955-0b88c25f3fff // indirect
 	github.com/charmbracelet/x/ansi v0.11.6 // indirect
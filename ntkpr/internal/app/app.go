@@ -5,8 +5,10 @@ import (
 	"sync"
 	"time"
 
+	"github.com/haochend413/ntkpr/internal/app/context"
 	"github.com/haochend413/ntkpr/internal/app/data"
 	editstack "github.com/haochend413/ntkpr/internal/app/editStack"
+	"github.com/haochend413/ntkpr/internal/app/journal"
 	"github.com/haochend413/ntkpr/internal/db"
 	"github.com/haochend413/ntkpr/internal/models"
 	"github.com/haochend413/ntkpr/state"
@@ -16,30 +18,38 @@ import (
 // Inside app we deal with how our local data, stored in contexts, interact with database.
 // In my opinion, we can just re-write the whole thing.
 type App struct {
-	db                 *db.DB
-	dataMgr            *data.DataMgr
-	editMgr            *editstack.EditMgr
-	nextThreadCreateID uint
-	nextBranchCreateID uint
-	nextNoteCreateID   uint
-	Synced             bool
-	mutex              sync.Mutex
+	db            *db.DB
+	dataMgr       *data.DataMgr
+	contextMgr    *context.ContextMgr
+	editMgr       *editstack.EditMgr
+	savedSearches []savedSearch
+	state         *state.AppState // restored by SetSavedSearches
+	nextTempID    uint            // next temporary ID of a created entity, see models.TempIDStart
+	journal       *journal.Journal
+	leftover      []journal.Entry // unsynced edits of a previous session, see ReplayJournal
+	undoStack     []*change       // actions to undo, the last one on top, see undo.go
+	redoStack     []*change       // undone actions
+	replaying     bool
+	syncing       bool // a SyncJob is running, see sync.go
+	Synced        bool
+	mutex         sync.Mutex
 }
 
 // NewApp creates a new application instance and restore app states
 func NewApp(dbConn *db.DB, AppState *state.AppState) *App {
 
 	app := &App{
-		db:                 dbConn,
-		dataMgr:            &data.DataMgr{},
-		editMgr:            editstack.NewEditMgr(),
-		nextThreadCreateID: 1,
-		nextBranchCreateID: 1,
-		nextNoteCreateID:   1,
-		Synced:             true,
+		db:         dbConn,
+		dataMgr:    &data.DataMgr{},
+		editMgr:    editstack.NewEditMgr(),
+		state:      AppState,
+		nextTempID: models.TempIDStart,
+		Synced:     true,
 	}
 
 	app.loadData()
+	app.restoreOrders()
+	app.openJournal()
 	return app
 }
 
@@ -66,129 +76,140 @@ func (a *App) GetNoteEditStack() []*editstack.NoteEdit {
 
 // loadData loads threads from the database and initializes data manager
 func (a *App) loadData() {
-	// fetch from db
-	threads, err := a.db.SyncData(
-		[]*models.Thread{},
-		make(map[editstack.EditKey]*editstack.Edit),
-	)
+	// fetch thread headers from db, the DataMgr loads branches and notes when they become active
+	threads, err := a.db.LoadThreads()
 
 	if err != nil {
 		log.Panic(err)
 	}
 
-	a.dataMgr = data.NewDataMgr(threads)
+	a.dataMgr = data.NewDataMgr(threads, a.db)
+	a.dataMgr.SetPending(a.pending)
+	a.contextMgr = context.NewContextMgr(a.dataMgr)
+}
 
-	// Set the next IDs for creation
-	a.nextNoteCreateID = a.db.GetCreateNoteID()
-	a.nextBranchCreateID = a.db.GetCreateBranchID()
-	a.nextThreadCreateID = a.db.GetCreateThreadID()
+// pending reports whether an entity has edits to sync. While a sync runs, every entity does: the data manager keeps
+// all it has loaded, for the edits a failed sync puts back. The data manager calls it with or without the mutex.
+func (a *App) pending(entity string, id uint) bool {
+	if a.syncing {
+		return true
+	}
+	_, ok := a.editMgr.GetEdit(entity, id)
+	return ok
+}
+
+// newTempID returns a temporary ID for a created entity. The database assigns the real one on sync.
+// Temporary IDs are never reused, so they cannot collide with each other or with synced IDs.
+func (a *App) newTempID() uint {
+	id := a.nextTempID
+	a.nextTempID++
+	return id
 }
 
 /*
 APIs to call, connecting context and database.
 */
 
-func (a *App) CreateNewThread(link *models.Superlink) {
+// CreateNewThread creates a new pending thread and returns its ID, or 0 when it could not be created.
+func (a *App) CreateNewThread(link *models.Superlink) uint {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
 	thread := &models.Thread{Name: ""}
 	thread.CreatedAt = time.Now()
 	thread.UpdatedAt = time.Now()
-	thread.ID = a.nextThreadCreateID
-	a.nextThreadCreateID += 1
+	thread.ID = a.newTempID()
 	a.Synced = false
 	edit := &editstack.Edit{EditType: editstack.CreateThread, ID: thread.ID}
-	if err := a.editMgr.AddEdit(edit, link); err != nil {
+	if err := a.trackEdit(edit, link, thread); err != nil {
 		log.Printf("Error adding Create edit: %v", err)
-		return
+		return 0
 	}
 	a.dataMgr.AddThread(thread)
+	a.pushChange(&change{editType: editstack.CreateThread, threadID: thread.ID, link: link})
+	return thread.ID
 }
 
-func (a *App) CreateNewBranch(link *models.Superlink) {
+// CreateNewBranch creates a new pending branch in the active thread and returns its ID, or 0 when it could not be created.
+func (a *App) CreateNewBranch(link *models.Superlink) uint {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
 	thread := a.dataMgr.GetActiveThread()
 	if thread == nil {
 		log.Printf("Cannot create branch: no active thread")
-		return
+		return 0
 	}
 	branch := &models.Branch{Name: ""}
 	branch.CreatedAt = time.Now()
 	branch.UpdatedAt = time.Now()
-	branch.ID = a.nextBranchCreateID
+	branch.ID = a.newTempID()
 	branch.ThreadID = thread.ID
-	a.nextBranchCreateID += 1
 	a.Synced = false
 	edit := &editstack.Edit{EditType: editstack.CreateBranch, ID: branch.ID}
-	if err := a.editMgr.AddEdit(edit, link); err != nil {
+	if err := a.trackEdit(edit, link, branch); err != nil {
 		log.Printf("Error adding Create edit: %v", err)
-		return
+		return 0
 	}
 	a.dataMgr.AddBranch(branch)
+	a.pushChange(&change{editType: editstack.CreateBranch, threadID: thread.ID, branchID: branch.ID, link: link})
+	return branch.ID
 }
 
-// CreateNewNote creates a new pending note
-func (a *App) CreateNewNote(link *models.Superlink) {
+// CreateNewNote creates a new pending note in the active branch and returns its ID, or 0 when it could not be created.
+func (a *App) CreateNewNote(link *models.Superlink) uint {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
 	thread := a.dataMgr.GetActiveThread()
 	branch := a.dataMgr.GetActiveBranch()
 	if thread == nil {
 		log.Printf("Cannot create note: no active thread")
-		return
+		return 0
 	}
 	if branch == nil {
 		log.Printf("Cannot create note: no active branch")
-		return
+		return 0
 	}
 	note := &models.Note{Content: ""}
 	note.CreatedAt = time.Now()
 	note.UpdatedAt = time.Now()
-	note.ID = a.nextNoteCreateID
+	note.ID = a.newTempID()
 	note.ThreadID = thread.ID
 	note.Branches = []*models.Branch{branch}
-	a.nextNoteCreateID += 1
 	a.Synced = false
 
 	edit := &editstack.Edit{EditType: editstack.CreateNote, ID: note.ID}
-	if err := a.editMgr.AddEdit(edit, link); err != nil {
+	if err := a.trackEdit(edit, link, note); err != nil {
 		log.Printf("Error adding Create edit: %v", err)
-		return
+		return 0
 	}
 
 	// Mark the branch as updated only if it already exists in the DB
 	// If the branch is pending (being created), the CreateBranch will handle the association
-	branchEdit, branchExists := a.editMgr.GetEdit(editstack.EntityBranch, branch.ID)
-	if !branchExists || branchEdit.EditType != editstack.CreateBranch {
-		// Branch either doesn't have pending edits or is not being created
-		// Safe to mark for update
-		updateEdit := &editstack.Edit{ID: branch.ID, EditType: editstack.UpdateBranch}
-		a.editMgr.AddEdit(updateEdit, link) // Ignore error - branch might already be marked
-	}
+	a.touchBranch(branch, link)
 
 	a.dataMgr.AddNote(note)
+	a.pushChange(&change{editType: editstack.CreateNote, threadID: thread.ID, branchID: branch.ID, noteID: note.ID, link: link})
+	return note.ID
 }
 
 func (a *App) GetThreadList() []*models.Thread {
 	if a == nil {
 		log.Panic("null app")
 	}
-	return a.dataMgr.GetThreads()
+	return a.contextMgr.ThreadContextMgr.FilterCurrentThreads(a.dataMgr.GetThreads())
 }
 
 func (a *App) GetActiveBranchList() []*models.Branch {
 	if a == nil {
 		log.Panic("null app")
 	}
-	return a.dataMgr.GetActiveBranchList()
+	return a.contextMgr.BranchContextMgr.FilterCurrentBranches(a.dataMgr.GetActiveBranchList())
 }
 
 func (a *App) GetActiveNoteList() []*models.Note {
 	if a == nil {
 		log.Panic("null app")
 	}
-	return a.dataMgr.GetActiveNoteList()
+	return a.contextMgr.NoteContextMgr.FilterCurrentNotes(a.dataMgr.GetActiveNoteList())
 }
 
 // // GetEditStack returns the edit stack for UI access
@@ -196,80 +217,18 @@ func (a *App) GetActiveNoteList() []*models.Note {
 // 	return a.editMgr
 // }
 
-/*
-This can be implemented later.
-*/
-
-// // UndoDelete undoes the last delete operation
-// func (a *App) UndoDelete() {
-// 	a.mutex.Lock()
-// 	defer a.mutex.Unlock()
-
-// 	currentBranch := a.branchContextMgr.GetCurrentBranch()
-// 	if currentBranch == nil {
-// 		log.Printf("No current branch selected")
-// 		return
-// 	}
-
-// 	// Find the most recent delete from the edit stack
-// 	var lastDeleteEdit *editstack.Edit
-// 	for i := len(a.editMgr.EditStack) - 1; i >= 0; i-- {
-// 		edit := a.editMgr.EditStack[i]
-// 		if edit.EditType == editstack.Delete {
-// 			lastDeleteEdit = edit
-// 			break
-// 		}
-// 	}
-
-// 	if lastDeleteEdit == nil {
-// 		return
-// 	}
-
-// 	deletedNote, exists := currentBranch.Notes[lastDeleteEdit.ID]
-// 	if !exists {
-// 		return
-// 	}
-
-// 	// Remove the delete edit
-// 	a.editMgr.RemoveEdit(lastDeleteEdit.ID)
-
-// 	// Add back to default context
-// 	a.noteContextMgr.AddNoteToDefault(deletedNote)
-// 	a.Synced = false
-// }
-
-// SyncWithDatabase syncs the current state with the database
+// SyncWithDatabase syncs the current state with the database and waits for it, see sync.go.
 func (a *App) SyncWithDatabase() {
-	a.mutex.Lock()
-	defer a.mutex.Unlock()
-
-	// Get threads from data manager and copy edit map
-	threads := a.dataMgr.GetThreads()
-	editMapCopy := make(map[editstack.EditKey]*editstack.Edit)
-	for k, v := range a.editMgr.EditMap {
-		editMapCopy[k] = v
-	}
-
-	// Sync with the database
-	updatedThreads, err := a.db.SyncData(threads, editMapCopy)
-
+	job, err := a.StartSync()
 	if err != nil {
 		log.Printf("Error syncing with database: %v", err)
 		return
 	}
-
-	threadID := a.dataMgr.GetActiveThreadID()
-	branchID := a.dataMgr.GetActiveBranchID()
-	noteID := a.dataMgr.GetActiveNoteID()
-
-	// Refresh data manager with updated threads
-	a.dataMgr.RefreshDataByID(updatedThreads, &threadID, &branchID, &noteID)
-
-	// Get next IDs from database (includes soft-deleted records)
-	// This ensures we never reuse an ID that exists in the DB
-	a.nextNoteCreateID = a.db.GetCreateNoteID()
-	a.nextBranchCreateID = a.db.GetCreateBranchID()
-	a.nextThreadCreateID = a.db.GetCreateThreadID()
-	a.editMgr.ClearOnSync()
-	a.Synced = true
+	if job == nil {
+		return
+	}
+	job.Run()
+	if err := a.FinishSync(job); err != nil {
+		log.Printf("Error syncing with database: %v", err)
+	}
 }
@@ -2,8 +2,9 @@ package context
 
 import (
 	"sort"
-	"strings"
 
+	"github.com/haochend413/ntkpr/internal/db"
+	"github.com/haochend413/ntkpr/internal/fuzzy"
 	"github.com/haochend413/ntkpr/internal/models"
 )
 
@@ -14,11 +15,13 @@ type BranchContext struct {
 	Branches []*models.Branch
 	Order    ContextOrder
 	Cursor   uint
+	Snippets map[uint]string // query contexts only, marked text keyed by ID
 }
 
 type BranchContextMgr struct {
 	previousContext ContextPtr
 	currentContext  ContextPtr
+	order           SortOrder // order of the table, applied to Default
 	Contexts        []*BranchContext
 }
 
@@ -38,6 +41,9 @@ func NewBranchContextMgr() *BranchContextMgr {
 
 func (cm *BranchContextMgr) SwitchContext(c ContextPtr) {
 	// make sure they are different
+	if c < 0 || int(c) >= len(cm.Contexts) {
+		return
+	}
 	if c != cm.currentContext {
 		cm.previousContext = cm.currentContext
 		cm.currentContext = c
@@ -77,34 +83,35 @@ func (cm *BranchContextMgr) RefreshRecentContext() {
 	cm.Contexts[Recent].Branches = bscp[:recentCount]
 }
 
+// RefreshSearchContext fuzzy-matches q against the list of the context the search started from.
+// Results are ranked by match score, and their snippets mark the matched characters.
 func (cm *BranchContextMgr) RefreshSearchContext(q string) {
-	// first, get the current note list
-	// I am not sure whether this is correct.
 	c := cm.currentContext
 	if cm.currentContext == Search {
 		c = cm.previousContext
 	}
-	bs := cm.Contexts[c].Branches
+	branches := cm.Contexts[c].Branches
 
-	//loop through and search
 	if q == "" {
-		cm.Contexts[Search].Branches = bs
+		cm.Contexts[Search].Branches = branches
+		cm.Contexts[Search].Snippets = nil
 		return
 	}
 
-	query := strings.ToLower(q)
-	filteredBranches := make([]*models.Branch, 0)
-	for _, b := range bs {
-		if strings.Contains(strings.ToLower(b.Name), query) {
-			filteredBranches = append(filteredBranches, b)
-			continue
-		}
+	candidates := make([]fuzzy.Candidate, len(branches))
+	for i, x := range branches {
+		candidates[i] = fuzzy.Candidate{Index: i, Text: x.Name}
 	}
-	// Sort by CreatedAt to maintain chronological order
-	sort.Slice(filteredBranches, func(i, j int) bool {
-		return filteredBranches[i].CreatedAt.Before(filteredBranches[j].CreatedAt)
-	})
-	cm.Contexts[Search].Branches = filteredBranches
+	ranked := fuzzy.Rank(q, candidates)
+	results := make([]*models.Branch, len(ranked))
+	snippets := make(map[uint]string, len(ranked))
+	for i, r := range ranked {
+		x := branches[r.Index]
+		results[i] = x
+		snippets[x.ID] = fuzzy.Snippet(x.Name, r.Positions, db.MatchStart, db.MatchEnd, snippetLead)
+	}
+	cm.Contexts[Search].Branches = results
+	cm.Contexts[Search].Snippets = snippets
 }
 
 // GetCurrentCursor returns the cursor position in the current context
@@ -162,41 +169,41 @@ func (cm *BranchContextMgr) RemoveBranchFromDefault(ID uint) {
 	}
 }
 
-// SortCurrentContext sorts the current context notes by CreatedAt (chronological order)
+// SortCurrentContext sorts the current context list in place by the order of the table.
 func (cm *BranchContextMgr) SortCurrentContext() {
-	bs := cm.Contexts[cm.currentContext].Branches
-	order := cm.Contexts[cm.currentContext].Order
-
-	// well this is in place ! careful!
-	switch order {
-	case CreateAt:
-		sort.Slice(bs, func(i, j int) bool {
-			return bs[i].CreatedAt.Before(bs[j].CreatedAt)
-		})
-	case UpdateAt:
-		sort.Slice(bs, func(i, j int) bool {
-			return bs[i].UpdatedAt.After(bs[j].UpdatedAt)
-		})
-	default:
-		sort.Slice(bs, func(i, j int) bool {
-			return bs[i].CreatedAt.Before(bs[j].CreatedAt)
-		})
-	}
+	sortInPlace(cm.Contexts[cm.currentContext].Branches, cm.order, branchKey)
+}
+
+// GetSortOrder returns the order of the table.
+func (cm *BranchContextMgr) GetSortOrder() SortOrder {
+	return cm.order
+}
+
+// SetSortOrder changes the order of the table.
+func (cm *BranchContextMgr) SetSortOrder(o SortOrder) {
+	cm.order = o
 }
 
 // only for data storage purposes. Do not use in coding.
 func (cm *BranchContextMgr) GetCursors() map[ContextPtr]uint {
-	return map[ContextPtr]uint{
-		Default: cm.Contexts[Default].Cursor,
-		Recent:  cm.Contexts[Recent].Cursor,
-		Search:  cm.Contexts[Search].Cursor,
+	cursors := make(map[ContextPtr]uint, len(cm.Contexts))
+	for _, c := range cm.Contexts {
+		cursors[c.Name] = c.Cursor
 	}
+	return cursors
 }
 
 func (cm *BranchContextMgr) SetCursors(m map[ContextPtr]uint) {
-	cm.Contexts[Default].Cursor = m[Default]
-	cm.Contexts[Recent].Cursor = m[Recent]
-	cm.Contexts[Search].Cursor = m[Search]
+	for _, c := range cm.Contexts {
+		c.Cursor = m[c.Name]
+	}
+}
+
+// AddContext appends a context, used for saved searches, and returns its pointer.
+func (cm *BranchContextMgr) AddContext() ContextPtr {
+	c := ContextPtr(len(cm.Contexts))
+	cm.Contexts = append(cm.Contexts, &BranchContext{Name: c, Branches: make([]*models.Branch, 0), Cursor: 0, Order: CreateAt})
+	return c
 }
 
 // UpdateContext switches context, saves current cursor, sorts, and returns new cursor
@@ -223,3 +230,40 @@ func (cm *BranchContextMgr) SelectItem(cursor int) *models.Branch {
 	cm.SetCurrentCursor(uint(cursor))
 	return branches[cursor]
 }
+
+// SetResults stores ranked results of a query context (Search or a saved search) with their snippets.
+func (cm *BranchContextMgr) SetResults(c ContextPtr, branches []*models.Branch, snippets map[uint]string) {
+	if !c.IsQuery() || int(c) >= len(cm.Contexts) {
+		return
+	}
+	cm.Contexts[c].Branches = branches
+	cm.Contexts[c].Snippets = snippets
+}
+
+// FilterCurrentBranches narrows a live branch list down to the current context.
+// Plain lists come back sorted by the order of the table, in a new slice.
+// Query results are matched by ID and keep their rank order, so they survive reloads after sync.
+func (cm *BranchContextMgr) FilterCurrentBranches(branches []*models.Branch) []*models.Branch {
+	if !cm.currentContext.IsQuery() {
+		return sortedCopy(branches, cm.order, branchKey)
+	}
+	byID := make(map[uint]*models.Branch, len(branches))
+	for _, x := range branches {
+		byID[x.ID] = x
+	}
+	filtered := make([]*models.Branch, 0)
+	for _, r := range cm.Contexts[cm.currentContext].Branches {
+		if x, ok := byID[r.ID]; ok {
+			filtered = append(filtered, x)
+		}
+	}
+	return filtered
+}
+
+// GetSnippet returns the search snippet of a branch, or "" outside query contexts.
+func (cm *BranchContextMgr) GetSnippet(id uint) string {
+	if !cm.currentContext.IsQuery() {
+		return ""
+	}
+	return cm.Contexts[cm.currentContext].Snippets[id]
+}
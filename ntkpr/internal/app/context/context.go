@@ -49,14 +49,29 @@ const (
 	Default ContextPtr = 0
 	Recent  ContextPtr = 1
 	Search  ContextPtr = 2
+	Saved   ContextPtr = 3 // first saved search; saved searches from config follow in order
 )
 
+// IsQuery reports whether a context lists query results (Search or a saved search) instead of a plain list.
+func (c ContextPtr) IsQuery() bool {
+	return c == Search || c >= Saved
+}
+
+// snippetLead is how many characters a search snippet shows before the first match.
+const snippetLead = 12
+
 // This is replicative, but might be useful in the future.
 type ContextOrder int
 
 const (
-	CreateAt ContextOrder = 0 // default , time order
-	UpdateAt ContextOrder = 1 // recent, most recently updated
+	CreateAt       ContextOrder = 0 // default , time order
+	UpdateAt       ContextOrder = 1 // recent, most recently updated
+	LastEdit       ContextOrder = 2 // last content edit
+	Frequency      ContextOrder = 3 // edit count
+	Name           ContextOrder = 4 // name, or content for notes
+	ID             ContextOrder = 5
+	HighlightFirst ContextOrder = 6 // highlighted first, then by creation time
+	Manual         ContextOrder = 7 // the order notes were put in within their branch, see App.MoveCurrentNote
 )
 
 // Everything should be fetched from ContextMgr.
@@ -67,8 +82,25 @@ type ContextMgr struct {
 	ThreadContextMgr *ThreadContextMgr
 }
 
-// This function needs to be implemented when we take everything into account.
-func NewContextMgr() {}
+// NewContextMgr creates the context managers on top of a data manager.
+// Default contexts are not copied: they are always read from the DataMgr, so switching threads and branches needs no refresh.
+func NewContextMgr(dm *data.DataMgr) *ContextMgr {
+	return &ContextMgr{
+		DataMgr:          dm,
+		NoteContextMgr:   NewNoteContextMgr(),
+		BranchContextMgr: NewBranchContextMgr(),
+		ThreadContextMgr: NewThreadContextMgr(),
+	}
+}
+
+// AddSavedContexts adds n saved search contexts to every table, at Saved, Saved+1, ...
+func (cm *ContextMgr) AddSavedContexts(n int) {
+	for i := 0; i < n; i++ {
+		cm.NoteContextMgr.AddContext()
+		cm.BranchContextMgr.AddContext()
+		cm.ThreadContextMgr.AddContext()
+	}
+}
 
 // RefreshThreadsContext should not take very long ? Is it really useful to separate it into many pieces ?
 // Wait, there is the cursor problem...Yes
@@ -95,3 +127,31 @@ func (cm *ContextMgr) RefreshNotesContext() {
 	notes := cm.DataMgr.GetActiveNoteList()
 	cm.NoteContextMgr.RefreshDefaultContext(notes)
 }
+
+// RemapIDs moves the snippets of query contexts from temporary IDs to the IDs the database assigned on sync.
+// The results themselves are the synced entities, whose IDs SyncData already replaced.
+func (cm *ContextMgr) RemapIDs(threads, branches, notes map[uint]uint) {
+	for _, c := range cm.ThreadContextMgr.Contexts {
+		c.Snippets = remapKeys(c.Snippets, threads)
+	}
+	for _, c := range cm.BranchContextMgr.Contexts {
+		c.Snippets = remapKeys(c.Snippets, branches)
+	}
+	for _, c := range cm.NoteContextMgr.Contexts {
+		c.Snippets = remapKeys(c.Snippets, notes)
+	}
+}
+
+func remapKeys(m map[uint]string, ids map[uint]uint) map[uint]string {
+	if len(m) == 0 || len(ids) == 0 {
+		return m
+	}
+	remapped := make(map[uint]string, len(m))
+	for id, v := range m {
+		if newID, ok := ids[id]; ok {
+			id = newID
+		}
+		remapped[id] = v
+	}
+	return remapped
+}
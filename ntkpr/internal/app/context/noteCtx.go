@@ -6,21 +6,24 @@ package context
 // Also, we can use fuzzy lib for search. Yes it is a good idea.
 import (
 	"sort"
-	"strings"
 
+	"github.com/haochend413/ntkpr/internal/db"
+	"github.com/haochend413/ntkpr/internal/fuzzy"
 	"github.com/haochend413/ntkpr/internal/models"
 )
 
 type NoteContext struct {
-	Name   ContextPtr
-	Notes  []*models.Note
-	Order  ContextOrder
-	Cursor uint
+	Name     ContextPtr
+	Notes    []*models.Note
+	Order    ContextOrder
+	Cursor   uint
+	Snippets map[uint]string // query contexts only, marked text keyed by ID
 }
 
 type NoteContextMgr struct {
 	previousContext ContextPtr
 	currentContext  ContextPtr
+	order           SortOrder // order of the table, applied to Default
 	Contexts        []*NoteContext
 }
 
@@ -40,6 +43,9 @@ func NewNoteContextMgr() *NoteContextMgr {
 // SwitchContext switches the contextMgr into a new context and update previous context.
 func (cm *NoteContextMgr) SwitchContext(c ContextPtr) {
 	// make sure they are different
+	if c < 0 || int(c) >= len(cm.Contexts) {
+		return
+	}
 	if c != cm.currentContext {
 		cm.previousContext = cm.currentContext
 		cm.currentContext = c
@@ -83,32 +89,35 @@ func (cm *NoteContextMgr) RefreshRecentContext() {
 	cm.Contexts[Recent].Notes = notesCopy[:recentCount]
 }
 
+// RefreshSearchContext fuzzy-matches q against the list of the context the search started from.
+// Results are ranked by match score, and their snippets mark the matched characters.
 func (cm *NoteContextMgr) RefreshSearchContext(q string) {
-	// first, get the current note list
-	// I am not sure whether this is correct.
 	c := cm.currentContext
 	if cm.currentContext == Search {
 		c = cm.previousContext
 	}
 	notes := cm.Contexts[c].Notes
 
-	//loop through and search
 	if q == "" {
 		cm.Contexts[Search].Notes = notes
+		cm.Contexts[Search].Snippets = nil
 		return
 	}
-	query := strings.ToLower(q)
-	filteredNotes := make([]*models.Note, 0)
-	for _, note := range notes {
-		if strings.Contains(strings.ToLower(note.Content), query) {
-			filteredNotes = append(filteredNotes, note)
-		}
+
+	candidates := make([]fuzzy.Candidate, len(notes))
+	for i, x := range notes {
+		candidates[i] = fuzzy.Candidate{Index: i, Text: x.Content}
 	}
-	// Sort by CreatedAt to maintain chronological order
-	sort.Slice(filteredNotes, func(i, j int) bool {
-		return filteredNotes[i].CreatedAt.Before(filteredNotes[j].CreatedAt)
-	})
-	cm.Contexts[Search].Notes = filteredNotes
+	ranked := fuzzy.Rank(q, candidates)
+	results := make([]*models.Note, len(ranked))
+	snippets := make(map[uint]string, len(ranked))
+	for i, r := range ranked {
+		x := notes[r.Index]
+		results[i] = x
+		snippets[x.ID] = fuzzy.Snippet(x.Content, r.Positions, db.MatchStart, db.MatchEnd, snippetLead)
+	}
+	cm.Contexts[Search].Notes = results
+	cm.Contexts[Search].Snippets = snippets
 }
 
 // GetCurrentCursor returns the cursor position in the current context
@@ -161,41 +170,41 @@ func (cm *NoteContextMgr) RemoveNoteFromDefault(noteID uint) {
 	}
 }
 
-// SortCurrentContext sorts the current context notes by CreatedAt (chronological order)
+// SortCurrentContext sorts the current context list in place by the order of the table.
 func (cm *NoteContextMgr) SortCurrentContext() {
-	notes := cm.Contexts[cm.currentContext].Notes
-	order := cm.Contexts[cm.currentContext].Order
-
-	// well this is in place ! careful!
-	switch order {
-	case CreateAt:
-		sort.Slice(notes, func(i, j int) bool {
-			return notes[i].CreatedAt.Before(notes[j].CreatedAt)
-		})
-	case UpdateAt:
-		sort.Slice(notes, func(i, j int) bool {
-			return notes[i].UpdatedAt.After(notes[j].UpdatedAt)
-		})
-	default:
-		sort.Slice(notes, func(i, j int) bool {
-			return notes[i].CreatedAt.Before(notes[j].CreatedAt)
-		})
-	}
+	sortInPlace(cm.Contexts[cm.currentContext].Notes, cm.order, noteKey)
+}
+
+// GetSortOrder returns the order of the table.
+func (cm *NoteContextMgr) GetSortOrder() SortOrder {
+	return cm.order
+}
+
+// SetSortOrder changes the order of the table.
+func (cm *NoteContextMgr) SetSortOrder(o SortOrder) {
+	cm.order = o
 }
 
 // only for data storage and load purposes. Do not use in coding.
 func (cm *NoteContextMgr) GetCursors() map[ContextPtr]uint {
-	return map[ContextPtr]uint{
-		Default: cm.Contexts[Default].Cursor,
-		Recent:  cm.Contexts[Recent].Cursor,
-		Search:  cm.Contexts[Search].Cursor,
+	cursors := make(map[ContextPtr]uint, len(cm.Contexts))
+	for _, c := range cm.Contexts {
+		cursors[c.Name] = c.Cursor
 	}
+	return cursors
 }
 
 func (cm *NoteContextMgr) SetCursors(m map[ContextPtr]uint) {
-	cm.Contexts[Default].Cursor = m[Default]
-	cm.Contexts[Recent].Cursor = m[Recent]
-	cm.Contexts[Search].Cursor = m[Search]
+	for _, c := range cm.Contexts {
+		c.Cursor = m[c.Name]
+	}
+}
+
+// AddContext appends a context, used for saved searches, and returns its pointer.
+func (cm *NoteContextMgr) AddContext() ContextPtr {
+	c := ContextPtr(len(cm.Contexts))
+	cm.Contexts = append(cm.Contexts, &NoteContext{Name: c, Notes: make([]*models.Note, 0), Cursor: 0, Order: CreateAt})
+	return c
 }
 
 // UpdateContext switches context, saves current cursor, sorts, and returns new cursor
@@ -222,3 +231,40 @@ func (cm *NoteContextMgr) SelectItem(cursor int) *models.Note {
 	cm.SetCurrentCursor(uint(cursor))
 	return notes[cursor]
 }
+
+// SetResults stores ranked results of a query context (Search or a saved search) with their snippets.
+func (cm *NoteContextMgr) SetResults(c ContextPtr, notes []*models.Note, snippets map[uint]string) {
+	if !c.IsQuery() || int(c) >= len(cm.Contexts) {
+		return
+	}
+	cm.Contexts[c].Notes = notes
+	cm.Contexts[c].Snippets = snippets
+}
+
+// FilterCurrentNotes narrows a live note list down to the current context.
+// Plain lists come back sorted by the order of the table, in a new slice.
+// Query results are matched by ID and keep their rank order, so they survive reloads after sync.
+func (cm *NoteContextMgr) FilterCurrentNotes(notes []*models.Note) []*models.Note {
+	if !cm.currentContext.IsQuery() {
+		return sortedCopy(notes, cm.order, noteKey)
+	}
+	byID := make(map[uint]*models.Note, len(notes))
+	for _, x := range notes {
+		byID[x.ID] = x
+	}
+	filtered := make([]*models.Note, 0)
+	for _, r := range cm.Contexts[cm.currentContext].Notes {
+		if x, ok := byID[r.ID]; ok {
+			filtered = append(filtered, x)
+		}
+	}
+	return filtered
+}
+
+// GetSnippet returns the search snippet of a note, or "" outside query contexts.
+func (cm *NoteContextMgr) GetSnippet(id uint) string {
+	if !cm.currentContext.IsQuery() {
+		return ""
+	}
+	return cm.Contexts[cm.currentContext].Snippets[id]
+}
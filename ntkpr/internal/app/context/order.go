@@ -0,0 +1,151 @@
+package context
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/haochend413/ntkpr/internal/models"
+)
+
+// order.go sorts the lists shown by the tables. Each table has one SortOrder, chosen by the user and kept in the state file.
+// It applies to the plain lists; query results keep their rank order.
+
+// SortOrder is the order of one table.
+type SortOrder struct {
+	By         ContextOrder `json:"by"`
+	Descending bool         `json:"descending"`
+}
+
+// Orders lists the orders a table cycles through, in order.
+// Manual applies to notes only, other tables skip it, see App.CycleSortOrder.
+var Orders = []ContextOrder{CreateAt, UpdateAt, LastEdit, Frequency, Name, ID, HighlightFirst, Manual}
+
+// Next returns the order after o in Orders, keeping the direction.
+func (o SortOrder) Next() SortOrder {
+	for i, by := range Orders {
+		if by == o.By {
+			return SortOrder{By: Orders[(i+1)%len(Orders)], Descending: o.Descending}
+		}
+	}
+	return SortOrder{By: CreateAt, Descending: o.Descending}
+}
+
+// String names the order for the status bar, e.g. "Name ↑".
+func (o SortOrder) String() string {
+	arrow := " ↑"
+	if o.Descending {
+		arrow = " ↓"
+	}
+	return o.By.String() + arrow
+}
+
+func (o ContextOrder) String() string {
+	switch o {
+	case UpdateAt:
+		return "Updated"
+	case LastEdit:
+		return "Last edit"
+	case Frequency:
+		return "Frequency"
+	case Name:
+		return "Name"
+	case ID:
+		return "ID"
+	case HighlightFirst:
+		return "Highlighted"
+	case Manual:
+		return "Manual"
+	}
+	return "Created"
+}
+
+// sortKey holds the fields an entity can be sorted on.
+type sortKey struct {
+	created   time.Time
+	updated   time.Time
+	lastEdit  time.Time
+	frequency int
+	name      string
+	id        uint
+	highlight bool
+}
+
+func noteKey(n *models.Note) sortKey {
+	return sortKey{n.CreatedAt, n.UpdatedAt, n.LastEdit, n.Frequency, n.Content, n.ID, n.Highlight}
+}
+
+func branchKey(b *models.Branch) sortKey {
+	return sortKey{b.CreatedAt, b.UpdatedAt, b.LastEdit, b.Frequency, b.Name, b.ID, b.Highlight}
+}
+
+func threadKey(t *models.Thread) sortKey {
+	return sortKey{t.CreatedAt, t.UpdatedAt, t.LastEdit, t.Frequency, t.Name, t.ID, t.Highlight}
+}
+
+// compareKeys compares two entities in ascending order of o.By. Ties fall back to creation time.
+// HighlightFirst puts highlighted entities first, each group by creation time.
+func compareKeys(by ContextOrder, a, b sortKey) int {
+	c := 0
+	switch by {
+	case UpdateAt:
+		c = a.updated.Compare(b.updated)
+	case LastEdit:
+		// never edited counts from the last update, like the edited: filter
+		ae, be := a.lastEdit, b.lastEdit
+		if ae.IsZero() {
+			ae = a.updated
+		}
+		if be.IsZero() {
+			be = b.updated
+		}
+		c = ae.Compare(be)
+	case Frequency:
+		c = a.frequency - b.frequency
+	case Name:
+		c = strings.Compare(strings.ToLower(a.name), strings.ToLower(b.name))
+	case ID:
+		c = int(a.id) - int(b.id)
+	case HighlightFirst:
+		if a.highlight != b.highlight {
+			if a.highlight {
+				return -1
+			}
+			return 1
+		}
+	}
+	if c == 0 {
+		c = a.created.Compare(b.created)
+	}
+	return c
+}
+
+// sortedCopy returns list sorted by o, leaving list untouched. Equal entities keep their order.
+// Manual keeps the order of list, which is the order of the notes in their branch.
+func sortedCopy[T any](list []T, o SortOrder, key func(T) sortKey) []T {
+	sorted := make([]T, len(list))
+	copy(sorted, list)
+	if o.By == Manual {
+		if o.Descending {
+			slices.Reverse(sorted)
+		}
+		return sorted
+	}
+	sortInPlace(sorted, o, key)
+	return sorted
+}
+
+// sortInPlace sorts list by o. A list in Manual order is left as it is.
+func sortInPlace[T any](list []T, o SortOrder, key func(T) sortKey) {
+	if o.By == Manual {
+		return
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		c := compareKeys(o.By, key(list[i]), key(list[j]))
+		if o.Descending {
+			return c > 0
+		}
+		return c < 0
+	})
+}
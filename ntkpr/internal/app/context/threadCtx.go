@@ -2,23 +2,26 @@ package context
 
 import (
 	"sort"
-	"strings"
 
+	"github.com/haochend413/ntkpr/internal/db"
+	"github.com/haochend413/ntkpr/internal/fuzzy"
 	"github.com/haochend413/ntkpr/internal/models"
 )
 
 /* Threads */
 
 type ThreadContext struct {
-	Name    ContextPtr
-	Threads []*models.Thread
-	Order   ContextOrder
-	Cursor  uint
+	Name     ContextPtr
+	Threads  []*models.Thread
+	Order    ContextOrder
+	Cursor   uint
+	Snippets map[uint]string // query contexts only, marked text keyed by ID
 }
 
 type ThreadContextMgr struct {
 	previousContext ContextPtr
 	currentContext  ContextPtr
+	order           SortOrder // order of the table, applied to Default
 	Contexts        []*ThreadContext
 }
 
@@ -36,6 +39,9 @@ func NewThreadContextMgr() *ThreadContextMgr {
 }
 
 func (cm *ThreadContextMgr) SwitchContext(c ContextPtr) {
+	if c < 0 || int(c) >= len(cm.Contexts) {
+		return
+	}
 	if c != cm.currentContext {
 		cm.previousContext = cm.currentContext
 		cm.currentContext = c
@@ -72,6 +78,8 @@ func (cm *ThreadContextMgr) RefreshRecentContext() {
 	cm.Contexts[Recent].Threads = threadsCopy[:recentCount]
 }
 
+// RefreshSearchContext fuzzy-matches q against the list of the context the search started from.
+// Results are ranked by match score, and their snippets mark the matched characters.
 func (cm *ThreadContextMgr) RefreshSearchContext(q string) {
 	c := cm.currentContext
 	if cm.currentContext == Search {
@@ -81,21 +89,24 @@ func (cm *ThreadContextMgr) RefreshSearchContext(q string) {
 
 	if q == "" {
 		cm.Contexts[Search].Threads = threads
+		cm.Contexts[Search].Snippets = nil
 		return
 	}
 
-	query := strings.ToLower(q)
-	filteredThreads := make([]*models.Thread, 0)
-	for _, thread := range threads {
-		if strings.Contains(strings.ToLower(thread.Name), query) {
-			filteredThreads = append(filteredThreads, thread)
-			continue
-		}
+	candidates := make([]fuzzy.Candidate, len(threads))
+	for i, x := range threads {
+		candidates[i] = fuzzy.Candidate{Index: i, Text: x.Name}
 	}
-	sort.Slice(filteredThreads, func(i, j int) bool {
-		return filteredThreads[i].CreatedAt.Before(filteredThreads[j].CreatedAt)
-	})
-	cm.Contexts[Search].Threads = filteredThreads
+	ranked := fuzzy.Rank(q, candidates)
+	results := make([]*models.Thread, len(ranked))
+	snippets := make(map[uint]string, len(ranked))
+	for i, r := range ranked {
+		x := threads[r.Index]
+		results[i] = x
+		snippets[x.ID] = fuzzy.Snippet(x.Name, r.Positions, db.MatchStart, db.MatchEnd, snippetLead)
+	}
+	cm.Contexts[Search].Threads = results
+	cm.Contexts[Search].Snippets = snippets
 }
 
 func (cm *ThreadContextMgr) GetCurrentCursor() uint {
@@ -145,24 +156,19 @@ func (cm *ThreadContextMgr) RemoveThreadFromDefault(threadID uint) {
 	}
 }
 
+// SortCurrentContext sorts the current context list in place by the order of the table.
 func (cm *ThreadContextMgr) SortCurrentContext() {
-	threads := cm.Contexts[cm.currentContext].Threads
-	order := cm.Contexts[cm.currentContext].Order
+	sortInPlace(cm.Contexts[cm.currentContext].Threads, cm.order, threadKey)
+}
 
-	switch order {
-	case CreateAt:
-		sort.Slice(threads, func(i, j int) bool {
-			return threads[i].CreatedAt.Before(threads[j].CreatedAt)
-		})
-	case UpdateAt:
-		sort.Slice(threads, func(i, j int) bool {
-			return threads[i].UpdatedAt.After(threads[j].UpdatedAt)
-		})
-	default:
-		sort.Slice(threads, func(i, j int) bool {
-			return threads[i].CreatedAt.Before(threads[j].CreatedAt)
-		})
-	}
+// GetSortOrder returns the order of the table.
+func (cm *ThreadContextMgr) GetSortOrder() SortOrder {
+	return cm.order
+}
+
+// SetSortOrder changes the order of the table.
+func (cm *ThreadContextMgr) SetSortOrder(o SortOrder) {
+	cm.order = o
 }
 
 // UpdateContext switches context, saves current cursor, sorts, and returns new cursor
@@ -191,15 +197,59 @@ func (cm *ThreadContextMgr) SelectItem(cursor int) *models.Thread {
 }
 
 func (cm *ThreadContextMgr) GetCursors() map[ContextPtr]uint {
-	return map[ContextPtr]uint{
-		Default: cm.Contexts[Default].Cursor,
-		Recent:  cm.Contexts[Recent].Cursor,
-		Search:  cm.Contexts[Search].Cursor,
+	cursors := make(map[ContextPtr]uint, len(cm.Contexts))
+	for _, c := range cm.Contexts {
+		cursors[c.Name] = c.Cursor
 	}
+	return cursors
 }
 
 func (cm *ThreadContextMgr) SetCursors(m map[ContextPtr]uint) {
-	cm.Contexts[Default].Cursor = m[Default]
-	cm.Contexts[Recent].Cursor = m[Recent]
-	cm.Contexts[Search].Cursor = m[Search]
+	for _, c := range cm.Contexts {
+		c.Cursor = m[c.Name]
+	}
+}
+
+// AddContext appends a context, used for saved searches, and returns its pointer.
+func (cm *ThreadContextMgr) AddContext() ContextPtr {
+	c := ContextPtr(len(cm.Contexts))
+	cm.Contexts = append(cm.Contexts, &ThreadContext{Name: c, Threads: make([]*models.Thread, 0), Cursor: 0, Order: CreateAt})
+	return c
+}
+
+// SetResults stores ranked results of a query context (Search or a saved search) with their snippets.
+func (cm *ThreadContextMgr) SetResults(c ContextPtr, threads []*models.Thread, snippets map[uint]string) {
+	if !c.IsQuery() || int(c) >= len(cm.Contexts) {
+		return
+	}
+	cm.Contexts[c].Threads = threads
+	cm.Contexts[c].Snippets = snippets
+}
+
+// FilterCurrentThreads narrows a live thread list down to the current context.
+// Plain lists come back sorted by the order of the table, in a new slice.
+// Query results are matched by ID and keep their rank order, so they survive reloads after sync.
+func (cm *ThreadContextMgr) FilterCurrentThreads(threads []*models.Thread) []*models.Thread {
+	if !cm.currentContext.IsQuery() {
+		return sortedCopy(threads, cm.order, threadKey)
+	}
+	byID := make(map[uint]*models.Thread, len(threads))
+	for _, x := range threads {
+		byID[x.ID] = x
+	}
+	filtered := make([]*models.Thread, 0)
+	for _, r := range cm.Contexts[cm.currentContext].Threads {
+		if x, ok := byID[r.ID]; ok {
+			filtered = append(filtered, x)
+		}
+	}
+	return filtered
+}
+
+// GetSnippet returns the search snippet of a thread, or "" outside query contexts.
+func (cm *ThreadContextMgr) GetSnippet(id uint) string {
+	if !cm.currentContext.IsQuery() {
+		return ""
+	}
+	return cm.Contexts[cm.currentContext].Snippets[id]
 }
@@ -0,0 +1,285 @@
+package app
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/haochend413/ntkpr/config"
+	"github.com/haochend413/ntkpr/internal/app/context"
+	"github.com/haochend413/ntkpr/internal/db"
+	"github.com/haochend413/ntkpr/state"
+)
+
+// contexts.go manages which context each table shows: Default, Search, or one of the saved searches from config.
+// Saved searches are re-evaluated every time their context is entered, so they follow edits and syncs.
+// Every context keeps its own cursor, and the cursors are persisted in the state file.
+
+// savedSearch is a saved search bound to its context.
+type savedSearch struct {
+	ptr   context.ContextPtr
+	name  string
+	query string
+	kind  string
+}
+
+// contextSwitcher is what the three table context managers have in common.
+type contextSwitcher interface {
+	SwitchContext(c context.ContextPtr)
+	GetCurrentContext() context.ContextPtr
+	GetCurrentCursor() uint
+	SetCurrentCursor(cursor uint)
+	GetCursors() map[context.ContextPtr]uint
+	SetCursors(m map[context.ContextPtr]uint)
+	GetSortOrder() context.SortOrder
+	SetSortOrder(o context.SortOrder)
+}
+
+// contextsOf returns the context manager of the table of one kind.
+func (a *App) contextsOf(kind string) contextSwitcher {
+	switch kind {
+	case db.SearchKindThread:
+		return a.contextMgr.ThreadContextMgr
+	case db.SearchKindBranch:
+		return a.contextMgr.BranchContextMgr
+	case db.SearchKindNote:
+		return a.contextMgr.NoteContextMgr
+	}
+	return nil
+}
+
+// SetSavedSearches adds a context for each saved search, then restores the cursors and last contexts of the state file.
+// It should be called once, right after NewApp.
+func (a *App) SetSavedSearches(searches []config.SavedSearch) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.contextMgr.AddSavedContexts(len(searches))
+	a.savedSearches = make([]savedSearch, 0, len(searches))
+	for i, s := range searches {
+		kind := s.Table
+		switch kind {
+		case db.SearchKindThread, db.SearchKindBranch, db.SearchKindNote:
+		case "":
+			kind = db.SearchKindNote
+		default:
+			log.Printf("Saved search %q: unknown table %q, using note", s.Name, s.Table)
+			kind = db.SearchKindNote
+		}
+		a.savedSearches = append(a.savedSearches, savedSearch{
+			ptr:   context.Saved + context.ContextPtr(i),
+			name:  s.Name,
+			query: s.Query,
+			kind:  kind,
+		})
+	}
+	a.restoreContexts()
+}
+
+// restoreContexts applies the cursors and last contexts of the state passed to NewApp.
+// Default cursors are only stored here; the UI moves the active items onto them.
+// Saved searches are matched by name, so reordering them in config keeps their state, and removed ones are dropped.
+func (a *App) restoreContexts() {
+	if a.state == nil {
+		return
+	}
+	restore := []struct {
+		kind    string
+		cursors map[context.ContextPtr]uint
+		saved   map[string]uint
+		last    string
+	}{
+		{db.SearchKindThread, a.state.ThreadCursors, a.state.ThreadSavedCursors, a.state.LastThreadSearch},
+		{db.SearchKindBranch, a.state.BranchCursors, a.state.BranchSavedCursors, a.state.LastBranchSearch},
+		{db.SearchKindNote, a.state.NoteCursors, a.state.NoteSavedCursors, a.state.LastNoteSearch},
+	}
+	for _, r := range restore {
+		mgr := a.contextsOf(r.kind)
+		cursors := make(map[context.ContextPtr]uint, len(r.cursors)+len(r.saved))
+		for c, cursor := range r.cursors {
+			// older state files numbered saved searches, which may now be other ones
+			if c < context.Saved {
+				cursors[c] = cursor
+			}
+		}
+		for _, s := range a.savedSearches {
+			if cursor, ok := r.saved[s.name]; ok && s.kind == r.kind {
+				cursors[s.ptr] = cursor
+			}
+		}
+		mgr.SetCursors(cursors)
+
+		s, ok := a.savedSearchNamed(r.kind, r.last)
+		if !ok {
+			continue
+		}
+		if err := a.refreshSaved(s); err != nil {
+			log.Printf("Error restoring saved search %q: %v", s.name, err)
+			continue
+		}
+		mgr.SwitchContext(s.ptr)
+	}
+}
+
+// savedSearchNamed returns the first saved search of one kind called name.
+func (a *App) savedSearchNamed(kind, name string) (savedSearch, bool) {
+	if name == "" {
+		return savedSearch{}, false
+	}
+	for _, s := range a.savedSearches {
+		if s.kind == kind && s.name == name {
+			return s, true
+		}
+	}
+	return savedSearch{}, false
+}
+
+// savedSearchAt returns the saved search of context c.
+func (a *App) savedSearchAt(c context.ContextPtr) (savedSearch, bool) {
+	i := int(c - context.Saved)
+	if c < context.Saved || i >= len(a.savedSearches) {
+		return savedSearch{}, false
+	}
+	return a.savedSearches[i], true
+}
+
+// refreshSaved re-runs a saved search and stores its results in its context.
+func (a *App) refreshSaved(s savedSearch) error {
+	le := a.loadedEntities()
+	found, err := a.find(s.kind, s.query, le)
+	if err != nil {
+		return fmt.Errorf("saved search %q: %w", s.name, err)
+	}
+	a.setResults(s.kind, s.ptr, found, le)
+	return nil
+}
+
+// ContextsFor lists the contexts the table of one kind can cycle through: Default, then its saved searches.
+func (a *App) ContextsFor(kind string) []context.ContextPtr {
+	contexts := []context.ContextPtr{context.Default}
+	for _, s := range a.savedSearches {
+		if s.kind == kind {
+			contexts = append(contexts, s.ptr)
+		}
+	}
+	return contexts
+}
+
+// SwitchContext switches the table of one kind into context c and returns the cursor to restore.
+// A saved search is re-run first. Back in Default, the cursor is the row of the active item of the DataMgr.
+func (a *App) SwitchContext(kind string, c context.ContextPtr) (uint, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	mgr := a.contextsOf(kind)
+	if mgr == nil {
+		return 0, nil
+	}
+	if s, ok := a.savedSearchAt(c); ok {
+		if err := a.refreshSaved(s); err != nil {
+			return 0, err
+		}
+	}
+	mgr.SwitchContext(c)
+
+	if c == context.Default {
+		return uint(max(0, a.ActiveRow(kind))), nil
+	}
+	return mgr.GetCurrentCursor(), nil
+}
+
+// ResetContext switches the table of one kind back to its Default context.
+func (a *App) ResetContext(kind string) {
+	if _, err := a.SwitchContext(kind, context.Default); err != nil {
+		log.Printf("Error resetting context: %v", err)
+	}
+}
+
+// GetContext returns the current context of the table of one kind.
+func (a *App) GetContext(kind string) context.ContextPtr {
+	if mgr := a.contextsOf(kind); mgr != nil {
+		return mgr.GetCurrentContext()
+	}
+	return context.None
+}
+
+// ContextName returns the display name of the current context of the table of one kind.
+func (a *App) ContextName(kind string) string {
+	c := a.GetContext(kind)
+	if s, ok := a.savedSearchAt(c); ok {
+		return s.name
+	}
+	switch c {
+	case context.Recent:
+		return "Recent"
+	case context.Search:
+		return "Search"
+	}
+	return "Default"
+}
+
+// GetCursor returns the stored cursor of the current context of the table of one kind.
+func (a *App) GetCursor(kind string) uint {
+	if mgr := a.contextsOf(kind); mgr != nil {
+		return mgr.GetCurrentCursor()
+	}
+	return 0
+}
+
+// SetCursor stores the table cursor in the current context of the table of one kind.
+func (a *App) SetCursor(kind string, cursor uint) {
+	if mgr := a.contextsOf(kind); mgr != nil {
+		mgr.SetCurrentCursor(cursor)
+	}
+}
+
+// CollectState returns the contexts, cursors and orders to persist.
+// Default cursors are the rows of the active items of the DataMgr, which are only listed by Default.
+// Search results are not persisted, so a table left in Search comes back in Default.
+// Saved searches are persisted by name, see restoreContexts.
+func (a *App) CollectState() state.AppState {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	collect := func(kind string) (map[context.ContextPtr]uint, map[string]uint, string, context.SortOrder) {
+		mgr := a.contextsOf(kind)
+		cursors := make(map[context.ContextPtr]uint)
+		saved := make(map[string]uint)
+		for c, cursor := range mgr.GetCursors() {
+			if c < context.Saved {
+				cursors[c] = cursor
+			} else if s, ok := a.savedSearchAt(c); ok && s.kind == kind {
+				saved[s.name] = cursor
+			}
+		}
+		last := ""
+		switch c := mgr.GetCurrentContext(); {
+		case c == context.Default:
+			cursors[context.Default] = uint(max(0, a.ActiveRow(kind)))
+		case c >= context.Saved:
+			if s, ok := a.savedSearchAt(c); ok {
+				last = s.name
+			}
+		}
+		return cursors, saved, last, mgr.GetSortOrder()
+	}
+
+	s := state.AppState{
+		LastThreadContext: context.Default,
+		LastBranchContext: context.Default,
+		LastNoteContext:   context.Default,
+	}
+	s.ThreadCursors, s.ThreadSavedCursors, s.LastThreadSearch, s.ThreadOrder = collect(db.SearchKindThread)
+	s.BranchCursors, s.BranchSavedCursors, s.LastBranchSearch, s.BranchOrder = collect(db.SearchKindBranch)
+	s.NoteCursors, s.NoteSavedCursors, s.LastNoteSearch, s.NoteOrder = collect(db.SearchKindNote)
+	return s
+}
+
+// restoreOrders applies the table orders of the state passed to NewApp.
+func (a *App) restoreOrders() {
+	if a.state == nil {
+		return
+	}
+	a.contextMgr.ThreadContextMgr.SetSortOrder(a.state.ThreadOrder)
+	a.contextMgr.BranchContextMgr.SetSortOrder(a.state.BranchOrder)
+	a.contextMgr.NoteContextMgr.SetSortOrder(a.state.NoteOrder)
+}
@@ -0,0 +1,99 @@
+package app
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/haochend413/ntkpr/config"
+	"github.com/haochend413/ntkpr/internal/app/context"
+	"github.com/haochend413/ntkpr/internal/db"
+	"github.com/haochend413/ntkpr/state"
+	"gorm.io/gorm/logger"
+)
+
+func TestSavedSearchStateByName(t *testing.T) {
+	d, err := db.NewDB(filepath.Join(t.TempDir(), "ntkpr.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	d.Conn.Logger = logger.Discard
+
+	todo := config.SavedSearch{Name: "Todo", Query: "is:highlight", Table: "note"}
+	week := config.SavedSearch{Name: "Week", Query: "edited:<7d", Table: "note"}
+	old := config.SavedSearch{Name: "Old", Query: "edited:>30d", Table: "branch"}
+
+	a := NewApp(d, nil)
+	a.SetSavedSearches([]config.SavedSearch{todo, week, old})
+	weekPtr := context.Saved + 1
+	if _, err := a.SwitchContext(db.SearchKindNote, weekPtr); err != nil {
+		t.Fatal(err)
+	}
+	a.SetCursor(db.SearchKindNote, 4)
+	if _, err := a.SwitchContext(db.SearchKindBranch, context.Saved+2); err != nil {
+		t.Fatal(err)
+	}
+	a.SetCursor(db.SearchKindBranch, 2)
+	s := a.CollectState()
+
+	if s.LastNoteSearch != "Week" || s.NoteSavedCursors["Week"] != 4 {
+		t.Fatalf("collected note search %q at %d, want Week at 4", s.LastNoteSearch, s.NoteSavedCursors["Week"])
+	}
+	for c := range s.NoteCursors {
+		if c >= context.Saved {
+			t.Errorf("saved search context %d persisted by number", c)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		searches   []config.SavedSearch
+		wantNote   string
+		wantCursor uint
+		wantBranch string
+	}{
+		{"same order", []config.SavedSearch{todo, week, old}, "Week", 4, "Old"},
+		{"reordered", []config.SavedSearch{old, week, todo}, "Week", 4, "Old"},
+		{"removed", []config.SavedSearch{todo}, "Default", 0, "Default"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewApp(d, &s)
+			a.SetSavedSearches(tt.searches)
+			if got := a.ContextName(db.SearchKindNote); got != tt.wantNote {
+				t.Errorf("note context %q, want %q", got, tt.wantNote)
+			}
+			if got := a.GetCursor(db.SearchKindNote); got != tt.wantCursor {
+				t.Errorf("note cursor %d, want %d", got, tt.wantCursor)
+			}
+			if got := a.ContextName(db.SearchKindBranch); got != tt.wantBranch {
+				t.Errorf("branch context %q, want %q", got, tt.wantBranch)
+			}
+		})
+	}
+}
+
+// A state file written before saved searches were kept by name numbers them, and these numbers are ignored.
+func TestSavedSearchLegacyState(t *testing.T) {
+	d, err := db.NewDB(filepath.Join(t.TempDir(), "ntkpr.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	d.Conn.Logger = logger.Discard
+
+	s := state.DefaultState().App
+	s.LastNoteContext = context.Saved
+	s.NoteCursors[context.Saved] = 7
+	a := NewApp(d, &s)
+	a.SetSavedSearches([]config.SavedSearch{{Name: "Todo", Query: "is:highlight"}})
+	if got := a.ContextName(db.SearchKindNote); got != "Default" {
+		t.Errorf("note context %q, want Default", got)
+	}
+	if _, err := a.SwitchContext(db.SearchKindNote, context.Saved); err != nil {
+		t.Fatal(err)
+	}
+	if got := a.GetCursor(db.SearchKindNote); got != 0 {
+		t.Errorf("saved search cursor %d, want 0", got)
+	}
+}
@@ -6,7 +6,9 @@ import (
 	"time"
 
 	editstack "github.com/haochend413/ntkpr/internal/app/editStack"
+	"github.com/haochend413/ntkpr/internal/app/journal"
 	"github.com/haochend413/ntkpr/internal/models"
+	"github.com/haochend413/ntkpr/internal/vault"
 )
 
 // current_branch.go provides a controlled interface for accessing and modifying
@@ -39,7 +41,8 @@ func (a *App) GetCurrentBranchID() uint {
 	return branch.ID
 }
 
-// GetCurrentBranchName returns the name of the current branch, or empty string if none selected
+// GetCurrentBranchName returns the name of the current branch, or empty string if none selected.
+// A name that stays sealed while the vault is locked is returned as vault.Placeholder, and so is the summary.
 func (a *App) GetCurrentBranchName() string {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
@@ -48,7 +51,7 @@ func (a *App) GetCurrentBranchName() string {
 	if branch == nil {
 		return ""
 	}
-	return branch.Name
+	return vault.Show(branch.Name)
 }
 
 func (a *App) GetCurrentBranchSummary() string {
@@ -59,7 +62,7 @@ func (a *App) GetCurrentBranchSummary() string {
 	if branch == nil {
 		return ""
 	}
-	return branch.Summary
+	return vault.Show(branch.Summary)
 }
 
 // GetCurrentBranchHighlight returns whether the current branch is highlighted
@@ -95,7 +98,7 @@ func (a *App) GetCurrentBranchNoteCount() int {
 	if branch == nil {
 		return 0
 	}
-	return len(branch.Notes)
+	return a.dataMgr.NoteCount(branch)
 }
 
 // GetCurrentBranchNotes returns a copy of the current branch's notes to prevent external mutation.
@@ -167,7 +170,7 @@ func (a *App) IncrementCurrentBranchFrequency(link *models.Superlink) {
 	a.Synced = false
 
 	edit := &editstack.Edit{ID: branch.ID, EditType: editstack.UpdateBranch}
-	if err := a.editMgr.AddEdit(edit, link); err != nil {
+	if err := a.trackEdit(edit, link, branch); err != nil {
 		log.Printf("Error tracking branch frequency increment: %v", err)
 	}
 }
@@ -210,16 +213,22 @@ func (a *App) SetCurrentBranchName(name string, link *models.Superlink) {
 	if branch.Name == name {
 		return
 	}
+	if sealed(branch.Name, branch.Summary) {
+		log.Printf("Cannot update branch %d: %v", branch.ID, ErrLocked)
+		return
+	}
 
+	before := journal.BranchOf(branch)
 	branch.Name = name
 	branch.LastEdit = time.Now()
 	branch.Frequency += 1
 	a.Synced = false
 
 	edit := &editstack.Edit{ID: branch.ID, EditType: editstack.UpdateBranch}
-	if err := a.editMgr.AddEdit(edit, link); err != nil {
+	if err := a.trackEdit(edit, link, branch); err != nil {
 		log.Printf("Error tracking branch update: %v", err)
 	}
+	a.pushUpdate(editstack.UpdateBranch, before, link)
 }
 
 // SetCurrentBranchSummary updates the current branch's summary with edit tracking
@@ -236,6 +245,11 @@ func (a *App) SetCurrentBranchSummary(summary string, link *models.Superlink) {
 	if branch.Summary == summary {
 		return
 	}
+	if sealed(branch.Name, branch.Summary) {
+		log.Printf("Cannot update branch %d: %v", branch.ID, ErrLocked)
+		return
+	}
+	before := journal.BranchOf(branch)
 	branch.Summary = summary
 	lines := strings.Split(summary, "\n")
 	if len(lines) > 0 {
@@ -248,9 +262,10 @@ func (a *App) SetCurrentBranchSummary(summary string, link *models.Superlink) {
 	a.Synced = false
 
 	edit := &editstack.Edit{ID: branch.ID, EditType: editstack.UpdateBranch}
-	if err := a.editMgr.AddEdit(edit, link); err != nil {
+	if err := a.trackEdit(edit, link, branch); err != nil {
 		log.Printf("Error tracking branch update: %v", err)
 	}
+	a.pushUpdate(editstack.UpdateBranch, before, link)
 }
 
 // SetCurrentBranchLastEdit updates the LastEdit timestamp of the current branch to the current time.
@@ -281,14 +296,16 @@ func (a *App) ToggleCurrentBranchHighlight(link *models.Superlink) {
 		return
 	}
 
+	before := journal.BranchOf(branch)
 	branch.Highlight = !branch.Highlight
 	branch.UpdatedAt = time.Now()
 	a.Synced = false
 
 	edit := &editstack.Edit{ID: branch.ID, EditType: editstack.UpdateBranch}
-	if err := a.editMgr.AddEdit(edit, link); err != nil {
+	if err := a.trackEdit(edit, link, branch); err != nil {
 		log.Printf("Error tracking branch update: %v", err)
 	}
+	a.pushUpdate(editstack.UpdateBranch, before, link)
 }
 
 // ToggleCurrentBranchPrivate toggles the private status of the current branch
@@ -300,15 +317,22 @@ func (a *App) ToggleCurrentBranchPrivate(link *models.Superlink) {
 	if branch == nil {
 		return
 	}
+	// the text is sealed or opened as the entity becomes private or not, which needs the key
+	if !vault.Unlocked() {
+		log.Printf("Cannot toggle private of branch %d: %v", branch.ID, ErrLocked)
+		return
+	}
 
+	before := journal.BranchOf(branch)
 	branch.Private = !branch.Private
 	branch.UpdatedAt = time.Now()
 	a.Synced = false
 
 	edit := &editstack.Edit{ID: branch.ID, EditType: editstack.UpdateBranch}
-	if err := a.editMgr.AddEdit(edit, link); err != nil {
+	if err := a.trackEdit(edit, link, branch); err != nil {
 		log.Printf("Error tracking branch update: %v", err)
 	}
+	a.pushUpdate(editstack.UpdateBranch, before, link)
 }
 
 // =============================================================================
@@ -320,6 +344,18 @@ func (a *App) DeleteCurrentBranch(link *models.Superlink) {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
 
+	branch := a.getCurrentBranch()
+	if branch == nil {
+		return
+	}
+	c := a.newChange(editstack.DeleteBranch, link)
+	c.entity = branch
+	a.deleteCurrentBranch(link)
+	a.pushChange(c)
+}
+
+// deleteCurrentBranch is DeleteCurrentBranch for callers that hold the mutex.
+func (a *App) deleteCurrentBranch(link *models.Superlink) {
 	branch := a.getCurrentBranch()
 	if branch == nil {
 		return
@@ -333,10 +369,12 @@ func (a *App) DeleteCurrentBranch(link *models.Superlink) {
 	if exists && edit.EditType == editstack.CreateBranch {
 		// Branch was created but not yet synced - just discard it
 		a.editMgr.RemoveEdit(editstack.EntityBranch, branchID)
+		a.unshareBranchNotes(branch)
+		a.record(&editstack.Edit{ID: branchID, EditType: editstack.DeleteBranch}, link, branch)
 	} else if branchID != 0 {
 		// Branch exists in DB - mark for deletion
 		deleteEdit := &editstack.Edit{ID: branchID, EditType: editstack.DeleteBranch}
-		if err := a.editMgr.AddEdit(deleteEdit, link); err != nil {
+		if err := a.trackEdit(deleteEdit, link, branch); err != nil {
 			log.Printf("Error tracking branch deletion: %v", err)
 			return
 		}
@@ -2,11 +2,14 @@ package app
 
 import (
 	"log"
+	"slices"
 	"time"
 
 	editstack "github.com/haochend413/ntkpr/internal/app/editStack"
+	"github.com/haochend413/ntkpr/internal/app/journal"
+	"github.com/haochend413/ntkpr/internal/checklist"
 	"github.com/haochend413/ntkpr/internal/models"
-	"github.com/sergi/go-diff/diffmatchpatch"
+	"github.com/haochend413/ntkpr/internal/vault"
 )
 
 // current_note.go provides a controlled interface for accessing and modifying
@@ -27,7 +30,8 @@ func (a *App) getCurrentNote() *models.Note {
 // Getters - Read-only access to current note properties
 // =============================================================================
 
-// GetCurrentNoteContent returns the content of the current note, or empty string if none selected
+// GetCurrentNoteContent returns the content of the current note, or empty string if none selected.
+// Content that stays sealed while the vault is locked is returned as vault.Placeholder.
 func (a *App) GetCurrentNoteContent() string {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
@@ -36,7 +40,7 @@ func (a *App) GetCurrentNoteContent() string {
 	if note == nil {
 		return ""
 	}
-	return note.Content
+	return vault.Show(note.Content)
 }
 
 // GetCurrentNoteID returns the ID of the current note, or 0 if none selected
@@ -139,7 +143,7 @@ func (a *App) HasCurrentNote() bool {
 // =============================================================================
 
 // SetCurrentNoteContent updates the current note's content with edit tracking.
-// This function should automatically update diff. Maybe controlled with a signal.
+// History is recorded as revisions on sync, see GetNoteDiff.
 func (a *App) SetCurrentNoteContent(content string, link *models.Superlink) {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
@@ -149,30 +153,55 @@ func (a *App) SetCurrentNoteContent(content string, link *models.Superlink) {
 		return
 	}
 
+	a.setNoteContent(note, content, link)
+}
+
+// TickCurrentNote ticks the first open checkbox of the current note, or with done unset unticks the last ticked one,
+// see package checklist. It returns the checkbox, and false when there is none to change. The change is a content edit.
+func (a *App) TickCurrentNote(done bool, link *models.Superlink) (checklist.Item, bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	note := a.getCurrentNote()
+	if note == nil {
+		return checklist.Item{}, false
+	}
+	items := checklist.Items(note.Content)
+	if !done {
+		slices.Reverse(items)
+	}
+	for _, item := range items {
+		if item.Done != done {
+			a.setNoteContent(note, checklist.Set(note.Content, item.Box, done), link)
+			item.Done = done
+			return item, true
+		}
+	}
+	return checklist.Item{}, false
+}
+
+// setNoteContent writes content into a note and tracks it as an UpdateNote edit. Callers hold the mutex.
+func (a *App) setNoteContent(note *models.Note, content string, link *models.Superlink) {
 	// No-op if content hasn't changed
 	if note.Content == content {
 		return
 	}
+	if sealed(note.Content) {
+		log.Printf("Cannot update note %d: %v", note.ID, ErrLocked)
+		return
+	}
 
-	dmp := diffmatchpatch.New()
-	dmp.PatchMargin = 10
-	// f, _ := os.Create("app.log")
-	// fmt.Fprintln(f, "content:", content)
-	// fmt.Fprintln(f, "note content:", note.Content)
-	// fmt.Fprintln(f, "diff:", dmp.PatchToText(dmp.PatchMake(content, note.Content)))
-	// fmt.Fprintln(f, "diff:", dmp.DiffMain(content, note.Content, false))
-
-	// note.Diff = dmp.PatchToText((dmp.PatchMake(content, note.Content))) // might need to convert it to rune for chinese.
-	note.Diff = dmp.DiffPrettyText((dmp.DiffMain(note.Content, content, false)))
+	before := journal.NoteOf(note)
 	note.Content = content
 	note.Frequency++
 	note.LastEdit = time.Now()
 	a.Synced = false
 
 	edit := &editstack.Edit{ID: note.ID, EditType: editstack.UpdateNote}
-	if err := a.editMgr.AddEdit(edit, link); err != nil {
+	if err := a.trackEdit(edit, link, note); err != nil {
 		log.Printf("Error tracking note update: %v", err)
 	}
+	a.pushUpdate(editstack.UpdateNote, before, link)
 }
 
 // SetCurrentNoteLastEdit updates the LastEdit timestamp of the current note to the current time.
@@ -203,14 +232,16 @@ func (a *App) ToggleCurrentNoteHighlight(link *models.Superlink) {
 		return
 	}
 
+	before := journal.NoteOf(note)
 	note.Highlight = !note.Highlight
 	note.UpdatedAt = time.Now()
 	a.Synced = false
 
 	edit := &editstack.Edit{ID: note.ID, EditType: editstack.UpdateNote}
-	if err := a.editMgr.AddEdit(edit, link); err != nil {
+	if err := a.trackEdit(edit, link, note); err != nil {
 		log.Printf("Error tracking note update: %v", err)
 	}
+	a.pushUpdate(editstack.UpdateNote, before, link)
 }
 
 // ToggleCurrentNotePrivate toggles the private status of the current note
@@ -222,15 +253,22 @@ func (a *App) ToggleCurrentNotePrivate(link *models.Superlink) {
 	if note == nil {
 		return
 	}
+	// the text is sealed or opened as the entity becomes private or not, which needs the key
+	if !vault.Unlocked() {
+		log.Printf("Cannot toggle private of note %d: %v", note.ID, ErrLocked)
+		return
+	}
 
+	before := journal.NoteOf(note)
 	note.Private = !note.Private
 	note.UpdatedAt = time.Now()
 	a.Synced = false
 
 	edit := &editstack.Edit{ID: note.ID, EditType: editstack.UpdateNote}
-	if err := a.editMgr.AddEdit(edit, link); err != nil {
+	if err := a.trackEdit(edit, link, note); err != nil {
 		log.Printf("Error tracking note update: %v", err)
 	}
+	a.pushUpdate(editstack.UpdateNote, before, link)
 }
 
 // =============================================================================
@@ -243,6 +281,18 @@ func (a *App) DeleteCurrentNote(link *models.Superlink) {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
 
+	note := a.getCurrentNote()
+	if note == nil {
+		return
+	}
+	c := a.newChange(editstack.DeleteNote, link)
+	c.entity = note
+	a.deleteCurrentNote(link)
+	a.pushChange(c)
+}
+
+// deleteCurrentNote is DeleteCurrentNote for callers that hold the mutex.
+func (a *App) deleteCurrentNote(link *models.Superlink) {
 	note := a.getCurrentNote()
 	if note == nil {
 		return
@@ -254,9 +304,10 @@ func (a *App) DeleteCurrentNote(link *models.Superlink) {
 	edit, exists := a.editMgr.GetEdit(editstack.EntityNote, noteID)
 	if exists && edit.EditType == editstack.CreateNote {
 		a.editMgr.RemoveEdit(editstack.EntityNote, noteID)
+		a.record(&editstack.Edit{ID: noteID, EditType: editstack.DeleteNote}, link, note)
 	} else if noteID != 0 {
 		deleteEdit := &editstack.Edit{ID: noteID, EditType: editstack.DeleteNote}
-		if err := a.editMgr.AddEdit(deleteEdit, link); err != nil {
+		if err := a.trackEdit(deleteEdit, link, note); err != nil {
 			log.Printf("Error tracking note deletion: %v", err)
 			return
 		}
@@ -264,14 +315,7 @@ func (a *App) DeleteCurrentNote(link *models.Superlink) {
 
 	// Mark branch as updated to sync the association change
 	// Only if branch is not pending (not being created)
-	if branch != nil && branch.ID != 0 {
-		branchEdit, branchExists := a.editMgr.GetEdit(editstack.EntityBranch, branch.ID)
-		if !branchExists || branchEdit.EditType != editstack.CreateBranch {
-			// Branch is not pending creation, safe to mark for update
-			updateEdit := &editstack.Edit{ID: branch.ID, EditType: editstack.UpdateBranch}
-			a.editMgr.AddEdit(updateEdit, link) // Ignore error - branch might already be marked
-		}
-	}
+	a.touchBranch(branch, link)
 
 	// Find the index of the note in the active note list
 	notes := a.dataMgr.GetActiveNoteList()
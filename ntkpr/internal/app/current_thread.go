@@ -6,7 +6,9 @@ import (
 	"time"
 
 	editstack "github.com/haochend413/ntkpr/internal/app/editStack"
+	"github.com/haochend413/ntkpr/internal/app/journal"
 	"github.com/haochend413/ntkpr/internal/models"
+	"github.com/haochend413/ntkpr/internal/vault"
 )
 
 // current_thread.go provides a controlled interface for accessing and modifying
@@ -39,7 +41,8 @@ func (a *App) GetCurrentThreadID() uint {
 	return thread.ID
 }
 
-// GetCurrentThreadName returns the name of the current thread, or empty string if none selected
+// GetCurrentThreadName returns the name of the current thread, or empty string if none selected.
+// A name that stays sealed while the vault is locked is returned as vault.Placeholder, and so is the summary.
 func (a *App) GetCurrentThreadName() string {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
@@ -48,7 +51,7 @@ func (a *App) GetCurrentThreadName() string {
 	if thread == nil {
 		return ""
 	}
-	return thread.Name
+	return vault.Show(thread.Name)
 }
 
 func (a *App) GetCurrentThreadSummary() string {
@@ -59,7 +62,7 @@ func (a *App) GetCurrentThreadSummary() string {
 	if thread == nil {
 		return ""
 	}
-	return thread.Summary
+	return vault.Show(thread.Summary)
 }
 
 // GetCurrentThreadHighlight returns whether the current thread is highlighted
@@ -95,7 +98,7 @@ func (a *App) GetCurrentThreadBranchCount() int {
 	if thread == nil {
 		return 0
 	}
-	return len(thread.Branches)
+	return a.dataMgr.BranchCount(thread)
 }
 
 // GetCurrentThreadBranches returns a copy of the current thread's branches to prevent external mutation
@@ -167,7 +170,7 @@ func (a *App) IncrementCurrentThreadFrequency(link *models.Superlink) {
 	a.Synced = false
 
 	edit := &editstack.Edit{ID: thread.ID, EditType: editstack.UpdateThread}
-	if err := a.editMgr.AddEdit(edit, link); err != nil {
+	if err := a.trackEdit(edit, link, thread); err != nil {
 		log.Printf("Error tracking thread frequency increment: %v", err)
 	}
 }
@@ -210,16 +213,22 @@ func (a *App) SetCurrentThreadName(name string, link *models.Superlink) {
 	if thread.Name == name {
 		return
 	}
+	if sealed(thread.Name, thread.Summary) {
+		log.Printf("Cannot update thread %d: %v", thread.ID, ErrLocked)
+		return
+	}
 
+	before := journal.ThreadOf(thread)
 	thread.Name = name
 	thread.LastEdit = time.Now()
 	thread.Frequency += 1
 	a.Synced = false
 
 	edit := &editstack.Edit{ID: thread.ID, EditType: editstack.UpdateThread}
-	if err := a.editMgr.AddEdit(edit, link); err != nil {
+	if err := a.trackEdit(edit, link, thread); err != nil {
 		log.Printf("Error tracking thread update: %v", err)
 	}
+	a.pushUpdate(editstack.UpdateThread, before, link)
 }
 
 // SetCurrentThreadSummary updates the current thread's summary with edit tracking
@@ -236,7 +245,12 @@ func (a *App) SetCurrentThreadSummary(summary string, link *models.Superlink) {
 	if thread.Summary == summary {
 		return
 	}
+	if sealed(thread.Name, thread.Summary) {
+		log.Printf("Cannot update thread %d: %v", thread.ID, ErrLocked)
+		return
+	}
 
+	before := journal.ThreadOf(thread)
 	thread.Summary = summary
 	lines := strings.Split(summary, "\n")
 	if len(lines) > 0 {
@@ -249,9 +263,10 @@ func (a *App) SetCurrentThreadSummary(summary string, link *models.Superlink) {
 	a.Synced = false
 
 	edit := &editstack.Edit{ID: thread.ID, EditType: editstack.UpdateThread}
-	if err := a.editMgr.AddEdit(edit, link); err != nil {
+	if err := a.trackEdit(edit, link, thread); err != nil {
 		log.Printf("Error tracking thread update: %v", err)
 	}
+	a.pushUpdate(editstack.UpdateThread, before, link)
 }
 
 // SetCurrentThreadLastEdit updates the LastEdit timestamp of the current thread to the current time.
@@ -282,14 +297,16 @@ func (a *App) ToggleCurrentThreadHighlight(link *models.Superlink) {
 		return
 	}
 
+	before := journal.ThreadOf(thread)
 	thread.Highlight = !thread.Highlight
 	thread.UpdatedAt = time.Now()
 	a.Synced = false
 
 	edit := &editstack.Edit{ID: thread.ID, EditType: editstack.UpdateThread}
-	if err := a.editMgr.AddEdit(edit, link); err != nil {
+	if err := a.trackEdit(edit, link, thread); err != nil {
 		log.Printf("Error tracking thread update: %v", err)
 	}
+	a.pushUpdate(editstack.UpdateThread, before, link)
 }
 
 // ToggleCurrentThreadPrivate toggles the private status of the current thread
@@ -301,15 +318,22 @@ func (a *App) ToggleCurrentThreadPrivate(link *models.Superlink) {
 	if thread == nil {
 		return
 	}
+	// the text is sealed or opened as the entity becomes private or not, which needs the key
+	if !vault.Unlocked() {
+		log.Printf("Cannot toggle private of thread %d: %v", thread.ID, ErrLocked)
+		return
+	}
 
+	before := journal.ThreadOf(thread)
 	thread.Private = !thread.Private
 	thread.UpdatedAt = time.Now()
 	a.Synced = false
 
 	edit := &editstack.Edit{ID: thread.ID, EditType: editstack.UpdateThread}
-	if err := a.editMgr.AddEdit(edit, link); err != nil {
+	if err := a.trackEdit(edit, link, thread); err != nil {
 		log.Printf("Error tracking thread update: %v", err)
 	}
+	a.pushUpdate(editstack.UpdateThread, before, link)
 }
 
 // =============================================================================
@@ -321,6 +345,18 @@ func (a *App) DeleteCurrentThread(link *models.Superlink) {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
 
+	thread := a.getCurrentThread()
+	if thread == nil {
+		return
+	}
+	c := a.newChange(editstack.DeleteThread, link)
+	c.entity = thread
+	a.deleteCurrentThread(link)
+	a.pushChange(c)
+}
+
+// deleteCurrentThread is DeleteCurrentThread for callers that hold the mutex.
+func (a *App) deleteCurrentThread(link *models.Superlink) {
 	thread := a.getCurrentThread()
 	if thread == nil {
 		return
@@ -334,10 +370,11 @@ func (a *App) DeleteCurrentThread(link *models.Superlink) {
 	if exists && edit.EditType == editstack.CreateThread {
 		// Thread was created but not yet synced - just discard it
 		a.editMgr.RemoveEdit(editstack.EntityThread, threadID)
+		a.record(&editstack.Edit{ID: threadID, EditType: editstack.DeleteThread}, link, thread)
 	} else if threadID != 0 {
 		// Thread exists in DB - mark for deletion (will cascade to branches)
 		deleteEdit := &editstack.Edit{ID: threadID, EditType: editstack.DeleteThread}
-		if err := a.editMgr.AddEdit(deleteEdit, link); err != nil {
+		if err := a.trackEdit(deleteEdit, link, thread); err != nil {
 			log.Printf("Error tracking thread deletion: %v", err)
 			return
 		}
@@ -1,6 +1,13 @@
 package data
 
-import "github.com/haochend413/ntkpr/internal/models"
+import (
+	"sort"
+
+	editstack "github.com/haochend413/ntkpr/internal/app/editStack"
+	"github.com/haochend413/ntkpr/internal/db"
+	"github.com/haochend413/ntkpr/internal/models"
+	"github.com/haochend413/ntkpr/internal/vault"
+)
 
 // DataMgr should handle the switching logic between threads, branches and notes. It keeps record of all threads, and exposing current threads, branches and notes.
 // DataMgr should only expose 1 threadlist, 1 branchlist, and 1 notelist. ContextMgr will Demonstrate based on that. THe optimization and storage should happen at this level.
@@ -24,9 +31,13 @@ type DataMgr struct {
 	threadIndexByID map[uint]int
 	branchIndexByID map[uint]int
 	noteIndexByID   map[uint]int
+	lazy            *lazyState // nil when everything was loaded up front, see lazy.go
 }
 
-func NewDataMgr(threads []*models.Thread) *DataMgr {
+// NewDataMgr creates a DataMgr over threads. With a loader, threads are only headers:
+// branches are loaded when their thread becomes active, and notes a page at a time when their branch does.
+// Without one, threads must come with all their branches and notes.
+func NewDataMgr(threads []*models.Thread, loader Loader) *DataMgr {
 	dm := &DataMgr{
 		threads:         threads,
 		activeThreadPtr: 0,
@@ -36,26 +47,15 @@ func NewDataMgr(threads []*models.Thread) *DataMgr {
 		branchIndexByID: make(map[uint]int),
 		noteIndexByID:   make(map[uint]int),
 	}
+	if loader != nil {
+		dm.lazy = newLazyState(loader)
+	}
 
 	dm.rebuildThreadIndex()
 
 	// Initialize branches and notes from first thread if available
 	if len(threads) > 0 {
-		dm.branches = threads[0].Branches
-		dm.activeThreadID = threads[0].ID
-		dm.rebuildBranchIndex()
-		if len(dm.branches) > 0 {
-			dm.notes = dm.branches[0].Notes
-			dm.activeBranchID = dm.branches[0].ID
-			dm.rebuildNoteIndex()
-			if len(dm.notes) > 0 {
-				dm.activeNoteID = dm.notes[0].ID
-			}
-		} else {
-			dm.notes = []*models.Note{}
-			dm.activeBranchID = 0
-			dm.activeNoteID = 0
-		}
+		dm.SwitchActiveThreadByID(threads[0].ID)
 	} else {
 		dm.branches = []*models.Branch{}
 		dm.notes = []*models.Note{}
@@ -295,6 +295,7 @@ func (dm *DataMgr) SwitchActiveThreadByID(threadID uint) bool {
 
 	dm.activeThreadPtr = idx
 	dm.activeThreadID = threadID
+	dm.loadBranches(dm.threads[idx])
 	dm.branches = dm.threads[idx].Branches
 	dm.rebuildBranchIndex()
 
@@ -310,6 +311,7 @@ func (dm *DataMgr) SwitchActiveThreadByID(threadID uint) bool {
 
 	dm.activeBranchPtr = 0
 	dm.activeBranchID = dm.branches[0].ID
+	dm.loadNotes(dm.branches[0])
 	dm.notes = dm.branches[0].Notes
 	dm.rebuildNoteIndex()
 
@@ -362,6 +364,7 @@ func (dm *DataMgr) SwitchActiveBranchByID(branchID uint) bool {
 
 	dm.activeBranchPtr = idx
 	dm.activeBranchID = branchID
+	dm.loadNotes(dm.branches[idx])
 	dm.notes = dm.branches[idx].Notes
 	dm.rebuildNoteIndex()
 
@@ -398,6 +401,13 @@ func (dm *DataMgr) SwitchActiveNoteByID(noteID uint) bool {
 	}
 
 	idx, ok := dm.noteIndexByID[noteID]
+	// the note may be on a page that is not loaded yet
+	for !ok && dm.HasMoreNotes() {
+		if dm.loadNextNotes() == 0 {
+			break
+		}
+		idx, ok = dm.noteIndexByID[noteID]
+	}
 	if !ok {
 		return false
 	}
@@ -533,6 +543,90 @@ func (dm *DataMgr) RemoveNote(index int) {
 	dm.SwitchActiveNote(index)
 }
 
+// ListNote adds a note to a branch, which need not be active, without switching to it.
+// A branch that lists a copy of the note already lists n instead, see ReplaceNote.
+func (dm *DataMgr) ListNote(b *models.Branch, n *models.Note) {
+	if b == nil || n == nil || dm.ReplaceNote(b, n) {
+		return
+	}
+	b.Notes = append(b.Notes, n)
+	if b.ID == dm.activeBranchID {
+		dm.notes = b.Notes
+		dm.rebuildNoteIndex()
+	}
+}
+
+// ReplaceNote makes a branch list n instead of the copy of it that was loaded with the branch, so that every branch
+// shares one note. It reports whether the branch listed the note; a note on a page that is not loaded yet is left alone.
+func (dm *DataMgr) ReplaceNote(b *models.Branch, n *models.Note) bool {
+	listed := false
+	for i, x := range b.Notes {
+		if x.ID == n.ID {
+			b.Notes[i] = n
+			listed = true
+		}
+	}
+	if listed && b.ID == dm.activeBranchID {
+		dm.notes = b.Notes
+		dm.rebuildNoteIndex()
+	}
+	return listed
+}
+
+// OrderNotes puts the notes of a branch, which need not be active, in the order of ids.
+// Notes that ids leaves out keep their order after the others.
+func (dm *DataMgr) OrderNotes(b *models.Branch, ids []uint) {
+	if b == nil {
+		return
+	}
+	rank := make(map[uint]int, len(ids))
+	for i, id := range ids {
+		rank[id] = i
+	}
+	sort.SliceStable(b.Notes, func(i, j int) bool {
+		ri, ok := rank[b.Notes[i].ID]
+		if !ok {
+			ri = len(ids)
+		}
+		rj, ok := rank[b.Notes[j].ID]
+		if !ok {
+			rj = len(ids)
+		}
+		return ri < rj
+	})
+	dm.reordered(b)
+}
+
+// reordered shows the new order of the notes of a branch, keeping the active note.
+func (dm *DataMgr) reordered(b *models.Branch) {
+	if b.ID != dm.activeBranchID {
+		return
+	}
+	dm.notes = b.Notes
+	dm.rebuildNoteIndex()
+	if idx, ok := dm.noteIndexByID[dm.activeNoteID]; ok {
+		dm.activeNotePtr = idx
+	}
+}
+
+// UnlistNote removes a note from a branch, which need not be active. The active note moves on like with RemoveNote.
+func (dm *DataMgr) UnlistNote(b *models.Branch, noteID uint) {
+	if b == nil {
+		return
+	}
+	for i, n := range b.Notes {
+		if n.ID != noteID {
+			continue
+		}
+		if b.ID == dm.activeBranchID {
+			dm.RemoveNote(i)
+			return
+		}
+		b.Notes = append(b.Notes[:i], b.Notes[i+1:]...)
+		return
+	}
+}
+
 // FindThreadByID finds a thread by ID across all threads
 func (dm *DataMgr) FindThreadByID(id uint) *models.Thread {
 	for _, t := range dm.threads {
@@ -596,3 +690,167 @@ func (dm *DataMgr) FindNoteByLink(link models.Superlink) *models.Note {
 
 	return nil
 }
+
+// OpenSealed opens the sealed text of the loaded threads, branches and notes, once the vault is unlocked, see package vault.
+func (dm *DataMgr) OpenSealed() {
+	for _, t := range dm.threads {
+		t.Name, t.Summary = vault.Open(t.Name), vault.Open(t.Summary)
+		for _, b := range t.Branches {
+			b.Name, b.Summary = vault.Open(b.Name), vault.Open(b.Summary)
+			for _, n := range b.Notes {
+				n.Content = vault.Open(n.Content)
+			}
+		}
+	}
+}
+
+// Snapshot copies the loaded threads with their branches and notes, for a sync that runs next to the UI.
+// The copies link to each other like the originals, and can be written by SyncData without touching the loaded data.
+func (dm *DataMgr) Snapshot() []*models.Thread {
+	branchCopies := make(map[uint]*models.Branch)
+	noteCopies := make(map[uint]*models.Note)
+	threads := make([]*models.Thread, len(dm.threads))
+	for i, t := range dm.threads {
+		tc := *t
+		tc.Branches = make([]*models.Branch, len(t.Branches))
+		for j, b := range t.Branches {
+			bc := *b
+			bc.Notes = make([]*models.Note, len(b.Notes))
+			branchCopies[b.ID] = &bc
+			tc.Branches[j] = &bc
+		}
+		threads[i] = &tc
+	}
+	for _, t := range dm.threads {
+		for _, b := range t.Branches {
+			bc := branchCopies[b.ID]
+			for k, n := range b.Notes {
+				nc, ok := noteCopies[n.ID]
+				if !ok {
+					c := *n
+					c.Branches = make([]*models.Branch, len(n.Branches))
+					for l, nb := range n.Branches {
+						if x, ok := branchCopies[nb.ID]; ok {
+							c.Branches[l] = x
+						} else {
+							x := *nb
+							x.Notes = nil
+							c.Branches[l] = &x
+						}
+					}
+					nc = &c
+					noteCopies[n.ID] = nc
+				}
+				bc.Notes[k] = nc
+			}
+		}
+	}
+	return threads
+}
+
+// ApplySync patches the result of a sync into the loaded data, instead of reloading the database.
+// Entities created before the sync take the IDs the database assigned. Synced entities keep their pointers
+// and take over the stored fields, unless pending reports an edit made while the sync ran, which is kept.
+// Deleted entities are dropped from every list, unless they were put back while the sync ran, and active entities are kept.
+// pending is called with an editstack entity type and an ID, and may be nil.
+func (dm *DataMgr) ApplySync(res db.SyncResult, pending func(entity string, id uint) bool) {
+	if pending == nil {
+		pending = func(string, uint) bool { return false }
+	}
+	threads := make(map[uint]*models.Thread, len(res.Threads))
+	for _, t := range res.Threads {
+		threads[t.ID] = t
+	}
+	branches := make(map[uint]*models.Branch, len(res.Branches))
+	for _, b := range res.Branches {
+		branches[b.ID] = b
+	}
+	notes := make(map[uint]*models.Note, len(res.Notes))
+	for _, n := range res.Notes {
+		notes[n.ID] = n
+	}
+	deletedThreads := idSet(res.DeletedThreads)
+	deletedBranches := idSet(res.DeletedBranches)
+	deletedNotes := idSet(res.DeletedNotes)
+
+	dm.remapIDs(res.IDs)
+
+	kept := dm.threads[:0]
+	for _, t := range dm.threads {
+		if deletedThreads[t.ID] && !pending(editstack.EntityThread, t.ID) {
+			continue
+		}
+		if stored, ok := threads[t.ID]; ok && !pending(editstack.EntityThread, t.ID) {
+			list := t.Branches
+			*t = *stored
+			t.Branches = list
+		}
+		keptBranches := t.Branches[:0]
+		for _, b := range t.Branches {
+			if deletedBranches[b.ID] && !pending(editstack.EntityBranch, b.ID) {
+				continue
+			}
+			if stored, ok := branches[b.ID]; ok && !pending(editstack.EntityBranch, b.ID) {
+				list := b.Notes
+				*b = *stored
+				b.Notes = list
+			}
+			keptNotes := b.Notes[:0]
+			for _, n := range b.Notes {
+				if deletedNotes[n.ID] && !pending(editstack.EntityNote, n.ID) {
+					continue
+				}
+				if stored, ok := notes[n.ID]; ok && !pending(editstack.EntityNote, n.ID) {
+					list := n.Branches
+					*n = *stored
+					n.Branches = list
+				}
+				keptNotes = append(keptNotes, n)
+			}
+			b.Notes = keptNotes
+			keptBranches = append(keptBranches, b)
+		}
+		t.Branches = keptBranches
+		kept = append(kept, t)
+	}
+
+	threadID := res.IDs.Thread(dm.activeThreadID)
+	branchID := res.IDs.Branch(dm.activeBranchID)
+	noteID := res.IDs.Note(dm.activeNoteID)
+	dm.RefreshDataByID(kept, &threadID, &branchID, &noteID)
+}
+
+// remapIDs gives the loaded entities created before a sync the IDs the database assigned them.
+// Notes are listed in every branch they are in, so each is remapped once.
+func (dm *DataMgr) remapIDs(ids db.IDRemap) {
+	if len(ids.Threads)+len(ids.Branches)+len(ids.Notes) == 0 {
+		return
+	}
+	done := make(map[*models.Note]bool)
+	for _, t := range dm.threads {
+		t.ID = ids.Thread(t.ID)
+		for _, b := range t.Branches {
+			b.ID = ids.Branch(b.ID)
+			b.ThreadID = ids.Thread(b.ThreadID)
+			b.ForkBranchID = ids.Branch(b.ForkBranchID)
+			b.ForkNoteID = ids.Note(b.ForkNoteID)
+			for _, n := range b.Notes {
+				if done[n] {
+					continue
+				}
+				done[n] = true
+				n.ID = ids.Note(n.ID)
+				n.ThreadID = ids.Thread(n.ThreadID)
+			}
+		}
+	}
+	dm.remapLoaded(ids)
+}
+
+func idSet(ids []uint) map[uint]bool {
+	set := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set
+}
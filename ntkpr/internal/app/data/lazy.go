@@ -0,0 +1,459 @@
+package data
+
+import (
+	"log"
+	"slices"
+
+	editstack "github.com/haochend413/ntkpr/internal/app/editStack"
+	"github.com/haochend413/ntkpr/internal/checklist"
+	"github.com/haochend413/ntkpr/internal/db"
+	"github.com/haochend413/ntkpr/internal/models"
+)
+
+// lazy.go loads branches and notes on demand, so large journals open without reading everything.
+// Threads are loaded as headers. The branches of a thread are loaded when it first becomes active,
+// and the notes of a branch one page at a time: the first page when the branch becomes active, the next ones on request.
+// Stored counts are read up front, so tables can show how many branches and notes there are before loading them,
+// and how many of their checkboxes are ticked.
+// Only the branches used last keep their notes loaded; the notes of the others are dropped, and read again when they become active.
+
+// NotePageSize is how many notes are loaded per page.
+const NotePageSize = 200
+
+// LoadedBranchLimit is how many branches keep their notes loaded. Beyond it, the notes of the branch that was active
+// the longest ago are dropped, unless they or the branch have edits to sync, see SetPending.
+const LoadedBranchLimit = 16
+
+// Loader reads what DataMgr has not loaded yet. It is implemented by db.DB.
+type Loader interface {
+	// BranchCounts returns the number of live branches of every thread.
+	BranchCounts() (map[uint]int, error)
+	// LoadBranches returns the live branches of a thread, without their notes.
+	LoadBranches(threadID uint) ([]*models.Branch, error)
+	// NoteCounts returns the number of live notes of every branch of a thread.
+	NoteCounts(threadID uint) (map[uint]int, error)
+	// LoadNotes returns up to limit live notes of a branch after a cursor, in their order in the branch, with their branches,
+	// and the cursor the next page starts after.
+	LoadNotes(branchID uint, after db.NoteCursor, limit int) ([]*models.Note, db.NoteCursor, error)
+	// ThreadChecks returns the checkboxes of the live notes of every thread.
+	ThreadChecks() (map[uint]checklist.Counts, error)
+	// ThreadChecksOf returns the checkboxes of the live notes of a thread.
+	ThreadChecksOf(threadID uint) (checklist.Counts, error)
+	// BranchChecks returns the checkboxes of the live notes of every branch of a thread.
+	BranchChecks(threadID uint) (map[uint]checklist.Counts, error)
+}
+
+// lazyState tracks what has been loaded.
+type lazyState struct {
+	loader         Loader
+	branchCounts   map[uint]int           // thread ID -> stored branches
+	branchesLoaded map[uint]bool          // thread ID -> branches loaded
+	noteCounts     map[uint]int           // branch ID -> stored notes, when its thread was loaded
+	notesLoaded    map[uint]int           // branch ID -> stored notes loaded so far
+	notesAfter     map[uint]db.NoteCursor // branch ID -> end of the last page loaded, the next page starts after it
+	notesComplete  map[uint]bool          // branch ID -> every stored note loaded
+
+	// Checkboxes work like the counts: what was stored, less what was loaded of it, plus what is listed now.
+	threadChecks   map[uint]checklist.Counts // thread ID -> checkboxes of its stored notes
+	threadLoaded   map[uint]checklist.Counts // thread ID -> checkboxes of its stored notes loaded so far
+	branchChecks   map[uint]checklist.Counts // branch ID -> checkboxes of its stored notes, when its thread was loaded
+	branchLoaded   map[uint]checklist.Counts // branch ID -> checkboxes of its stored notes loaded so far
+	notesOfThreads map[uint]uint             // note ID -> thread ID, for the stored notes counted in threadLoaded
+
+	recent  []uint                            // IDs of the branches whose notes are loaded, the one active the longest ago first
+	pending func(entity string, id uint) bool // reports the entities with edits to sync, see SetPending
+}
+
+func newLazyState(loader Loader) *lazyState {
+	l := &lazyState{
+		loader:         loader,
+		branchCounts:   make(map[uint]int),
+		branchesLoaded: make(map[uint]bool),
+		noteCounts:     make(map[uint]int),
+		notesLoaded:    make(map[uint]int),
+		notesAfter:     make(map[uint]db.NoteCursor),
+		notesComplete:  make(map[uint]bool),
+		threadChecks:   make(map[uint]checklist.Counts),
+		threadLoaded:   make(map[uint]checklist.Counts),
+		branchChecks:   make(map[uint]checklist.Counts),
+		branchLoaded:   make(map[uint]checklist.Counts),
+		notesOfThreads: make(map[uint]uint),
+	}
+	if checks, err := loader.ThreadChecks(); err != nil {
+		log.Printf("Error counting checkboxes: %v", err)
+	} else {
+		l.threadChecks = checks
+	}
+	counts, err := loader.BranchCounts()
+	if err != nil {
+		log.Printf("Error counting branches: %v", err)
+		return l
+	}
+	l.branchCounts = counts
+	return l
+}
+
+// loadBranches loads the branches of a thread, unless they are loaded already.
+// Branches created locally before are kept after the loaded ones.
+func (dm *DataMgr) loadBranches(t *models.Thread) {
+	l := dm.lazy
+	if l == nil || l.branchesLoaded[t.ID] || models.IsTempID(t.ID) {
+		return
+	}
+	branches, err := l.loader.LoadBranches(t.ID)
+	if err != nil {
+		log.Printf("Error loading branches of thread %d: %v", t.ID, err)
+		return
+	}
+	counts, err := l.loader.NoteCounts(t.ID)
+	if err != nil {
+		log.Printf("Error counting notes of thread %d: %v", t.ID, err)
+	}
+	for id, n := range counts {
+		l.noteCounts[id] = n
+	}
+	checks, err := l.loader.BranchChecks(t.ID)
+	if err != nil {
+		log.Printf("Error counting checkboxes of thread %d: %v", t.ID, err)
+	}
+	for id, c := range checks {
+		l.branchChecks[id] = c
+	}
+	// the branches are read anew, so their notes are too, and the thread may have been synced since the counts were read
+	dm.forgetChecks(t.ID)
+	l.branchesLoaded[t.ID] = true
+
+	seen := make(map[uint]bool, len(branches))
+	for _, b := range branches {
+		seen[b.ID] = true
+	}
+	for _, b := range t.Branches {
+		if !seen[b.ID] {
+			branches = append(branches, b)
+		}
+	}
+	t.Branches = branches
+}
+
+// loadNotes loads the first page of notes of a branch, unless some are loaded already.
+func (dm *DataMgr) loadNotes(b *models.Branch) {
+	l := dm.lazy
+	if l == nil {
+		return
+	}
+	dm.keepNotes(b)
+	if l.notesComplete[b.ID] {
+		return
+	}
+	if _, started := l.notesAfter[b.ID]; started {
+		return
+	}
+	if models.IsTempID(b.ID) {
+		l.notesComplete[b.ID] = true
+		return
+	}
+	dm.loadNotePage(b)
+}
+
+// loadNotePage loads the next page of notes of a branch and returns how many notes were added.
+// The page goes after the notes of the pages before it, and before the notes listed here since, such as new ones.
+// Notes that are already listed, such as notes created here and synced since, are skipped.
+func (dm *DataMgr) loadNotePage(b *models.Branch) int {
+	l := dm.lazy
+	after, started := l.notesAfter[b.ID]
+	notes, next, err := l.loader.LoadNotes(b.ID, after, NotePageSize)
+	if err != nil {
+		log.Printf("Error loading notes of branch %d: %v", b.ID, err)
+		return 0
+	}
+	if len(notes) < NotePageSize {
+		l.notesComplete[b.ID] = true
+	}
+	l.notesAfter[b.ID] = next
+	if len(notes) == 0 {
+		return 0
+	}
+
+	listed := make(map[uint]bool, len(b.Notes))
+	for _, n := range b.Notes {
+		listed[n.ID] = true
+	}
+	// notes listed by other loaded branches of the thread are shared with them, so that an edit made in one shows in all
+	shared := dm.notesOfThread(b)
+	page := make([]*models.Note, 0, len(notes))
+	for _, n := range notes {
+		if listed[n.ID] {
+			continue
+		}
+		if s, ok := shared[n.ID]; ok {
+			n = s
+		}
+		page = append(page, n)
+	}
+	at := 0
+	if started {
+		at = pageEnd(b.Notes, after.NoteID)
+	}
+	b.Notes = append(b.Notes[:at], append(page, b.Notes[at:]...)...)
+	l.notesLoaded[b.ID] += len(page)
+	for _, n := range page {
+		c := n.Checks()
+		l.branchLoaded[b.ID] = l.branchLoaded[b.ID].Add(c)
+		// a note listed by several branches is loaded once for each, and counts once for its thread
+		if _, seen := l.notesOfThreads[n.ID]; !seen {
+			l.notesOfThreads[n.ID] = n.ThreadID
+			l.threadLoaded[n.ThreadID] = l.threadLoaded[n.ThreadID].Add(c)
+		}
+	}
+	return len(page)
+}
+
+// remapLoaded keeps the threads and branches created here loaded once a sync gave them their IDs.
+// They hold nothing but what was created here, which is listed already; reading them again would replace what is listed,
+// and drop what was created in them while the sync ran.
+func (dm *DataMgr) remapLoaded(ids db.IDRemap) {
+	l := dm.lazy
+	if l == nil {
+		return
+	}
+	for _, id := range ids.Threads {
+		l.branchesLoaded[id] = true
+	}
+	for tempID, id := range ids.Branches {
+		delete(l.notesComplete, tempID)
+		l.notesComplete[id] = true
+	}
+}
+
+// notesOfThread returns the notes the other branches of the thread of b list, by ID.
+func (dm *DataMgr) notesOfThread(b *models.Branch) map[uint]*models.Note {
+	notes := make(map[uint]*models.Note)
+	idx, ok := dm.threadIndexByID[b.ThreadID]
+	if !ok {
+		return notes
+	}
+	for _, other := range dm.threads[idx].Branches {
+		if other == b {
+			continue
+		}
+		for _, n := range other.Notes {
+			notes[n.ID] = n
+		}
+	}
+	return notes
+}
+
+// SetPending tells which entities have edits to sync, as an editstack entity type and an ID.
+// The branches that list them, or have edits themselves, keep their notes loaded.
+func (dm *DataMgr) SetPending(pending func(entity string, id uint) bool) {
+	if dm.lazy != nil {
+		dm.lazy.pending = pending
+	}
+}
+
+// keepNotes marks b as the branch used last, and drops the notes of the branches used the longest ago
+// beyond LoadedBranchLimit.
+func (dm *DataMgr) keepNotes(b *models.Branch) {
+	l := dm.lazy
+	l.recent = slices.DeleteFunc(l.recent, func(id uint) bool { return id == b.ID })
+	l.recent = append(l.recent, b.ID)
+	for i := 0; len(l.recent) > LoadedBranchLimit && i < len(l.recent)-1; {
+		// a branch that is no longer listed, such as a deleted one, has nothing to drop
+		if old := dm.FindBranchByID(l.recent[i]); old != nil && old.ID != dm.activeBranchID && !dm.dropNotes(old) {
+			i++
+			continue
+		}
+		l.recent = slices.Delete(l.recent, i, i+1)
+	}
+}
+
+// dropNotes drops the loaded notes of a branch, unless the branch or one of them has edits to sync, and reports whether it did.
+// The counts stay what they were: they go back to the stored ones less what is loaded, see NoteCount and BranchChecks.
+func (dm *DataMgr) dropNotes(b *models.Branch) bool {
+	l := dm.lazy
+	pending := l.pending
+	if pending == nil {
+		pending = func(string, uint) bool { return false }
+	}
+	if models.IsTempID(b.ID) || pending(editstack.EntityBranch, b.ID) {
+		return false
+	}
+	for _, n := range b.Notes {
+		if models.IsTempID(n.ID) || pending(editstack.EntityNote, n.ID) {
+			return false
+		}
+	}
+
+	shared := dm.notesOfThread(b)
+	var c checklist.Counts
+	for _, n := range b.Notes {
+		c = c.Add(n.Checks())
+		// a note other branches still list stays counted as loaded for its thread
+		if threadID, counted := l.notesOfThreads[n.ID]; counted && shared[n.ID] == nil {
+			l.threadLoaded[threadID] = l.threadLoaded[threadID].Sub(n.Checks())
+			delete(l.notesOfThreads, n.ID)
+		}
+	}
+	l.branchLoaded[b.ID] = l.branchLoaded[b.ID].Sub(c)
+	l.notesLoaded[b.ID] -= len(b.Notes)
+	delete(l.notesAfter, b.ID)
+	delete(l.notesComplete, b.ID)
+	b.Notes = nil
+	return true
+}
+
+// pageEnd returns the index after the note a page ended with, or of the first note created here when it is no longer listed.
+func pageEnd(notes []*models.Note, lastID uint) int {
+	for i, n := range notes {
+		if n.ID == lastID {
+			return i + 1
+		}
+	}
+	for i, n := range notes {
+		if models.IsTempID(n.ID) {
+			return i
+		}
+	}
+	return len(notes)
+}
+
+// HasMoreNotes reports whether the active branch has notes that are not loaded yet.
+func (dm *DataMgr) HasMoreNotes() bool {
+	b := dm.GetActiveBranch()
+	return dm.lazy != nil && b != nil && !dm.lazy.notesComplete[b.ID]
+}
+
+// LoadMoreNotes loads the next page of notes of the active branch, keeping the active note, and returns how many were added.
+func (dm *DataMgr) LoadMoreNotes() int {
+	if !dm.HasMoreNotes() {
+		return 0
+	}
+	return dm.loadNextNotes()
+}
+
+// LoadAllNotes loads every note of the active branch that is not loaded yet, keeping the active note.
+func (dm *DataMgr) LoadAllNotes() {
+	for dm.HasMoreNotes() {
+		after := dm.lazy.notesAfter[dm.activeBranchID]
+		dm.loadNextNotes()
+		if dm.HasMoreNotes() && dm.lazy.notesAfter[dm.activeBranchID] == after {
+			return // the page failed to load, see loadNotePage
+		}
+	}
+}
+
+func (dm *DataMgr) loadNextNotes() int {
+	b := dm.branches[dm.activeBranchPtr]
+	added := dm.loadNotePage(b)
+	dm.notes = b.Notes
+	dm.rebuildNoteIndex()
+	if idx, ok := dm.noteIndexByID[dm.activeNoteID]; ok {
+		dm.activeNotePtr = idx
+	}
+	return added
+}
+
+// BranchCount returns how many branches a thread has, loaded or not.
+func (dm *DataMgr) BranchCount(t *models.Thread) int {
+	if dm.lazy == nil || dm.lazy.branchesLoaded[t.ID] {
+		return len(t.Branches)
+	}
+	return dm.lazy.branchCounts[t.ID] + len(t.Branches)
+}
+
+// NoteCount returns how many notes a branch has, loaded or not.
+// Notes listed beyond the loaded stored ones were created here; deleted ones have left the list.
+func (dm *DataMgr) NoteCount(b *models.Branch) int {
+	if dm.lazy == nil || dm.lazy.notesComplete[b.ID] {
+		return len(b.Notes)
+	}
+	return dm.lazy.noteCounts[b.ID] - dm.lazy.notesLoaded[b.ID] + len(b.Notes)
+}
+
+// BranchChecks returns how many checkboxes the notes of a branch have and how many are ticked, loaded or not.
+func (dm *DataMgr) BranchChecks(b *models.Branch) checklist.Counts {
+	var c checklist.Counts
+	for _, n := range b.Notes {
+		c = c.Add(n.Checks())
+	}
+	if dm.lazy == nil || dm.lazy.notesComplete[b.ID] {
+		return c
+	}
+	return dm.lazy.branchChecks[b.ID].Sub(dm.lazy.branchLoaded[b.ID]).Add(c)
+}
+
+// ThreadChecks returns how many checkboxes the notes of a thread have and how many are ticked, loaded or not.
+// A note listed by several branches counts once.
+func (dm *DataMgr) ThreadChecks(t *models.Thread) checklist.Counts {
+	var c checklist.Counts
+	counted := make(map[uint]bool)
+	for _, b := range t.Branches {
+		for _, n := range b.Notes {
+			if !counted[n.ID] {
+				counted[n.ID] = true
+				c = c.Add(n.Checks())
+			}
+		}
+	}
+	if dm.lazy == nil {
+		return c
+	}
+	return dm.lazy.threadChecks[t.ID].Sub(dm.lazy.threadLoaded[t.ID]).Add(c)
+}
+
+// AddStoredThread adds a thread read from the database, such as one restored from the trash, without switching to it.
+// What was loaded of it before it was deleted is forgotten, and its branches are read again.
+func (dm *DataMgr) AddStoredThread(t *models.Thread) {
+	if l := dm.lazy; l != nil {
+		delete(l.branchesLoaded, t.ID)
+		dm.loadBranches(t)
+		for _, b := range t.Branches {
+			dm.forgetNotes(b.ID)
+		}
+	}
+	dm.AddThread(t)
+}
+
+// AddStoredBranch adds a branch read from the database, such as one restored from the trash, to the active thread.
+// What was loaded of it before it was deleted is forgotten, and its notes are read again when it becomes active.
+func (dm *DataMgr) AddStoredBranch(b *models.Branch) {
+	if l := dm.lazy; l != nil {
+		dm.forgetNotes(b.ID)
+		counts, err := l.loader.NoteCounts(b.ThreadID)
+		if err != nil {
+			log.Printf("Error counting notes of thread %d: %v", b.ThreadID, err)
+		}
+		l.noteCounts[b.ID] = counts[b.ID]
+		checks, err := l.loader.BranchChecks(b.ThreadID)
+		if err != nil {
+			log.Printf("Error counting checkboxes of thread %d: %v", b.ThreadID, err)
+		}
+		l.branchChecks[b.ID] = checks[b.ID]
+	}
+	dm.AddBranch(b)
+}
+
+// forgetNotes drops what was loaded of the notes of a branch, so that they are read again.
+func (dm *DataMgr) forgetNotes(branchID uint) {
+	l := dm.lazy
+	delete(l.notesLoaded, branchID)
+	delete(l.notesAfter, branchID)
+	delete(l.notesComplete, branchID)
+	delete(l.branchLoaded, branchID)
+}
+
+// forgetChecks reads the checkboxes of a thread again, and drops what was loaded of them.
+func (dm *DataMgr) forgetChecks(threadID uint) {
+	l := dm.lazy
+	checks, err := l.loader.ThreadChecksOf(threadID)
+	if err != nil {
+		log.Printf("Error counting checkboxes: %v", err)
+	}
+	l.threadChecks[threadID] = checks
+	delete(l.threadLoaded, threadID)
+	for noteID, id := range l.notesOfThreads {
+		if id == threadID {
+			delete(l.notesOfThreads, noteID)
+		}
+	}
+}
@@ -40,7 +40,8 @@ Maybe we need a "main" branch ? I'm not sure.
 
 Do we support the operation of "SetBranch" ? Append a note to a different branch ? Or init a new branch ?
 
-Let's not support that for now. Keep things simple. Right now, delete branch is equal to delete all its notes.
+Let's not support that for now. Keep things simple.
+(Update: notes can be moved to another branch, in another thread too, and added to more branches of their thread: MoveNote and LinkNote, see app/move.go.) Right now, delete branch is equal to delete all its notes.
 Same for thread, deleting a thread is deleting all its branches.
 
 There are different types :
@@ -87,6 +88,9 @@ const (
 	CreateBranch EditType = 7 // since branch do not persist across threads, we do not have to introduce add / remove branch
 	UpdateBranch EditType = 8
 	DeleteBranch EditType = 10
+	MoveNote     EditType = 11 // the note left a branch for another one, possibly in another thread
+	LinkNote     EditType = 12 // the note was added to one more branch of its thread
+	ReorderNotes EditType = 13 // the notes of the branch were put in another order
 )
 
 // EntityType constants for EditKey
@@ -125,11 +129,11 @@ type EditMgr struct {
 // getEntityType returns the entity type string for a given EditType
 func getEntityType(tp EditType) string {
 	switch tp {
-	case CreateNote, UpdateNote, DeleteNote:
+	case CreateNote, UpdateNote, DeleteNote, MoveNote, LinkNote:
 		return EntityNote
 	case CreateThread, UpdateThread, DeleteThread:
 		return EntityThread
-	case CreateBranch, UpdateBranch, DeleteBranch:
+	case CreateBranch, UpdateBranch, DeleteBranch, ReorderNotes:
 		return EntityBranch
 	default:
 		return ""
@@ -185,6 +189,8 @@ func (em *EditMgr) AddEdit(curr *Edit, spl *models.Superlink) error {
 				return fmt.Errorf("invalid state: attempting to CreateNote %d that is already marked for UpdateNote", id)
 			case DeleteNote:
 				return fmt.Errorf("invalid state: attempting to CreateNote %d that is already marked for DeleteNote", id)
+			case MoveNote, LinkNote:
+				return fmt.Errorf("invalid state: attempting to CreateNote %d that is already marked for modification", id)
 			}
 		case UpdateNote:
 			switch prevType {
@@ -193,8 +199,8 @@ func (em *EditMgr) AddEdit(curr *Edit, spl *models.Superlink) error {
 				// No change needed, edit.EditType is already CreateNote
 				// append to note edit
 				em.NoteEditStack = AppendNoteEdit(em.NoteEditStack, &ne)
-			case UpdateNote:
-				// Already marked as UpdateNote, no change needed
+			case UpdateNote, MoveNote, LinkNote:
+				// Already marked as modified, no change needed
 				em.NoteEditStack = AppendNoteEdit(em.NoteEditStack, &ne)
 			case DeleteNote:
 				return fmt.Errorf("invalid state: attempting to UpdateNote %d that is marked for DeleteNote", id)
@@ -204,13 +210,25 @@ func (em *EditMgr) AddEdit(curr *Edit, spl *models.Superlink) error {
 			case CreateNote:
 				// Created then deleted without sync, no DB operation needed
 				em.EditMap[key].EditType = None
-			case UpdateNote:
+			case UpdateNote, MoveNote, LinkNote:
 				// Updated then deleted = need to delete from DB
 				em.EditMap[key].EditType = DeleteNote
 			case DeleteNote:
 				return fmt.Errorf("invalid state: attempting to DeleteNote %d that is already marked for DeleteNote", id)
 			}
 
+		// A move or link rewrites the whole note, like an update; the move is kept over the others, it says the most.
+		case MoveNote, LinkNote:
+			switch prevType {
+			case CreateNote:
+				// Keep as CreateNote - the new note is written where it is now
+			case UpdateNote, LinkNote:
+				em.EditMap[key].EditType = tp
+			case MoveNote:
+			case DeleteNote:
+				return fmt.Errorf("invalid state: attempting to move note %d that is marked for DeleteNote", id)
+			}
+
 		// Thread operations
 		case CreateThread:
 			switch prevType {
@@ -243,7 +261,7 @@ func (em *EditMgr) AddEdit(curr *Edit, spl *models.Superlink) error {
 			switch prevType {
 			case CreateBranch:
 				return fmt.Errorf("invalid state: attempting to CreateBranch %d that is already marked for CreateBranch", id)
-			case UpdateBranch:
+			case UpdateBranch, ReorderNotes:
 				return fmt.Errorf("invalid state: attempting to CreateBranch %d that is already marked for modification", id)
 			case DeleteBranch:
 				return fmt.Errorf("invalid state: attempting to CreateBranch %d that is already marked for DeleteBranch", id)
@@ -253,18 +271,30 @@ func (em *EditMgr) AddEdit(curr *Edit, spl *models.Superlink) error {
 			case CreateBranch:
 				// Keep as CreateBranch - new branch being modified before sync
 				// No change needed, edit.EditType is already CreateBranch
-			case UpdateBranch:
+			case UpdateBranch, ReorderNotes:
 				// Already marked for modification, update to latest operation
 
 			case DeleteBranch:
 				return fmt.Errorf("invalid state: attempting to modify branch %d that is marked for DeleteBranch", id)
 			}
+
+		// A reorder rewrites the branch, like an update, and the positions of its notes too.
+		case ReorderNotes:
+			switch prevType {
+			case CreateBranch:
+				// Keep as CreateBranch - a new branch is written with the order of its notes
+			case UpdateBranch:
+				em.EditMap[key].EditType = ReorderNotes
+			case ReorderNotes:
+			case DeleteBranch:
+				return fmt.Errorf("invalid state: attempting to reorder branch %d that is marked for DeleteBranch", id)
+			}
 		case DeleteBranch:
 			switch prevType {
 			case CreateBranch:
 				// Created then deleted without sync, no DB operation needed。 This also should not happen since the ID is going non stop.
 				em.EditMap[key].EditType = None
-			case UpdateBranch:
+			case UpdateBranch, ReorderNotes:
 				// Modified then deleted = need to delete from DB
 				em.EditMap[key].EditType = DeleteBranch
 			case DeleteBranch:
@@ -296,6 +326,34 @@ func (em *EditMgr) ClearOnSync() {
 	em.EditMap = make(map[EditKey]*Edit)
 }
 
+// Detach hands the pending edits over to a sync and starts an empty record for the edits made while it runs.
+// NoteEditStack is history and stays.
+func (em *EditMgr) Detach() (map[EditKey]*Edit, []*Edit) {
+	edits, stack := em.EditMap, em.EditStack
+	em.EditMap = make(map[EditKey]*Edit)
+	em.EditStack = make([]*Edit, 0)
+	return edits, stack
+}
+
+// Reattach puts back the edits of a sync that failed, and merges the edits made since into them.
+// Each entity ends up with the edit its two edits add up to, e.g. created before and deleted since is dropped.
+func (em *EditMgr) Reattach(edits map[EditKey]*Edit, stack []*Edit) {
+	since, sinceStack := em.EditMap, em.EditStack
+	em.EditMap = edits
+	for _, edit := range since {
+		if edit.EditType == None {
+			continue
+		}
+		em.AddEdit(&Edit{ID: edit.ID, EditType: edit.EditType}, nil) // Ignore error - the earlier edit stays
+	}
+	for key, edit := range em.EditMap {
+		if edit.EditType == None {
+			delete(em.EditMap, key)
+		}
+	}
+	em.EditStack = append(stack, sinceStack...)
+}
+
 // These needs further adaption to NoteEditStack
 
 // RemoveEdit removes an edit from the map (for undo operations)
@@ -304,9 +362,79 @@ func (em *EditMgr) RemoveEdit(entityType string, id uint) {
 	delete(em.EditMap, key)
 }
 
+// Undelete takes back the pending deletion of an entity that is put back, e.g. by undo.
+// The entity is updated instead, so the next sync writes it again. It reports false when no deletion is pending.
+func (em *EditMgr) Undelete(entityType string, id uint) bool {
+	edit, exists := em.EditMap[EditKey{EntityType: entityType, ID: id}]
+	if !exists {
+		return false
+	}
+	switch edit.EditType {
+	case DeleteNote:
+		edit.EditType = UpdateNote
+	case DeleteBranch:
+		edit.EditType = UpdateBranch
+	case DeleteThread:
+		edit.EditType = UpdateThread
+	default:
+		return false
+	}
+	em.EditStack = append(em.EditStack, &Edit{ID: id, EditType: edit.EditType})
+	return true
+}
+
+// RelinkNote points the note edit history of a note at the thread and branch it was moved to.
+func (em *EditMgr) RelinkNote(noteID, threadID, branchID uint) {
+	for _, ne := range em.NoteEditStack {
+		if ne.Link.NoteID == int(noteID) {
+			ne.Link.ThreadID = int(threadID)
+			ne.Link.BranchID = int(branchID)
+		}
+	}
+}
+
 // GetEdit retrieves an edit from the map
 func (em *EditMgr) GetEdit(entityType string, id uint) (*Edit, bool) {
 	key := EditKey{EntityType: entityType, ID: id}
 	edit, exists := em.EditMap[key]
 	return edit, exists
 }
+
+// RemapIDs replaces temporary IDs with the IDs the database assigned on sync, in the edits and in the superlinks of the note edit history.
+// The maps go from temporary to assigned ID, one per entity type.
+func (em *EditMgr) RemapIDs(threads, branches, notes map[uint]uint) {
+	byType := map[string]map[uint]uint{
+		EntityThread: threads,
+		EntityBranch: branches,
+		EntityNote:   notes,
+	}
+	remapped := make(map[EditKey]*Edit, len(em.EditMap))
+	for key, edit := range em.EditMap {
+		if id, ok := byType[key.EntityType][key.ID]; ok {
+			key.ID = id
+			edit.ID = id
+		}
+		remapped[key] = edit
+	}
+	em.EditMap = remapped
+	for _, edit := range em.EditStack {
+		if id, ok := byType[getEntityType(edit.EditType)][edit.ID]; ok {
+			edit.ID = id
+		}
+	}
+
+	remapLink := func(m map[uint]uint, id int) int {
+		if id <= 0 {
+			return id
+		}
+		if newID, ok := m[uint(id)]; ok {
+			return int(newID)
+		}
+		return id
+	}
+	for _, ne := range em.NoteEditStack {
+		ne.Link.ThreadID = remapLink(threads, ne.Link.ThreadID)
+		ne.Link.BranchID = remapLink(branches, ne.Link.BranchID)
+		ne.Link.NoteID = remapLink(notes, ne.Link.NoteID)
+	}
+}
@@ -0,0 +1,119 @@
+package app
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	editstack "github.com/haochend413/ntkpr/internal/app/editStack"
+	"github.com/haochend413/ntkpr/internal/models"
+)
+
+// fork.go forks a branch at one of its notes, the way git branches off a commit.
+// The fork shares the notes of its branch up to that note through branch_notes, and the two go their own ways from there:
+// notes written in either branch stay in it. The fork keeps where it came from in Branch.ForkBranchID and ForkNoteID.
+
+// ForkCurrentBranch forks the current branch at the current note, makes the fork active, and returns its ID,
+// or 0 when there is no note to fork at. The fork can be undone like a create.
+func (a *App) ForkCurrentBranch(link *models.Superlink) uint {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	source := a.getCurrentBranch()
+	note := a.getCurrentNote()
+	if source == nil || note == nil {
+		log.Printf("Cannot fork: no active note")
+		return 0
+	}
+	branch := &models.Branch{Name: forkName(source)}
+	branch.CreatedAt = time.Now()
+	branch.UpdatedAt = time.Now()
+	branch.ID = a.newTempID()
+	branch.ThreadID = source.ThreadID
+	branch.ForkBranchID = source.ID
+	branch.ForkNoteID = note.ID
+	a.Synced = false
+	// the journal replays the fork from these fields, see replayEntry
+	edit := &editstack.Edit{EditType: editstack.CreateBranch, ID: branch.ID}
+	if err := a.trackEdit(edit, link, branch); err != nil {
+		log.Printf("Error adding Create edit: %v", err)
+		return 0
+	}
+	a.dataMgr.AddBranch(branch)
+	a.shareForkNotes(branch)
+	a.pushChange(&change{editType: editstack.CreateBranch, threadID: branch.ThreadID, branchID: branch.ID, link: link})
+	a.goTo(branch.ThreadID, branch.ID, note.ID)
+	return branch.ID
+}
+
+// GetCurrentBranchFork returns where the current branch was forked from: the name and ID of the branch,
+// and the last note they share. The IDs are 0 when the branch is not a fork; the name is empty when that branch is gone.
+func (a *App) GetCurrentBranchFork() (name string, branchID, noteID uint) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	branch := a.getCurrentBranch()
+	if branch == nil || branch.ForkBranchID == 0 {
+		return "", 0, 0
+	}
+	if source := a.dataMgr.FindBranchByID(branch.ForkBranchID); source != nil {
+		name = source.Name
+	}
+	return name, branch.ForkBranchID, branch.ForkNoteID
+}
+
+// forkName names a fork after its branch.
+func forkName(source *models.Branch) string {
+	if source.Name == "" || sealed(source.Name) {
+		return fmt.Sprintf("fork of #%d", source.ID)
+	}
+	return source.Name + " (fork)"
+}
+
+// shareForkNotes lists the notes of the branch a fork was made from, up to the note it was forked at, in the fork.
+// The fork lists them in the order they have in that branch. Callers hold the mutex.
+func (a *App) shareForkNotes(fork *models.Branch) {
+	// the fork note may be on a page of its branch that is not loaded yet
+	if !a.goTo(fork.ThreadID, fork.ForkBranchID, fork.ForkNoteID) {
+		log.Printf("Cannot fork: note %d of branch %d is gone", fork.ForkNoteID, fork.ForkBranchID)
+		return
+	}
+	for _, n := range a.getCurrentBranch().Notes {
+		fork.Notes = append(fork.Notes, n)
+		if n.ID == fork.ForkNoteID {
+			break
+		}
+	}
+	a.shareBranchNotes(fork)
+}
+
+// shareBranchNotes adds a branch to the notes it lists, so that they keep it when they are written.
+// Other loaded branches that list copies of these notes list the same ones. Callers hold the mutex.
+func (a *App) shareBranchNotes(branch *models.Branch) {
+	for _, n := range branch.Notes {
+		listed := false
+		for _, b := range n.Branches {
+			if b.ID == branch.ID {
+				listed = true
+			} else if loaded := a.dataMgr.FindBranchByID(b.ID); loaded != nil {
+				a.dataMgr.ReplaceNote(loaded, n)
+			}
+		}
+		if !listed {
+			n.Branches = append(n.Branches, branch)
+		}
+	}
+}
+
+// unshareBranchNotes takes a branch that was never synced off the notes it lists, as it is discarded. Callers hold the mutex.
+func (a *App) unshareBranchNotes(branch *models.Branch) {
+	for _, n := range branch.Notes {
+		kept := n.Branches[:0]
+		for _, b := range n.Branches {
+			if b.ID != branch.ID {
+				kept = append(kept, b)
+			}
+		}
+		n.Branches = kept
+	}
+}
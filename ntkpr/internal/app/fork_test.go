@@ -0,0 +1,113 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/haochend413/ntkpr/internal/db"
+)
+
+// notesOf lists the notes the database puts in a branch, in their order in it.
+func notesOf(t *testing.T, d *db.DB, branchID uint) []uint {
+	t.Helper()
+	var ids []uint
+	if err := d.Conn.Raw(`SELECT note_id FROM branch_notes WHERE branch_id = ? ORDER BY position`, branchID).Scan(&ids).Error; err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+// A fork shares the notes of its branch up to the one it was forked at, and the notes written in either stay in it.
+func TestForkBranch(t *testing.T) {
+	d, a := testApp(t)
+	seed(t, a, "one", "two", "three")
+	a.SetCurrentBranchName("main", nil)
+	syncApp(t, a)
+
+	a.goTo(1, 1, 2)
+	forkID := a.ForkCurrentBranch(nil)
+	if forkID == 0 {
+		t.Fatal("cannot fork")
+	}
+	if a.GetCurrentBranchID() != forkID || a.GetCurrentNoteID() != 2 || a.GetCurrentBranchName() != "main (fork)" {
+		t.Errorf("active %q %d at note %d, want the fork at note 2", a.GetCurrentBranchName(), a.GetCurrentBranchID(), a.GetCurrentNoteID())
+	}
+	if name, branchID, noteID := a.GetCurrentBranchFork(); name != "main" || branchID != 1 || noteID != 2 {
+		t.Errorf("forked from %q %d at note %d, want main 1 at note 2", name, branchID, noteID)
+	}
+	dm := a.GetDataMgr()
+	main, fork := dm.FindBranchByID(1), dm.FindBranchByID(forkID)
+	if len(fork.Notes) != 2 || fork.Notes[0] != main.Notes[0] || fork.Notes[1] != main.Notes[1] {
+		t.Errorf("the fork lists %d notes, want the first two of its branch", len(fork.Notes))
+	}
+
+	a.goTo(1, forkID, a.CreateNewNote(nil))
+	a.SetCurrentNoteContent("in the fork", nil)
+	a.goTo(1, 1, 0)
+	a.goTo(1, 1, a.CreateNewNote(nil))
+	a.SetCurrentNoteContent("in main", nil)
+	syncApp(t, a)
+
+	var row struct {
+		Name                     string
+		ForkBranchID, ForkNoteID uint
+	}
+	d.Conn.Raw(`SELECT name, fork_branch_id, fork_note_id FROM branches WHERE id = 2`).Scan(&row)
+	if row.Name != "main (fork)" || row.ForkBranchID != 1 || row.ForkNoteID != 2 {
+		t.Errorf("stored the fork as %+v", row)
+	}
+	if got, want := notesOf(t, d, 1), []uint{1, 2, 3, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("main lists %v, want %v", got, want)
+	}
+	if got, want := notesOf(t, d, 2), []uint{1, 2, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("the fork lists %v, want %v", got, want)
+	}
+
+	fresh := NewApp(d, nil)
+	fresh.goTo(1, 2, 0)
+	if name, branchID, noteID := fresh.GetCurrentBranchFork(); name != "main" || branchID != 1 || noteID != 2 {
+		t.Errorf("loaded afresh, forked from %q %d at note %d", name, branchID, noteID)
+	}
+
+	// the fork is undone like a create, after the notes written since
+	for range 5 {
+		if _, err := a.Undo(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	syncApp(t, a)
+	want := []string{"thread:1", "branch:1", "note:1:one:", "note:2:two:", "note:3:three:"}
+	if got := stored(t, d); !reflect.DeepEqual(got, want) {
+		t.Errorf("undone, stored %v, want %v", got, want)
+	}
+}
+
+// A fork that is undone before it is synced leaves nothing behind, and its branch does not list it.
+func TestForkUndoneBeforeSync(t *testing.T) {
+	d, a := testApp(t)
+	seed(t, a, "one", "two")
+	syncApp(t, a)
+	a.goTo(1, 1, 1)
+	if a.ForkCurrentBranch(nil) == 0 {
+		t.Fatal("cannot fork")
+	}
+	if _, err := a.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	if got := loadedPlaceOf(t, a, 1); got != "thread 1 [1]" {
+		t.Errorf("note 1 is loaded in %s, want thread 1 [1]", got)
+	}
+	syncApp(t, a)
+	var branches int64
+	d.Conn.Unscoped().Raw(`SELECT count(*) FROM branches`).Scan(&branches)
+	if branches != 1 || placeOf(t, d, 1) != "thread 1 [1]" {
+		t.Errorf("stored %d branches, note 1 in %s", branches, placeOf(t, d, 1))
+	}
+
+	// there is nothing to fork at in an empty branch
+	a.goTo(1, 0, 0)
+	a.goTo(1, a.CreateNewBranch(nil), 0)
+	if id := a.ForkCurrentBranch(nil); id != 0 {
+		t.Errorf("forked an empty branch into %d", id)
+	}
+}
@@ -0,0 +1,88 @@
+package app
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/haochend413/ntkpr/internal/models"
+	"github.com/haochend413/ntkpr/internal/vault"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// history.go exposes the revision history of notes.
+// Revisions are written by db.SyncData, so unsynced changes only show up as the difference to the latest revision.
+
+// GetCurrentNoteRevisions returns the synced revisions of the current note, newest first.
+func (a *App) GetCurrentNoteRevisions() []*models.NoteRevision {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	note := a.getCurrentNote()
+	if note == nil {
+		return nil
+	}
+
+	revisions, err := a.db.GetNoteRevisions(note.ID)
+	if err != nil {
+		log.Printf("Error loading revisions of note %d: %v", note.ID, err)
+		return nil
+	}
+	return revisions
+}
+
+// GetNoteDiff returns a colored diff between the note's previous version and its current content.
+// The previous version is the newest revision whose content differs from the note.
+func (a *App) GetNoteDiff(note *models.Note) string {
+	if note == nil {
+		return ""
+	}
+	if sealed(note.Content) {
+		return vault.Placeholder
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	revisions, err := a.db.GetNoteRevisions(note.ID)
+	if err != nil {
+		log.Printf("Error loading revisions of note %d: %v", note.ID, err)
+		return ""
+	}
+
+	base := ""
+	for _, r := range revisions {
+		if r.Content != note.Content {
+			base = vault.Show(r.Content)
+			break
+		}
+	}
+
+	dmp := diffmatchpatch.New()
+	return dmp.DiffPrettyText(dmp.DiffMain(base, note.Content, false))
+}
+
+// RevertCurrentNote restores the current note to the content of one of its revisions.
+// The restore is a normal UpdateNote edit: it syncs like any other edit, and the overwritten text stays in history.
+func (a *App) RevertCurrentNote(revisionID uint, link *models.Superlink) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	note := a.getCurrentNote()
+	if note == nil {
+		return fmt.Errorf("no note selected")
+	}
+
+	revision, err := a.db.GetNoteRevision(revisionID)
+	if err != nil {
+		return fmt.Errorf("failed to load revision %d: %w", revisionID, err)
+	}
+	if revision.NoteID != note.ID {
+		return fmt.Errorf("revision %d belongs to note %d, not note %d", revisionID, revision.NoteID, note.ID)
+	}
+	if sealed(note.Content, revision.Content) {
+		return ErrLocked
+	}
+
+	a.setNoteContent(note, revision.Content, link)
+	return nil
+}
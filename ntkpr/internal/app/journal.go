@@ -0,0 +1,240 @@
+package app
+
+import (
+	"log"
+	"time"
+
+	editstack "github.com/haochend413/ntkpr/internal/app/editStack"
+	"github.com/haochend413/ntkpr/internal/app/journal"
+	"github.com/haochend413/ntkpr/internal/models"
+)
+
+// journal.go writes every tracked edit to the on-disk journal, and replays a journal left over by a crash.
+// Entries are dropped from the journal once a sync has written them, see FinishSync.
+
+// openJournal opens the journal next to the database and keeps the entries it still holds for PendingJournal.
+func (a *App) openJournal() {
+	if a.db == nil || a.db.Path() == "" {
+		return
+	}
+	j, entries, err := journal.Open(journal.PathFor(a.db.Path()))
+	if err != nil {
+		log.Printf("Error opening journal: %v", err)
+		return
+	}
+	a.journal = j
+	a.leftover = entries
+}
+
+// trackEdit adds an edit to the EditMgr and appends it to the journal, with a snapshot of entity.
+func (a *App) trackEdit(edit *editstack.Edit, link *models.Superlink, entity any) error {
+	if err := a.editMgr.AddEdit(edit, link); err != nil {
+		return err
+	}
+	a.record(edit, link, entity)
+	return nil
+}
+
+// record appends an edit to the journal. Entity is the *models.Thread, *models.Branch or *models.Note the edit is about.
+// Nothing is written while a journal is replayed, its entries are still in the file.
+func (a *App) record(edit *editstack.Edit, link *models.Superlink, entity any) {
+	if a.journal == nil || a.replaying {
+		return
+	}
+	e := journal.Entry{Time: time.Now(), EditType: edit.EditType, ID: edit.ID, Link: link}
+	switch x := entity.(type) {
+	case *models.Thread:
+		e.Thread = journal.ThreadOf(x)
+	case *models.Branch:
+		e.Branch = journal.BranchOf(x)
+		if edit.EditType == editstack.ReorderNotes {
+			e.Branch.NoteIDs = noteIDs(x.Notes)
+		}
+	case *models.Note:
+		e.Note = journal.NoteOf(x)
+	}
+	if err := a.journal.Append(e); err != nil {
+		log.Printf("Error writing journal: %v", err)
+	}
+}
+
+// clearJournal removes the journal with all its entries. Callers hold the mutex.
+func (a *App) clearJournal() {
+	if a.journal == nil {
+		return
+	}
+	if err := a.journal.Clear(); err != nil {
+		log.Printf("Error clearing journal: %v", err)
+	}
+	a.leftover = nil
+}
+
+// PendingJournal returns the number of unsynced edits found in the journal on startup.
+func (a *App) PendingJournal() int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return len(a.leftover)
+}
+
+// DiscardJournal throws away the edits left in the journal.
+func (a *App) DiscardJournal() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.clearJournal()
+}
+
+// ReplayJournal applies the edits left in the journal on top of the loaded data, and tracks them again for the next sync.
+// The journal file is kept until that sync. It returns how many edits were applied, edits of entities that no longer exist are skipped.
+func (a *App) ReplayJournal() int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	dm := a.dataMgr
+	threadID, branchID, noteID := dm.GetActiveThreadID(), dm.GetActiveBranchID(), dm.GetActiveNoteID()
+
+	a.replaying = true
+	applied := 0
+	for _, e := range a.leftover {
+		if a.replayEntry(e) {
+			applied++
+		} else {
+			log.Printf("Skipped journal entry: edit %d of %d", e.EditType, e.ID)
+		}
+		// created entities keep their temporary IDs, new ones must not collide with them
+		if models.IsTempID(e.ID) && e.ID >= a.nextTempID {
+			a.nextTempID = e.ID + 1
+		}
+	}
+	a.replaying = false
+	a.leftover = nil
+	if applied > 0 {
+		a.Synced = false
+	}
+
+	a.goTo(threadID, branchID, noteID)
+	return applied
+}
+
+// goTo makes the given thread, branch and note active, loading them when needed. Zero IDs are skipped.
+func (a *App) goTo(threadID, branchID, noteID uint) bool {
+	dm := a.dataMgr
+	if !dm.SwitchActiveThreadByID(threadID) {
+		return false
+	}
+	if branchID != 0 && !dm.SwitchActiveBranchByID(branchID) {
+		return false
+	}
+	if noteID != 0 && !dm.SwitchActiveNoteByID(noteID) {
+		return false
+	}
+	return true
+}
+
+// replayEntry applies one journal entry. Callers hold the mutex.
+// A create of a stored entity is one that was put back by undo, see trackPutBack.
+func (a *App) replayEntry(e journal.Entry) bool {
+	dm := a.dataMgr
+	edit := &editstack.Edit{ID: e.ID, EditType: e.EditType}
+
+	switch {
+	case e.Thread != nil:
+		switch e.EditType {
+		case editstack.CreateThread:
+			thread := &models.Thread{}
+			e.Thread.CopyTo(thread)
+			thread.ID = e.ID
+			dm.AddThread(thread)
+			a.trackPutBack(editstack.EntityThread, e.ID, e.Link, thread)
+			return true
+		case editstack.UpdateThread:
+			if !a.goTo(e.ID, 0, 0) {
+				return false
+			}
+			e.Thread.CopyTo(dm.GetActiveThread())
+		case editstack.DeleteThread:
+			if !a.goTo(e.ID, 0, 0) {
+				return false
+			}
+			a.deleteCurrentThread(e.Link)
+			return true
+		default:
+			return false
+		}
+
+	case e.Branch != nil:
+		switch e.EditType {
+		case editstack.CreateBranch:
+			if !a.goTo(e.Branch.ThreadID, 0, 0) {
+				return false
+			}
+			branch := &models.Branch{}
+			e.Branch.CopyTo(branch)
+			branch.ID = e.ID
+			dm.AddBranch(branch)
+			if branch.ForkBranchID != 0 && models.IsTempID(branch.ID) {
+				// the notes of a new fork are shared again from where it was forked, as they were then
+				a.shareForkNotes(branch)
+			}
+			a.trackPutBack(editstack.EntityBranch, e.ID, e.Link, branch)
+			return true
+		case editstack.UpdateBranch:
+			if !a.goTo(e.Branch.ThreadID, e.ID, 0) {
+				return false
+			}
+			e.Branch.CopyTo(dm.GetActiveBranch())
+		case editstack.ReorderNotes:
+			return a.orderNotes(e.Branch.ThreadID, e.ID, e.Branch.NoteIDs, e.Link) == nil
+		case editstack.DeleteBranch:
+			if !a.goTo(e.Branch.ThreadID, e.ID, 0) {
+				return false
+			}
+			a.deleteCurrentBranch(e.Link)
+			return true
+		default:
+			return false
+		}
+
+	case e.Note != nil:
+		if len(e.Note.BranchIDs) == 0 {
+			return false
+		}
+		branchID := e.Note.BranchIDs[0]
+		switch e.EditType {
+		case editstack.CreateNote:
+			if !a.goTo(e.Note.ThreadID, branchID, 0) {
+				return false
+			}
+			note := &models.Note{}
+			e.Note.CopyTo(note)
+			note.ID = e.ID
+			note.Branches = []*models.Branch{dm.GetActiveBranch()}
+			dm.AddNote(note)
+			a.trackPutBack(editstack.EntityNote, e.ID, e.Link, note)
+			return true
+		case editstack.UpdateNote:
+			if !a.goTo(e.Note.ThreadID, branchID, e.ID) {
+				return false
+			}
+			e.Note.CopyTo(dm.GetActiveNote())
+		case editstack.DeleteNote:
+			if !a.goTo(e.Note.ThreadID, branchID, e.ID) {
+				return false
+			}
+			a.deleteCurrentNote(e.Link)
+			return true
+		case editstack.MoveNote, editstack.LinkNote:
+			return a.placeNote(e.ID, e.Note.ThreadID, e.Note.BranchIDs, e.EditType, e.Link) == nil
+		default:
+			return false
+		}
+
+	default:
+		return false
+	}
+
+	if err := a.editMgr.AddEdit(edit, e.Link); err != nil {
+		log.Printf("Error replaying journal entry: %v", err)
+		return false
+	}
+	return true
+}
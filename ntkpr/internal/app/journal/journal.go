@@ -0,0 +1,359 @@
+/*
+The journal keeps edits that are not synced yet on disk, so a crash does not lose them.
+
+Every edit tracked by the EditMgr is appended as one JSON line to a file next to the database,
+together with a snapshot of the entity it touched. The file is flushed to disk on every append,
+and entries are dropped once a sync has written them. A journal left over on startup belongs to a
+session that did not end cleanly, and can be replayed on top of the database or thrown away.
+
+Snapshots hold the stored fields only, without associations: they are what SyncData would write.
+The text of private entities is sealed in the file like in the database, see package vault, and opened by CopyTo.
+*/
+
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/haochend413/ntkpr/internal/models"
+	"github.com/haochend413/ntkpr/internal/vault"
+)
+
+// PathFor returns the journal path of the database at dbPath.
+func PathFor(dbPath string) string {
+	return dbPath + ".journal"
+}
+
+// Entry is one tracked edit. Exactly one snapshot is set, matching the entity type of the edit.
+type Entry struct {
+	Time     time.Time         `json:"time"`
+	EditType int               `json:"edit_type"` // editstack.EditType
+	ID       uint              `json:"id"`
+	Link     *models.Superlink `json:"link,omitempty"`
+	Thread   *Thread           `json:"thread,omitempty"`
+	Branch   *Branch           `json:"branch,omitempty"`
+	Note     *Note             `json:"note,omitempty"`
+}
+
+// Thread is the snapshot of a thread.
+type Thread struct {
+	Name      string    `json:"name"`
+	Summary   string    `json:"summary"`
+	LastEdit  time.Time `json:"last_edit"`
+	Highlight bool      `json:"highlight"`
+	Private   bool      `json:"private"`
+	Frequency int       `json:"frequency"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Branch is the snapshot of a branch.
+type Branch struct {
+	ThreadID     uint      `json:"thread_id"`
+	Name         string    `json:"name"`
+	Summary      string    `json:"summary"`
+	LastEdit     time.Time `json:"last_edit"`
+	Highlight    bool      `json:"highlight"`
+	Private      bool      `json:"private"`
+	Archived     bool      `json:"archived,omitempty"`
+	Frequency    int       `json:"frequency"`
+	ForkBranchID uint      `json:"fork_branch_id,omitempty"`
+	ForkNoteID   uint      `json:"fork_note_id,omitempty"`
+	NoteIDs      []uint    `json:"note_ids,omitempty"` // the notes of the branch in their order, for ReorderNotes only
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// Note is the snapshot of a note. BranchIDs lists the branches the note is in, by ID.
+type Note struct {
+	ThreadID    uint              `json:"thread_id"`
+	BranchIDs   []uint            `json:"branch_ids"`
+	Content     string            `json:"content"`
+	LastEdit    time.Time         `json:"last_edit"`
+	Highlight   bool              `json:"highlight"`
+	Private     bool              `json:"private"`
+	Frequency   int               `json:"frequency"`
+	Status      models.TaskStatus `json:"status,omitempty"`
+	Due         *time.Time        `json:"due,omitempty"`
+	CompletedAt *time.Time        `json:"completed_at,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+}
+
+// ThreadOf takes a snapshot of t.
+func ThreadOf(t *models.Thread) *Thread {
+	return &Thread{
+		Name:      t.Name,
+		Summary:   t.Summary,
+		LastEdit:  t.LastEdit,
+		Highlight: t.Highlight,
+		Private:   t.Private,
+		Frequency: t.Frequency,
+		CreatedAt: t.CreatedAt,
+		UpdatedAt: t.UpdatedAt,
+	}
+}
+
+// CopyTo writes the snapshot into t, leaving its ID and branches alone.
+func (s *Thread) CopyTo(t *models.Thread) {
+	t.Name = vault.Open(s.Name)
+	t.Summary = vault.Open(s.Summary)
+	t.LastEdit = s.LastEdit
+	t.Highlight = s.Highlight
+	t.Private = s.Private
+	t.Frequency = s.Frequency
+	t.CreatedAt = s.CreatedAt
+	t.UpdatedAt = s.UpdatedAt
+}
+
+// BranchOf takes a snapshot of b.
+func BranchOf(b *models.Branch) *Branch {
+	return &Branch{
+		ThreadID:     b.ThreadID,
+		Name:         b.Name,
+		Summary:      b.Summary,
+		LastEdit:     b.LastEdit,
+		Highlight:    b.Highlight,
+		Private:      b.Private,
+		Archived:     b.Archived,
+		Frequency:    b.Frequency,
+		ForkBranchID: b.ForkBranchID,
+		ForkNoteID:   b.ForkNoteID,
+		CreatedAt:    b.CreatedAt,
+		UpdatedAt:    b.UpdatedAt,
+	}
+}
+
+// CopyTo writes the snapshot into b, leaving its ID and notes alone.
+func (s *Branch) CopyTo(b *models.Branch) {
+	b.ThreadID = s.ThreadID
+	b.Name = vault.Open(s.Name)
+	b.Summary = vault.Open(s.Summary)
+	b.LastEdit = s.LastEdit
+	b.Highlight = s.Highlight
+	b.Private = s.Private
+	b.Archived = s.Archived
+	b.Frequency = s.Frequency
+	b.ForkBranchID = s.ForkBranchID
+	b.ForkNoteID = s.ForkNoteID
+	b.CreatedAt = s.CreatedAt
+	b.UpdatedAt = s.UpdatedAt
+}
+
+// NoteOf takes a snapshot of n.
+func NoteOf(n *models.Note) *Note {
+	ids := make([]uint, 0, len(n.Branches))
+	for _, b := range n.Branches {
+		ids = append(ids, b.ID)
+	}
+	return &Note{
+		ThreadID:    n.ThreadID,
+		BranchIDs:   ids,
+		Content:     n.Content,
+		LastEdit:    n.LastEdit,
+		Highlight:   n.Highlight,
+		Private:     n.Private,
+		Frequency:   n.Frequency,
+		Status:      n.Status,
+		Due:         n.Due,
+		CompletedAt: n.CompletedAt,
+		CreatedAt:   n.CreatedAt,
+		UpdatedAt:   n.UpdatedAt,
+	}
+}
+
+// CopyTo writes the snapshot into n, leaving its ID and branches alone.
+func (s *Note) CopyTo(n *models.Note) {
+	n.ThreadID = s.ThreadID
+	n.Content = vault.Open(s.Content)
+	n.LastEdit = s.LastEdit
+	n.Highlight = s.Highlight
+	n.Private = s.Private
+	n.Frequency = s.Frequency
+	n.Status = s.Status
+	n.Due = s.Due
+	n.CompletedAt = s.CompletedAt
+	n.CreatedAt = s.CreatedAt
+	n.UpdatedAt = s.UpdatedAt
+}
+
+// Journal appends entries to the journal file. The file is created by the first append.
+type Journal struct {
+	path  string
+	f     *os.File
+	count int  // entries in the file
+	torn  bool // the file ends in a half-written line, the next append starts a new one
+}
+
+// Open reads the entries left in the journal at path and returns the journal, ready to append.
+// A line that does not decode, like the half-written last line of a crash, is skipped.
+func Open(path string) (*Journal, []Entry, error) {
+	j := &Journal{path: path}
+	entries, torn, err := readEntries(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	j.count = len(entries)
+	j.torn = torn
+	return j, entries, nil
+}
+
+// readEntries reads the entries of the journal at path, and reports whether its last line is unfinished.
+func readEntries(path string) ([]Entry, bool, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("read journal %s: %w", path, err)
+	}
+
+	var entries []Entry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(data)+1)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, false, fmt.Errorf("read journal %s: %w", path, err)
+	}
+	torn := len(data) > 0 && data[len(data)-1] != '\n'
+	return entries, torn, nil
+}
+
+// Len returns the number of entries in the journal.
+func (j *Journal) Len() int {
+	return j.count
+}
+
+// Path returns the path of the journal file.
+func (j *Journal) Path() string {
+	return j.path
+}
+
+// Append writes e to the end of the journal and flushes it to disk.
+func (j *Journal) Append(e Entry) error {
+	if j.f == nil {
+		f, err := os.OpenFile(j.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		j.f = f
+	}
+	line, err := json.Marshal(sealed(e))
+	if err != nil {
+		return err
+	}
+	if j.torn {
+		line = append([]byte{'\n'}, line...)
+	}
+	if _, err := j.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	j.torn = false
+	j.count++
+	return j.f.Sync()
+}
+
+// sealed returns e with the text of a private snapshot sealed. The snapshots of e are left as they are.
+func sealed(e Entry) Entry {
+	if t := e.Thread; t != nil && t.Private {
+		c := *t
+		c.Name, c.Summary = vault.Seal(t.Name), vault.Seal(t.Summary)
+		e.Thread = &c
+	}
+	if b := e.Branch; b != nil && b.Private {
+		c := *b
+		c.Name, c.Summary = vault.Seal(b.Name), vault.Seal(b.Summary)
+		e.Branch = &c
+	}
+	if n := e.Note; n != nil && n.Private {
+		c := *n
+		c.Content = vault.Seal(n.Content)
+		e.Note = &c
+	}
+	return e
+}
+
+// Compact drops the first n entries, once a sync has written them, and passes the others through remap.
+// The rest is written to a new file that replaces the journal, so a crash leaves either the old or the new one.
+func (j *Journal) Compact(n int, remap func(e *Entry)) error {
+	if err := j.Close(); err != nil {
+		return err
+	}
+	entries, _, err := readEntries(j.path)
+	if err != nil {
+		return err
+	}
+	if n >= len(entries) {
+		return j.Clear()
+	}
+	rest := entries[n:]
+
+	tmp := j.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for i := range rest {
+		remap(&rest[i])
+		line, err := json.Marshal(sealed(rest[i]))
+		if err != nil {
+			f.Close()
+			return err
+		}
+		w.Write(line)
+		w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, j.path); err != nil {
+		return err
+	}
+	j.count = len(rest)
+	j.torn = false
+	return nil
+}
+
+// Clear removes the journal file, once its edits are synced or thrown away.
+func (j *Journal) Clear() error {
+	if err := j.Close(); err != nil {
+		return err
+	}
+	if err := os.Remove(j.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	j.count = 0
+	j.torn = false
+	return nil
+}
+
+// Close closes the journal file, keeping it on disk.
+func (j *Journal) Close() error {
+	if j.f == nil {
+		return nil
+	}
+	err := j.f.Close()
+	j.f = nil
+	return err
+}
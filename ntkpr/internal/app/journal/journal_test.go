@@ -0,0 +1,213 @@
+package journal
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/haochend413/ntkpr/internal/models"
+	"github.com/haochend413/ntkpr/internal/vault"
+)
+
+var when = time.Date(2025, 3, 10, 9, 30, 0, 0, time.UTC)
+
+// entries returns n entries of edit types 1, 2, ... with IDs 10, 11, ..., cycling through the three snapshots.
+func entries(n int) []Entry {
+	es := make([]Entry, n)
+	for i := range es {
+		e := Entry{Time: when, EditType: i + 1, ID: uint(10 + i)}
+		switch i % 3 {
+		case 0:
+			e.Thread = &Thread{Name: "thread", Summary: "about it", LastEdit: when, CreatedAt: when, UpdatedAt: when}
+		case 1:
+			e.Branch = &Branch{ThreadID: 1, Name: "branch", NoteIDs: []uint{3, 2}, LastEdit: when, CreatedAt: when, UpdatedAt: when}
+		case 2:
+			e.Note = &Note{ThreadID: 1, BranchIDs: []uint{4}, Content: "- [ ] note", Status: models.TaskTodo, Due: &when,
+				LastEdit: when, CreatedAt: when, UpdatedAt: when}
+		}
+		es[i] = e
+	}
+	return es
+}
+
+// appended writes es to a new journal, closes it and returns its path.
+func appended(t *testing.T, es []Entry) string {
+	t.Helper()
+	path := PathFor(filepath.Join(t.TempDir(), "ntkpr.db"))
+	j, _, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range es {
+		if err := j.Append(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func reopen(t *testing.T, path string) (*Journal, []Entry) {
+	t.Helper()
+	j, es, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { j.Close() })
+	return j, es
+}
+
+func TestAppendOpen(t *testing.T) {
+	for _, n := range []int{0, 1, 3, 100} {
+		want := entries(n)
+		j, got := reopen(t, appended(t, want))
+		if j.Len() != n || len(got) != n {
+			t.Fatalf("%d entries: read %d, Len %d", n, len(got), j.Len())
+		}
+		if n > 0 && !reflect.DeepEqual(got, want) {
+			t.Errorf("%d entries: read %+v, want %+v", n, got, want)
+		}
+	}
+}
+
+func TestOpenMissing(t *testing.T) {
+	j, es := reopen(t, filepath.Join(t.TempDir(), "none.journal"))
+	if len(es) != 0 || j.Len() != 0 {
+		t.Errorf("read %d entries from a missing journal", len(es))
+	}
+	if _, err := os.Stat(j.Path()); !os.IsNotExist(err) {
+		t.Error("opening created the journal file")
+	}
+}
+
+func TestTornLines(t *testing.T) {
+	tests := []struct {
+		name string
+		tail string // written after two whole entries
+		want int    // entries read
+	}{
+		{"whole", "", 2},
+		{"torn last line", `{"time":"2025-03-10T09:30:00Z","edit_type":3,"id":`, 2},
+		{"torn last line ending in a brace", `{"note":{"content":"x"}`, 2},
+		{"garbage line", "not json\n", 2},
+		{"blank lines", "\n\n", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := appended(t, entries(2))
+			f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.WriteString(tt.tail)
+			f.Close()
+
+			j, es := reopen(t, path)
+			if len(es) != tt.want {
+				t.Fatalf("read %d entries, want %d", len(es), tt.want)
+			}
+			// the next entry starts on a line of its own, and nothing is lost
+			next := entries(3)[2]
+			if err := j.Append(next); err != nil {
+				t.Fatal(err)
+			}
+			j.Close()
+			_, es = reopen(t, path)
+			if len(es) != tt.want+1 || !reflect.DeepEqual(es[len(es)-1], next) {
+				t.Errorf("after appending read %d entries, last %+v", len(es), es[len(es)-1])
+			}
+		})
+	}
+}
+
+func TestCompact(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries int
+		drop    int
+		wantIDs []uint
+	}{
+		{"nothing synced", 3, 0, []uint{110, 111, 112}},
+		{"some synced", 3, 2, []uint{112}},
+		{"all synced", 3, 3, nil},
+		{"more than there is", 3, 5, nil},
+		{"empty", 0, 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := appended(t, entries(tt.entries))
+			j, _ := reopen(t, path)
+			// synced entities got their stored IDs
+			err := j.Compact(tt.drop, func(e *Entry) { e.ID += 100 })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if j.Len() != len(tt.wantIDs) {
+				t.Errorf("Len %d, want %d", j.Len(), len(tt.wantIDs))
+			}
+			if len(tt.wantIDs) == 0 {
+				if _, err := os.Stat(path); !os.IsNotExist(err) {
+					t.Error("the journal file is left")
+				}
+			}
+			if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+				t.Error("the temporary file is left")
+			}
+
+			_, es := reopen(t, path)
+			var ids []uint
+			for _, e := range es {
+				ids = append(ids, e.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("IDs %v, want %v", ids, tt.wantIDs)
+			}
+		})
+	}
+}
+
+// Private snapshots are sealed in the file, and opened again by CopyTo.
+func TestPrivateSealed(t *testing.T) {
+	k, err := vault.DeriveKey("passphrase", vault.Params{Salt: []byte("salt-0123456789a"), Time: 1, Memory: 64, Threads: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vault.Unlock(k)
+	defer vault.Lock()
+
+	es := entries(3)
+	es[0].Thread.Private = true
+	es[2].Note.Private = true
+	es[2].Note.Content = "private content"
+	path := appended(t, es)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, text := range []string{"private content", "about it"} {
+		if strings.Contains(string(data), text) {
+			t.Errorf("%q is in the journal file", text)
+		}
+	}
+	if !strings.Contains(string(data), `"name":"branch"`) {
+		t.Error("the public branch is sealed")
+	}
+	if es[2].Note.Content != "private content" {
+		t.Error("Append sealed the entry passed to it")
+	}
+
+	_, read := reopen(t, path)
+	var note models.Note
+	read[2].Note.CopyTo(&note)
+	var thread models.Thread
+	read[0].Thread.CopyTo(&thread)
+	if note.Content != "private content" || thread.Summary != "about it" {
+		t.Errorf("CopyTo opened %q and %q", note.Content, thread.Summary)
+	}
+}
@@ -0,0 +1,265 @@
+package app
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/haochend413/ntkpr/internal/app/data"
+	"github.com/haochend413/ntkpr/internal/checklist"
+	"github.com/haochend413/ntkpr/internal/db"
+	"gorm.io/gorm/logger"
+)
+
+// A journal of 200k notes: 100 threads of 10 branches of 200 notes.
+const (
+	largeThreads  = 100
+	largeBranches = 10
+	largeNotes    = 200
+)
+
+// largeDB writes a synthetic journal with raw SQL, which is much faster than syncing it, and returns its path.
+func largeDB(tb testing.TB) string {
+	tb.Helper()
+	path := filepath.Join(tb.TempDir(), "large.db")
+	d, err := db.NewDB(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer d.Close()
+	d.Conn.Logger = logger.Discard
+	branches := largeThreads * largeBranches
+	notes := branches * largeNotes
+	stmts := []string{
+		`WITH RECURSIVE seq(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM seq WHERE i < ?)
+			INSERT INTO threads (id, created_at, updated_at, last_edit, name, summary)
+			SELECT i, datetime('now'), datetime('now'), datetime('now'), 'thread ' || i, 'thread ' || i FROM seq`,
+		`WITH RECURSIVE seq(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM seq WHERE i < ?)
+			INSERT INTO branches (id, created_at, updated_at, last_edit, thread_id, name, summary)
+			SELECT i, datetime('now'), datetime('now'), datetime('now'), (i - 1) / 10 + 1, 'branch ' || i, 'branch ' || i FROM seq`,
+		`WITH RECURSIVE seq(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM seq WHERE i < ?)
+			INSERT INTO notes (id, created_at, updated_at, last_edit, thread_id, content, checks_done, checks_total)
+			SELECT i, datetime('now'), datetime('now'), datetime('now'), (i - 1) / 2000 + 1, 'note ' || i || char(10) || '- [x] a' || char(10) || '- [ ] b', 1, 2 FROM seq`,
+		`WITH RECURSIVE seq(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM seq WHERE i < ?)
+			INSERT INTO branch_notes (branch_id, note_id, position) SELECT (i - 1) / 200 + 1, i, (i - 1) % 200 FROM seq`,
+	}
+	counts := []int{largeThreads, branches, notes, notes}
+	for i, stmt := range stmts {
+		if err := d.Conn.Exec(stmt, counts[i]).Error; err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return path
+}
+
+// openLarge opens the journal at path the way the TUI does.
+func openLarge(tb testing.TB, path string) (*db.DB, *App) {
+	tb.Helper()
+	d, err := db.NewDB(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	d.Conn.Logger = logger.Discard
+	return d, NewApp(d, nil)
+}
+
+// loadedNotes counts the notes held in memory.
+func loadedNotes(dm *data.DataMgr) int {
+	n := 0
+	for _, t := range dm.GetThreads() {
+		for _, b := range t.Branches {
+			n += len(b.Notes)
+		}
+	}
+	return n
+}
+
+func heapAlloc() uint64 {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapAlloc
+}
+
+func TestOpenLargeJournal(t *testing.T) {
+	if testing.Short() {
+		t.Skip("writes a journal of 200k notes")
+	}
+	path := largeDB(t)
+
+	before := heapAlloc()
+	start := time.Now()
+	d, a := openLarge(t, path)
+	defer d.Close()
+	elapsed := time.Since(start)
+	grown := int64(heapAlloc()) - int64(before)
+	t.Logf("opened %d notes in %v, heap grew by %d KiB", largeThreads*largeBranches*largeNotes, elapsed, grown/1024)
+
+	if elapsed > time.Second {
+		t.Errorf("opening took %v, want under a second", elapsed)
+	}
+	dm := a.GetDataMgr()
+	if got := len(dm.GetThreads()); got != largeThreads {
+		t.Fatalf("loaded %d threads, want %d", got, largeThreads)
+	}
+	// the active thread is loaded with one page of its active branch, nothing else
+	if got := loadedNotes(dm); got > data.NotePageSize {
+		t.Errorf("loaded %d notes, want at most a page of %d", got, data.NotePageSize)
+	}
+	if grown > 64<<20 {
+		t.Errorf("heap grew by %d MiB, want under 64 MiB", grown>>20)
+	}
+
+	// the counts are known without loading, and stay right once a thread is loaded
+	last := dm.GetThreads()[largeThreads-1]
+	want := largeBranches * largeNotes
+	if got := dm.ThreadChecks(last).Total; got != 2*want {
+		t.Errorf("thread checkboxes %d, want %d", got, 2*want)
+	}
+	dm.SwitchActiveThreadByID(last.ID)
+	if got := dm.ThreadChecks(last).Total; got != 2*want {
+		t.Errorf("thread checkboxes after loading %d, want %d", got, 2*want)
+	}
+	if got := loadedNotes(dm); got > 2*data.NotePageSize {
+		t.Errorf("loaded %d notes after switching threads, want at most %d", got, 2*data.NotePageSize)
+	}
+}
+
+// Visiting every branch keeps the notes of the last ones only.
+func TestVisitLargeJournal(t *testing.T) {
+	if testing.Short() {
+		t.Skip("writes a journal of 200k notes")
+	}
+	path := largeDB(t)
+
+	before := heapAlloc()
+	d, a := openLarge(t, path)
+	defer d.Close()
+	dm := a.GetDataMgr()
+	for _, th := range dm.GetThreads() {
+		dm.SwitchActiveThreadByID(th.ID)
+		for _, b := range th.Branches {
+			dm.SwitchActiveBranchByID(b.ID)
+		}
+	}
+	grown := int64(heapAlloc()) - int64(before)
+	t.Logf("visited %d branches, heap grew by %d KiB", largeThreads*largeBranches, grown/1024)
+
+	if got, limit := loadedNotes(dm), data.LoadedBranchLimit*data.NotePageSize; got > limit {
+		t.Errorf("loaded %d notes, want at most %d", got, limit)
+	}
+	if grown > 16<<20 {
+		t.Errorf("heap grew by %d MiB, want under 16 MiB", grown>>20)
+	}
+	first := dm.GetThreads()[0]
+	if got := dm.NoteCount(first.Branches[0]); got != largeNotes {
+		t.Errorf("a dropped branch counts %d notes, want %d", got, largeNotes)
+	}
+	if got := dm.ThreadChecks(first).Total; got != 2*largeBranches*largeNotes {
+		t.Errorf("a dropped thread counts %d checkboxes, want %d", got, 2*largeBranches*largeNotes)
+	}
+}
+
+// Branches with edits to sync keep their notes, the counts of the branches that dropped theirs stay right,
+// and a note listed by several branches is shared by them once they are loaded.
+func TestDropNotesOfInactiveBranches(t *testing.T) {
+	d, a := testApp(t)
+	threadID, _, _ := seed(t, a)
+	var branches []uint
+	for range data.LoadedBranchLimit + 4 {
+		a.goTo(threadID, 0, 0)
+		id := a.CreateNewBranch(nil)
+		a.goTo(threadID, id, 0)
+		for range 2 {
+			a.goTo(threadID, id, a.CreateNewNote(nil))
+			a.SetCurrentNoteContent("- [x] a\n- [ ] b", nil)
+		}
+		branches = append(branches, id)
+	}
+	syncApp(t, a)
+	// note 1 is in branches 2 and 3 too
+	if err := a.LinkNote(1, 2, 1, 3, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.LinkNote(1, 2, 1, 4, nil); err != nil {
+		t.Fatal(err)
+	}
+	syncApp(t, a)
+
+	a = NewApp(d, nil)
+	dm := a.GetDataMgr()
+	thread := dm.GetActiveThread()
+	counts := func() []string {
+		var out []string
+		for _, b := range thread.Branches {
+			out = append(out, fmt.Sprintf("branch %d: %d notes, %s", b.ID, dm.NoteCount(b), dm.BranchChecks(b)))
+		}
+		return append(out, "thread: "+dm.ThreadChecks(thread).String())
+	}
+	want := counts()
+
+	// edited in branch 3, the note shared with branch 2, loaded first, and branch 4, loaded next, keeps the three loaded
+	a.goTo(1, 3, 1)
+	a.SetCurrentNoteContent("- [x] edited", nil)
+	for _, b := range thread.Branches {
+		dm.SwitchActiveBranchByID(b.ID)
+	}
+	var loaded []uint
+	for _, b := range thread.Branches {
+		if len(b.Notes) > 0 {
+			loaded = append(loaded, b.ID)
+		}
+	}
+	if len(loaded) > data.LoadedBranchLimit || !slices.Contains(loaded, 2) || !slices.Contains(loaded, 3) || !slices.Contains(loaded, 4) {
+		t.Errorf("branches %v keep their notes, want at most %d with 2, 3 and 4", loaded, data.LoadedBranchLimit)
+	}
+	if slices.Contains(loaded, 5) {
+		t.Error("branch 5 keeps its notes")
+	}
+	// note 1 had one of two boxes ticked, and has one of one
+	want[1] = fmt.Sprintf("branch 2: 2 notes, %s", checklist.Counts{Done: 2, Total: 3})
+	want[2] = fmt.Sprintf("branch 3: 3 notes, %s", checklist.Counts{Done: 3, Total: 5})
+	want[3] = fmt.Sprintf("branch 4: 3 notes, %s", checklist.Counts{Done: 3, Total: 5})
+	want[len(want)-1] = "thread: " + checklist.Counts{Done: 40, Total: 79}.String()
+	if got := counts(); !reflect.DeepEqual(got, want) {
+		t.Errorf("counts after dropping notes\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	a.goTo(1, 4, 1)
+	if got := a.GetCurrentNoteContent(); got != "- [x] edited" {
+		t.Errorf("branch 4 lists note 1 as %q", got)
+	}
+	syncApp(t, a)
+	var content string
+	d.Conn.Raw(`SELECT content FROM notes WHERE id = 1`).Scan(&content)
+	if content != "- [x] edited" {
+		t.Errorf("stored note 1 is %q, want the edit made in branch 3", content)
+	}
+}
+
+func BenchmarkOpenLargeJournal(b *testing.B) {
+	path := largeDB(b)
+	b.ResetTimer()
+	for b.Loop() {
+		d, _ := openLarge(b, path)
+		d.Close()
+	}
+}
+
+func BenchmarkSwitchLargeThread(b *testing.B) {
+	d, a := openLarge(b, largeDB(b))
+	defer d.Close()
+	dm := a.GetDataMgr()
+	threads := dm.GetThreads()
+	b.ResetTimer()
+	i := 0
+	for b.Loop() {
+		dm.SwitchActiveThreadByID(threads[i%len(threads)].ID)
+		i++
+	}
+}
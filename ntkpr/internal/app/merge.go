@@ -0,0 +1,166 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	editstack "github.com/haochend413/ntkpr/internal/app/editStack"
+	"github.com/haochend413/ntkpr/internal/app/journal"
+	"github.com/haochend413/ntkpr/internal/models"
+)
+
+// merge.go merges a branch into another branch of its thread. Every note of the merged branch is added to the other one
+// with a LinkNote edit, the notes are put in creation order, its summary is appended to the other summary, and it is kept, archived or deleted afterwards.
+// All of it is tracked in the EditMgr, so the next sync writes the merge in one transaction, and it is undone as one action.
+
+// MergeFate is what becomes of a branch after it was merged into another one.
+type MergeFate int
+
+const (
+	MergeKeep    MergeFate = iota // the branch stays as it was
+	MergeArchive                  // the branch is archived, see Branch.Archived
+	MergeDelete                   // the branch is deleted; its notes stay in the branch it was merged into
+)
+
+var (
+	ErrMergeSelf        = errors.New("a branch cannot be merged into itself")
+	ErrMergeOtherThread = errors.New("only branches of the same thread can be merged")
+)
+
+// MergeBranch merges the branch with fromID into the branch with intoID, and makes the latter active.
+// The notes of both end up in it in creation order, and its summary is followed by the summary of the merged branch,
+// see MergedSummary. The merge can be undone.
+func (a *App) MergeBranch(intoID, fromID uint, fate MergeFate, link *models.Superlink) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if intoID == fromID {
+		return ErrMergeSelf
+	}
+	into := a.dataMgr.FindBranchByID(intoID)
+	from := a.dataMgr.FindBranchByID(fromID)
+	if into == nil || from == nil {
+		return fmt.Errorf("cannot merge branch %d into branch %d: a branch is gone", fromID, intoID)
+	}
+	if into.ThreadID != from.ThreadID {
+		return ErrMergeOtherThread
+	}
+	// the summaries are joined
+	if sealed(into.Summary, from.Summary) {
+		return ErrLocked
+	}
+	threadID := into.ThreadID
+	// notes on pages that are not loaded yet are merged too
+	for _, id := range []uint{intoID, fromID} {
+		if !a.goTo(threadID, id, 0) {
+			return fmt.Errorf("branch %d is gone", id)
+		}
+		a.dataMgr.LoadAllNotes()
+	}
+
+	listed := make(map[uint]bool, len(into.Notes))
+	for _, n := range into.Notes {
+		listed[n.ID] = true
+	}
+	var added []uint
+	for _, n := range from.Notes {
+		if !listed[n.ID] {
+			added = append(added, n.ID)
+		}
+	}
+
+	merge := &change{editType: editstack.UpdateBranch, threadID: threadID, branchID: intoID, link: link}
+	if err := a.mergeBranch(merge, into, from, added, fate); err != nil {
+		// take back what was merged before the error
+		if undoErr := a.applyChange(merge, true); undoErr != nil {
+			return fmt.Errorf("%w, and cannot take the merge back: %v", err, undoErr)
+		}
+		return err
+	}
+	a.pushChange(merge)
+	a.goTo(threadID, intoID, 0)
+	return nil
+}
+
+// mergeBranch makes the changes of a merge and adds them to merge as its parts. Callers hold the mutex.
+func (a *App) mergeBranch(merge *change, into, from *models.Branch, added []uint, fate MergeFate) error {
+	threadID, link := into.ThreadID, merge.link
+	for _, id := range added {
+		note := a.findNote(id)
+		if note == nil {
+			return fmt.Errorf("note %d is gone", id)
+		}
+		before := journal.NoteOf(note)
+		branchIDs := append(append([]uint{}, before.BranchIDs...), into.ID)
+		if err := a.placeNote(id, threadID, branchIDs, editstack.LinkNote, link); err != nil {
+			return err
+		}
+		c := a.newChange(editstack.LinkNote, link)
+		c.before = before
+		c.after = journal.NoteOf(a.getCurrentNote())
+		merge.parts = append(merge.parts, c)
+	}
+	// creation order, which is the order of the IDs; new notes have the highest
+	before := noteIDs(into.Notes)
+	after := slices.Sorted(slices.Values(before))
+	if !slices.Equal(before, after) {
+		if err := a.orderNotes(threadID, into.ID, after, link); err != nil {
+			return err
+		}
+		c := &change{editType: editstack.ReorderNotes, threadID: threadID, branchID: into.ID, link: link, before: before, after: after}
+		merge.parts = append(merge.parts, c)
+	}
+
+	if summary := MergedSummary(into.Summary, from.Summary); summary != into.Summary {
+		if err := a.updateBranch(merge, into, func(b *models.Branch) { b.Summary = summary }); err != nil {
+			return err
+		}
+	}
+
+	switch fate {
+	case MergeArchive:
+		return a.updateBranch(merge, from, func(b *models.Branch) { b.Archived = true })
+	case MergeDelete:
+		c := &change{editType: editstack.DeleteBranch, threadID: threadID, branchID: from.ID, link: link}
+		if err := a.takeAway(c); err != nil {
+			return err
+		}
+		merge.parts = append(merge.parts, c)
+	}
+	return nil
+}
+
+// updateBranch applies set to a branch, tracks the update, and adds it to merge as a part. Callers hold the mutex.
+func (a *App) updateBranch(merge *change, branch *models.Branch, set func(*models.Branch)) error {
+	if !a.goTo(branch.ThreadID, branch.ID, 0) {
+		return fmt.Errorf("branch %d is gone", branch.ID)
+	}
+	before := journal.BranchOf(branch)
+	set(branch)
+	branch.LastEdit = time.Now()
+	branch.UpdatedAt = time.Now()
+	a.Synced = false
+	edit := &editstack.Edit{ID: branch.ID, EditType: editstack.UpdateBranch}
+	if err := a.trackEdit(edit, merge.link, branch); err != nil {
+		return err
+	}
+	c := a.newChange(editstack.UpdateBranch, merge.link)
+	c.before = before
+	c.after = journal.BranchOf(branch)
+	merge.parts = append(merge.parts, c)
+	return nil
+}
+
+// MergedSummary returns the summary of a branch after another branch with summary from was merged into it:
+// both, separated by an empty line, or the one that is not empty.
+func MergedSummary(into, from string) string {
+	switch {
+	case from == "" || from == into:
+		return into
+	case into == "":
+		return from
+	}
+	return into + "\n\n" + from
+}
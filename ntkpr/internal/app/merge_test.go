@@ -0,0 +1,217 @@
+package app
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/haochend413/ntkpr/internal/db"
+	"gorm.io/gorm"
+)
+
+// branchRow is what the database holds of a branch, deleted or not.
+type branchRow struct {
+	Summary  string
+	Archived bool
+	Deleted  bool
+}
+
+func branchRowOf(t *testing.T, d *db.DB, id uint) branchRow {
+	t.Helper()
+	var row branchRow
+	if err := d.Conn.Raw(`SELECT summary, archived, deleted_at IS NOT NULL AS deleted FROM branches WHERE id = ?`, id).Scan(&row).Error; err != nil {
+		t.Fatal(err)
+	}
+	return row
+}
+
+// mergeSetup makes thread 1 with branch 1, which lists notes 1 and 2 and is summed up as "one", and branch 2,
+// which lists note 3 and then note 1, and is summed up as "two". Thread 2 has branch 3.
+func mergeSetup(t *testing.T) (*db.DB, *App) {
+	t.Helper()
+	d, a := testApp(t)
+	threadID, _, _ := seed(t, a, "one", "two")
+	a.SetCurrentBranchSummary("one", nil)
+	a.goTo(threadID, 0, 0)
+	branchID := a.CreateNewBranch(nil)
+	a.goTo(threadID, branchID, 0)
+	a.goTo(threadID, branchID, a.CreateNewNote(nil))
+	a.SetCurrentNoteContent("three", nil)
+	a.SetCurrentBranchSummary("two", nil)
+	seed(t, a)
+	syncApp(t, a)
+	if err := a.LinkNote(1, 1, 1, 2, nil); err != nil {
+		t.Fatal(err)
+	}
+	syncApp(t, a)
+	if got := notesOf(t, d, 2); !reflect.DeepEqual(got, []uint{3, 1}) {
+		t.Fatalf("branch 2 lists %v, want [3 1]", got)
+	}
+	a.undoStack = nil
+	return d, a
+}
+
+// A merge puts the notes of both branches in creation order and joins their summaries, does what the fate says with
+// the merged branch, and is undone and redone as one action.
+func TestMergeBranch(t *testing.T) {
+	tests := []struct {
+		name string
+		fate MergeFate
+		from branchRow
+	}{
+		{name: "keep", fate: MergeKeep, from: branchRow{Summary: "one"}},
+		{name: "archive", fate: MergeArchive, from: branchRow{Summary: "one", Archived: true}},
+		{name: "delete", fate: MergeDelete, from: branchRow{Summary: "one", Deleted: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			d, a := mergeSetup(t)
+			if err := a.MergeBranch(2, 1, tt.fate, nil); err != nil {
+				t.Fatal(err)
+			}
+			if a.GetCurrentBranchID() != 2 {
+				t.Errorf("active branch %d, want the one merged into", a.GetCurrentBranchID())
+			}
+			into := a.GetDataMgr().FindBranchByID(2)
+			if got := noteIDs(into.Notes); !reflect.DeepEqual(got, []uint{1, 2, 3}) {
+				t.Errorf("loaded, branch 2 lists %v, want [1 2 3]", got)
+			}
+			syncApp(t, a)
+			merged := func(when string) {
+				t.Helper()
+				if got := notesOf(t, d, 2); !reflect.DeepEqual(got, []uint{1, 2, 3}) {
+					t.Errorf("%s, branch 2 lists %v, want [1 2 3]", when, got)
+				}
+				if got := placeOf(t, d, 2); got != "thread 1 [1 2]" {
+					t.Errorf("%s, note 2 is in %s", when, got)
+				}
+				if got, want := branchRowOf(t, d, 2), (branchRow{Summary: "two\n\none"}); got != want {
+					t.Errorf("%s, branch 2 is %+v, want %+v", when, got, want)
+				}
+				if got := branchRowOf(t, d, 1); got != tt.from {
+					t.Errorf("%s, branch 1 is %+v, want %+v", when, got, tt.from)
+				}
+			}
+			merged("merged")
+
+			if _, err := a.Undo(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := a.Undo(); !errors.Is(err, ErrNothingToUndo) {
+				t.Errorf("undid %v after the merge, want it to be one action", err)
+			}
+			syncApp(t, a)
+			if got := notesOf(t, d, 2); !reflect.DeepEqual(got, []uint{3, 1}) {
+				t.Errorf("undone, branch 2 lists %v, want [3 1]", got)
+			}
+			if got := placeOf(t, d, 2); got != "thread 1 [1]" {
+				t.Errorf("undone, note 2 is in %s", got)
+			}
+			if got, want := branchRowOf(t, d, 2), (branchRow{Summary: "two"}); got != want {
+				t.Errorf("undone, branch 2 is %+v, want %+v", got, want)
+			}
+			if got, want := branchRowOf(t, d, 1), (branchRow{Summary: "one"}); got != want {
+				t.Errorf("undone, branch 1 is %+v, want %+v", got, want)
+			}
+
+			if _, err := a.Redo(); err != nil {
+				t.Fatal(err)
+			}
+			syncApp(t, a)
+			merged("redone")
+		})
+	}
+}
+
+func TestMergeBranchRefused(t *testing.T) {
+	d, a := mergeSetup(t)
+	if err := a.MergeBranch(1, 1, MergeKeep, nil); !errors.Is(err, ErrMergeSelf) {
+		t.Errorf("merged a branch into itself: %v", err)
+	}
+	if err := a.MergeBranch(1, 3, MergeKeep, nil); !errors.Is(err, ErrMergeOtherThread) {
+		t.Errorf("merged a branch of another thread: %v", err)
+	}
+	if len(a.undoStack) != 0 || len(a.GetEditMap()) != 0 {
+		t.Errorf("refused merges left %d actions and %d edits", len(a.undoStack), len(a.GetEditMap()))
+	}
+	syncApp(t, a)
+	if got := notesOf(t, d, 1); !reflect.DeepEqual(got, []uint{1, 2}) {
+		t.Errorf("branch 1 lists %v, want [1 2]", got)
+	}
+}
+
+// The edits of a merge are written in one transaction: when one of them fails, none is stored, and the next sync
+// writes them all.
+func TestMergeBranchSyncsAtOnce(t *testing.T) {
+	d, a := mergeSetup(t)
+	if err := a.MergeBranch(2, 1, MergeArchive, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// the branches fail to be written, after the notes were linked
+	boom := errors.New("boom")
+	fail := func(tx *gorm.DB) {
+		if tx.Statement.Table == "branches" {
+			tx.AddError(boom)
+		}
+	}
+	callbacks := d.Conn.Callback()
+	if err := callbacks.Create().Before("gorm:create").Register("test:fail", fail); err != nil {
+		t.Fatal(err)
+	}
+	if err := callbacks.Update().Before("gorm:update").Register("test:fail", fail); err != nil {
+		t.Fatal(err)
+	}
+	job, err := a.StartSync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	job.Run()
+	if err := a.FinishSync(job); !errors.Is(err, boom) {
+		t.Fatalf("synced with %v, want the failure of the branches", err)
+	}
+	if got := notesOf(t, d, 2); !reflect.DeepEqual(got, []uint{3, 1}) {
+		t.Errorf("rolled back, branch 2 lists %v, want [3 1]", got)
+	}
+	if got := placeOf(t, d, 2); got != "thread 1 [1]" {
+		t.Errorf("rolled back, note 2 is in %s", got)
+	}
+	if got, want := branchRowOf(t, d, 1), (branchRow{Summary: "one"}); got != want {
+		t.Errorf("rolled back, branch 1 is %+v, want %+v", got, want)
+	}
+
+	if err := callbacks.Create().Remove("test:fail"); err != nil {
+		t.Fatal(err)
+	}
+	if err := callbacks.Update().Remove("test:fail"); err != nil {
+		t.Fatal(err)
+	}
+	syncApp(t, a)
+	if got := notesOf(t, d, 2); !reflect.DeepEqual(got, []uint{1, 2, 3}) {
+		t.Errorf("branch 2 lists %v, want [1 2 3]", got)
+	}
+	if got, want := branchRowOf(t, d, 2), (branchRow{Summary: "two\n\none"}); got != want {
+		t.Errorf("branch 2 is %+v, want %+v", got, want)
+	}
+	if got, want := branchRowOf(t, d, 1), (branchRow{Summary: "one", Archived: true}); got != want {
+		t.Errorf("branch 1 is %+v, want %+v", got, want)
+	}
+}
+
+func TestMergedSummary(t *testing.T) {
+	tests := []struct {
+		into, from, want string
+	}{
+		{"into", "from", "into\n\nfrom"},
+		{"", "from", "from"},
+		{"into", "", "into"},
+		{"same", "same", "same"},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		if got := MergedSummary(tt.into, tt.from); got != tt.want {
+			t.Errorf("MergedSummary(%q, %q) = %q, want %q", tt.into, tt.from, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+
+	editstack "github.com/haochend413/ntkpr/internal/app/editStack"
+	"github.com/haochend413/ntkpr/internal/app/journal"
+	"github.com/haochend413/ntkpr/internal/models"
+)
+
+// move.go moves notes between branches, to other threads too, and adds notes to more branches of their thread.
+// A note belongs to one thread and can be listed by several of its branches. Both are tracked as edits of the note,
+// MoveNote and LinkNote: the sync writes its thread and replaces its branch_notes rows from Note.Branches.
+
+var (
+	ErrAlreadyInBranch = errors.New("the note is already in that branch")
+	ErrOtherThread     = errors.New("a note can only be added to branches of its own thread")
+)
+
+// MoveNote moves a note out of the branch with branchID into the branch with toBranchID, and makes it active there.
+// Moved within its thread, the note stays in its other branches. Moved to another thread, it leaves all of them,
+// since a note has one thread. The move can be undone.
+func (a *App) MoveNote(threadID, branchID, noteID, toBranchID uint, link *models.Superlink) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	note, to, err := a.noteAndBranch(threadID, branchID, noteID, toBranchID)
+	if err != nil {
+		return err
+	}
+	before := journal.NoteOf(note)
+	var branchIDs []uint
+	if to.ThreadID == note.ThreadID {
+		for _, id := range before.BranchIDs {
+			if id == toBranchID {
+				return ErrAlreadyInBranch
+			}
+			if id != branchID {
+				branchIDs = append(branchIDs, id)
+			}
+		}
+	}
+	branchIDs = append(branchIDs, toBranchID)
+	return a.relocateNote(noteID, before, to.ThreadID, branchIDs, editstack.MoveNote, link)
+}
+
+// LinkNote adds a note to the branch with toBranchID in the same thread, keeps it in its branches, and makes it active there.
+// The link can be undone.
+func (a *App) LinkNote(threadID, branchID, noteID, toBranchID uint, link *models.Superlink) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	note, to, err := a.noteAndBranch(threadID, branchID, noteID, toBranchID)
+	if err != nil {
+		return err
+	}
+	if to.ThreadID != note.ThreadID {
+		return ErrOtherThread
+	}
+	before := journal.NoteOf(note)
+	for _, id := range before.BranchIDs {
+		if id == toBranchID {
+			return ErrAlreadyInBranch
+		}
+	}
+	branchIDs := append(append([]uint{}, before.BranchIDs...), toBranchID)
+	return a.relocateNote(noteID, before, to.ThreadID, branchIDs, editstack.LinkNote, link)
+}
+
+// noteAndBranch finds a note where it is listed and the loaded branch it goes to. Callers hold the mutex.
+func (a *App) noteAndBranch(threadID, branchID, noteID, toBranchID uint) (*models.Note, *models.Branch, error) {
+	if !a.goTo(threadID, branchID, noteID) {
+		return nil, nil, fmt.Errorf("note %d is gone", noteID)
+	}
+	note := a.getCurrentNote()
+	to := a.dataMgr.FindBranchByID(toBranchID)
+	if to == nil {
+		return nil, nil, fmt.Errorf("branch %d is gone", toBranchID)
+	}
+	return note, to, nil
+}
+
+// relocateNote places a note and records the action for Undo. Callers hold the mutex.
+func (a *App) relocateNote(noteID uint, before *journal.Note, threadID uint, branchIDs []uint, tp editstack.EditType, link *models.Superlink) error {
+	if err := a.placeNote(noteID, threadID, branchIDs, tp, link); err != nil {
+		return err
+	}
+	c := a.newChange(tp, link)
+	c.before = before
+	c.after = journal.NoteOf(a.getCurrentNote())
+	a.pushChange(c)
+	return nil
+}
+
+// placeNote puts a note into the given branches of a thread, and out of the ones it leaves, and tracks the edit as tp.
+// The note becomes active in the first branch it joined, or in the first one it stays in. It is used to move and link notes,
+// to undo and redo that, and to replay it from the journal. Callers hold the mutex.
+func (a *App) placeNote(noteID, threadID uint, branchIDs []uint, tp editstack.EditType, link *models.Superlink) error {
+	dm := a.dataMgr
+	note := a.findNote(noteID)
+	if note == nil {
+		return fmt.Errorf("note %d is gone", noteID)
+	}
+	if len(branchIDs) == 0 {
+		return fmt.Errorf("note %d would be in no branch", noteID)
+	}
+	targets := make([]*models.Branch, 0, len(branchIDs))
+	for _, id := range branchIDs {
+		if !a.goTo(threadID, id, 0) {
+			return fmt.Errorf("branch %d is gone", id)
+		}
+		targets = append(targets, a.getCurrentBranch())
+	}
+
+	stays := make(map[uint]bool, len(branchIDs))
+	for _, id := range branchIDs {
+		stays[id] = true
+	}
+	was := make(map[uint]bool, len(note.Branches))
+	for _, b := range note.Branches {
+		was[b.ID] = true
+		if stays[b.ID] {
+			continue
+		}
+		// a branch of a thread that is not loaded lists no notes
+		if loaded := dm.FindBranchByID(b.ID); loaded != nil {
+			dm.UnlistNote(loaded, noteID)
+			a.touchBranch(loaded, link)
+		}
+	}
+
+	note.ThreadID = threadID
+	note.Branches = targets
+	landing := targets[0].ID
+	joined := false
+	for _, b := range targets {
+		if was[b.ID] {
+			dm.ReplaceNote(b, note)
+			continue
+		}
+		dm.ListNote(b, note)
+		a.touchBranch(b, link)
+		if !joined {
+			landing, joined = b.ID, true
+		}
+	}
+	a.Synced = false
+
+	edit := &editstack.Edit{ID: noteID, EditType: tp}
+	if err := a.trackEdit(edit, link, note); err != nil {
+		return err
+	}
+	a.editMgr.RelinkNote(noteID, threadID, landing)
+	a.goTo(threadID, landing, noteID)
+	return nil
+}
+
+// findNote returns a loaded note by ID, loading it where it is stored when it is not loaded yet. Callers hold the mutex.
+func (a *App) findNote(id uint) *models.Note {
+	if note := a.dataMgr.FindNoteByID(id); note != nil {
+		return note
+	}
+	if a.db == nil || models.IsTempID(id) {
+		return nil
+	}
+	stored, err := a.db.LoadNotesByID([]uint{id})
+	if err != nil || len(stored) == 0 || len(stored[0].Branches) == 0 {
+		return nil
+	}
+	if !a.goTo(stored[0].ThreadID, stored[0].Branches[0].ID, id) {
+		return nil
+	}
+	return a.getCurrentNote()
+}
@@ -0,0 +1,162 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/haochend413/ntkpr/internal/db"
+	"github.com/haochend413/ntkpr/internal/models"
+)
+
+// placeOf says where the database puts a note: "thread 1 [1 2]" for a note of thread 1 in branches 1 and 2.
+func placeOf(t *testing.T, d *db.DB, noteID uint) string {
+	t.Helper()
+	var threadID uint
+	var branches []uint
+	if err := d.Conn.Raw(`SELECT thread_id FROM notes WHERE id = ?`, noteID).Scan(&threadID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Conn.Raw(`SELECT branch_id FROM branch_notes WHERE note_id = ? ORDER BY branch_id`, noteID).Scan(&branches).Error; err != nil {
+		t.Fatal(err)
+	}
+	return fmt.Sprintf("thread %d %v", threadID, branches)
+}
+
+// loadedPlaceOf says where the loaded data puts a note, like placeOf, loading every branch.
+// The branches that list the note must list the same one, and be the ones it lists.
+func loadedPlaceOf(t *testing.T, a *App, noteID uint) string {
+	t.Helper()
+	dm := a.GetDataMgr()
+	var note *models.Note
+	var branches []uint
+	for _, th := range dm.GetThreads() {
+		dm.SwitchActiveThreadByID(th.ID)
+		for _, b := range th.Branches {
+			dm.SwitchActiveBranchByID(b.ID)
+			dm.LoadAllNotes()
+			for _, n := range b.Notes {
+				if n.ID != noteID {
+					continue
+				}
+				if note != nil && n != note {
+					t.Errorf("branch %d lists a copy of note %d", b.ID, noteID)
+				}
+				note = n
+				branches = append(branches, b.ID)
+			}
+		}
+	}
+	if note == nil {
+		return "nowhere"
+	}
+	var listed []uint
+	for _, b := range note.Branches {
+		listed = append(listed, b.ID)
+	}
+	slices.Sort(listed)
+	slices.Sort(branches)
+	if !slices.Equal(listed, branches) {
+		t.Errorf("note %d lists branches %v, is listed by %v", noteID, listed, branches)
+	}
+	return fmt.Sprintf("thread %d %v", note.ThreadID, branches)
+}
+
+// Moved within its thread, a note stays in its other branches; moved to another thread, it leaves them all.
+// Linked, it stays where it is too. Both are synced as edits of the note, and undone and redone like any other.
+func TestMoveAndLinkNote(t *testing.T) {
+	// thread 1 has branches 1, 2 and 3, the notes 1 and 2 in branch 1; thread 2 has branch 4, note 3 in it
+	setup := func(t *testing.T) (*db.DB, *App) {
+		d, a := testApp(t)
+		threadID, _, _ := seed(t, a, "one", "two")
+		a.goTo(threadID, 0, 0)
+		a.CreateNewBranch(nil)
+		a.CreateNewBranch(nil)
+		seed(t, a, "three")
+		syncApp(t, a)
+		return d, a
+	}
+	tests := []struct {
+		name    string
+		linked  []uint // branches note 1 is linked to first
+		link    bool   // link instead of move
+		from    uint
+		to      uint
+		want    string
+		wantErr error
+	}{
+		{name: "move to a branch of its thread", from: 1, to: 2, want: "thread 1 [2]"},
+		{name: "move out of one of its branches", linked: []uint{2}, from: 1, to: 3, want: "thread 1 [2 3]"},
+		{name: "move to another thread", linked: []uint{2}, from: 1, to: 4, want: "thread 2 [4]"},
+		{name: "move to a branch it is in", linked: []uint{2}, from: 1, to: 2, wantErr: ErrAlreadyInBranch},
+		{name: "link", link: true, from: 1, to: 2, want: "thread 1 [1 2]"},
+		{name: "link again", linked: []uint{2}, link: true, from: 1, to: 3, want: "thread 1 [1 2 3]"},
+		{name: "link to a branch it is in", linked: []uint{2}, link: true, from: 2, to: 1, wantErr: ErrAlreadyInBranch},
+		{name: "link to another thread", link: true, from: 1, to: 4, wantErr: ErrOtherThread},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			d, a := setup(t)
+			for _, id := range tt.linked {
+				if err := a.LinkNote(1, 1, 1, id, nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+			syncApp(t, a)
+			before := placeOf(t, d, 1)
+
+			place := a.MoveNote
+			if tt.link {
+				place = a.LinkNote
+			}
+			err := place(1, tt.from, 1, tt.to, nil)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if got := loadedPlaceOf(t, a, 1); got != before {
+					t.Errorf("refused, the note is loaded in %s, want %s", got, before)
+				}
+				return
+			}
+			if th, b, n := a.GetCurrentThreadID(), a.GetCurrentBranchID(), a.GetCurrentNoteID(); th != a.GetDataMgr().FindBranchByID(tt.to).ThreadID || b != tt.to || n != 1 {
+				t.Errorf("active %d/%d/%d, want the note in branch %d", th, b, n, tt.to)
+			}
+			if got := loadedPlaceOf(t, a, 1); got != tt.want {
+				t.Errorf("loaded in %s, want %s", got, tt.want)
+			}
+			syncApp(t, a)
+			if got := placeOf(t, d, 1); got != tt.want {
+				t.Errorf("stored in %s, want %s", got, tt.want)
+			}
+
+			if _, err := a.Undo(); err != nil {
+				t.Fatal(err)
+			}
+			if got := loadedPlaceOf(t, a, 1); got != before {
+				t.Errorf("undone, loaded in %s, want %s", got, before)
+			}
+			syncApp(t, a)
+			if got := placeOf(t, d, 1); got != before {
+				t.Errorf("undone, stored in %s, want %s", got, before)
+			}
+			if _, err := a.Redo(); err != nil {
+				t.Fatal(err)
+			}
+			syncApp(t, a)
+			if got := placeOf(t, d, 1); got != tt.want {
+				t.Errorf("redone, stored in %s, want %s", got, tt.want)
+			}
+
+			// the other notes stay where they were, and a fresh load agrees
+			if got := placeOf(t, d, 2); got != "thread 1 [1]" {
+				t.Errorf("note 2 is stored in %s", got)
+			}
+			if got := loadedPlaceOf(t, NewApp(d, nil), 1); got != tt.want {
+				t.Errorf("loaded afresh in %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/haochend413/ntkpr/internal/app/context"
+	editstack "github.com/haochend413/ntkpr/internal/app/editStack"
+	"github.com/haochend413/ntkpr/internal/db"
+	"github.com/haochend413/ntkpr/internal/models"
+)
+
+// order.go exposes the sort order of each table, and maps items to their rows in the sorted lists.
+// The DataMgr keeps its own load order, so the UI must never use its pointers as table rows.
+// The notes of a branch also have a manual order, their order in Branch.Notes, which the sync writes to branch_notes.position.
+
+var (
+	ErrNoteAtEdge     = errors.New("the note cannot move further in its branch")
+	ErrOrderOfResults = errors.New("search results keep their rank, clear the search to move notes")
+)
+
+// GetSortOrder returns the order of the table of one kind.
+func (a *App) GetSortOrder(kind string) context.SortOrder {
+	if mgr := a.contextsOf(kind); mgr != nil {
+		return mgr.GetSortOrder()
+	}
+	return context.SortOrder{}
+}
+
+// CycleSortOrder moves the table of one kind to the next order of context.Orders and returns it.
+func (a *App) CycleSortOrder(kind string) context.SortOrder {
+	mgr := a.contextsOf(kind)
+	if mgr == nil {
+		return context.SortOrder{}
+	}
+	o := mgr.GetSortOrder().Next()
+	if o.By == context.Manual && kind != db.SearchKindNote {
+		// only notes are put in order by hand
+		o = o.Next()
+	}
+	mgr.SetSortOrder(o)
+	return o
+}
+
+// ToggleSortDirection flips the table of one kind between ascending and descending, and returns the new order.
+func (a *App) ToggleSortDirection(kind string) context.SortOrder {
+	mgr := a.contextsOf(kind)
+	if mgr == nil {
+		return context.SortOrder{}
+	}
+	o := mgr.GetSortOrder()
+	o.Descending = !o.Descending
+	mgr.SetSortOrder(o)
+	return o
+}
+
+// RowOf returns the row of an item in the table of one kind, or -1 when the table does not list it.
+func (a *App) RowOf(kind string, id uint) int {
+	switch kind {
+	case db.SearchKindThread:
+		for i, t := range a.GetThreadList() {
+			if t.ID == id {
+				return i
+			}
+		}
+	case db.SearchKindBranch:
+		for i, b := range a.GetActiveBranchList() {
+			if b.ID == id {
+				return i
+			}
+		}
+	case db.SearchKindNote:
+		for i, n := range a.GetActiveNoteList() {
+			if n.ID == id {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// ActiveRow returns the row of the active item in the table of one kind, or -1 when the table does not list it.
+func (a *App) ActiveRow(kind string) int {
+	switch kind {
+	case db.SearchKindThread:
+		return a.RowOf(kind, a.dataMgr.GetActiveThreadID())
+	case db.SearchKindBranch:
+		return a.RowOf(kind, a.dataMgr.GetActiveBranchID())
+	case db.SearchKindNote:
+		return a.RowOf(kind, a.dataMgr.GetActiveNoteID())
+	}
+	return -1
+}
+
+// MoveCurrentNote moves the current note one place up or down in its branch, as the notes table shows it,
+// and switches the notes table to the Manual order. The move can be undone.
+func (a *App) MoveCurrentNote(up bool, link *models.Superlink) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	mgr := a.contextMgr.NoteContextMgr
+	if mgr.GetCurrentContext().IsQuery() {
+		return ErrOrderOfResults
+	}
+	branch := a.getCurrentBranch()
+	note := a.getCurrentNote()
+	if branch == nil || note == nil {
+		return fmt.Errorf("no note to move")
+	}
+	o := mgr.GetSortOrder()
+	if o.By != context.Manual {
+		o = context.SortOrder{By: context.Manual}
+		mgr.SetSortOrder(o)
+	}
+	// the order is written for the whole branch
+	a.dataMgr.LoadAllNotes()
+
+	before := noteIDs(branch.Notes)
+	i := -1
+	for k, id := range before {
+		if id == note.ID {
+			i = k
+		}
+	}
+	j := i + 1
+	if up != o.Descending {
+		j = i - 1
+	}
+	if i < 0 || j < 0 || j >= len(before) {
+		return ErrNoteAtEdge
+	}
+	after := append([]uint{}, before...)
+	after[i], after[j] = after[j], after[i]
+
+	if err := a.orderNotes(branch.ThreadID, branch.ID, after, link); err != nil {
+		return err
+	}
+	c := a.newChange(editstack.ReorderNotes, link)
+	c.noteID = note.ID
+	c.before = before
+	c.after = after
+	a.pushChange(c)
+	a.goTo(branch.ThreadID, branch.ID, note.ID)
+	return nil
+}
+
+// orderNotes puts the notes of a branch in the order of ids, and tracks the reorder. Every note of the branch is loaded first,
+// since the sync writes the position of each. It is used to move notes, to undo and redo that, and to replay it from the journal.
+// Callers hold the mutex.
+func (a *App) orderNotes(threadID, branchID uint, ids []uint, link *models.Superlink) error {
+	if !a.goTo(threadID, branchID, 0) {
+		return fmt.Errorf("branch %d is gone", branchID)
+	}
+	a.dataMgr.LoadAllNotes()
+	branch := a.getCurrentBranch()
+	a.dataMgr.OrderNotes(branch, ids)
+	a.Synced = false
+	edit := &editstack.Edit{ID: branchID, EditType: editstack.ReorderNotes}
+	return a.trackEdit(edit, link, branch)
+}
+
+// noteIDs returns the IDs of notes, in order.
+func noteIDs(notes []*models.Note) []uint {
+	ids := make([]uint, len(notes))
+	for i, n := range notes {
+		ids[i] = n.ID
+	}
+	return ids
+}
@@ -0,0 +1,99 @@
+package app
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/haochend413/ntkpr/internal/app/context"
+	"github.com/haochend413/ntkpr/internal/db"
+)
+
+// activeNoteIDs lists the notes table as it is shown.
+func activeNoteIDs(a *App) []uint {
+	return noteIDs(a.GetActiveNoteList())
+}
+
+// Moving a note puts the notes table in the manual order, and the order of each branch is kept apart, also for
+// a note listed in several. The moves are synced and undone.
+func TestMoveCurrentNote(t *testing.T) {
+	d, a := testApp(t)
+	threadID, _, _ := seed(t, a, "one", "two", "three")
+	a.goTo(threadID, 0, 0)
+	branchID := a.CreateNewBranch(nil)
+	a.goTo(threadID, branchID, 0)
+	a.goTo(threadID, branchID, a.CreateNewNote(nil))
+	syncApp(t, a)
+	// branch 2 lists note 4, then notes 1 and 2
+	for _, id := range []uint{1, 2} {
+		if err := a.LinkNote(1, 1, id, 2, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	syncApp(t, a)
+	a.undoStack = nil
+
+	a.goTo(1, 1, 1)
+	if err := a.MoveCurrentNote(false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if o := a.GetSortOrder(db.SearchKindNote); o != (context.SortOrder{By: context.Manual}) {
+		t.Errorf("the notes table is in %+v order, want the manual one", o)
+	}
+	if got, want := activeNoteIDs(a), []uint{2, 1, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("branch 1 shows %v, want %v", got, want)
+	}
+	if a.GetCurrentNoteID() != 1 {
+		t.Errorf("active note %d, want the moved one", a.GetCurrentNoteID())
+	}
+	a.goTo(1, 1, 3)
+	if err := a.MoveCurrentNote(false, nil); !errors.Is(err, ErrNoteAtEdge) {
+		t.Errorf("moved the last note down: %v", err)
+	}
+	a.goTo(1, 2, 2)
+	if err := a.MoveCurrentNote(true, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := activeNoteIDs(a), []uint{4, 2, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("branch 2 shows %v, want %v", got, want)
+	}
+	syncApp(t, a)
+	if got, want := notesOf(t, d, 1), []uint{2, 1, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("branch 1 stored as %v, want %v", got, want)
+	}
+	if got, want := notesOf(t, d, 2), []uint{4, 2, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("branch 2 stored as %v, want %v", got, want)
+	}
+
+	// the order is read back per branch
+	fresh := NewApp(d, nil)
+	for id, want := range map[uint][]uint{1: {2, 1, 3}, 2: {4, 2, 1}} {
+		fresh.goTo(1, id, 0)
+		fresh.GetDataMgr().LoadAllNotes()
+		if got := noteIDs(fresh.GetDataMgr().FindBranchByID(id).Notes); !reflect.DeepEqual(got, want) {
+			t.Errorf("loaded afresh, branch %d lists %v, want %v", id, got, want)
+		}
+	}
+
+	// undone, the move in branch 2 leaves branch 1 as it is
+	if _, err := a.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	syncApp(t, a)
+	if got, want := notesOf(t, d, 2), []uint{4, 1, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("undone, branch 2 stored as %v, want %v", got, want)
+	}
+	if got, want := notesOf(t, d, 1), []uint{2, 1, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("undone, branch 1 stored as %v, want %v", got, want)
+	}
+
+	// down in a descending table is up in the branch
+	a.goTo(1, 1, 3)
+	a.ToggleSortDirection(db.SearchKindNote)
+	if err := a.MoveCurrentNote(false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := activeNoteIDs(a), []uint{1, 3, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("descending, branch 1 shows %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,471 @@
+package app
+
+import (
+	"log"
+	"sort"
+
+	"github.com/haochend413/ntkpr/internal/app/context"
+	editstack "github.com/haochend413/ntkpr/internal/app/editStack"
+	"github.com/haochend413/ntkpr/internal/db"
+	"github.com/haochend413/ntkpr/internal/fuzzy"
+	"github.com/haochend413/ntkpr/internal/models"
+	"github.com/haochend413/ntkpr/internal/query"
+)
+
+// search.go connects the full-text index with the Search contexts of the three tables, and with global search.
+// Each table searches on its own: kind is one of db.SearchKindThread, db.SearchKindBranch or db.SearchKindNote.
+// Results are resolved against everything loaded, and each table then shows the part that falls in its current list.
+// Branches and notes are loaded on demand, so index hits outside the loaded data are read from the database,
+// and so are the entities that pass the filters of a global search without free text, see db.FilterIDs.
+
+const searchLimit = 500
+
+// fuzzyLead is how many characters a fuzzy snippet shows before the first matched one.
+const fuzzyLead = 12
+
+// searchMatch is one matching entity, before it is resolved to a loaded model.
+type searchMatch struct {
+	kind    string
+	id      uint
+	snippet string
+}
+
+// loadedEntities indexes everything held by the DataMgr, so matches can be resolved without repeated scans.
+type loadedEntities struct {
+	threads    map[uint]*models.Thread
+	branches   map[uint]*models.Branch
+	notes      map[uint]*models.Note
+	noteBranch map[uint]*models.Branch // first branch that lists the note
+	order      []searchMatch           // every loaded entity in list order, snippet unset
+}
+
+func (a *App) loadedEntities() *loadedEntities {
+	le := &loadedEntities{
+		threads:    make(map[uint]*models.Thread),
+		branches:   make(map[uint]*models.Branch),
+		notes:      make(map[uint]*models.Note),
+		noteBranch: make(map[uint]*models.Branch),
+	}
+	for _, t := range a.dataMgr.GetThreads() {
+		le.threads[t.ID] = t
+		le.order = append(le.order, searchMatch{kind: db.SearchKindThread, id: t.ID})
+		for _, b := range t.Branches {
+			le.branches[b.ID] = b
+			le.order = append(le.order, searchMatch{kind: db.SearchKindBranch, id: b.ID})
+			for _, n := range b.Notes {
+				if _, seen := le.notes[n.ID]; seen {
+					continue
+				}
+				le.notes[n.ID] = n
+				le.noteBranch[n.ID] = b
+				le.order = append(le.order, searchMatch{kind: db.SearchKindNote, id: n.ID})
+			}
+		}
+	}
+	return le
+}
+
+// addStored adds stored branches and notes that are not loaded to le, so hits on them can be resolved.
+// ids lists the wanted IDs per kind. Entities deleted locally are left out.
+func (a *App) addStored(le *loadedEntities, ids map[string][]uint) {
+	missing := func(kind string, known func(uint) bool) []uint {
+		var m []uint
+		for _, id := range ids[kind] {
+			if !known(id) {
+				m = append(m, id)
+			}
+		}
+		return m
+	}
+	branches, err := a.db.LoadBranchesByID(missing(db.SearchKindBranch, func(id uint) bool { return le.branches[id] != nil }))
+	if err != nil {
+		log.Printf("Error loading branches for search: %v", err)
+	}
+	notes, err := a.db.LoadNotesByID(missing(db.SearchKindNote, func(id uint) bool { return le.notes[id] != nil }))
+	if err != nil {
+		log.Printf("Error loading notes for search: %v", err)
+	}
+
+	for _, b := range branches {
+		if le.branches[b.ID] != nil || a.deletedLocally(editstack.EntityBranch, b.ID) {
+			continue
+		}
+		le.branches[b.ID] = b
+		le.order = append(le.order, searchMatch{kind: db.SearchKindBranch, id: b.ID})
+	}
+	for _, n := range notes {
+		if le.notes[n.ID] != nil || a.deletedLocally(editstack.EntityNote, n.ID) {
+			continue
+		}
+		var branch *models.Branch
+		for _, b := range n.Branches {
+			if !a.deletedLocally(editstack.EntityBranch, b.ID) {
+				branch = b
+				break
+			}
+		}
+		if branch == nil {
+			continue
+		}
+		le.notes[n.ID] = n
+		le.noteBranch[n.ID] = branch
+		le.order = append(le.order, searchMatch{kind: db.SearchKindNote, id: n.ID})
+	}
+}
+
+// deletedLocally reports whether an entity has an unsynced deletion.
+func (a *App) deletedLocally(entity string, id uint) bool {
+	edit, ok := a.editMgr.GetEdit(entity, id)
+	if !ok {
+		return false
+	}
+	switch edit.EditType {
+	case editstack.DeleteNote, editstack.DeleteBranch, editstack.DeleteThread:
+		return true
+	}
+	return false
+}
+
+// editEntity maps a search kind to the entity type used by EditKey.
+func editEntity(kind string) string {
+	switch kind {
+	case db.SearchKindThread:
+		return editstack.EntityThread
+	case db.SearchKindBranch:
+		return editstack.EntityBranch
+	default:
+		return editstack.EntityNote
+	}
+}
+
+// has reports whether an entity is in le.
+func (le *loadedEntities) has(kind string, id uint) bool {
+	switch kind {
+	case db.SearchKindThread:
+		return le.threads[id] != nil
+	case db.SearchKindBranch:
+		return le.branches[id] != nil
+	default:
+		return le.notes[id] != nil
+	}
+}
+
+// body returns the searchable text of a loaded entity, or "" while it is sealed.
+func (le *loadedEntities) body(m searchMatch) string {
+	var body string
+	switch m.kind {
+	case db.SearchKindThread:
+		body = db.SummaryBody(le.threads[m.id].Name, le.threads[m.id].Summary)
+	case db.SearchKindBranch:
+		body = db.SummaryBody(le.branches[m.id].Name, le.branches[m.id].Summary)
+	default:
+		body = le.notes[m.id].Content
+	}
+	if sealed(body) {
+		return ""
+	}
+	return body
+}
+
+// fuzzyBody returns the text ~words are matched against: the content of a note, or the name of a branch or thread.
+// Sealed text matches nothing.
+func (le *loadedEntities) fuzzyBody(m searchMatch) string {
+	var body string
+	switch m.kind {
+	case db.SearchKindThread:
+		body = le.threads[m.id].Name
+	case db.SearchKindBranch:
+		body = le.branches[m.id].Name
+	default:
+		body = le.notes[m.id].Content
+	}
+	if sealed(body) {
+		return ""
+	}
+	return body
+}
+
+// private reports whether an entity of le is private. Private entities are not in the index, see db.Search.
+func (le *loadedEntities) private(m searchMatch) bool {
+	switch m.kind {
+	case db.SearchKindThread:
+		return le.threads[m.id].Private
+	case db.SearchKindBranch:
+		return le.branches[m.id].Private
+	default:
+		return le.notes[m.id].Private
+	}
+}
+
+// match applies the filters of q to a loaded entity.
+func (le *loadedEntities) match(q *query.Query, m searchMatch) bool {
+	switch m.kind {
+	case db.SearchKindThread:
+		t, ok := le.threads[m.id]
+		return ok && q.MatchThread(t)
+	case db.SearchKindBranch:
+		b, ok := le.branches[m.id]
+		return ok && q.MatchBranch(b, le.threads[b.ThreadID])
+	default:
+		n, ok := le.notes[m.id]
+		return ok && q.MatchNote(n, le.threads[n.ThreadID])
+	}
+}
+
+// matches runs free text against the index, restricted to kind unless it is "", and returns ranked matches.
+// Entities with unsynced edits are not in the index yet, and private ones are never in it, so they are matched in memory;
+// private entities that are not loaded are not found.
+func (a *App) matches(kind string, text string, le *loadedEntities) []searchMatch {
+	hits, err := a.db.Search(text, kind, searchLimit)
+	if err != nil {
+		log.Printf("Error searching %q: %v", text, err)
+		hits = nil
+	}
+
+	type key struct {
+		kind string
+		id   uint
+	}
+	snippets := make(map[key]string, len(hits))
+	order := make([]key, 0, len(hits))
+	hitIDs := make(map[string][]uint)
+	for _, h := range hits {
+		k := key{h.Kind, h.RefID}
+		snippets[k] = h.Snippet
+		order = append(order, k)
+		hitIDs[h.Kind] = append(hitIDs[h.Kind], h.RefID)
+	}
+	a.addStored(le, hitIDs)
+
+	for _, m := range le.order {
+		if kind != "" && m.kind != kind {
+			continue
+		}
+		if _, ok := a.editMgr.GetEdit(editEntity(m.kind), m.id); !ok && !le.private(m) {
+			continue
+		}
+		k := key{m.kind, m.id}
+		snippet, ok := db.MatchText(le.body(m), text)
+		if !ok {
+			delete(snippets, k)
+			continue
+		}
+		if _, hit := snippets[k]; !hit {
+			order = append(order, k)
+		}
+		snippets[k] = snippet
+	}
+
+	result := make([]searchMatch, 0, len(order))
+	for _, k := range order {
+		// entities deleted locally are still in the index until the next sync
+		if !le.has(k.kind, k.id) {
+			continue
+		}
+		if snippet := snippets[k]; snippet != "" {
+			result = append(result, searchMatch{kind: k.kind, id: k.id, snippet: snippet})
+		}
+	}
+	return result
+}
+
+// find parses a query string, matches its free text and applies its filters.
+// A query made of filters only lists every loaded entity of kind that passes them.
+func (a *App) find(kind string, s string, le *loadedEntities) ([]searchMatch, error) {
+	q, err := query.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []searchMatch
+	if q.Text != "" {
+		found = a.matches(kind, q.Text, le)
+	} else {
+		if kind == "" {
+			// a global search lists the entities that pass, loaded or not; the stored ones are picked in SQL, at most searchLimit of each kind
+			ids, err := a.db.FilterIDs(q, searchLimit)
+			if err != nil {
+				return nil, err
+			}
+			a.addStored(le, ids)
+		}
+		for _, m := range le.order {
+			if kind == "" || m.kind == kind {
+				found = append(found, m)
+			}
+		}
+	}
+	if len(q.Fuzzy) > 0 {
+		found = rankFuzzy(found, q.Fuzzy, le, q.Text == "")
+	}
+	if !q.HasFilters() {
+		return found, nil
+	}
+
+	filtered := make([]searchMatch, 0, len(found))
+	for _, m := range found {
+		if le.match(q, m) {
+			filtered = append(filtered, m)
+		}
+	}
+	return filtered, nil
+}
+
+// rankFuzzy keeps the matches whose fuzzy body contains every word as a subsequence, best total score first.
+// With mark set, the snippet is replaced by the body with the matched characters marked.
+func rankFuzzy(found []searchMatch, words []string, le *loadedEntities, mark bool) []searchMatch {
+	type scored struct {
+		searchMatch
+		score int
+	}
+	ranked := make([]scored, 0, len(found))
+	for _, m := range found {
+		body := le.fuzzyBody(m)
+		s := scored{searchMatch: m}
+		var positions []int
+		ok := true
+		for _, w := range words {
+			r, matched := fuzzy.Match(w, body)
+			if !matched {
+				ok = false
+				break
+			}
+			s.score += r.Score
+			positions = append(positions, r.Positions...)
+		}
+		if !ok {
+			continue
+		}
+		if mark {
+			sort.Ints(positions)
+			s.snippet = fuzzy.Snippet(body, positions, db.MatchStart, db.MatchEnd, fuzzyLead)
+		}
+		ranked = append(ranked, s)
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].score > ranked[j].score
+	})
+
+	result := make([]searchMatch, len(ranked))
+	for i, s := range ranked {
+		result[i] = s.searchMatch
+	}
+	return result
+}
+
+// setResults resolves matches of one kind to loaded models and stores them in the query context c of that table.
+func (a *App) setResults(kind string, c context.ContextPtr, found []searchMatch, le *loadedEntities) {
+	snippets := make(map[uint]string, len(found))
+	for _, m := range found {
+		snippets[m.id] = m.snippet
+	}
+
+	switch kind {
+	case db.SearchKindThread:
+		results := make([]*models.Thread, 0, len(found))
+		for _, m := range found {
+			if t, ok := le.threads[m.id]; ok {
+				results = append(results, t)
+			}
+		}
+		a.contextMgr.ThreadContextMgr.SetResults(c, results, snippets)
+	case db.SearchKindBranch:
+		results := make([]*models.Branch, 0, len(found))
+		for _, m := range found {
+			if b, ok := le.branches[m.id]; ok {
+				results = append(results, b)
+			}
+		}
+		a.contextMgr.BranchContextMgr.SetResults(c, results, snippets)
+	case db.SearchKindNote:
+		results := make([]*models.Note, 0, len(found))
+		for _, m := range found {
+			if n, ok := le.notes[m.id]; ok {
+				results = append(results, n)
+			}
+		}
+		a.contextMgr.NoteContextMgr.SetResults(c, results, snippets)
+	}
+}
+
+// Search runs a query for one kind of entity and switches its table to the Search context.
+// See package query for the syntax.
+func (a *App) Search(kind string, s string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	le := a.loadedEntities()
+	found, err := a.find(kind, s, le)
+	if err != nil {
+		return err
+	}
+	mgr := a.contextsOf(kind)
+	if mgr == nil {
+		return nil
+	}
+	a.setResults(kind, context.Search, found, le)
+	mgr.SwitchContext(context.Search)
+	mgr.SetCurrentCursor(0)
+	return nil
+}
+
+// GlobalHit is one result of a global search, together with the place it lives in.
+type GlobalHit struct {
+	Thread  *models.Thread
+	Branch  *models.Branch // nil for thread hits
+	Note    *models.Note   // nil for thread and branch hits
+	Snippet string
+}
+
+// GlobalSearch searches threads, branches and notes everywhere, whatever is currently active.
+// A note listed by several branches is reported under the first of them.
+func (a *App) GlobalSearch(s string) ([]GlobalHit, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	le := a.loadedEntities()
+	found, err := a.find("", s, le)
+	if err != nil {
+		return nil, err
+	}
+	hits := make([]GlobalHit, 0, len(found))
+	for _, m := range found {
+		var hit GlobalHit
+		switch m.kind {
+		case db.SearchKindThread:
+			hit.Thread = le.threads[m.id]
+		case db.SearchKindBranch:
+			hit.Branch = le.branches[m.id]
+			if hit.Branch != nil {
+				hit.Thread = le.threads[hit.Branch.ThreadID]
+			}
+		case db.SearchKindNote:
+			hit.Note = le.notes[m.id]
+			hit.Branch = le.noteBranch[m.id]
+			if hit.Note != nil {
+				hit.Thread = le.threads[hit.Note.ThreadID]
+			}
+		}
+		// skip entities deleted locally but still in the index
+		if hit.Thread == nil || (m.kind != db.SearchKindThread && hit.Branch == nil) {
+			continue
+		}
+		hit.Snippet = m.snippet
+		hits = append(hits, hit)
+	}
+	return hits, nil
+}
+
+// GetSnippet returns the search snippet of an entity, or "" when its table is not searching.
+// Matched text is wrapped in db.MatchStart and db.MatchEnd.
+func (a *App) GetSnippet(kind string, id uint) string {
+	switch kind {
+	case db.SearchKindThread:
+		return a.contextMgr.ThreadContextMgr.GetSnippet(id)
+	case db.SearchKindBranch:
+		return a.contextMgr.BranchContextMgr.GetSnippet(id)
+	case db.SearchKindNote:
+		return a.contextMgr.NoteContextMgr.GetSnippet(id)
+	}
+	return ""
+}
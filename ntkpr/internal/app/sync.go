@@ -0,0 +1,132 @@
+package app
+
+import (
+	"errors"
+	"log"
+
+	editstack "github.com/haochend413/ntkpr/internal/app/editStack"
+	"github.com/haochend413/ntkpr/internal/app/journal"
+	"github.com/haochend413/ntkpr/internal/db"
+	"github.com/haochend413/ntkpr/internal/models"
+)
+
+// sync.go splits a sync in three steps, so it can run next to the UI:
+// StartSync takes the pending edits and a copy of the loaded data, SyncJob.Run writes them without touching the App,
+// and FinishSync patches the result in. Edits made while Run is busy go to a new record and are kept for the next sync.
+
+// ErrSyncRunning is returned when a sync is started while another one has not finished.
+var ErrSyncRunning = errors.New("a sync is already running")
+
+// SyncJob is one sync, between StartSync and FinishSync.
+type SyncJob struct {
+	db        *db.DB
+	threads   []*models.Thread // copy of the loaded data, written by SyncData
+	edits     map[editstack.EditKey]*editstack.Edit
+	stack     []*editstack.Edit
+	journaled int // journal entries covered by this sync
+	result    db.SyncResult
+	err       error
+}
+
+// Run writes the edits of the job to the database. It does not touch the App, so it can run in its own goroutine.
+func (j *SyncJob) Run() error {
+	j.result, j.err = j.db.SyncData(j.threads, j.edits)
+	return j.err
+}
+
+// Result returns what the sync changed, once Run has succeeded.
+func (j *SyncJob) Result() db.SyncResult {
+	return j.result
+}
+
+// StartSync takes the pending edits for a sync. It returns nil when there is nothing to sync,
+// and ErrSyncRunning while another sync is on its way.
+func (a *App) StartSync() (*SyncJob, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.syncing {
+		return nil, ErrSyncRunning
+	}
+	if len(a.editMgr.EditMap) == 0 {
+		a.Synced = true
+		return nil, nil
+	}
+	job := &SyncJob{
+		db:      a.db,
+		threads: a.dataMgr.Snapshot(),
+	}
+	job.edits, job.stack = a.editMgr.Detach()
+	if a.journal != nil {
+		job.journaled = a.journal.Len()
+	}
+	a.syncing = true
+	return job, nil
+}
+
+// Syncing reports whether a sync was started and has not finished yet.
+func (a *App) Syncing() bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.syncing
+}
+
+// FinishSync ends a job after Run. A failed sync puts its edits back, merged with the ones made since.
+// A successful one patches what changed into the data manager, and created entities follow their new IDs.
+func (a *App) FinishSync(job *SyncJob) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.syncing = false
+	if job.err != nil {
+		a.editMgr.Reattach(job.edits, job.stack)
+		return job.err
+	}
+
+	remap := job.result.IDs
+	// edits made since the start can point at entities created by this sync
+	a.editMgr.RemapIDs(remap.Threads, remap.Branches, remap.Notes)
+	a.contextMgr.RemapIDs(remap.Threads, remap.Branches, remap.Notes)
+	a.remapUndo(remap)
+	a.dataMgr.ApplySync(job.result, a.pending)
+	if a.journal != nil {
+		if err := a.journal.Compact(job.journaled, func(e *journal.Entry) { remapEntry(e, remap) }); err != nil {
+			log.Printf("Error compacting journal: %v", err)
+		}
+	}
+	a.Synced = len(a.editMgr.EditMap) == 0
+	return nil
+}
+
+// remapEntry moves a journal entry from temporary IDs to the ones a sync assigned.
+func remapEntry(e *journal.Entry, ids db.IDRemap) {
+	switch {
+	case e.Thread != nil:
+		e.ID = ids.Thread(e.ID)
+	case e.Branch != nil:
+		e.ID = ids.Branch(e.ID)
+		e.Branch.ThreadID = ids.Thread(e.Branch.ThreadID)
+		e.Branch.ForkBranchID = ids.Branch(e.Branch.ForkBranchID)
+		e.Branch.ForkNoteID = ids.Note(e.Branch.ForkNoteID)
+		for i, id := range e.Branch.NoteIDs {
+			e.Branch.NoteIDs[i] = ids.Note(id)
+		}
+	case e.Note != nil:
+		e.ID = ids.Note(e.ID)
+		e.Note.ThreadID = ids.Thread(e.Note.ThreadID)
+		for i, id := range e.Note.BranchIDs {
+			e.Note.BranchIDs[i] = ids.Branch(id)
+		}
+	}
+	if e.Link != nil {
+		remapLink := func(id int, remap func(uint) uint) int {
+			if id <= 0 {
+				return id
+			}
+			return int(remap(uint(id)))
+		}
+		e.Link.ThreadID = remapLink(e.Link.ThreadID, ids.Thread)
+		e.Link.BranchID = remapLink(e.Link.BranchID, ids.Branch)
+		e.Link.NoteID = remapLink(e.Link.NoteID, ids.Note)
+	}
+}
@@ -0,0 +1,260 @@
+package app
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/haochend413/ntkpr/internal/app/data"
+	editstack "github.com/haochend413/ntkpr/internal/app/editStack"
+	"github.com/haochend413/ntkpr/internal/app/journal"
+	"github.com/haochend413/ntkpr/internal/models"
+)
+
+// tempIDsOf lists the temporary IDs the loaded data refers to, as "branch 3 fork note" and so on.
+// A note listed by several branches is checked once.
+func tempIDsOf(dm *data.DataMgr) []string {
+	var out []string
+	seen := make(map[*models.Note]bool)
+	check := func(what string, id uint, field string) {
+		if models.IsTempID(id) {
+			out = append(out, fmt.Sprintf("%s %s %d", what, field, id))
+		}
+	}
+	for _, t := range dm.GetThreads() {
+		check("thread", t.ID, "ID")
+		for _, b := range t.Branches {
+			what := fmt.Sprintf("branch %d", b.ID)
+			check(what, b.ID, "ID")
+			check(what, b.ThreadID, "thread")
+			check(what, b.ForkBranchID, "fork branch")
+			check(what, b.ForkNoteID, "fork note")
+			for _, n := range b.Notes {
+				if seen[n] {
+					continue
+				}
+				seen[n] = true
+				what := fmt.Sprintf("note %d", n.ID)
+				check(what, n.ID, "ID")
+				check(what, n.ThreadID, "thread")
+				for _, nb := range n.Branches {
+					check(what, nb.ID, "branch")
+				}
+			}
+		}
+	}
+	return out
+}
+
+// tempIDsOfEntry lists the temporary IDs a journal entry refers to.
+func tempIDsOfEntry(e journal.Entry) []uint {
+	ids := []uint{e.ID}
+	if e.Branch != nil {
+		ids = append(ids, e.Branch.ThreadID, e.Branch.ForkBranchID, e.Branch.ForkNoteID)
+		ids = append(ids, e.Branch.NoteIDs...)
+	}
+	if e.Note != nil {
+		ids = append(ids, e.Note.ThreadID)
+		ids = append(ids, e.Note.BranchIDs...)
+	}
+	var temp []uint
+	for _, id := range ids {
+		if models.IsTempID(id) {
+			temp = append(temp, id)
+		}
+	}
+	return temp
+}
+
+// Entities created before a sync take their stored IDs in the loaded data, in the edits and journal entries made while
+// the sync ran, and in the actions to undo and redo.
+func TestSyncRemapsTempIDs(t *testing.T) {
+	d, a := testApp(t)
+	threadID, branchID, noteIDs := seed(t, a, "one", "two")
+	a.goTo(threadID, branchID, noteIDs[1])
+	a.SetCurrentNoteContent("two, edited", nil)
+	a.goTo(threadID, branchID, noteIDs[0])
+	forkID := a.ForkCurrentBranch(nil)
+	if forkID == 0 {
+		t.Fatal("cannot fork")
+	}
+	// the fork is taken back, and waits to be redone with the temporary IDs of its branch and note
+	if _, err := a.Undo(); err != nil {
+		t.Fatal(err)
+	}
+
+	job, err := a.StartSync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// made while the sync runs, these are kept for the next one
+	if _, err := a.Redo(); err != nil {
+		t.Fatal(err)
+	}
+	a.goTo(threadID, branchID, noteIDs[1])
+	a.SetCurrentNoteContent("two, during the sync", nil)
+	createdID := a.CreateNewNote(nil)
+	job.Run()
+	if err := a.FinishSync(job); err != nil {
+		t.Fatal(err)
+	}
+
+	dm := a.GetDataMgr()
+	want := []string{
+		fmt.Sprintf("note 1 branch %d", forkID),
+		fmt.Sprintf("note %d ID %d", createdID, createdID),
+		fmt.Sprintf("branch %d ID %d", forkID, forkID),
+	}
+	if got := tempIDsOf(dm); !reflect.DeepEqual(got, want) {
+		t.Errorf("temporary IDs left %v, want only what was created during the sync %v", got, want)
+	}
+	fork := dm.FindBranchByID(forkID)
+	if fork == nil || fork.ThreadID != 1 || fork.ForkBranchID != 1 || fork.ForkNoteID != 1 {
+		t.Fatalf("the fork is %+v, want it in thread 1 forked from note 1 of branch 1", fork)
+	}
+	// the branches created before the sync are not read again, which would drop what was created in them since
+	branch := dm.FindBranchByID(1)
+	if len(branch.Notes) != 3 || branch.Notes[2].ID != createdID || branch.Notes[0] != fork.Notes[0] {
+		t.Errorf("branch 1 lists %d notes, want the two synced, shared with the fork, and the one created during the sync", len(branch.Notes))
+	}
+	if th, b, n := dm.GetActiveThreadID(), dm.GetActiveBranchID(), dm.GetActiveNoteID(); th != 1 || b != 1 || n != 2 {
+		t.Errorf("active %d/%d/%d, want 1/1/2", th, b, n)
+	}
+	wantEdits := map[editstack.EditKey]editstack.EditType{
+		{EntityType: editstack.EntityBranch, ID: forkID}:  editstack.CreateBranch,
+		{EntityType: editstack.EntityNote, ID: 2}:         editstack.UpdateNote,
+		{EntityType: editstack.EntityNote, ID: createdID}: editstack.CreateNote,
+	}
+	for key, tp := range wantEdits {
+		if edit, ok := a.GetEditMap()[key]; !ok || edit.EditType != tp {
+			t.Errorf("edit of %v is %+v, want %d", key, edit, tp)
+		}
+	}
+
+	_, entries, err := journal.Open(journal.PathFor(d.Path()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) == 0 {
+		t.Fatal("the edits made during the sync left the journal")
+	}
+	for _, e := range entries {
+		if temp := tempIDsOfEntry(e); len(temp) > 0 && !reflect.DeepEqual(temp, []uint{e.ID}) {
+			t.Errorf("journal entry %d of %d keeps temporary IDs %v", e.EditType, e.ID, temp)
+		}
+	}
+
+	syncApp(t, a)
+	want = []string{"thread:1", "branch:1", "branch:2", "note:1:one:", "note:2:two, during the sync:", "note:3::"}
+	if got := stored(t, d); !reflect.DeepEqual(got, want) {
+		t.Errorf("stored %v, want %v", got, want)
+	}
+	var forks []struct{ ID, ForkBranchID, ForkNoteID uint }
+	d.Conn.Raw(`SELECT id, fork_branch_id, fork_note_id FROM branches WHERE fork_branch_id IS NOT NULL AND fork_branch_id != 0`).Scan(&forks)
+	if len(forks) != 1 || forks[0].ID != 2 || forks[0].ForkBranchID != 1 || forks[0].ForkNoteID != 1 {
+		t.Errorf("stored forks %+v, want branch 2 forked from note 1 of branch 1", forks)
+	}
+
+	// the actions recorded before the first sync are undone on the stored entities
+	for range 4 {
+		if _, err := a.Undo(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	syncApp(t, a)
+	want = []string{"thread:1", "branch:1", "note:1:one:", "note:2:two:"}
+	if got := stored(t, d); !reflect.DeepEqual(got, want) {
+		t.Errorf("after undoing stored %v, want %v", got, want)
+	}
+	if got := tempIDsOf(dm); len(got) > 0 {
+		t.Errorf("temporary IDs left %v", got)
+	}
+}
+
+// loadedData loads everything of the data manager of a, and describes it thread by thread, in the order it is listed.
+func loadedData(a *App) []string {
+	dm := a.GetDataMgr()
+	utc := func(ts ...*time.Time) {
+		for _, t := range ts {
+			if t != nil {
+				*t = t.UTC().Round(0)
+			}
+		}
+	}
+	var out []string
+	for _, t := range slices.Clone(dm.GetThreads()) {
+		dm.SwitchActiveThreadByID(t.ID)
+		tc := *t
+		tc.Branches = nil
+		utc(&tc.CreatedAt, &tc.UpdatedAt, &tc.LastEdit)
+		out = append(out, fmt.Sprintf("thread %+v", tc))
+		for _, b := range slices.Clone(t.Branches) {
+			dm.SwitchActiveBranchByID(b.ID)
+			dm.LoadAllNotes()
+			bc := *b
+			bc.Notes = nil
+			utc(&bc.CreatedAt, &bc.UpdatedAt, &bc.LastEdit)
+			out = append(out, fmt.Sprintf("  branch %+v", bc))
+			for _, n := range b.Notes {
+				nc := *n
+				var branches []uint
+				for _, nb := range n.Branches {
+					branches = append(branches, nb.ID)
+				}
+				slices.Sort(branches)
+				nc.Branches = nil
+				utc(&nc.CreatedAt, &nc.UpdatedAt, &nc.LastEdit, nc.Due, nc.CompletedAt)
+				out = append(out, fmt.Sprintf("    note in %v %+v", branches, nc))
+			}
+		}
+	}
+	return out
+}
+
+// A sync patches its result into the loaded data, which then holds what a fresh load of the database does.
+func TestApplySyncMatchesReload(t *testing.T) {
+	d, a := testApp(t)
+	work, workLog, notes := seed(t, a, "one", "two", "three")
+	home, homeLog, _ := seed(t, a, "four")
+	seed(t, a, "five")
+	syncApp(t, a)
+	work, workLog, home, homeLog, old := uint(1), uint(1), uint(2), uint(2), uint(3)
+	notes = []uint{1, 2, 3}
+
+	// edits, creates and deletes in both threads and a new one, with the stored and the new entities
+	a.goTo(work, workLog, notes[0])
+	a.SetCurrentNoteContent("one, edited", nil)
+	a.goTo(work, workLog, notes[1])
+	a.ToggleCurrentNoteHighlight(nil)
+	a.goTo(work, workLog, notes[2])
+	a.DeleteCurrentNote(nil)
+	a.goTo(work, workLog, 0)
+	a.CreateNewNote(nil)
+	a.SetCurrentBranchName("work log", nil)
+	a.CreateNewBranch(nil)
+
+	a.goTo(home, homeLog, 0)
+	a.SetCurrentThreadName("home", nil)
+	created := a.CreateNewBranch(nil)
+	a.goTo(home, created, 0)
+	a.goTo(home, created, a.CreateNewNote(nil))
+	a.SetCurrentNoteContent("in a new branch", nil)
+	a.goTo(home, homeLog, 0)
+	a.DeleteCurrentBranch(nil)
+
+	a.goTo(a.CreateNewThread(nil), 0, 0)
+	a.goTo(a.GetCurrentThreadID(), a.CreateNewBranch(nil), 0)
+	a.CreateNewNote(nil)
+	a.goTo(old, 0, 0)
+	a.DeleteCurrentThread(nil)
+	syncApp(t, a)
+
+	got := loadedData(a)
+	want := loadedData(NewApp(d, nil))
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("after the sync\n%s\nloaded afresh\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,158 @@
+package app
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	editstack "github.com/haochend413/ntkpr/internal/app/editStack"
+	"github.com/haochend413/ntkpr/internal/app/journal"
+	"github.com/haochend413/ntkpr/internal/db"
+	"github.com/haochend413/ntkpr/internal/models"
+	"github.com/haochend413/ntkpr/internal/query"
+)
+
+// tasks.go turns notes into tasks. A note gets a status with CycleCurrentNoteStatus and a due day with SetCurrentNoteDue,
+// both tracked as updates of the note. Tasks lists the open tasks of every thread, loaded or not, the first due first.
+
+// Task is an open task, together with the place it lives in.
+type Task struct {
+	Thread  *models.Thread
+	Branch  *models.Branch // the first branch that lists the note
+	Note    *models.Note
+	Overdue bool // the due day is over, see Note.Overdue
+}
+
+// Tasks lists the notes of every thread that are open tasks: by due day, then the ones without one, each in list order.
+func (a *App) Tasks() ([]Task, error) {
+	ids, err := a.db.OpenTaskIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	le := a.loadedEntities()
+	// loaded notes have the unsynced edits, so they are used even when the database has them too
+	a.addStored(le, map[string][]uint{db.SearchKindNote: ids})
+
+	now := time.Now()
+	var tasks []Task
+	for _, m := range le.order {
+		if m.kind != db.SearchKindNote {
+			continue
+		}
+		n := le.notes[m.id]
+		thread := le.threads[n.ThreadID]
+		if !n.Status.Open() || thread == nil {
+			continue
+		}
+		tasks = append(tasks, Task{Thread: thread, Branch: le.noteBranch[n.ID], Note: n, Overdue: n.Overdue(now)})
+	}
+	sort.SliceStable(tasks, func(i, j int) bool {
+		di, dj := tasks[i].Note.Due, tasks[j].Note.Due
+		if di == nil || dj == nil {
+			return di != nil && dj == nil
+		}
+		return di.Before(*dj)
+	})
+	return tasks, nil
+}
+
+// GetCurrentNoteDue returns the due day of the current note, or nil when it has none.
+func (a *App) GetCurrentNoteDue() *time.Time {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	note := a.getCurrentNote()
+	if note == nil {
+		return nil
+	}
+	return note.Due
+}
+
+// CycleCurrentNoteStatus moves the current note to its next task status, see models.TaskStatus.Next, and returns it.
+// Done tasks get their completion time; a note that stops being a task loses its due day.
+func (a *App) CycleCurrentNoteStatus(link *models.Superlink) models.TaskStatus {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	note := a.getCurrentNote()
+	if note == nil {
+		return models.TaskNone
+	}
+
+	before := journal.NoteOf(note)
+	note.Status = note.Status.Next()
+	note.CompletedAt = nil
+	switch note.Status {
+	case models.TaskDone:
+		now := time.Now()
+		note.CompletedAt = &now
+	case models.TaskNone:
+		note.Due = nil
+	}
+	a.updateCurrentNote(before, link)
+	return note.Status
+}
+
+// SetCurrentNoteDue sets the due day of the current note, or clears it when due is nil.
+// A note that is not a task yet becomes one.
+func (a *App) SetCurrentNoteDue(due *time.Time, link *models.Superlink) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	note := a.getCurrentNote()
+	if note == nil {
+		return
+	}
+
+	before := journal.NoteOf(note)
+	note.Due = due
+	if due != nil && note.Status == models.TaskNone {
+		note.Status = models.TaskTodo
+	}
+	a.updateCurrentNote(before, link)
+}
+
+// updateCurrentNote tracks a change of the current note from before, and pushes it to the undo stack. Callers hold the mutex.
+func (a *App) updateCurrentNote(before *journal.Note, link *models.Superlink) {
+	note := a.getCurrentNote()
+	note.UpdatedAt = time.Now()
+	a.Synced = false
+
+	edit := &editstack.Edit{ID: note.ID, EditType: editstack.UpdateNote}
+	if err := a.trackEdit(edit, link, note); err != nil {
+		log.Printf("Error tracking note update: %v", err)
+	}
+	a.pushUpdate(editstack.UpdateNote, before, link)
+}
+
+// ParseDue parses a due day relative to now: 2025-01-31, today, tomorrow, or a time from now like 3d or 2w.
+// It returns midnight of that day in local time, or nil for an empty string, which clears the due day.
+func ParseDue(s string, now time.Time) (*time.Time, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	var day time.Time
+	switch s {
+	case "":
+		return nil, nil
+	case "today":
+		day = now
+	case "tomorrow":
+		day = now.AddDate(0, 0, 1)
+	default:
+		if date, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+			return &date, nil
+		}
+		age, err := query.ParseAge(strings.TrimPrefix(s, "+"))
+		if err != nil {
+			return nil, fmt.Errorf("due day must look like 2025-01-31, today, tomorrow or 3d")
+		}
+		day = now.Add(age)
+	}
+	day = day.In(time.Local)
+	due := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.Local)
+	return &due, nil
+}
@@ -0,0 +1,199 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/haochend413/ntkpr/internal/models"
+)
+
+func TestParseDue(t *testing.T) {
+	now := time.Date(2025, 1, 30, 15, 4, 5, 0, time.Local)
+	day := func(month time.Month, d int) *time.Time {
+		due := time.Date(2025, month, d, 0, 0, 0, 0, time.Local)
+		return &due
+	}
+	tests := []struct {
+		in      string
+		want    *time.Time
+		wantErr bool
+	}{
+		{in: "", want: nil},
+		{in: "today", want: day(1, 30)},
+		{in: " Today ", want: day(1, 30)},
+		{in: "tomorrow", want: day(1, 31)},
+		{in: "3d", want: day(2, 2)},
+		{in: "+3d", want: day(2, 2)},
+		{in: "2w", want: day(2, 13)},
+		{in: "2025-03-01", want: day(3, 1)},
+		{in: "soon", wantErr: true},
+		{in: "2025-02-30", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseDue(tt.in, now)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseDue(%q) error %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseDue(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestOverdue(t *testing.T) {
+	now := time.Date(2025, 1, 30, 15, 4, 5, 0, time.Local)
+	yesterday, today := now.AddDate(0, 0, -1), now.Add(-time.Hour)
+	tests := []struct {
+		name   string
+		status models.TaskStatus
+		due    *time.Time
+		want   bool
+	}{
+		{name: "due yesterday", status: models.TaskTodo, due: &yesterday, want: true},
+		{name: "doing, due yesterday", status: models.TaskDoing, due: &yesterday, want: true},
+		{name: "due today", status: models.TaskTodo, due: &today},
+		{name: "done, due yesterday", status: models.TaskDone, due: &yesterday},
+		{name: "no due day", status: models.TaskTodo},
+		{name: "not a task", due: &yesterday},
+	}
+	for _, tt := range tests {
+		n := &models.Note{Status: tt.status, Due: tt.due}
+		if got := n.Overdue(now); got != tt.want {
+			t.Errorf("%s: overdue %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// storedTask is what the database holds of the task of a note.
+type storedTask struct {
+	Status      models.TaskStatus
+	Due         *time.Time
+	CompletedAt *time.Time
+}
+
+func storedTaskOf(t *testing.T, a *App, noteID uint) storedTask {
+	t.Helper()
+	var task storedTask
+	if err := a.db.Conn.Raw(`SELECT status, due, completed_at FROM notes WHERE id = ?`, noteID).Scan(&task).Error; err != nil {
+		t.Fatal(err)
+	}
+	return task
+}
+
+// The status of a note goes todo, doing, done and back to a plain note. Done tasks record when they were finished,
+// and a note that stops being a task loses its due day.
+func TestCycleCurrentNoteStatus(t *testing.T) {
+	_, a := testApp(t)
+	seed(t, a, "task")
+	syncApp(t, a)
+	a.goTo(1, 1, 1)
+	due, _ := ParseDue("tomorrow", time.Now())
+	a.SetCurrentNoteDue(due, nil)
+	note := a.getCurrentNote()
+	if note.Status != models.TaskTodo {
+		t.Errorf("a note with a due day is %q, want a todo", note.Status)
+	}
+
+	start := time.Now()
+	for _, want := range []models.TaskStatus{models.TaskDoing, models.TaskDone, models.TaskNone, models.TaskTodo} {
+		if got := a.CycleCurrentNoteStatus(nil); got != want {
+			t.Fatalf("cycled to %q, want %q", got, want)
+		}
+		syncApp(t, a)
+		task := storedTaskOf(t, a, 1)
+		if task.Status != want {
+			t.Errorf("stored %q, want %q", task.Status, want)
+		}
+		if done := task.CompletedAt != nil; done != (want == models.TaskDone) || done && task.CompletedAt.Before(start.Add(-time.Second)) {
+			t.Errorf("%q completed at %v", want, task.CompletedAt)
+		}
+		if hasDue := task.Due != nil; hasDue != (want == models.TaskDoing || want == models.TaskDone) {
+			t.Errorf("%q due %v, want the due day kept until the note stops being a task", want, task.Due)
+		}
+	}
+
+	// undone, the note is a plain note again, and then the task it was before
+	if _, err := a.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	syncApp(t, a)
+	if task := storedTaskOf(t, a, 1); task.Status != models.TaskNone {
+		t.Errorf("undone, stored %+v, want a plain note", task)
+	}
+	if _, err := a.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	syncApp(t, a)
+	if task := storedTaskOf(t, a, 1); task.Status != models.TaskDone || task.CompletedAt == nil || task.Due == nil || !task.Due.Equal(*due) {
+		t.Errorf("undone twice, stored %+v, want the done task due %v", task, due)
+	}
+}
+
+// Tasks lists the open tasks of every thread, synced or not, the first due first and the ones without a due day last.
+func TestTasks(t *testing.T) {
+	d, a := testApp(t)
+	threadID, branchID, notes := seed(t, a, "later", "overdue", "no day", "done", "plain", "soon")
+	now := time.Now()
+	set := func(i int, due string, cycles int) {
+		t.Helper()
+		a.goTo(threadID, branchID, notes[i])
+		if due != "" {
+			day, err := ParseDue(due, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			a.SetCurrentNoteDue(day, nil)
+		}
+		for range cycles {
+			a.CycleCurrentNoteStatus(nil)
+		}
+	}
+	set(0, "2w", 0)
+	yesterday := now.AddDate(0, 0, -1).Format("2006-01-02")
+	set(1, yesterday, 1)
+	set(2, "", 1)
+	set(3, "today", 2)
+	set(5, "3d", 0)
+	syncApp(t, a)
+
+	// a task of another thread, which is not synced yet
+	_, _, other := seed(t, a, "elsewhere")
+	a.goTo(a.GetCurrentThreadID(), a.GetCurrentBranchID(), other[0])
+	a.CycleCurrentNoteStatus(nil)
+
+	describe := func(tasks []Task) []string {
+		var out []string
+		for _, task := range tasks {
+			s := task.Note.Content
+			if task.Overdue {
+				s += " (overdue)"
+			}
+			out = append(out, s)
+		}
+		return out
+	}
+	tasks, err := a.Tasks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"overdue (overdue)", "soon", "later", "no day", "elsewhere"}
+	if got := describe(tasks); !reflect.DeepEqual(got, want) {
+		t.Errorf("tasks %v, want %v", got, want)
+	}
+	for _, task := range tasks {
+		if task.Thread == nil || task.Branch == nil || task.Note.ThreadID != task.Thread.ID {
+			t.Errorf("task %q is in thread %v, branch %v", task.Note.Content, task.Thread, task.Branch)
+		}
+	}
+
+	// the synced tasks are listed before their threads are loaded
+	tasks, err = NewApp(d, nil).Tasks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := describe(tasks), want[:4]; !reflect.DeepEqual(got, want) {
+		t.Errorf("loaded afresh, tasks %v, want %v", got, want)
+	}
+}
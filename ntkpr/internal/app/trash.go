@@ -0,0 +1,168 @@
+package app
+
+import (
+	"fmt"
+
+	editstack "github.com/haochend413/ntkpr/internal/app/editStack"
+	"github.com/haochend413/ntkpr/internal/db"
+	"github.com/haochend413/ntkpr/internal/models"
+	"gorm.io/gorm"
+)
+
+// trash.go lists the deleted threads, branches and notes, puts them back, and purges them for good.
+// The trash is read from the database, so a deletion shows up in it once it is synced; before that, undo takes it back.
+// Restoring works like undoing a delete: the entity is put back and written again by the next sync, see trackPutBack.
+
+// Trash returns what is in the trash, the last deleted first. Items that were restored and not synced yet are left out.
+func (a *App) Trash() ([]db.TrashItem, error) {
+	items, err := a.db.LoadTrash()
+	if err != nil {
+		return nil, err
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	kept := items[:0]
+	for _, item := range items {
+		if _, pending := a.editMgr.GetEdit(item.Kind, item.ID); pending {
+			continue
+		}
+		kept = append(kept, item)
+	}
+	return kept, nil
+}
+
+// RestoreFromTrash puts a thread, branch or note from the trash back, and makes it active.
+// Its thread comes back with it if it is in the trash too, and so do the branches of a note.
+// The restore can be undone like a create.
+func (a *App) RestoreFromTrash(item db.TrashItem) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	c := &change{threadID: item.ThreadID}
+	var err error
+	switch item.Kind {
+	case db.SearchKindThread:
+		c.editType = editstack.CreateThread
+		err = a.restoreThread(item.ID)
+	case db.SearchKindBranch:
+		c.editType, c.branchID = editstack.CreateBranch, item.ID
+		err = a.restoreBranch(item.ID)
+	case db.SearchKindNote:
+		c.editType, c.noteID = editstack.CreateNote, item.ID
+		err = a.restoreNote(item.ID)
+		c.branchID = a.dataMgr.GetActiveBranchID()
+	default:
+		err = fmt.Errorf("unknown kind %q", item.Kind)
+	}
+	if err != nil {
+		return fmt.Errorf("restore %s %d: %w", item.Kind, item.ID, err)
+	}
+	a.Synced = false
+	a.pushChange(c)
+	return nil
+}
+
+// PurgeFromTrash deletes a thread, branch or note in the trash for good.
+func (a *App) PurgeFromTrash(item db.TrashItem) error {
+	a.mutex.Lock()
+	if _, pending := a.editMgr.GetEdit(item.Kind, item.ID); pending {
+		a.mutex.Unlock()
+		return fmt.Errorf("%s %d was restored, sync before purging it", item.Kind, item.ID)
+	}
+	a.mutex.Unlock()
+	return a.db.PurgeTrashItem(item.Kind, item.ID)
+}
+
+// restoreThread puts a thread back from the trash, unless it is loaded already. Callers hold the mutex.
+func (a *App) restoreThread(id uint) error {
+	if a.dataMgr.FindThreadByID(id) != nil {
+		return nil
+	}
+	thread, err := a.db.LoadTrashedThread(id)
+	if err != nil {
+		return err
+	}
+	if !thread.DeletedAt.Valid {
+		return fmt.Errorf("thread %d is deleted here but not synced, undo the deletion instead", id)
+	}
+	thread.DeletedAt = gorm.DeletedAt{}
+	a.dataMgr.AddStoredThread(thread)
+	a.trackPutBack(editstack.EntityThread, id, nil, thread)
+	return a.goToOrFail(id, 0, 0)
+}
+
+// restoreBranch puts a branch back from the trash with its thread, unless it is loaded already. Callers hold the mutex.
+func (a *App) restoreBranch(id uint) error {
+	branch, err := a.db.LoadTrashedBranch(id)
+	if err != nil {
+		return err
+	}
+	if err := a.restoreThread(branch.ThreadID); err != nil {
+		return err
+	}
+	if err := a.goToOrFail(branch.ThreadID, 0, 0); err != nil {
+		return err
+	}
+	if a.dataMgr.FindBranchByID(id) != nil {
+		return a.goToOrFail(branch.ThreadID, id, 0)
+	}
+	if !branch.DeletedAt.Valid {
+		return fmt.Errorf("branch %d is deleted here but not synced, undo the deletion instead", id)
+	}
+	branch.DeletedAt = gorm.DeletedAt{}
+	a.dataMgr.AddStoredBranch(branch)
+	a.trackPutBack(editstack.EntityBranch, id, nil, branch)
+	return a.goToOrFail(branch.ThreadID, id, 0)
+}
+
+// restoreNote puts a note back from the trash with its thread and the branches it was in. Callers hold the mutex.
+func (a *App) restoreNote(id uint) error {
+	note, err := a.db.LoadTrashedNote(id)
+	if err != nil {
+		return err
+	}
+	if !note.DeletedAt.Valid {
+		return fmt.Errorf("note %d is deleted here but not synced, undo the deletion instead", id)
+	}
+	if err := a.restoreThread(note.ThreadID); err != nil {
+		return err
+	}
+	// the note is listed by the loaded branches, not by the copies read with it
+	branches := make([]*models.Branch, 0, max(1, len(note.Branches)))
+	for _, b := range note.Branches {
+		if err := a.restoreBranch(b.ID); err != nil {
+			return err
+		}
+		branches = append(branches, a.getCurrentBranch())
+	}
+	if len(branches) == 0 {
+		// its branches were purged, it goes to the active branch of its thread
+		if err := a.goToOrFail(note.ThreadID, 0, 0); err != nil {
+			return err
+		}
+		branch := a.getCurrentBranch()
+		if branch == nil {
+			return fmt.Errorf("note %d is in no branch and thread %d has none", id, note.ThreadID)
+		}
+		branches = append(branches, branch)
+	}
+	note.Branches = branches
+	note.DeletedAt = gorm.DeletedAt{}
+
+	if err := a.goToOrFail(note.ThreadID, branches[0].ID, 0); err != nil {
+		return err
+	}
+	a.dataMgr.AddNote(note)
+	a.trackPutBack(editstack.EntityNote, id, nil, note)
+	a.touchBranch(branches[0], nil)
+	return a.goToOrFail(note.ThreadID, branches[0].ID, id)
+}
+
+// goToOrFail is goTo for callers that report an error.
+func (a *App) goToOrFail(threadID, branchID, noteID uint) error {
+	if !a.goTo(threadID, branchID, noteID) {
+		return fmt.Errorf("cannot open thread %d, branch %d, note %d", threadID, branchID, noteID)
+	}
+	return nil
+}
@@ -0,0 +1,392 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	editstack "github.com/haochend413/ntkpr/internal/app/editStack"
+	"github.com/haochend413/ntkpr/internal/app/journal"
+	"github.com/haochend413/ntkpr/internal/db"
+	"github.com/haochend413/ntkpr/internal/models"
+)
+
+// undo.go keeps the actions of the user, so they can be undone and redone.
+// Undoing an action does not drop its edit from the EditMgr: it makes the opposite edit, which is tracked and synced like any other.
+// That works the same before and after the action is synced. A deleted entity that was synced is put back and written again,
+// which undeletes its row, see trackPutBack.
+
+// undoLimit is how many actions Undo can go back.
+const undoLimit = 100
+
+var (
+	ErrNothingToUndo = errors.New("nothing to undo")
+	ErrNothingToRedo = errors.New("nothing to redo")
+)
+
+// change is one action on one entity: created, deleted, or updated from one snapshot to another.
+type change struct {
+	editType editstack.EditType // CreateX, UpdateX, DeleteX, MoveNote, LinkNote or ReorderNotes
+	threadID uint               // where the entity is: its thread, its branch and itself, as far as they apply
+	branchID uint
+	noteID   uint
+	link     *models.Superlink
+	entity   any // the *models.Thread, *models.Branch or *models.Note, kept while it is deleted
+	before   any // for updates, the *journal.Thread, *journal.Branch or *journal.Note before and after the action; for reorders, the note IDs of the branch in order
+	after    any
+	parts    []*change // for actions made of several changes, like a merge, which are undone in reverse order
+}
+
+// entityType returns the editstack entity type of the change.
+func (c *change) entityType() string {
+	switch c.editType {
+	case editstack.CreateThread, editstack.UpdateThread, editstack.DeleteThread:
+		return editstack.EntityThread
+	case editstack.CreateBranch, editstack.UpdateBranch, editstack.DeleteBranch, editstack.ReorderNotes:
+		return editstack.EntityBranch
+	default:
+		return editstack.EntityNote
+	}
+}
+
+// String describes the action, for the status bar.
+func (c *change) String() string {
+	if c.parts != nil {
+		return "merge branches"
+	}
+	switch c.editType {
+	case editstack.CreateThread, editstack.CreateBranch, editstack.CreateNote:
+		return "create " + c.entityType()
+	case editstack.DeleteThread, editstack.DeleteBranch, editstack.DeleteNote:
+		return "delete " + c.entityType()
+	case editstack.MoveNote:
+		return "move note"
+	case editstack.LinkNote:
+		return "add note to branch"
+	case editstack.ReorderNotes:
+		return "reorder notes"
+	default:
+		return "edit " + c.entityType()
+	}
+}
+
+// newChange returns a change of the active entity the edit is about. Callers hold the mutex.
+func (a *App) newChange(editType editstack.EditType, link *models.Superlink) *change {
+	dm := a.dataMgr
+	c := &change{editType: editType, link: link, threadID: dm.GetActiveThreadID()}
+	switch c.entityType() {
+	case editstack.EntityBranch:
+		c.branchID = dm.GetActiveBranchID()
+	case editstack.EntityNote:
+		c.branchID = dm.GetActiveBranchID()
+		c.noteID = dm.GetActiveNoteID()
+	}
+	return c
+}
+
+// pushChange records an action for Undo, and drops the actions that were undone before it. Callers hold the mutex.
+func (a *App) pushChange(c *change) {
+	if a.replaying {
+		return
+	}
+	a.undoStack = append(a.undoStack, c)
+	if len(a.undoStack) > undoLimit {
+		a.undoStack = a.undoStack[len(a.undoStack)-undoLimit:]
+	}
+	a.redoStack = nil
+}
+
+// pushUpdate records an update of the active entity for Undo. before is its snapshot from before the update. Callers hold the mutex.
+func (a *App) pushUpdate(editType editstack.EditType, before any, link *models.Superlink) {
+	c := a.newChange(editType, link)
+	c.before = before
+	switch editType {
+	case editstack.UpdateThread:
+		c.after = journal.ThreadOf(a.getCurrentThread())
+	case editstack.UpdateBranch:
+		c.after = journal.BranchOf(a.getCurrentBranch())
+	case editstack.UpdateNote:
+		c.after = journal.NoteOf(a.getCurrentNote())
+	}
+	a.pushChange(c)
+}
+
+// CanUndo reports whether there is an action to undo.
+func (a *App) CanUndo() bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return len(a.undoStack) > 0
+}
+
+// CanRedo reports whether there is an undone action to redo.
+func (a *App) CanRedo() bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return len(a.redoStack) > 0
+}
+
+// Undo takes back the last action and makes its entity active. It returns a description of the action.
+// An action whose entity can no longer be found, like a note in a branch deleted since, is dropped.
+func (a *App) Undo() (string, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if len(a.undoStack) == 0 {
+		return "", ErrNothingToUndo
+	}
+	c := a.undoStack[len(a.undoStack)-1]
+	a.undoStack = a.undoStack[:len(a.undoStack)-1]
+	if err := a.applyChange(c, true); err != nil {
+		return "", fmt.Errorf("cannot undo %s: %w", c, err)
+	}
+	a.redoStack = append(a.redoStack, c)
+	return c.String(), nil
+}
+
+// Redo makes the last undone action again and makes its entity active. It returns a description of the action.
+func (a *App) Redo() (string, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if len(a.redoStack) == 0 {
+		return "", ErrNothingToRedo
+	}
+	c := a.redoStack[len(a.redoStack)-1]
+	a.redoStack = a.redoStack[:len(a.redoStack)-1]
+	if err := a.applyChange(c, false); err != nil {
+		return "", fmt.Errorf("cannot redo %s: %w", c, err)
+	}
+	a.undoStack = append(a.undoStack, c)
+	return c.String(), nil
+}
+
+// applyChange undoes c, or makes it again. Callers hold the mutex.
+func (a *App) applyChange(c *change, undo bool) error {
+	if c.parts != nil {
+		for i := range c.parts {
+			part := c.parts[i]
+			if undo {
+				part = c.parts[len(c.parts)-1-i]
+			}
+			if err := a.applyChange(part, undo); err != nil {
+				return err
+			}
+		}
+		a.goTo(c.threadID, c.branchID, c.noteID)
+		return nil
+	}
+	switch c.editType {
+	case editstack.CreateThread, editstack.CreateBranch, editstack.CreateNote:
+		if undo {
+			return a.takeAway(c)
+		}
+		return a.putBack(c)
+	case editstack.DeleteThread, editstack.DeleteBranch, editstack.DeleteNote:
+		if undo {
+			return a.putBack(c)
+		}
+		return a.takeAway(c)
+	case editstack.MoveNote, editstack.LinkNote:
+		place := c.after.(*journal.Note)
+		if undo {
+			place = c.before.(*journal.Note)
+		}
+		return a.placeNote(c.noteID, place.ThreadID, place.BranchIDs, c.editType, c.link)
+	case editstack.ReorderNotes:
+		order := c.after.([]uint)
+		if undo {
+			order = c.before.([]uint)
+		}
+		if err := a.orderNotes(c.threadID, c.branchID, order, c.link); err != nil {
+			return err
+		}
+		if c.noteID != 0 {
+			a.goTo(c.threadID, c.branchID, c.noteID)
+		}
+		return nil
+	}
+
+	if !a.goTo(c.threadID, c.branchID, c.noteID) {
+		return fmt.Errorf("the %s is gone", c.entityType())
+	}
+	snapshot := c.after
+	if undo {
+		snapshot = c.before
+	}
+	var entity any
+	switch s := snapshot.(type) {
+	case *journal.Thread:
+		thread := a.getCurrentThread()
+		s.CopyTo(thread)
+		entity = thread
+	case *journal.Branch:
+		branch := a.getCurrentBranch()
+		s.CopyTo(branch)
+		entity = branch
+	case *journal.Note:
+		note := a.getCurrentNote()
+		s.CopyTo(note)
+		entity = note
+	}
+	a.Synced = false
+	edit := &editstack.Edit{ID: c.entityID(), EditType: c.editType}
+	if err := a.trackEdit(edit, c.link, entity); err != nil {
+		return err
+	}
+	return nil
+}
+
+// entityID returns the ID of the entity the change is about.
+func (c *change) entityID() uint {
+	switch c.entityType() {
+	case editstack.EntityThread:
+		return c.threadID
+	case editstack.EntityBranch:
+		return c.branchID
+	default:
+		return c.noteID
+	}
+}
+
+// takeAway deletes the entity of c, and keeps it in c for putBack. Callers hold the mutex.
+func (a *App) takeAway(c *change) error {
+	if !a.goTo(c.threadID, c.branchID, c.noteID) {
+		return fmt.Errorf("the %s is gone", c.entityType())
+	}
+	switch c.entityType() {
+	case editstack.EntityThread:
+		c.entity = a.getCurrentThread()
+		a.deleteCurrentThread(c.link)
+	case editstack.EntityBranch:
+		c.entity = a.getCurrentBranch()
+		a.deleteCurrentBranch(c.link)
+	default:
+		c.entity = a.getCurrentNote()
+		a.deleteCurrentNote(c.link)
+	}
+	return nil
+}
+
+// putBack adds the entity kept in c back where it was, and makes it active. Callers hold the mutex.
+func (a *App) putBack(c *change) error {
+	dm := a.dataMgr
+	switch x := c.entity.(type) {
+	case *models.Thread:
+		dm.AddThread(x)
+		a.trackPutBack(editstack.EntityThread, x.ID, c.link, x)
+	case *models.Branch:
+		if !a.goTo(c.threadID, 0, 0) {
+			return fmt.Errorf("its thread is gone")
+		}
+		dm.AddBranch(x)
+		a.shareBranchNotes(x)
+		a.trackPutBack(editstack.EntityBranch, x.ID, c.link, x)
+	case *models.Note:
+		if !a.goTo(c.threadID, c.branchID, 0) {
+			return fmt.Errorf("its branch is gone")
+		}
+		dm.AddNote(x)
+		a.trackPutBack(editstack.EntityNote, x.ID, c.link, x)
+		a.touchBranch(a.getCurrentBranch(), c.link)
+	default:
+		return fmt.Errorf("the %s was not kept", c.entityType())
+	}
+	a.Synced = false
+	if !a.goTo(c.threadID, c.branchID, c.noteID) {
+		return fmt.Errorf("the %s did not come back", c.entityType())
+	}
+	return nil
+}
+
+// trackPutBack tracks an entity that is back after it was deleted. A deletion that is still pending is taken back,
+// an entity that was never synced is created again, and one whose deletion was synced is written again, which undeletes it.
+// The journal keeps it as a create, which replays as a put back too. Callers hold the mutex.
+func (a *App) trackPutBack(entityType string, id uint, link *models.Superlink, entity any) {
+	var create, update editstack.EditType
+	switch entityType {
+	case editstack.EntityThread:
+		create, update = editstack.CreateThread, editstack.UpdateThread
+	case editstack.EntityBranch:
+		create, update = editstack.CreateBranch, editstack.UpdateBranch
+	default:
+		create, update = editstack.CreateNote, editstack.UpdateNote
+	}
+
+	if !a.editMgr.Undelete(entityType, id) {
+		tp := update
+		if models.IsTempID(id) {
+			tp = create
+		}
+		if err := a.editMgr.AddEdit(&editstack.Edit{ID: id, EditType: tp}, link); err != nil {
+			log.Printf("Error tracking put back %s: %v", entityType, err)
+		}
+	}
+	a.record(&editstack.Edit{ID: id, EditType: create}, link, entity)
+}
+
+// touchBranch marks a branch as updated after a note joined or left it, unless the branch is pending creation,
+// which writes its notes anyway. Callers hold the mutex.
+func (a *App) touchBranch(branch *models.Branch, link *models.Superlink) {
+	if branch == nil || branch.ID == 0 {
+		return
+	}
+	branchEdit, exists := a.editMgr.GetEdit(editstack.EntityBranch, branch.ID)
+	if exists && branchEdit.EditType == editstack.CreateBranch {
+		return
+	}
+	updateEdit := &editstack.Edit{ID: branch.ID, EditType: editstack.UpdateBranch}
+	a.trackEdit(updateEdit, link, branch) // Ignore error - branch might already be marked
+}
+
+// remapUndo moves the recorded actions from temporary IDs to the ones a sync assigned. Callers hold the mutex.
+func (a *App) remapUndo(ids db.IDRemap) {
+	for _, stack := range [][]*change{a.undoStack, a.redoStack} {
+		for _, c := range stack {
+			remapChange(c, ids)
+		}
+	}
+}
+
+// remapChange moves one action and its parts from temporary IDs to the ones a sync assigned.
+func remapChange(c *change, ids db.IDRemap) {
+	c.threadID = ids.Thread(c.threadID)
+	c.branchID = ids.Branch(c.branchID)
+	c.noteID = ids.Note(c.noteID)
+	if c.link != nil {
+		e := journal.Entry{Link: c.link}
+		remapEntry(&e, ids)
+	}
+	// loaded entities were remapped by the data manager, deleted ones are not loaded
+	switch x := c.entity.(type) {
+	case *models.Thread:
+		x.ID = ids.Thread(x.ID)
+	case *models.Branch:
+		x.ID = ids.Branch(x.ID)
+		x.ThreadID = ids.Thread(x.ThreadID)
+		x.ForkBranchID = ids.Branch(x.ForkBranchID)
+		x.ForkNoteID = ids.Note(x.ForkNoteID)
+	case *models.Note:
+		x.ID = ids.Note(x.ID)
+		x.ThreadID = ids.Thread(x.ThreadID)
+	}
+	for _, s := range []any{c.before, c.after} {
+		switch x := s.(type) {
+		case *journal.Branch:
+			x.ThreadID = ids.Thread(x.ThreadID)
+			x.ForkBranchID = ids.Branch(x.ForkBranchID)
+			x.ForkNoteID = ids.Note(x.ForkNoteID)
+		case *journal.Note:
+			x.ThreadID = ids.Thread(x.ThreadID)
+			for i, id := range x.BranchIDs {
+				x.BranchIDs[i] = ids.Branch(id)
+			}
+		case []uint:
+			for i, id := range x {
+				x[i] = ids.Note(id)
+			}
+		}
+	}
+	for _, part := range c.parts {
+		remapChange(part, ids)
+	}
+}
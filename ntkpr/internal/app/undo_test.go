@@ -0,0 +1,341 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	editstack "github.com/haochend413/ntkpr/internal/app/editStack"
+	"github.com/haochend413/ntkpr/internal/db"
+	"github.com/haochend413/ntkpr/internal/vault"
+	"gorm.io/gorm/logger"
+)
+
+// testApp opens an App on a new database.
+func testApp(t *testing.T) (*db.DB, *App) {
+	t.Helper()
+	d, err := db.NewDB(filepath.Join(t.TempDir(), "ntkpr.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Conn.Logger = logger.Discard
+	t.Cleanup(func() { d.Close() })
+	return d, NewApp(d, nil)
+}
+
+// seed creates a thread with a branch of notes of the given contents, makes the first note active, and returns the IDs.
+// The actions are not kept for Undo.
+func seed(t *testing.T, a *App, contents ...string) (threadID, branchID uint, noteIDs []uint) {
+	t.Helper()
+	threadID = a.CreateNewThread(nil)
+	a.goTo(threadID, 0, 0)
+	branchID = a.CreateNewBranch(nil)
+	a.goTo(threadID, branchID, 0)
+	for _, c := range contents {
+		id := a.CreateNewNote(nil)
+		a.goTo(threadID, branchID, id)
+		a.SetCurrentNoteContent(c, nil)
+		noteIDs = append(noteIDs, id)
+	}
+	if threadID == 0 || branchID == 0 || len(noteIDs) > 0 && !a.goTo(threadID, branchID, noteIDs[0]) {
+		t.Fatal("cannot seed the journal")
+	}
+	a.undoStack = nil
+	return threadID, branchID, noteIDs
+}
+
+// syncApp syncs the App with its database, failing the test on error.
+func syncApp(t *testing.T, a *App) {
+	t.Helper()
+	job, err := a.StartSync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job == nil {
+		return
+	}
+	job.Run()
+	if err := a.FinishSync(job); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// stored lists what the database holds alive: "thread:1", "branch:1" and "note:1:content:flags",
+// where the flags are h for highlighted and p for private. Private content is opened.
+func stored(t *testing.T, d *db.DB) []string {
+	t.Helper()
+	var out []string
+	for _, kind := range [][2]string{{"thread", "threads"}, {"branch", "branches"}} {
+		var ids []uint
+		if err := d.Conn.Raw(`SELECT id FROM ` + kind[1] + ` WHERE deleted_at IS NULL ORDER BY id`).Scan(&ids).Error; err != nil {
+			t.Fatal(err)
+		}
+		for _, id := range ids {
+			out = append(out, fmt.Sprintf("%s:%d", kind[0], id))
+		}
+	}
+	var notes []struct {
+		ID        uint
+		Content   string
+		Highlight bool
+		Private   bool
+	}
+	if err := d.Conn.Raw(`SELECT id, content, highlight, private FROM notes WHERE deleted_at IS NULL ORDER BY id`).Scan(&notes).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range notes {
+		flags := ""
+		if n.Highlight {
+			flags += "h"
+		}
+		if n.Private {
+			flags += "p"
+		}
+		out = append(out, fmt.Sprintf("note:%d:%s:%s", n.ID, vault.Open(n.Content), flags))
+	}
+	return out
+}
+
+// unlockVault unlocks the vault for the test with a key that is cheap to derive.
+func unlockVault(t *testing.T) {
+	t.Helper()
+	k, err := vault.DeriveKey("passphrase", vault.Params{Salt: []byte("salt-0123456789a"), Time: 1, Memory: 64, Threads: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vault.Unlock(k)
+	t.Cleanup(vault.Lock)
+}
+
+// Every action is undone and redone by the opposite edit, which is synced like any other, before or after the action is synced.
+func TestUndoRedo(t *testing.T) {
+	unlockVault(t)
+	before := []string{"thread:1", "branch:1", "note:1:first:"}
+	tests := []struct {
+		name string
+		do   func(a *App)
+		want []string
+		// the edit that undoes the action once it is synced
+		undoKey  editstack.EditKey
+		undoType editstack.EditType
+	}{
+		{
+			name:     "edit note",
+			do:       func(a *App) { a.SetCurrentNoteContent("second", nil) },
+			want:     []string{"thread:1", "branch:1", "note:1:second:"},
+			undoKey:  editstack.EditKey{EntityType: editstack.EntityNote, ID: 1},
+			undoType: editstack.UpdateNote,
+		},
+		{
+			name:     "highlight note",
+			do:       func(a *App) { a.ToggleCurrentNoteHighlight(nil) },
+			want:     []string{"thread:1", "branch:1", "note:1:first:h"},
+			undoKey:  editstack.EditKey{EntityType: editstack.EntityNote, ID: 1},
+			undoType: editstack.UpdateNote,
+		},
+		{
+			name:     "make note private",
+			do:       func(a *App) { a.ToggleCurrentNotePrivate(nil) },
+			want:     []string{"thread:1", "branch:1", "note:1:first:p"},
+			undoKey:  editstack.EditKey{EntityType: editstack.EntityNote, ID: 1},
+			undoType: editstack.UpdateNote,
+		},
+		{
+			name:     "create note",
+			do:       func(a *App) { a.CreateNewNote(nil) },
+			want:     []string{"thread:1", "branch:1", "note:1:first:", "note:2::"},
+			undoKey:  editstack.EditKey{EntityType: editstack.EntityNote, ID: 2},
+			undoType: editstack.DeleteNote,
+		},
+		{
+			// the deletion was synced, so the note is written again, which undeletes its row
+			name:     "delete note",
+			do:       func(a *App) { a.DeleteCurrentNote(nil) },
+			want:     []string{"thread:1", "branch:1"},
+			undoKey:  editstack.EditKey{EntityType: editstack.EntityNote, ID: 1},
+			undoType: editstack.UpdateNote,
+		},
+		{
+			name:     "create branch",
+			do:       func(a *App) { a.CreateNewBranch(nil) },
+			want:     []string{"thread:1", "branch:1", "branch:2", "note:1:first:"},
+			undoKey:  editstack.EditKey{EntityType: editstack.EntityBranch, ID: 2},
+			undoType: editstack.DeleteBranch,
+		},
+		{
+			// only the branch row is deleted, its notes stay in the thread
+			name:     "delete branch",
+			do:       func(a *App) { a.DeleteCurrentBranch(nil) },
+			want:     []string{"thread:1", "note:1:first:"},
+			undoKey:  editstack.EditKey{EntityType: editstack.EntityBranch, ID: 1},
+			undoType: editstack.UpdateBranch,
+		},
+		{
+			// only the thread row is deleted, its branches and notes are trashed with it
+			name:     "delete thread",
+			do:       func(a *App) { a.DeleteCurrentThread(nil) },
+			want:     []string{"branch:1", "note:1:first:"},
+			undoKey:  editstack.EditKey{EntityType: editstack.EntityThread, ID: 1},
+			undoType: editstack.UpdateThread,
+		},
+	}
+	for _, tt := range tests {
+		for _, synced := range []bool{true, false} {
+			name := tt.name
+			if !synced {
+				name += " before sync"
+			}
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+				d, a := testApp(t)
+				seed(t, a, "first")
+				syncApp(t, a)
+				if got := stored(t, d); !reflect.DeepEqual(got, before) {
+					t.Fatalf("seeded %v, want %v", got, before)
+				}
+				a.goTo(1, 1, 1)
+
+				tt.do(a)
+				if synced {
+					syncApp(t, a)
+					if got := stored(t, d); !reflect.DeepEqual(got, tt.want) {
+						t.Errorf("after the action %v, want %v", got, tt.want)
+					}
+				}
+				if _, err := a.Undo(); err != nil {
+					t.Fatal(err)
+				}
+				if a.Synced {
+					t.Error("undoing left nothing to sync")
+				}
+				if synced {
+					if edit, ok := a.GetEditMap()[tt.undoKey]; !ok || edit.EditType != tt.undoType {
+						t.Errorf("undoing tracked %+v, want edit %d of %v", edit, tt.undoType, tt.undoKey)
+					}
+				}
+				syncApp(t, a)
+				if got := stored(t, d); !reflect.DeepEqual(got, before) {
+					t.Errorf("after undo %v, want %v", got, before)
+				}
+
+				if _, err := a.Redo(); err != nil {
+					t.Fatal(err)
+				}
+				syncApp(t, a)
+				if got := stored(t, d); !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("after redo %v, want %v", got, tt.want)
+				}
+				if _, err := a.Undo(); err != nil {
+					t.Fatal(err)
+				}
+				syncApp(t, a)
+				if got := stored(t, d); !reflect.DeepEqual(got, before) {
+					t.Errorf("after undoing the redo %v, want %v", got, before)
+				}
+			})
+		}
+	}
+}
+
+// Actions are undone last first, across syncs, and a new action drops the ones that were undone.
+func TestUndoSeveral(t *testing.T) {
+	d, a := testApp(t)
+	seed(t, a, "a")
+	syncApp(t, a)
+	a.goTo(1, 1, 1)
+
+	a.SetCurrentNoteContent("b", nil)
+	syncApp(t, a)
+	a.SetCurrentNoteContent("c", nil)
+	a.ToggleCurrentNoteHighlight(nil)
+	for _, want := range []string{"note:1:c:", "note:1:b:", "note:1:a:"} {
+		if _, err := a.Undo(); err != nil {
+			t.Fatal(err)
+		}
+		syncApp(t, a)
+		if got := stored(t, d)[2]; got != want {
+			t.Errorf("undone to %s, want %s", got, want)
+		}
+	}
+	if _, err := a.Undo(); !errors.Is(err, ErrNothingToUndo) {
+		t.Errorf("undoing past the first action: %v", err)
+	}
+
+	if _, err := a.Redo(); err != nil {
+		t.Fatal(err)
+	}
+	if got := a.GetCurrentNoteContent(); got != "b" {
+		t.Errorf("redone to %q, want b", got)
+	}
+	a.SetCurrentNoteContent("d", nil)
+	if a.CanRedo() {
+		t.Error("a new edit left undone actions to redo")
+	}
+	if _, err := a.Redo(); !errors.Is(err, ErrNothingToRedo) {
+		t.Errorf("redo after a new edit: %v", err)
+	}
+	syncApp(t, a)
+	if got, want := stored(t, d)[2], "note:1:d:"; got != want {
+		t.Errorf("stored %s, want %s", got, want)
+	}
+	if _, err := a.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	syncApp(t, a)
+	if got, want := stored(t, d)[2], "note:1:b:"; got != want {
+		t.Errorf("undoing the new edit stored %s, want %s", got, want)
+	}
+}
+
+// A deleted note that is put back takes back its pending deletion, is created again when it was never synced,
+// and is written again when its deletion was synced.
+func TestUndoDeleteTracksPutBack(t *testing.T) {
+	tests := []struct {
+		name     string
+		synced   bool // the note was synced before it was deleted
+		syncGone bool // the deletion was synced before it was undone
+		want     editstack.EditType
+	}{
+		{"deletion pending", true, false, editstack.UpdateNote},
+		{"deletion synced", true, true, editstack.UpdateNote},
+		{"never synced", false, false, editstack.CreateNote},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			d, a := testApp(t)
+			threadID, branchID, noteIDs := seed(t, a, "kept", "deleted")
+			if tt.synced {
+				syncApp(t, a)
+				threadID, branchID, noteIDs = 1, 1, []uint{1, 2}
+			}
+			a.goTo(threadID, branchID, noteIDs[1])
+			a.DeleteCurrentNote(nil)
+			if tt.syncGone {
+				syncApp(t, a)
+			}
+			if _, err := a.Undo(); err != nil {
+				t.Fatal(err)
+			}
+			edits := a.GetEditMap()
+			if edit, ok := edits[editstack.EditKey{EntityType: editstack.EntityNote, ID: noteIDs[1]}]; !ok || edit.EditType != tt.want {
+				t.Errorf("the note is tracked as %+v, want edit %d", edit, tt.want)
+			}
+			if a.GetCurrentNoteID() != noteIDs[1] || a.GetCurrentNoteContent() != "deleted" {
+				t.Errorf("note %d %q is active, want the one put back", a.GetCurrentNoteID(), a.GetCurrentNoteContent())
+			}
+			syncApp(t, a)
+			want := []string{"thread:1", "branch:1", "note:1:kept:", "note:2:deleted:"}
+			if got := stored(t, d); !reflect.DeepEqual(got, want) {
+				t.Errorf("stored %v, want %v", got, want)
+			}
+			var branchNotes int64
+			d.Conn.Raw(`SELECT count(*) FROM branch_notes WHERE branch_id = 1`).Scan(&branchNotes)
+			if branchNotes != 2 {
+				t.Errorf("the branch lists %d notes, want 2", branchNotes)
+			}
+		})
+	}
+}
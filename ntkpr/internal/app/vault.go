@@ -0,0 +1,91 @@
+package app
+
+import (
+	"errors"
+	"log"
+	"slices"
+
+	"github.com/haochend413/ntkpr/internal/db"
+	"github.com/haochend413/ntkpr/internal/vault"
+)
+
+// vault.go unlocks the text of private threads, branches and notes for the session, see package vault.
+// While the vault is locked, private text that was stored sealed stays sealed in memory, and is shown as a placeholder.
+// Sealed text cannot be edited, so the changes that would rewrite it are refused until the vault is unlocked.
+
+// ErrLocked is returned for changes to text that stays sealed while the vault is locked,
+// and for making entities private or not, which seals or opens their text.
+var ErrLocked = errors.New("private text is locked")
+
+// Locked reports whether the vault of the session is locked.
+func (a *App) Locked() bool {
+	return !vault.Unlocked()
+}
+
+// HasVault reports whether a passphrase was set, so that a new one is to be confirmed when it is not.
+func (a *App) HasVault() bool {
+	has, err := a.db.HasVault()
+	if err != nil {
+		log.Printf("Error reading vault: %v", err)
+	}
+	return has
+}
+
+// NeedsUnlock reports whether the vault is locked while there is private text, or a passphrase was set before.
+func (a *App) NeedsUnlock() bool {
+	if !a.Locked() {
+		return false
+	}
+	if a.HasVault() {
+		return true
+	}
+	has, err := a.db.HasPrivate()
+	if err != nil {
+		log.Printf("Error reading private items: %v", err)
+	}
+	return has
+}
+
+// Unlock unlocks the vault with passphrase for the session, setting it when there is no vault yet,
+// opens the loaded private text, and seals the private text still stored in plain text.
+// It returns how many texts were sealed, or vault.ErrWrongPassphrase.
+func (a *App) Unlock(passphrase string) (int, error) {
+	key, err := a.db.OpenVault(passphrase)
+	if err != nil {
+		return 0, err
+	}
+	vault.Unlock(key)
+
+	a.mutex.Lock()
+	a.dataMgr.OpenSealed()
+	a.mutex.Unlock()
+	return a.db.SealPrivate()
+}
+
+// CurrentLocked reports whether the text of the current thread, branch or note, as kind says, stays sealed.
+// kind is one of db.SearchKindThread, db.SearchKindBranch or db.SearchKindNote.
+func (a *App) CurrentLocked(kind string) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	switch kind {
+	case db.SearchKindThread:
+		if t := a.getCurrentThread(); t != nil {
+			return sealed(t.Name, t.Summary)
+		}
+	case db.SearchKindBranch:
+		if b := a.getCurrentBranch(); b != nil {
+			return sealed(b.Name, b.Summary)
+		}
+	case db.SearchKindNote:
+		if n := a.getCurrentNote(); n != nil {
+			return sealed(n.Content)
+		}
+	}
+	return false
+}
+
+// sealed reports whether any of texts stays sealed. Callers check it before changing private text.
+func sealed(texts ...string) bool {
+	return slices.ContainsFunc(texts, vault.IsSealed)
+}
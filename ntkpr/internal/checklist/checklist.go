@@ -0,0 +1,127 @@
+// Package checklist reads the markdown checkboxes of note content, lines like "- [ ] todo" and "- [x] done".
+// A checkbox is a list item, marked with -, * or + or a number followed by . or ), whose text starts with [ ], [x] or [X].
+// Lines inside fenced code blocks are not checkboxes.
+package checklist
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Counts is how many checkboxes are ticked, out of how many.
+type Counts struct {
+	Done  int
+	Total int
+}
+
+// Add returns the sum of c and o.
+func (c Counts) Add(o Counts) Counts {
+	return Counts{Done: c.Done + o.Done, Total: c.Total + o.Total}
+}
+
+// Sub returns c minus o.
+func (c Counts) Sub(o Counts) Counts {
+	return Counts{Done: c.Done - o.Done, Total: c.Total - o.Total}
+}
+
+// String shows the counts like 3/7, or "" when there are no checkboxes.
+func (c Counts) String() string {
+	if c.Total == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d/%d", c.Done, c.Total)
+}
+
+// Item is one checkbox.
+type Item struct {
+	Box  int    // byte offset of the character between the brackets
+	Done bool   // ticked
+	Text string // the text after the box
+}
+
+// Items returns the checkboxes of content, in order.
+func Items(content string) []Item {
+	var items []Item
+	inFence := false
+	offset := 0
+	for _, line := range strings.SplitAfter(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			inFence = !inFence
+		} else if !inFence {
+			if box, ok := boxOf(line); ok {
+				items = append(items, Item{
+					Box:  offset + box,
+					Done: line[box] != ' ',
+					Text: strings.TrimSpace(line[box+2:]),
+				})
+			}
+		}
+		offset += len(line)
+	}
+	return items
+}
+
+// Count counts the checkboxes of content.
+func Count(content string) Counts {
+	var c Counts
+	for _, item := range Items(content) {
+		c.Total++
+		if item.Done {
+			c.Done++
+		}
+	}
+	return c
+}
+
+// Set ticks or unticks the checkbox at box, an Item.Box of content, and returns the new content.
+func Set(content string, box int, done bool) string {
+	mark := " "
+	if done {
+		mark = "x"
+	}
+	return content[:box] + mark + content[box+1:]
+}
+
+// boxOf returns the offset of the character between the brackets when line is a checkbox.
+func boxOf(line string) (int, bool) {
+	i := len(line) - len(strings.TrimLeft(line, " \t"))
+	// the list marker
+	switch {
+	case i < len(line) && strings.IndexByte("-*+", line[i]) >= 0:
+		i++
+	case i < len(line) && isDigit(line[i]):
+		for i < len(line) && isDigit(line[i]) {
+			i++
+		}
+		if i == len(line) || (line[i] != '.' && line[i] != ')') {
+			return 0, false
+		}
+		i++
+	default:
+		return 0, false
+	}
+	// at least one blank, then the box
+	if i == len(line) || !isBlank(line[i]) {
+		return 0, false
+	}
+	for i < len(line) && isBlank(line[i]) {
+		i++
+	}
+	if i+3 > len(line) || line[i] != '[' || line[i+2] != ']' || strings.IndexByte(" xX", line[i+1]) < 0 {
+		return 0, false
+	}
+	// the box ends the line or is followed by a blank
+	if end := i + 3; end < len(line) && !isBlank(line[end]) && line[end] != '\n' && line[end] != '\r' {
+		return 0, false
+	}
+	return i + 1, true
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
+
+func isBlank(b byte) bool {
+	return b == ' ' || b == '\t'
+}
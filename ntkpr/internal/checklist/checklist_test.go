@@ -0,0 +1,105 @@
+package checklist
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCount(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    Counts
+	}{
+		{"empty", "", Counts{}},
+		{"no boxes", "just text\n- a list item", Counts{}},
+		{"markers", "- [ ] a\n* [x] b\n+ [X] c\n1. [ ] d\n2) [x] e", Counts{Done: 3, Total: 5}},
+		{"indented", "  - [ ] a\n\t- [x] b", Counts{Done: 1, Total: 2}},
+		{"box alone", "- [ ]\n- [x]", Counts{Done: 1, Total: 2}},
+		{"windows line ends", "- [x] a\r\n- [ ] b\r\n", Counts{Done: 1, Total: 2}},
+		{"no blank after the marker", "-[ ] a\n1.[x] b", Counts{}},
+		{"text right after the box", "- [x]a\n- [ ]b", Counts{}},
+		{"other marks", "- [-] a\n- [o] b\n- [] c", Counts{}},
+		{"not a list", "[ ] a\nx [x] b\n1 [x] c", Counts{}},
+		{"fenced code", "- [ ] a\n```\n- [ ] b\n```\n~~~md\n- [x] c\n~~~\n- [x] d", Counts{Done: 1, Total: 2}},
+		{"unclosed fence", "- [x] a\n```\n- [ ] b", Counts{Done: 1, Total: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Count(tt.content); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestItems(t *testing.T) {
+	content := "intro\n- [ ] first  \n  3. [x] second\n"
+	want := []Item{
+		{Box: 9, Done: false, Text: "first"},
+		{Box: 26, Done: true, Text: "second"},
+	}
+	if got := Items(content); !slices.Equal(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestSet(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		box     int // index into Items
+		done    bool
+		want    string
+	}{
+		{"tick", "- [ ] a\n- [ ] b", 1, true, "- [ ] a\n- [x] b"},
+		{"untick", "- [x] a\n- [X] b", 1, false, "- [x] a\n- [ ] b"},
+		{"tick a ticked box", "- [X] a", 0, true, "- [x] a"},
+		{"after a fence", "```\n- [ ] code\n```\n- [ ] a", 0, true, "```\n- [ ] code\n```\n- [x] a"},
+		{"unicode before", "é ü\n- [ ] a", 0, true, "é ü\n- [x] a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items := Items(tt.content)
+			got := Set(tt.content, items[tt.box].Box, tt.done)
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			// the counts follow
+			before, after := Count(tt.content), Count(got)
+			if after.Total != before.Total || after.Done != before.Done-boolInt(items[tt.box].Done)+boolInt(tt.done) {
+				t.Errorf("counts went from %v to %v", before, after)
+			}
+		})
+	}
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func TestCounts(t *testing.T) {
+	a, b := Counts{Done: 1, Total: 3}, Counts{Done: 2, Total: 2}
+	if got := a.Add(b); got != (Counts{Done: 3, Total: 5}) {
+		t.Errorf("Add = %v", got)
+	}
+	if got := a.Add(b).Sub(b); got != a {
+		t.Errorf("Sub = %v, want %v", got, a)
+	}
+	tests := []struct {
+		c    Counts
+		want string
+	}{
+		{Counts{}, ""},
+		{Counts{Done: 0, Total: 2}, "0/2"},
+		{Counts{Done: 3, Total: 7}, "3/7"},
+	}
+	for _, tt := range tests {
+		if got := tt.c.String(); got != tt.want {
+			t.Errorf("%#v.String() = %q, want %q", tt.c, got, tt.want)
+		}
+	}
+}
@@ -5,17 +5,18 @@ import (
 	"os"
 	"path/filepath"
 
-	"github.com/haochend413/ntkpr/internal/models"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
 // DB wraps the GORM database connection
 type DB struct {
-	Conn *gorm.DB
+	Conn          *gorm.DB
+	path          string
+	searchEnabled bool // FTS5 index available, see search.go
 }
 
-// NewDB initializes a new database connection and migrates schema
+// NewDB initializes a new database connection and migrates schema, see migrate.go
 func NewDB(path string) (*DB, error) {
 	// if not exist, create all dirs
 	_, err := os.ReadFile(path)
@@ -29,16 +30,28 @@ func NewDB(path string) (*DB, error) {
 
 	}
 
-	conn, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+	// syncs run next to the UI, so a read can meet a write in progress: wait for it instead of failing.
+	// Deleted and overwritten content is zeroed, so that private text does not linger in free pages after it is sealed.
+	conn, err := gorm.Open(sqlite.Open(path+"?_busy_timeout=5000&_secure_delete=on"), &gorm.Config{})
 	if err != nil {
 		return nil, err
 	}
+	d := &DB{Conn: conn, path: path}
 	// Migrate schema
-	err = conn.AutoMigrate(&models.Note{}, &models.Thread{}, &models.Branch{})
-	if err != nil {
+	if err := d.migrate(path); err != nil {
+		d.Close()
+		return nil, err
+	}
+	if err := d.initSearchIndex(); err != nil {
+		d.Close()
 		return nil, err
 	}
-	return &DB{Conn: conn}, nil
+	return d, nil
+}
+
+// Path returns the path of the database file.
+func (d *DB) Path() string {
+	return d.path
 }
 
 // Close closes the database connection
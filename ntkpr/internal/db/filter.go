@@ -0,0 +1,109 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/haochend413/ntkpr/internal/query"
+	"gorm.io/gorm"
+)
+
+// filter.go runs the filters of a query in SQL, so that a search without free text finds stored branches and notes
+// without reading them all. The result is a set of candidates: callers still match the loaded ones with query.Query,
+// which also sees private text once it is opened and unsynced edits.
+
+// FilterIDs returns the IDs of the live branches and notes that pass the filters and ~words of q, the newest first,
+// at most limit of each kind. Sealed names and content do not match thread:, branch: or ~words.
+func (d *DB) FilterIDs(q *query.Query, limit int) (map[string][]uint, error) {
+	ids := make(map[string][]uint, 2)
+	for _, kind := range []string{SearchKindBranch, SearchKindNote} {
+		var found []uint
+		err := d.filterScope(q, kind).Order("id DESC").Limit(limit).Pluck("id", &found).Error
+		if err != nil {
+			return nil, fmt.Errorf("filter %ss: %w", kind, err)
+		}
+		ids[kind] = found
+	}
+	return ids, nil
+}
+
+// filterScope selects the rows of kind that pass q.
+func (d *DB) filterScope(q *query.Query, kind string) *gorm.DB {
+	table, text := "branches", "name"
+	if kind == SearchKindNote {
+		table, text = "notes", "content"
+	}
+	tx := d.Conn.Table(table).Where("deleted_at IS NULL")
+
+	if q.Highlight != nil {
+		tx = tx.Where("highlight = ?", *q.Highlight)
+	}
+	if q.Private != nil {
+		tx = tx.Where("private = ?", *q.Private)
+	}
+	// dates are compared as julian days, stored times may carry any offset
+	if !q.After.IsZero() {
+		tx = tx.Where("julianday(created_at) >= julianday(?)", q.After)
+	}
+	if !q.Before.IsZero() {
+		tx = tx.Where("julianday(created_at) < julianday(?)", q.Before)
+	}
+	if q.Edited != nil {
+		// items that were never edited count from their last update, like query.Query does
+		age := `julianday('now') - julianday(CASE WHEN last_edit IS NULL OR last_edit < '1000' THEN updated_at ELSE last_edit END)`
+		tx = tx.Where(age+" "+q.Edited.Op+" ?", q.Edited.Age.Hours()/24)
+	}
+	if q.Freq != nil {
+		tx = tx.Where("frequency "+q.Freq.Op+" ?", q.Freq.Value)
+	}
+	if q.Thread != "" {
+		tx = tx.Where(`thread_id IN (SELECT id FROM threads WHERE deleted_at IS NULL AND name LIKE ? ESCAPE '\')`, likeContains(q.Thread))
+	}
+	if q.Branch != "" {
+		if kind == SearchKindNote {
+			tx = tx.Where(`id IN (SELECT bn.note_id FROM branch_notes bn JOIN branches b ON b.id = bn.branch_id
+				WHERE b.deleted_at IS NULL AND b.name LIKE ? ESCAPE '\')`, likeContains(q.Branch))
+		} else {
+			tx = tx.Where(`name LIKE ? ESCAPE '\'`, likeContains(q.Branch))
+		}
+	}
+	for _, word := range q.Fuzzy {
+		tx = tx.Where(text+` LIKE ? ESCAPE '\'`, likeSubsequence(word))
+	}
+	return tx
+}
+
+// likeEscaper escapes the wildcards of LIKE.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// likeContains is a LIKE pattern for text containing s. LIKE ignores case for ASCII letters, like query.Query does for all.
+func likeContains(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('%')
+	for _, r := range s {
+		sb.WriteString(likeRune(r))
+	}
+	sb.WriteByte('%')
+	return sb.String()
+}
+
+// likeSubsequence is a LIKE pattern for text that has the characters of word in order, as fuzzy.Match needs.
+// Spaces are dropped, like fuzzy.Match does.
+func likeSubsequence(word string) string {
+	var sb strings.Builder
+	sb.WriteByte('%')
+	for _, r := range strings.Join(strings.Fields(word), "") {
+		sb.WriteString(likeRune(r))
+		sb.WriteByte('%')
+	}
+	return sb.String()
+}
+
+// likeRune matches r. LIKE compares other letters than ASCII ones with their case, so they match any character,
+// and the candidates are matched again in memory.
+func likeRune(r rune) string {
+	if r > 127 {
+		return "_"
+	}
+	return likeEscaper.Replace(string(r))
+}
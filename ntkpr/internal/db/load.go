@@ -0,0 +1,211 @@
+package db
+
+import (
+	"slices"
+
+	"github.com/haochend413/ntkpr/internal/checklist"
+	"github.com/haochend413/ntkpr/internal/models"
+)
+
+// load.go reads the journal in parts, for data.DataMgr to load on demand, and for searches, see filter.go.
+
+// loadChunkSize bounds the IDs of one IN query, SQLite limits the number of bound variables.
+const loadChunkSize = 500
+
+// LoadThreads loads every live thread without its branches.
+func (d *DB) LoadThreads() ([]*models.Thread, error) {
+	var threads []*models.Thread
+	if err := d.Conn.Order("created_at ASC").Find(&threads).Error; err != nil {
+		return nil, err
+	}
+	return threads, nil
+}
+
+// BranchCounts returns the number of live branches of every thread.
+func (d *DB) BranchCounts() (map[uint]int, error) {
+	var rows []struct {
+		ThreadID uint
+		Count    int
+	}
+	err := d.Conn.Model(&models.Branch{}).
+		Select("thread_id, COUNT(*) AS count").
+		Group("thread_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[uint]int, len(rows))
+	for _, r := range rows {
+		counts[r.ThreadID] = r.Count
+	}
+	return counts, nil
+}
+
+// LoadBranches loads the live branches of a thread without their notes.
+func (d *DB) LoadBranches(threadID uint) ([]*models.Branch, error) {
+	var branches []*models.Branch
+	if err := d.Conn.Where("thread_id = ?", threadID).Order("id ASC").Find(&branches).Error; err != nil {
+		return nil, err
+	}
+	return branches, nil
+}
+
+// NoteCounts returns the number of live notes of every branch of a thread.
+func (d *DB) NoteCounts(threadID uint) (map[uint]int, error) {
+	var rows []struct {
+		BranchID uint
+		Count    int
+	}
+	err := d.Conn.Raw(`SELECT bn.branch_id, COUNT(*) AS count FROM branch_notes bn
+		JOIN branches b ON b.id = bn.branch_id
+		JOIN notes n ON n.id = bn.note_id
+		WHERE b.thread_id = ? AND n.deleted_at IS NULL
+		GROUP BY bn.branch_id`, threadID).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[uint]int, len(rows))
+	for _, r := range rows {
+		counts[r.BranchID] = r.Count
+	}
+	return counts, nil
+}
+
+// ThreadChecks returns the checkboxes of the live notes of every thread, see models.Note.ChecksDone.
+func (d *DB) ThreadChecks() (map[uint]checklist.Counts, error) {
+	var rows []struct {
+		ThreadID uint
+		Done     int
+		Total    int
+	}
+	err := d.Conn.Model(&models.Note{}).
+		Select("thread_id, SUM(checks_done) AS done, SUM(checks_total) AS total").
+		Where("checks_total > 0").
+		Group("thread_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	checks := make(map[uint]checklist.Counts, len(rows))
+	for _, r := range rows {
+		checks[r.ThreadID] = checklist.Counts{Done: r.Done, Total: r.Total}
+	}
+	return checks, nil
+}
+
+// ThreadChecksOf returns the checkboxes of the live notes of a thread.
+func (d *DB) ThreadChecksOf(threadID uint) (checklist.Counts, error) {
+	var c checklist.Counts
+	err := d.Conn.Model(&models.Note{}).
+		Select("COALESCE(SUM(checks_done), 0) AS done, COALESCE(SUM(checks_total), 0) AS total").
+		Where("thread_id = ? AND checks_total > 0", threadID).
+		Scan(&c).Error
+	return c, err
+}
+
+// BranchChecks returns the checkboxes of the live notes of every branch of a thread.
+func (d *DB) BranchChecks(threadID uint) (map[uint]checklist.Counts, error) {
+	var rows []struct {
+		BranchID uint
+		Done     int
+		Total    int
+	}
+	err := d.Conn.Raw(`SELECT bn.branch_id, SUM(n.checks_done) AS done, SUM(n.checks_total) AS total FROM branch_notes bn
+		JOIN branches b ON b.id = bn.branch_id
+		JOIN notes n ON n.id = bn.note_id
+		WHERE b.thread_id = ? AND n.deleted_at IS NULL AND n.checks_total > 0
+		GROUP BY bn.branch_id`, threadID).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	checks := make(map[uint]checklist.Counts, len(rows))
+	for _, r := range rows {
+		checks[r.BranchID] = checklist.Counts{Done: r.Done, Total: r.Total}
+	}
+	return checks, nil
+}
+
+// NoteCursor is where a page of the notes of a branch ends: the position and ID of its last note. The zero cursor is the start.
+type NoteCursor struct {
+	Position int
+	NoteID   uint
+}
+
+// LoadNotes loads up to limit live notes of a branch after the cursor, in their order in the branch, with the branches that list them.
+// It returns the cursor the next page starts after.
+func (d *DB) LoadNotes(branchID uint, after NoteCursor, limit int) ([]*models.Note, NoteCursor, error) {
+	var rows []branchNote
+	err := d.Conn.Raw(`SELECT bn.branch_id, bn.note_id, bn.position FROM branch_notes bn
+		JOIN notes n ON n.id = bn.note_id
+		WHERE bn.branch_id = ? AND n.deleted_at IS NULL
+		AND (bn.position > ? OR (bn.position = ? AND bn.note_id > ?))
+		ORDER BY bn.position ASC, bn.note_id ASC
+		LIMIT ?`, branchID, after.Position, after.Position, after.NoteID, limit).Scan(&rows).Error
+	if err != nil {
+		return nil, after, err
+	}
+	if len(rows) == 0 {
+		return nil, after, nil
+	}
+	ids := make([]uint, len(rows))
+	for i, row := range rows {
+		ids[i] = row.NoteID
+	}
+	var found []*models.Note
+	if err := d.Conn.Preload("Branches").Where("id IN ?", ids).Find(&found).Error; err != nil {
+		return nil, after, err
+	}
+	byID := make(map[uint]*models.Note, len(found))
+	for _, n := range found {
+		byID[n.ID] = n
+	}
+	notes := make([]*models.Note, 0, len(rows))
+	for _, row := range rows {
+		if n, ok := byID[row.NoteID]; ok {
+			notes = append(notes, n)
+		}
+	}
+	last := rows[len(rows)-1]
+	return notes, NoteCursor{Position: last.Position, NoteID: last.NoteID}, nil
+}
+
+// LoadBranchesByID loads live branches by ID, without their notes.
+func (d *DB) LoadBranchesByID(ids []uint) ([]*models.Branch, error) {
+	var branches []*models.Branch
+	if len(ids) == 0 {
+		return branches, nil
+	}
+	for chunk := range slices.Chunk(ids, loadChunkSize) {
+		var part []*models.Branch
+		if err := d.Conn.Where("id IN ?", chunk).Find(&part).Error; err != nil {
+			return nil, err
+		}
+		branches = append(branches, part...)
+	}
+	return branches, nil
+}
+
+// LoadNotesByID loads live notes by ID, with the branches that list them.
+func (d *DB) LoadNotesByID(ids []uint) ([]*models.Note, error) {
+	var notes []*models.Note
+	if len(ids) == 0 {
+		return notes, nil
+	}
+	for chunk := range slices.Chunk(ids, loadChunkSize) {
+		var part []*models.Note
+		if err := d.Conn.Preload("Branches").Where("id IN ?", chunk).Find(&part).Error; err != nil {
+			return nil, err
+		}
+		notes = append(notes, part...)
+	}
+	return notes, nil
+}
+
+// OpenTaskIDs returns the IDs of the live notes that are open tasks, see models.TaskStatus.Open.
+func (d *DB) OpenTaskIDs() ([]uint, error) {
+	var ids []uint
+	err := d.Conn.Model(&models.Note{}).
+		Where("status IN ?", []models.TaskStatus{models.TaskTodo, models.TaskDoing}).
+		Pluck("id", &ids).Error
+	return ids, err
+}
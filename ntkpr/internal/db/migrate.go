@@ -0,0 +1,432 @@
+package db
+
+// Schema migrations.
+// Every database records the migrations applied to it in schema_version. NewDB applies the missing ones in order,
+// each in its own transaction, after backing up the file. A database newer than this binary is refused.
+// The baseline migrates frozen copies of the models and the migrations after it use plain SQL, so they keep working
+// when the models change later.
+// To change the schema, append a migration; never edit or reorder one that has been released.
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/haochend413/ntkpr/internal/checklist"
+	"gorm.io/gorm"
+)
+
+// ErrSchemaTooNew is returned when a database was written by a newer version of ntkpr.
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary")
+
+const schemaVersionSchema = `CREATE TABLE IF NOT EXISTS schema_version (
+	version INTEGER PRIMARY KEY,
+	name TEXT NOT NULL,
+	applied_at DATETIME NOT NULL
+)`
+
+type migration struct {
+	version int
+	name    string
+	up      func(tx *gorm.DB) error
+}
+
+// migrations in the order they are applied. Versions must increase by one.
+var migrations = []migration{
+	{1, "baseline", migrateBaseline},
+	{2, "topics to branches", migrateTopicsToBranches},
+	{3, "adopt unlisted notes", migrateUnlistedNotes},
+	{4, "branch forks", migrateBranchForks},
+	{5, "branch archive", migrateBranchArchive},
+	{6, "note positions", migrateNotePositions},
+	{7, "note tasks", migrateNoteTasks},
+	{8, "note checkboxes", migrateNoteChecks},
+	{9, "private vault", migrateVault},
+	{10, "note thread index", migrateNoteThreadIndex},
+}
+
+// LatestSchemaVersion is the schema version this binary writes.
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// SchemaVersion returns the version of the open database, 0 for a database that was never migrated.
+func (d *DB) SchemaVersion() (int, error) {
+	if !d.Conn.Migrator().HasTable("schema_version") {
+		return 0, nil
+	}
+	var version int
+	if err := d.Conn.Raw(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version).Error; err != nil {
+		return 0, fmt.Errorf("read schema version: %w", err)
+	}
+	return version, nil
+}
+
+// migrate brings the database at path up to LatestSchemaVersion.
+// Databases with data are copied next to path first, see backupBeforeMigrate.
+func (d *DB) migrate(path string) error {
+	current, err := d.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	latest := LatestSchemaVersion()
+	if current > latest {
+		return fmt.Errorf("%s has schema version %d, this binary supports up to %d, please upgrade ntkpr: %w",
+			path, current, latest, ErrSchemaTooNew)
+	}
+	if current == latest {
+		return nil
+	}
+
+	// a database from before versioning has tables but no schema_version, and starts at 0
+	if current > 0 || d.Conn.Migrator().HasTable("notes") {
+		backup, err := d.backupBeforeMigrate(path, current)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Upgrading database from schema version %d to %d, backup saved to %s\n", current, latest, backup)
+	}
+
+	if err := d.Conn.Exec(schemaVersionSchema).Error; err != nil {
+		return fmt.Errorf("create schema_version: %w", err)
+	}
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		err := d.Conn.Transaction(func(tx *gorm.DB) error {
+			if err := m.up(tx); err != nil {
+				return err
+			}
+			return tx.Exec(`INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)`,
+				m.version, m.name, time.Now()).Error
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+		}
+	}
+	return nil
+}
+
+// backupBeforeMigrate writes a consistent copy of the database to path.v<version>-<time>.bak and returns its path.
+func (d *DB) backupBeforeMigrate(path string, version int) (string, error) {
+	backup := fmt.Sprintf("%s.v%d-%s.bak", path, version, time.Now().Format("20060102-150405"))
+	if err := d.Conn.Exec(`VACUUM INTO ?`, backup).Error; err != nil {
+		return "", fmt.Errorf("back up database before migrating: %w", err)
+	}
+	return backup, nil
+}
+
+// backupsOf lists the copies backupBeforeMigrate saved of the database at path.
+func backupsOf(path string) ([]string, error) {
+	dir := filepath.Dir(path)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	prefix := filepath.Base(path) + ".v"
+	var backups []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasPrefix(e.Name(), prefix) && strings.HasSuffix(e.Name(), ".bak") {
+			backups = append(backups, filepath.Join(dir, e.Name()))
+		}
+	}
+	return backups, nil
+}
+
+// migrateBaseline creates the tables of the thread / branch / note model, or adds missing columns to older ones.
+// It migrates copies of the models as they were at schema version 1, so that later changes to the models
+// do not change what it creates: columns added since come from their own migrations.
+func migrateBaseline(tx *gorm.DB) error {
+	return tx.AutoMigrate(&baselineNote{}, &baselineThread{}, &baselineBranch{}, &baselineNoteRevision{})
+}
+
+// The models at schema version 1. Do not change them, append a migration instead.
+type baselineNote struct {
+	gorm.Model
+	Content   string
+	LastEdit  time.Time
+	Highlight bool              `gorm:"default:false"`
+	Private   bool              `gorm:"default:false"`
+	Frequency int               `gorm:"not null;default:0"`
+	Branches  []*baselineBranch `gorm:"many2many:branch_notes;joinForeignKey:NoteID;joinReferences:BranchID;constraint:OnDelete:CASCADE;"`
+	ThreadID  uint
+}
+
+type baselineThread struct {
+	gorm.Model
+	Name      string
+	Summary   string
+	LastEdit  time.Time
+	Highlight bool              `gorm:"default:false"`
+	Private   bool              `gorm:"default:false"`
+	Branches  []*baselineBranch `gorm:"foreignKey:ThreadID"`
+	Frequency int               `gorm:"not null;default:0"`
+}
+
+type baselineBranch struct {
+	gorm.Model
+	ThreadID  uint
+	Name      string
+	Summary   string
+	LastEdit  time.Time
+	Highlight bool            `gorm:"default:false"`
+	Private   bool            `gorm:"default:false"`
+	Notes     []*baselineNote `gorm:"many2many:branch_notes;joinForeignKey:BranchID;joinReferences:NoteID;constraint:OnDelete:CASCADE;"`
+	Frequency int             `gorm:"not null;default:0"`
+}
+
+type baselineNoteRevision struct {
+	ID        uint `gorm:"primarykey"`
+	NoteID    uint `gorm:"index"`
+	Content   string
+	CreatedAt time.Time
+}
+
+func (baselineNote) TableName() string         { return "notes" }
+func (baselineThread) TableName() string       { return "threads" }
+func (baselineBranch) TableName() string       { return "branches" }
+func (baselineNoteRevision) TableName() string { return "note_revisions" }
+
+// migrateTopicsToBranches turns the topics of databases from before threads and branches into branches
+// of an "Imported" thread, so their notes show up again. The old topic tables are left in place.
+func migrateTopicsToBranches(tx *gorm.DB) error {
+	m := tx.Migrator()
+	if !m.HasTable("topics") || !m.HasTable("note_topics") ||
+		!m.HasColumn("note_topics", "note_id") || !m.HasColumn("note_topics", "topic_id") {
+		return nil
+	}
+	nameColumn := ""
+	for _, c := range []string{"topic", "name"} {
+		if m.HasColumn("topics", c) {
+			nameColumn = c
+			break
+		}
+	}
+	if nameColumn == "" {
+		return nil
+	}
+
+	type topic struct {
+		ID   uint
+		Name string
+	}
+	var topics []topic
+	sql := `SELECT id, ` + nameColumn + ` AS name FROM topics`
+	if m.HasColumn("topics", "deleted_at") {
+		sql += ` WHERE deleted_at IS NULL`
+	}
+	if err := tx.Raw(sql + ` ORDER BY id`).Scan(&topics).Error; err != nil {
+		return err
+	}
+	if len(topics) == 0 {
+		return nil
+	}
+
+	threadID, err := ensureThread(tx, "Imported")
+	if err != nil {
+		return err
+	}
+	for _, t := range topics {
+		branchID, err := ensureBranch(tx, threadID, t.Name)
+		if err != nil {
+			return err
+		}
+		if err := tx.Exec(`INSERT INTO branch_notes (branch_id, note_id)
+			SELECT ?, note_id FROM note_topics
+			WHERE topic_id = ? AND note_id IN (SELECT id FROM notes)
+			AND note_id NOT IN (SELECT note_id FROM branch_notes WHERE branch_id = ?)`,
+			branchID, t.ID, branchID).Error; err != nil {
+			return err
+		}
+	}
+	// notes from before threads belong to the thread their topics were moved to
+	return tx.Exec(`UPDATE notes SET thread_id = ?
+		WHERE (thread_id IS NULL OR thread_id NOT IN (SELECT id FROM threads))
+		AND id IN (SELECT note_id FROM branch_notes WHERE branch_id IN (SELECT id FROM branches WHERE thread_id = ?))`,
+		threadID, threadID).Error
+}
+
+// migrateUnlistedNotes puts live notes that no branch lists into an "Unsorted" branch, so they can be reached.
+// Notes without a valid thread go to the "Imported" thread.
+func migrateUnlistedNotes(tx *gorm.DB) error {
+	type unlisted struct {
+		ID       uint
+		ThreadID uint
+		Valid    bool
+	}
+	var notes []unlisted
+	if err := tx.Raw(`SELECT id, COALESCE(thread_id, 0) AS thread_id,
+			COALESCE(thread_id IN (SELECT id FROM threads WHERE deleted_at IS NULL), 0) AS valid
+		FROM notes
+		WHERE deleted_at IS NULL AND id NOT IN (SELECT note_id FROM branch_notes)
+		ORDER BY id`).Scan(&notes).Error; err != nil {
+		return err
+	}
+
+	branches := make(map[uint]uint) // thread ID -> Unsorted branch ID
+	for _, n := range notes {
+		threadID := n.ThreadID
+		if !n.Valid {
+			var err error
+			if threadID, err = ensureThread(tx, "Imported"); err != nil {
+				return err
+			}
+		}
+		branchID, ok := branches[threadID]
+		if !ok {
+			var err error
+			if branchID, err = ensureBranch(tx, threadID, "Unsorted"); err != nil {
+				return err
+			}
+			branches[threadID] = branchID
+		}
+		if err := tx.Exec(`UPDATE notes SET thread_id = ? WHERE id = ?`, threadID, n.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec(`INSERT INTO branch_notes (branch_id, note_id) VALUES (?, ?)`, branchID, n.ID).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateBranchForks adds the columns that record where a branch was forked from.
+// The baseline does not create them, so every database gets them here.
+func migrateBranchForks(tx *gorm.DB) error {
+	for _, column := range []string{"fork_branch_id", "fork_note_id"} {
+		if tx.Migrator().HasColumn("branches", column) {
+			continue
+		}
+		if err := tx.Exec(`ALTER TABLE branches ADD COLUMN ` + column + ` integer NOT NULL DEFAULT 0`).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateBranchArchive adds the column that marks archived branches.
+// The baseline does not create it, so every database gets it here.
+func migrateBranchArchive(tx *gorm.DB) error {
+	if tx.Migrator().HasColumn("branches", "archived") {
+		return nil
+	}
+	return tx.Exec(`ALTER TABLE branches ADD COLUMN archived numeric DEFAULT false`).Error
+}
+
+// migrateNotePositions adds the column that orders the notes of a branch, and puts them in creation order,
+// which is how they were shown before.
+func migrateNotePositions(tx *gorm.DB) error {
+	if tx.Migrator().HasColumn("branch_notes", "position") {
+		return nil
+	}
+	if err := tx.Exec(`ALTER TABLE branch_notes ADD COLUMN position integer NOT NULL DEFAULT 0`).Error; err != nil {
+		return err
+	}
+	return tx.Exec(`UPDATE branch_notes SET position = (SELECT COUNT(*) FROM branch_notes AS earlier
+		WHERE earlier.branch_id = branch_notes.branch_id AND earlier.note_id < branch_notes.note_id)`).Error
+}
+
+// migrateNoteTasks adds the task fields of notes. Existing notes are not tasks.
+func migrateNoteTasks(tx *gorm.DB) error {
+	columns := []struct{ name, ddl string }{
+		{"status", `ALTER TABLE notes ADD COLUMN status text NOT NULL DEFAULT ''`},
+		{"due", `ALTER TABLE notes ADD COLUMN due datetime`},
+		{"completed_at", `ALTER TABLE notes ADD COLUMN completed_at datetime`},
+	}
+	for _, c := range columns {
+		if tx.Migrator().HasColumn("notes", c.name) {
+			continue
+		}
+		if err := tx.Exec(c.ddl).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateNoteChecks adds the checkbox counts of notes, and counts the checkboxes of the notes there are.
+func migrateNoteChecks(tx *gorm.DB) error {
+	for _, column := range []string{"checks_done", "checks_total"} {
+		if tx.Migrator().HasColumn("notes", column) {
+			continue
+		}
+		if err := tx.Exec(`ALTER TABLE notes ADD COLUMN ` + column + ` integer NOT NULL DEFAULT 0`).Error; err != nil {
+			return err
+		}
+	}
+	var rows []struct {
+		ID      uint
+		Content string
+	}
+	if err := tx.Raw(`SELECT id, content FROM notes WHERE content LIKE '%[%]%'`).Scan(&rows).Error; err != nil {
+		return err
+	}
+	for _, r := range rows {
+		c := checklist.Count(r.Content)
+		if c.Total == 0 {
+			continue
+		}
+		err := tx.Exec(`UPDATE notes SET checks_done = ?, checks_total = ? WHERE id = ?`, c.Done, c.Total, r.ID).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateVault creates the table of the vault, see vault.go. Private text stays as it is until the vault is unlocked.
+func migrateVault(tx *gorm.DB) error {
+	return tx.Exec(`CREATE TABLE IF NOT EXISTS vault (
+		id INTEGER PRIMARY KEY,
+		salt BLOB NOT NULL,
+		time INTEGER NOT NULL,
+		memory INTEGER NOT NULL,
+		threads INTEGER NOT NULL,
+		check_value TEXT NOT NULL
+	)`).Error
+}
+
+// migrateNoteThreadIndex indexes the thread of notes, so that what DataMgr reads of one thread does not scan every note.
+func migrateNoteThreadIndex(tx *gorm.DB) error {
+	return tx.Exec(`CREATE INDEX IF NOT EXISTS idx_notes_thread_id ON notes(thread_id)`).Error
+}
+
+// ensureThread returns the ID of the live thread with this name, creating it when there is none.
+func ensureThread(tx *gorm.DB, name string) (uint, error) {
+	var id uint
+	if err := tx.Raw(`SELECT id FROM threads WHERE name = ? AND deleted_at IS NULL ORDER BY id LIMIT 1`, name).Scan(&id).Error; err != nil {
+		return 0, err
+	}
+	if id != 0 {
+		return id, nil
+	}
+	now := time.Now()
+	if err := tx.Exec(`INSERT INTO threads (created_at, updated_at, name, summary, last_edit) VALUES (?, ?, ?, ?, ?)`,
+		now, now, name, name, now).Error; err != nil {
+		return 0, err
+	}
+	err := tx.Raw(`SELECT last_insert_rowid()`).Scan(&id).Error
+	return id, err
+}
+
+// ensureBranch returns the ID of the live branch with this name in a thread, creating it when there is none.
+func ensureBranch(tx *gorm.DB, threadID uint, name string) (uint, error) {
+	var id uint
+	if err := tx.Raw(`SELECT id FROM branches WHERE thread_id = ? AND name = ? AND deleted_at IS NULL ORDER BY id LIMIT 1`,
+		threadID, name).Scan(&id).Error; err != nil {
+		return 0, err
+	}
+	if id != 0 {
+		return id, nil
+	}
+	now := time.Now()
+	if err := tx.Exec(`INSERT INTO branches (created_at, updated_at, thread_id, name, summary, last_edit) VALUES (?, ?, ?, ?, ?, ?)`,
+		now, now, threadID, name, name, now).Error; err != nil {
+		return 0, err
+	}
+	err := tx.Raw(`SELECT last_insert_rowid()`).Scan(&id).Error
+	return id, err
+}
@@ -0,0 +1,221 @@
+package db
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// The tables of a journal from before schema versions, as AutoMigrate created them from the models of then.
+const preVersioningSchema = "CREATE TABLE `threads` (`id` integer PRIMARY KEY AUTOINCREMENT,`created_at` datetime,`updated_at` datetime,`deleted_at` datetime,`name` text,`summary` text,`last_edit` datetime,`highlight` numeric DEFAULT false,`private` numeric DEFAULT false,`frequency` integer NOT NULL DEFAULT 0);" +
+	"CREATE TABLE `branches` (`id` integer PRIMARY KEY AUTOINCREMENT,`created_at` datetime,`updated_at` datetime,`deleted_at` datetime,`thread_id` integer,`name` text,`summary` text,`last_edit` datetime,`highlight` numeric DEFAULT false,`private` numeric DEFAULT false,`frequency` integer NOT NULL DEFAULT 0,CONSTRAINT `fk_threads_branches` FOREIGN KEY (`thread_id`) REFERENCES `threads`(`id`));" +
+	"CREATE TABLE `notes` (`id` integer PRIMARY KEY AUTOINCREMENT,`created_at` datetime,`updated_at` datetime,`deleted_at` datetime,`content` text,`diff` text,`last_edit` datetime,`highlight` numeric DEFAULT false,`private` numeric DEFAULT false,`frequency` integer NOT NULL DEFAULT 0,`thread_id` integer);" +
+	"CREATE TABLE `branch_notes` (`note_id` integer,`branch_id` integer,PRIMARY KEY (`note_id`,`branch_id`),CONSTRAINT `fk_branch_notes_note` FOREIGN KEY (`note_id`) REFERENCES `notes`(`id`) ON DELETE CASCADE,CONSTRAINT `fk_branch_notes_branch` FOREIGN KEY (`branch_id`) REFERENCES `branches`(`id`) ON DELETE CASCADE);"
+
+// The tables of a journal from before threads and branches, where notes had topics.
+const topicsSchema = "CREATE TABLE `notes` (`id` integer PRIMARY KEY AUTOINCREMENT,`created_at` datetime,`updated_at` datetime,`deleted_at` datetime,`content` text);" +
+	"CREATE TABLE `topics` (`id` integer PRIMARY KEY AUTOINCREMENT,`created_at` datetime,`updated_at` datetime,`deleted_at` datetime,`topic` text);" +
+	"CREATE TABLE `note_topics` (`note_id` integer,`topic_id` integer,PRIMARY KEY (`note_id`,`topic_id`));"
+
+// openRaw opens a database file without migrating it.
+func openRaw(t *testing.T, path string) *gorm.DB {
+	t.Helper()
+	conn, err := gorm.Open(sqlite.Open(path), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := conn.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return conn
+}
+
+// migrateTo applies the first version migrations to conn, as a binary of that version would have.
+func migrateTo(t *testing.T, conn *gorm.DB, version int) {
+	t.Helper()
+	if err := conn.Exec(schemaVersionSchema).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range migrations[:version] {
+		if err := m.up(conn); err != nil {
+			t.Fatalf("migration %d: %v", m.version, err)
+		}
+		err := conn.Exec(`INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)`, m.version, m.name, time.Now()).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// schemaOf lists the columns of every table and the indexes of conn, leaving out the search index.
+func schemaOf(t *testing.T, conn *gorm.DB) map[string][]string {
+	t.Helper()
+	var tables []string
+	err := conn.Raw(`SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'search_index%'
+		AND name NOT IN ('sqlite_sequence', 'topics', 'note_topics')`).Scan(&tables).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := make(map[string][]string, len(tables)+1)
+	for _, table := range tables {
+		var columns []string
+		if err := conn.Raw(`SELECT name FROM pragma_table_info(?)`, table).Scan(&columns).Error; err != nil {
+			t.Fatal(err)
+		}
+		slices.Sort(columns)
+		schema[table] = columns
+	}
+	var indexes []string
+	if err := conn.Raw(`SELECT name FROM sqlite_master WHERE type = 'index' AND name NOT LIKE 'sqlite_%'`).Scan(&indexes).Error; err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(indexes)
+	schema["indexes"] = indexes
+	return schema
+}
+
+func TestMigrateToLatest(t *testing.T) {
+	fresh := testDB(t)
+	want := schemaOf(t, fresh.Conn)
+	// the diff column of early notes is kept
+	wantPreVersioning := schemaOf(t, fresh.Conn)
+	wantPreVersioning["notes"] = append(slices.Clone(want["notes"]), "diff")
+	slices.Sort(wantPreVersioning["notes"])
+
+	tests := []struct {
+		name    string
+		setup   func(t *testing.T, conn *gorm.DB)
+		schema  map[string][]string
+		threads []string // names of the threads after migrating
+	}{
+		{
+			name: "topics",
+			setup: func(t *testing.T, conn *gorm.DB) {
+				exec(t, &DB{Conn: conn}, topicsSchema,
+					`INSERT INTO notes (id, created_at, updated_at, content) VALUES
+						(1, datetime('now'), datetime('now'), 'tagged'),
+						(2, datetime('now'), datetime('now'), '- [x] untagged'||char(10)||'- [ ] todo')`,
+					`INSERT INTO topics (id, topic) VALUES (1, 'ideas')`,
+					`INSERT INTO note_topics (note_id, topic_id) VALUES (1, 1)`)
+			},
+			threads: []string{"Imported"},
+		},
+		{
+			name: "pre-versioning",
+			setup: func(t *testing.T, conn *gorm.DB) {
+				exec(t, &DB{Conn: conn}, preVersioningSchema,
+					`INSERT INTO threads (id, created_at, updated_at, name) VALUES (1, datetime('now'), datetime('now'), 'work')`,
+					`INSERT INTO branches (id, created_at, updated_at, thread_id, name) VALUES (1, datetime('now'), datetime('now'), 1, 'main')`,
+					`INSERT INTO notes (id, created_at, updated_at, thread_id, content) VALUES
+						(1, datetime('now'), datetime('now'), 1, 'listed'),
+						(2, datetime('now'), datetime('now'), 1, '- [x] unlisted'||char(10)||'- [ ] todo')`,
+					`INSERT INTO branch_notes (note_id, branch_id) VALUES (1, 1)`)
+			},
+			schema:  wantPreVersioning,
+			threads: []string{"work"},
+		},
+		{
+			name: "version 3",
+			setup: func(t *testing.T, conn *gorm.DB) {
+				migrateTo(t, conn, 3)
+				exec(t, &DB{Conn: conn},
+					`INSERT INTO threads (id, created_at, updated_at, name) VALUES (1, datetime('now'), datetime('now'), 'work')`,
+					`INSERT INTO branches (id, created_at, updated_at, thread_id, name) VALUES
+						(1, datetime('now'), datetime('now'), 1, 'main'), (2, datetime('now'), datetime('now'), 1, 'Unsorted')`,
+					`INSERT INTO notes (id, created_at, updated_at, thread_id, content) VALUES
+						(1, datetime('now'), datetime('now'), 1, 'listed'),
+						(2, datetime('now'), datetime('now'), 1, '- [x] unlisted'||char(10)||'- [ ] todo')`,
+					`INSERT INTO branch_notes (note_id, branch_id) VALUES (1, 1), (2, 2)`)
+			},
+			threads: []string{"work"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "ntkpr.db")
+			tt.setup(t, openRaw(t, path))
+
+			d, err := NewDB(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d.Conn.Logger = logger.Discard
+
+			if got, err := d.SchemaVersion(); err != nil || got != LatestSchemaVersion() {
+				t.Fatalf("schema version %d (%v), want %d", got, err, LatestSchemaVersion())
+			}
+			schema := tt.schema
+			if schema == nil {
+				schema = want
+			}
+			got := schemaOf(t, d.Conn)
+			for table, columns := range schema {
+				if !slices.Equal(got[table], columns) {
+					t.Errorf("%s: got %v, want %v", table, got[table], columns)
+				}
+			}
+			for table := range got {
+				if _, ok := schema[table]; !ok {
+					t.Errorf("unexpected table %s", table)
+				}
+			}
+
+			backups, _ := filepath.Glob(path + ".v*.bak")
+			if len(backups) != 1 {
+				t.Errorf("backups %v, want one", backups)
+			}
+			var threads []string
+			d.Conn.Raw(`SELECT name FROM threads ORDER BY id`).Scan(&threads)
+			if !slices.Equal(threads, tt.threads) {
+				t.Errorf("threads %v, want %v", threads, tt.threads)
+			}
+			// every note can be reached, and its checkboxes are counted
+			var unlisted int64
+			d.Conn.Raw(`SELECT COUNT(*) FROM notes WHERE id NOT IN (SELECT note_id FROM branch_notes)`).Scan(&unlisted)
+			if unlisted != 0 {
+				t.Errorf("%d notes in no branch", unlisted)
+			}
+			var checks struct{ Done, Total int }
+			d.Conn.Raw(`SELECT checks_done AS done, checks_total AS total FROM notes WHERE id = 2`).Scan(&checks)
+			if checks.Done != 1 || checks.Total != 2 {
+				t.Errorf("note 2 has %d of %d checkboxes done, want 1 of 2", checks.Done, checks.Total)
+			}
+
+			// opening it again migrates nothing
+			d.Close()
+			again, err := NewDB(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			again.Close()
+			if backups, _ := filepath.Glob(path + ".v*.bak"); len(backups) != 1 {
+				t.Errorf("backups after reopening %v, want one", backups)
+			}
+		})
+	}
+}
+
+// A journal with a newer schema is refused instead of being written by an older binary.
+func TestMigrateRefusesNewerSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ntkpr.db")
+	conn := openRaw(t, path)
+	migrateTo(t, conn, LatestSchemaVersion())
+	exec(t, &DB{Conn: conn}, `INSERT INTO schema_version (version, name, applied_at) VALUES (999, 'future', datetime('now'))`)
+
+	d, err := NewDB(path)
+	if err == nil {
+		d.Close()
+		t.Fatal("opened a journal with a newer schema")
+	}
+	if !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("got %v, want ErrSchemaTooNew", err)
+	}
+}
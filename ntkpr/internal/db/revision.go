@@ -0,0 +1,100 @@
+package db
+
+import (
+	"time"
+
+	"github.com/haochend413/ntkpr/internal/models"
+	"github.com/haochend413/ntkpr/internal/vault"
+)
+
+// GetNoteRevisions returns all revisions of a note, newest first.
+func (d *DB) GetNoteRevisions(noteID uint) ([]*models.NoteRevision, error) {
+	var revisions []*models.NoteRevision
+	err := d.Conn.
+		Where("note_id = ?", noteID).
+		Order("created_at DESC, id DESC").
+		Find(&revisions).Error
+	if err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+// GetNoteRevision returns a single revision by its ID.
+func (d *DB) GetNoteRevision(id uint) (*models.NoteRevision, error) {
+	var revision models.NoteRevision
+	if err := d.Conn.First(&revision, id).Error; err != nil {
+		return nil, err
+	}
+	return &revision, nil
+}
+
+// recordBaselineRevisions stores the content currently in the database as the first revision of notes without history.
+// Notes written before revisions existed have no history, so their old text is saved before it gets overwritten.
+func (d *DB) recordBaselineRevisions(noteIDs []uint) error {
+	if len(noteIDs) == 0 {
+		return nil
+	}
+	var stored []models.Note
+	err := d.Conn.Select("id", "content", "private", "created_at", "last_edit").
+		Where("id IN ? AND id NOT IN (SELECT note_id FROM note_revisions WHERE note_id IN ?)", noteIDs, noteIDs).
+		Find(&stored).Error
+	if err != nil || len(stored) == 0 {
+		return err
+	}
+
+	revisions := make([]models.NoteRevision, len(stored))
+	for i, n := range stored {
+		createdAt := n.LastEdit
+		if createdAt.IsZero() {
+			createdAt = n.CreatedAt
+		}
+		revisions[i] = models.NoteRevision{NoteID: n.ID, Content: revisionContent(&n), CreatedAt: createdAt}
+	}
+	return d.Conn.CreateInBatches(revisions, syncBatchSize).Error
+}
+
+// recordRevisions appends the content of each note as a new revision, unless it equals the note's latest one.
+// Highlight / private toggles also produce UpdateNote edits, and those should not add history.
+func (d *DB) recordRevisions(notes []*models.Note) error {
+	if len(notes) == 0 {
+		return nil
+	}
+	ids := make([]uint, len(notes))
+	for i, n := range notes {
+		ids[i] = n.ID
+	}
+	var latest []models.NoteRevision
+	err := d.Conn.Raw(`SELECT r.note_id, r.content FROM note_revisions r
+		WHERE r.note_id IN ? AND r.id = (
+			SELECT id FROM note_revisions WHERE note_id = r.note_id ORDER BY created_at DESC, id DESC LIMIT 1
+		)`, ids).Scan(&latest).Error
+	if err != nil {
+		return err
+	}
+	latestContent := make(map[uint]string, len(latest))
+	for _, r := range latest {
+		latestContent[r.NoteID] = r.Content
+	}
+
+	now := time.Now()
+	revisions := make([]models.NoteRevision, 0, len(notes))
+	for _, n := range notes {
+		if content, ok := latestContent[n.ID]; ok && content == n.Content {
+			continue
+		}
+		revisions = append(revisions, models.NoteRevision{NoteID: n.ID, Content: revisionContent(n), CreatedAt: now})
+	}
+	if len(revisions) == 0 {
+		return nil
+	}
+	return d.Conn.CreateInBatches(revisions, syncBatchSize).Error
+}
+
+// revisionContent is the content a revision of n keeps: sealed when the note is private, see vault.go.
+func revisionContent(n *models.Note) string {
+	if n.Private {
+		return vault.Seal(n.Content)
+	}
+	return n.Content
+}
@@ -0,0 +1,478 @@
+package db
+
+// Full-text search over threads, branches and notes.
+// The index is an FTS5 virtual table kept up to date by SyncData. Private entities are left out, their text is sealed, see vault.go.
+// FTS5 is only compiled into go-sqlite3 with the sqlite_fts5 build tag; without it, search falls back to LIKE.
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/haochend413/ntkpr/internal/models"
+	"gorm.io/gorm"
+)
+
+// Kinds of indexed entities.
+const (
+	SearchKindThread = "thread"
+	SearchKindBranch = "branch"
+	SearchKindNote   = "note"
+)
+
+// Snippets wrap matched text with these markers, so the UI can style them.
+const (
+	MatchStart = "\x02"
+	MatchEnd   = "\x03"
+)
+
+// trigram needs at least 3 characters per term. Shorter terms (common for Chinese words) use LIKE instead.
+const minTrigramLen = 3
+
+const searchIndexSchema = `CREATE VIRTUAL TABLE IF NOT EXISTS search_index USING fts5(
+	kind UNINDEXED,
+	ref_id UNINDEXED,
+	thread_id UNINDEXED,
+	body,
+	tokenize = 'trigram'
+)`
+
+// SearchHit is one ranked search result.
+type SearchHit struct {
+	Kind     string
+	RefID    uint
+	ThreadID uint
+	Snippet  string
+	Rank     float64
+}
+
+// SearchEnabled reports whether the FTS5 index is available.
+func (d *DB) SearchEnabled() bool {
+	return d.searchEnabled
+}
+
+// initSearchIndex creates the index if the driver supports FTS5, and fills it when it is empty.
+func (d *DB) initSearchIndex() error {
+	if !hasFTS5(d.Conn) {
+		// no fts5 module in this build, keep working without the index.
+		// An index left by a build with it would go stale here: drop it, such a build rebuilds it.
+		d.searchEnabled = false
+		_, err := dropSearchIndex(d.Conn)
+		return err
+	}
+	d.searchEnabled = true
+	if err := d.Conn.Exec(searchIndexSchema).Error; err != nil {
+		return err
+	}
+	// deleted rows leave no tokens behind in the index, see unindexPrivate
+	if err := d.Conn.Exec(`INSERT INTO search_index(search_index, rank) VALUES('secure-delete', 1)`).Error; err != nil {
+		return err
+	}
+
+	var count int64
+	if err := d.Conn.Table("search_index").Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return d.unindexPrivate()
+	}
+	return d.RebuildSearchIndex()
+}
+
+// hasFTS5 reports whether the driver was built with FTS5.
+func hasFTS5(conn *gorm.DB) bool {
+	var used bool
+	err := conn.Raw(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&used).Error
+	return err == nil && used
+}
+
+// dropSearchIndex drops the search index of conn, and reports whether it had one.
+// Without FTS5 the index cannot be dropped as a table: its entry is removed from the schema, and its own tables dropped.
+func dropSearchIndex(conn *gorm.DB) (bool, error) {
+	var count int64
+	if err := conn.Raw(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'search_index'`).Scan(&count).Error; err != nil {
+		return false, err
+	}
+	if count == 0 {
+		return false, nil
+	}
+	if hasFTS5(conn) {
+		return true, conn.Exec(`DROP TABLE search_index`).Error
+	}
+	// writable_schema holds for one connection
+	err := conn.Connection(func(tx *gorm.DB) error {
+		if err := tx.Exec(`PRAGMA writable_schema = ON`).Error; err != nil {
+			return err
+		}
+		err := tx.Exec(`DELETE FROM sqlite_master WHERE name = 'search_index'`).Error
+		if rerr := tx.Exec(`PRAGMA writable_schema = RESET`).Error; err == nil {
+			err = rerr
+		}
+		if err != nil {
+			return err
+		}
+		for _, shadow := range []string{"data", "idx", "content", "docsize", "config"} {
+			if err := tx.Exec(`DROP TABLE IF EXISTS search_index_` + shadow).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("drop search index: %w", err)
+	}
+	return true, nil
+}
+
+// RebuildSearchIndex drops every indexed row and re-indexes all live threads, and the live branches and notes inside them.
+func (d *DB) RebuildSearchIndex() error {
+	if !d.searchEnabled {
+		return nil
+	}
+	return d.Conn.Transaction(func(tx *gorm.DB) error {
+		stmts := []string{
+			`DELETE FROM search_index`,
+			`INSERT INTO search_index (kind, ref_id, thread_id, body)
+				SELECT 'thread', id, id, COALESCE(NULLIF(summary, ''), name) FROM threads WHERE deleted_at IS NULL AND NOT private`,
+			`INSERT INTO search_index (kind, ref_id, thread_id, body)
+				SELECT 'branch', id, thread_id, COALESCE(NULLIF(summary, ''), name) FROM branches WHERE deleted_at IS NULL AND NOT private AND thread_id IN (SELECT id FROM threads WHERE deleted_at IS NULL)`,
+			`INSERT INTO search_index (kind, ref_id, thread_id, body)
+				SELECT 'note', id, thread_id, content FROM notes WHERE deleted_at IS NULL AND NOT private AND thread_id IN (SELECT id FROM threads WHERE deleted_at IS NULL)`,
+		}
+		for _, stmt := range stmts {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// indexEntry is the indexed row of one entity.
+type indexEntry struct {
+	refID    uint
+	threadID uint
+	body     string
+}
+
+// indexEntities replaces the indexed rows of entities of one kind, inserting them in batches.
+func (d *DB) indexEntities(kind string, entries []indexEntry) error {
+	if !d.searchEnabled || len(entries) == 0 {
+		return nil
+	}
+	ids := make([]uint, len(entries))
+	for i, e := range entries {
+		ids[i] = e.refID
+	}
+	if err := d.unindexEntities(kind, ids); err != nil {
+		return err
+	}
+	for start := 0; start < len(entries); start += syncBatchSize {
+		batch := entries[start:min(start+syncBatchSize, len(entries))]
+		values := make([]string, len(batch))
+		args := make([]any, 0, 4*len(batch))
+		for i, e := range batch {
+			values[i] = "(?, ?, ?, ?)"
+			args = append(args, kind, e.refID, e.threadID, e.body)
+		}
+		sql := `INSERT INTO search_index (kind, ref_id, thread_id, body) VALUES ` + strings.Join(values, ", ")
+		if err := d.Conn.Exec(sql, args...).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SummaryBody is the indexed text of a thread or branch.
+// The name is the first line of the summary, so the summary alone is enough unless it is empty.
+func SummaryBody(name, summary string) string {
+	if summary == "" {
+		return name
+	}
+	return summary
+}
+
+// indexThreads indexes threads, and drops the private ones from the index.
+func (d *DB) indexThreads(threads []*models.Thread) error {
+	entries := make([]indexEntry, 0, len(threads))
+	var private []uint
+	for _, t := range threads {
+		if t.Private {
+			private = append(private, t.ID)
+			continue
+		}
+		entries = append(entries, indexEntry{t.ID, t.ID, SummaryBody(t.Name, t.Summary)})
+	}
+	if err := d.unindexEntities(SearchKindThread, private); err != nil {
+		return err
+	}
+	return d.indexEntities(SearchKindThread, entries)
+}
+
+// indexBranches indexes branches, and drops the private ones from the index.
+func (d *DB) indexBranches(branches []*models.Branch) error {
+	entries := make([]indexEntry, 0, len(branches))
+	var private []uint
+	for _, b := range branches {
+		if b.Private {
+			private = append(private, b.ID)
+			continue
+		}
+		entries = append(entries, indexEntry{b.ID, b.ThreadID, SummaryBody(b.Name, b.Summary)})
+	}
+	if err := d.unindexEntities(SearchKindBranch, private); err != nil {
+		return err
+	}
+	return d.indexEntities(SearchKindBranch, entries)
+}
+
+// indexNotes indexes notes, and drops the private ones from the index.
+func (d *DB) indexNotes(notes []*models.Note) error {
+	entries := make([]indexEntry, 0, len(notes))
+	var private []uint
+	for _, n := range notes {
+		if n.Private {
+			private = append(private, n.ID)
+			continue
+		}
+		entries = append(entries, indexEntry{n.ID, n.ThreadID, n.Content})
+	}
+	if err := d.unindexEntities(SearchKindNote, private); err != nil {
+		return err
+	}
+	return d.indexEntities(SearchKindNote, entries)
+}
+
+// unindexPrivate drops private entities from the index, such as ones indexed before private text was sealed,
+// and scrubs what they left in the database file.
+func (d *DB) unindexPrivate() error {
+	res := d.Conn.Exec(`DELETE FROM search_index WHERE
+		(kind = 'thread' AND ref_id IN (SELECT id FROM threads WHERE private)) OR
+		(kind = 'branch' AND ref_id IN (SELECT id FROM branches WHERE private)) OR
+		(kind = 'note' AND ref_id IN (SELECT id FROM notes WHERE private))`)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return res.Error
+	}
+	return d.scrub()
+}
+
+// unindexEntities removes entities of one kind from the index.
+func (d *DB) unindexEntities(kind string, ids []uint) error {
+	if !d.searchEnabled || len(ids) == 0 {
+		return nil
+	}
+	return d.Conn.Exec(`DELETE FROM search_index WHERE kind = ? AND ref_id IN ?`, kind, ids).Error
+}
+
+// unindexThreads removes threads and everything inside them from the index.
+func (d *DB) unindexThreads(ids []uint) error {
+	if !d.searchEnabled || len(ids) == 0 {
+		return nil
+	}
+	return d.Conn.Exec(`DELETE FROM search_index WHERE thread_id IN ?`, ids).Error
+}
+
+// reindexThreadContents indexes the live branches and notes of threads that come back from the trash.
+// Deleting a thread dropped everything inside it from the index, see unindexThreads.
+func (d *DB) reindexThreadContents(ids []uint) error {
+	if !d.searchEnabled || len(ids) == 0 {
+		return nil
+	}
+	stmts := []string{
+		`DELETE FROM search_index WHERE thread_id IN ? AND kind != 'thread'`,
+		`INSERT INTO search_index (kind, ref_id, thread_id, body)
+			SELECT 'branch', id, thread_id, COALESCE(NULLIF(summary, ''), name) FROM branches WHERE thread_id IN ? AND deleted_at IS NULL AND NOT private`,
+		`INSERT INTO search_index (kind, ref_id, thread_id, body)
+			SELECT 'note', id, thread_id, content FROM notes WHERE thread_id IN ? AND deleted_at IS NULL AND NOT private`,
+	}
+	for _, stmt := range stmts {
+		if err := d.Conn.Exec(stmt, ids).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Search runs a ranked full-text query. kind limits results to one entity kind, "" searches everything.
+// Terms are ANDed; "double quoted" text is matched as a phrase, and a trailing * is accepted for prefix queries.
+func (d *DB) Search(query string, kind string, limit int) ([]SearchHit, error) {
+	terms := splitQueryTerms(query)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	if limit <= 0 {
+		limit = 200
+	}
+
+	useFTS := d.searchEnabled
+	for _, t := range terms {
+		if utf8.RuneCountInString(t) < minTrigramLen {
+			useFTS = false
+			break
+		}
+	}
+	if useFTS {
+		return d.searchFTS(terms, kind, limit)
+	}
+	return d.searchLike(terms, kind, limit)
+}
+
+func (d *DB) searchFTS(terms []string, kind string, limit int) ([]SearchHit, error) {
+	// quote every term so user input is never parsed as FTS5 syntax
+	quoted := make([]string, len(terms))
+	for i, t := range terms {
+		quoted[i] = `"` + strings.ReplaceAll(t, `"`, `""`) + `"`
+	}
+
+	sql := `SELECT kind, ref_id, thread_id,
+			snippet(search_index, 3, ?, ?, '…', 32) AS snippet,
+			bm25(search_index) AS rank
+		FROM search_index
+		WHERE search_index MATCH ?`
+	args := []interface{}{MatchStart, MatchEnd, strings.Join(quoted, " ")}
+	if kind != "" {
+		sql += ` AND kind = ?`
+		args = append(args, kind)
+	}
+	sql += ` ORDER BY rank LIMIT ?`
+	args = append(args, limit)
+
+	var hits []SearchHit
+	if err := d.Conn.Raw(sql, args...).Scan(&hits).Error; err != nil {
+		return nil, fmt.Errorf("search %q: %w", strings.Join(terms, " "), err)
+	}
+	return hits, nil
+}
+
+// searchLike is the fallback for builds without FTS5 and for terms too short for trigrams.
+// It finds what the index holds, see RebuildSearchIndex, and ranks by how early the first term appears.
+func (d *DB) searchLike(terms []string, kind string, limit int) ([]SearchHit, error) {
+	type row struct {
+		Kind     string
+		RefID    uint
+		ThreadID uint
+		Body     string
+	}
+
+	sources := []string{
+		`SELECT 'thread' AS kind, id AS ref_id, id AS thread_id, COALESCE(NULLIF(summary, ''), name) AS body FROM threads WHERE deleted_at IS NULL AND NOT private`,
+		`SELECT 'branch' AS kind, id AS ref_id, thread_id, COALESCE(NULLIF(summary, ''), name) AS body FROM branches WHERE deleted_at IS NULL AND NOT private AND thread_id IN (SELECT id FROM threads WHERE deleted_at IS NULL)`,
+		`SELECT 'note' AS kind, id AS ref_id, thread_id, content AS body FROM notes WHERE deleted_at IS NULL AND NOT private AND thread_id IN (SELECT id FROM threads WHERE deleted_at IS NULL)`,
+	}
+	sql := `SELECT * FROM (` + strings.Join(sources, " UNION ALL ") + `) WHERE 1 = 1`
+	args := []interface{}{}
+	if kind != "" {
+		sql += ` AND kind = ?`
+		args = append(args, kind)
+	}
+	for _, t := range terms {
+		sql += ` AND body LIKE ? ESCAPE '\'`
+		args = append(args, "%"+escapeLike(t)+"%")
+	}
+	sql += ` LIMIT ?`
+	args = append(args, limit)
+
+	var rows []row
+	if err := d.Conn.Raw(sql, args...).Scan(&rows).Error; err != nil {
+		return nil, fmt.Errorf("search %q: %w", strings.Join(terms, " "), err)
+	}
+
+	hits := make([]SearchHit, 0, len(rows))
+	for _, r := range rows {
+		snippet, pos := likeSnippet(r.Body, terms[0])
+		hits = append(hits, SearchHit{
+			Kind:     r.Kind,
+			RefID:    r.RefID,
+			ThreadID: r.ThreadID,
+			Snippet:  snippet,
+			Rank:     float64(pos),
+		})
+	}
+	sort.SliceStable(hits, func(i, j int) bool {
+		return hits[i].Rank < hits[j].Rank
+	})
+	return hits, nil
+}
+
+// splitQueryTerms splits a query on spaces, keeping "quoted phrases" together and dropping a trailing *.
+func splitQueryTerms(query string) []string {
+	terms := make([]string, 0)
+	var sb strings.Builder
+	inQuote := false
+	flush := func() {
+		t := strings.TrimSuffix(strings.TrimSpace(sb.String()), "*")
+		if t != "" {
+			terms = append(terms, t)
+		}
+		sb.Reset()
+	}
+	for _, r := range query {
+		switch {
+		case r == '"':
+			flush()
+			inQuote = !inQuote
+		case !inQuote && (r == ' ' || r == '\t' || r == '\n'):
+			flush()
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	flush()
+	return terms
+}
+
+func escapeLike(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "%", `\%`)
+	return strings.ReplaceAll(s, "_", `\_`)
+}
+
+// likeSnippet cuts a window of text around the first case-insensitive match of term and marks it.
+// It returns the snippet and the rune position of the match.
+func likeSnippet(body, term string) (string, int) {
+	runes := []rune(body)
+	lower := []rune(strings.ToLower(body))
+	needle := []rune(strings.ToLower(term))
+
+	pos := -1
+	for i := 0; i+len(needle) <= len(lower); i++ {
+		if string(lower[i:i+len(needle)]) == string(needle) {
+			pos = i
+			break
+		}
+	}
+	if pos < 0 {
+		if len(runes) > 32 {
+			return string(runes[:32]) + "…", len(runes)
+		}
+		return body, len(runes)
+	}
+
+	start := max(0, pos-12)
+	end := min(len(runes), pos+len(needle)+20)
+	snippet := string(runes[start:pos]) + MatchStart + string(runes[pos:pos+len(needle)]) + MatchEnd + string(runes[pos+len(needle):end])
+	if start > 0 {
+		snippet = "…" + snippet
+	}
+	if end < len(runes) {
+		snippet += "…"
+	}
+	return snippet, pos
+}
+
+// MatchText checks body against a query the same way the LIKE fallback does, and returns a marked snippet.
+// It is used for local changes that have not been synced into the index yet.
+func MatchText(body, query string) (string, bool) {
+	terms := splitQueryTerms(query)
+	if len(terms) == 0 {
+		return "", false
+	}
+	lower := strings.ToLower(body)
+	for _, t := range terms {
+		if !strings.Contains(lower, strings.ToLower(t)) {
+			return "", false
+		}
+	}
+	snippet, _ := likeSnippet(body, terms[0])
+	return snippet, true
+}
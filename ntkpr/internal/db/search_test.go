@@ -0,0 +1,266 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	editstack "github.com/haochend413/ntkpr/internal/app/editStack"
+	"github.com/haochend413/ntkpr/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// searchModes are the ways Search runs in this build: the LIKE fallback always, FTS5 with the sqlite_fts5 tag.
+func searchModes(t *testing.T) []string {
+	if testDB(t).SearchEnabled() {
+		return []string{"fts5", "like"}
+	}
+	t.Log("built without sqlite_fts5, testing the LIKE fallback only")
+	return []string{"like"}
+}
+
+// searchJournal syncs a thread "Work log" with a branch of four notes, the third private, and returns the thread.
+// In "like" mode the database behaves as a build without FTS5.
+func searchJournal(t *testing.T, mode string) (*DB, *models.Thread) {
+	t.Helper()
+	d := testDB(t)
+	if mode == "like" {
+		d.searchEnabled = false
+	}
+	thread, edits := localJournal(4)
+	thread.Summary = "Work log"
+	notes := thread.Branches[0].Notes
+	notes[0].Content = "deploy the server tonight"
+	notes[1].Content = "meeting notes about the roadmap"
+	notes[2].Content = "secret deploy plan"
+	notes[2].Private = true
+	notes[3].Content = "读书笔记 第一章"
+	if _, err := d.SyncData([]*models.Thread{thread}, edits); err != nil {
+		t.Fatal(err)
+	}
+	return d, thread
+}
+
+// hits runs a search and returns its hits as sorted "kind:id" strings. Every hit must mark its match.
+func hits(t *testing.T, d *DB, query, kind string) []string {
+	t.Helper()
+	found, err := d.Search(query, kind, 0)
+	if err != nil {
+		t.Fatalf("Search(%q): %v", query, err)
+	}
+	got := make([]string, 0, len(found))
+	for _, h := range found {
+		got = append(got, fmt.Sprintf("%s:%d", h.Kind, h.RefID))
+		if !strings.Contains(h.Snippet, MatchStart) || !strings.Contains(h.Snippet, MatchEnd) {
+			t.Errorf("Search(%q): snippet %q of %s:%d marks no match", query, h.Snippet, h.Kind, h.RefID)
+		}
+	}
+	slices.Sort(got)
+	return got
+}
+
+func TestSearch(t *testing.T) {
+	tests := []struct {
+		query, kind string
+		want        []string
+	}{
+		{"deploy", "", []string{"note:1"}},
+		{"DEPLOY", "", []string{"note:1"}},
+		{"deplo*", "", []string{"note:1"}},
+		{`"the server"`, "", []string{"note:1"}},
+		{`"server the"`, "", []string{}},
+		{"roadmap meeting", "", []string{"note:2"}},
+		{"roadmap deploy", "", []string{}},
+		{"work", "", []string{"thread:1"}},
+		{"branch", SearchKindBranch, []string{"branch:1"}},
+		{"note", SearchKindNote, []string{"note:2"}},
+		{"note", SearchKindThread, []string{}},
+		// terms shorter than a trigram fall back to LIKE, even with FTS5
+		{"de", "", []string{"note:1"}},
+		{"笔记", "", []string{"note:4"}},
+		{"读书笔记", "", []string{"note:4"}},
+		// private text is not searchable
+		{"secret", "", []string{}},
+		{"plan", "", []string{}},
+		// LIKE wildcards are matched as text
+		{"%", "", []string{}},
+		{"_", "", []string{}},
+	}
+	for _, mode := range searchModes(t) {
+		d, _ := searchJournal(t, mode)
+		for _, tt := range tests {
+			if got := hits(t, d, tt.query, tt.kind); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s: Search(%q, %q) = %v, want %v", mode, tt.query, tt.kind, got, tt.want)
+			}
+		}
+		if got, err := d.Search("  ", "", 0); err != nil || len(got) != 0 {
+			t.Errorf("%s: an empty query found %v, %v", mode, got, err)
+		}
+		if got, _ := d.Search("e", "", 2); len(got) != 2 {
+			t.Errorf("%s: a limit of 2 found %d hits", mode, len(got))
+		}
+	}
+}
+
+// indexRows lists the search index, sorted.
+func indexRows(t *testing.T, d *DB) []string {
+	t.Helper()
+	var rows []struct {
+		Kind     string
+		RefID    uint
+		ThreadID uint
+		Body     string
+	}
+	if err := d.Conn.Raw(`SELECT kind, ref_id, thread_id, body FROM search_index ORDER BY kind, ref_id`).Scan(&rows).Error; err != nil {
+		t.Fatal(err)
+	}
+	out := make([]string, len(rows))
+	for i, r := range rows {
+		out[i] = fmt.Sprintf("%s:%d:%d:%s", r.Kind, r.RefID, r.ThreadID, r.Body)
+	}
+	return out
+}
+
+// SyncData keeps the index as a rebuild would make it.
+func TestSearchFollowsSync(t *testing.T) {
+	for _, mode := range searchModes(t) {
+		t.Run(mode, func(t *testing.T) {
+			d, thread := searchJournal(t, mode)
+			branch := thread.Branches[0]
+			notes := branch.Notes
+			check := func(step string, want map[string][]string) {
+				t.Helper()
+				for query, ids := range want {
+					if got := hits(t, d, query, ""); !reflect.DeepEqual(got, ids) {
+						t.Errorf("%s: Search(%q) = %v, want %v", step, query, got, ids)
+					}
+				}
+				if !d.SearchEnabled() {
+					return
+				}
+				synced := indexRows(t, d)
+				if err := d.RebuildSearchIndex(); err != nil {
+					t.Fatal(err)
+				}
+				if rebuilt := indexRows(t, d); !reflect.DeepEqual(synced, rebuilt) {
+					t.Errorf("%s: index after sync\n%v\nafter a rebuild\n%v", step, synced, rebuilt)
+				}
+			}
+			sync := func(edits map[editstack.EditKey]*editstack.Edit) {
+				t.Helper()
+				if _, err := d.SyncData([]*models.Thread{thread}, edits); err != nil {
+					t.Fatal(err)
+				}
+			}
+			noteEdit := func(note *models.Note, editType editstack.EditType) map[editstack.EditKey]*editstack.Edit {
+				return map[editstack.EditKey]*editstack.Edit{
+					{EntityType: editstack.EntityNote, ID: note.ID}: {ID: note.ID, EditType: editType},
+				}
+			}
+
+			notes[0].Content = "roll back the server"
+			sync(noteEdit(notes[0], editstack.UpdateNote))
+			check("update", map[string][]string{"deploy": {}, "roll back": {"note:1"}})
+
+			notes[1].Private = true
+			sync(noteEdit(notes[1], editstack.UpdateNote))
+			check("made private", map[string][]string{"roadmap": {}})
+
+			notes[2].Private = false
+			sync(noteEdit(notes[2], editstack.UpdateNote))
+			check("made public", map[string][]string{"secret": {"note:3"}})
+
+			sync(noteEdit(notes[3], editstack.DeleteNote))
+			check("delete note", map[string][]string{"笔记": {}})
+
+			added := &models.Note{Content: "deploy again", ThreadID: thread.ID, Branches: []*models.Branch{branch}}
+			added.ID = models.TempIDStart + 10
+			branch.Notes = append(branch.Notes, added)
+			sync(noteEdit(added, editstack.CreateNote))
+			check("create", map[string][]string{"deploy": {"note:3", fmt.Sprintf("note:%d", added.ID)}})
+
+			branch.Name, branch.Summary = "renamed", "renamed"
+			sync(map[editstack.EditKey]*editstack.Edit{
+				{EntityType: editstack.EntityBranch, ID: branch.ID}: {ID: branch.ID, EditType: editstack.UpdateBranch},
+			})
+			check("rename branch", map[string][]string{"branch": {}, "renamed": {"branch:1"}})
+
+			sync(map[editstack.EditKey]*editstack.Edit{
+				{EntityType: editstack.EntityThread, ID: thread.ID}: {ID: thread.ID, EditType: editstack.DeleteThread},
+			})
+			check("delete thread", map[string][]string{"server": {}, "work": {}, "renamed": {}, "deploy": {}})
+		})
+	}
+}
+
+// An index left by a build with FTS5 is dropped by a build without it, where syncs would not keep it up to date,
+// and a build with FTS5 rebuilds an index that is missing.
+func TestSearchIndexAcrossBuilds(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ntkpr.db")
+	d, err := NewDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Conn.Logger = logger.Discard
+	thread, edits := localJournal(1)
+	thread.Branches[0].Notes[0].Content = "deploy the server"
+	if _, err := d.SyncData([]*models.Thread{thread}, edits); err != nil {
+		t.Fatal(err)
+	}
+
+	if d.SearchEnabled() {
+		// as a build without FTS5 leaves it: no index, and a note it does not know of
+		exec(t, d, `DROP TABLE search_index`)
+		d.searchEnabled = false
+	} else {
+		// as a build with FTS5 leaves it
+		exec(t, d,
+			`CREATE TABLE search_index_content (id INTEGER PRIMARY KEY, c0, c1, c2, c3)`,
+			`CREATE TABLE search_index_data (id INTEGER PRIMARY KEY, block BLOB)`,
+			`INSERT INTO search_index_content VALUES (1, 'note', 1, 1, 'deploy the server')`,
+		)
+		err := d.Conn.Connection(func(tx *gorm.DB) error {
+			return errors.Join(
+				tx.Exec(`PRAGMA writable_schema = ON`).Error,
+				tx.Exec(`INSERT INTO sqlite_master (type, name, tbl_name, rootpage, sql) VALUES ('table', 'search_index', 'search_index', 0,
+					'CREATE VIRTUAL TABLE search_index USING fts5(kind UNINDEXED, ref_id UNINDEXED, thread_id UNINDEXED, body)')`).Error,
+				tx.Exec(`PRAGMA writable_schema = RESET`).Error)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	added := &models.Note{Content: "deploy again", ThreadID: thread.ID, Branches: thread.Branches}
+	added.ID = models.TempIDStart + 1
+	thread.Branches[0].Notes = append(thread.Branches[0].Notes, added)
+	if _, err := d.SyncData([]*models.Thread{thread}, map[editstack.EditKey]*editstack.Edit{
+		{EntityType: editstack.EntityNote, ID: added.ID}: {ID: added.ID, EditType: editstack.CreateNote},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+
+	d, err = NewDB(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer d.Close()
+	if got, want := hits(t, d, "deploy", ""), []string{"note:1", "note:2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Search(deploy) = %v, want %v", got, want)
+	}
+	var tables []string
+	if err := d.Conn.Raw(`SELECT name FROM sqlite_master WHERE name LIKE 'search_index%'`).Scan(&tables).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !d.SearchEnabled() && len(tables) > 0 {
+		t.Errorf("the index is left: %v", tables)
+	}
+	if d.SearchEnabled() && !slices.Contains(tables, "search_index") {
+		t.Errorf("the index is not rebuilt: %v", tables)
+	}
+}
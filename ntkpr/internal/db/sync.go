@@ -2,19 +2,115 @@ package db
 
 // This package might need further tuning.
 // For starters, I think, maybe threads, branches and notes are redundant ?
+//
+// SyncData writes all pending edits in one transaction: either every edit lands, or none does.
+// Rows are written in batches of syncBatchSize instead of one statement per entity.
 import (
 	"fmt"
+	"slices"
 	"strings"
+	"time"
 
 	editstack "github.com/haochend413/ntkpr/internal/app/editStack"
 	"github.com/haochend413/ntkpr/internal/models"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-// SyncData takes in local stored data and edit record, sync with database and return the latest synced data.
+// syncBatchSize is the number of rows per INSERT. Notes have about a dozen columns,
+// so a batch stays far below the bound parameter limit of SQLite.
+const syncBatchSize = 100
+
+// branchNote is a row of the branch_notes join table.
+// Position orders the notes of a branch, ties go by note ID; the same note can sit at different positions in different branches.
+type branchNote struct {
+	BranchID uint
+	NoteID   uint
+	Position int
+}
+
+func (branchNote) TableName() string {
+	return "branch_notes"
+}
+
+// IDRemap maps the temporary IDs of entities created by a sync to the IDs the database assigned them.
+type IDRemap struct {
+	Threads  map[uint]uint
+	Branches map[uint]uint
+	Notes    map[uint]uint
+}
+
+func newIDRemap() IDRemap {
+	return IDRemap{
+		Threads:  make(map[uint]uint),
+		Branches: make(map[uint]uint),
+		Notes:    make(map[uint]uint),
+	}
+}
+
+// Thread returns the ID thread id has after the sync.
+func (r IDRemap) Thread(id uint) uint {
+	return remapID(r.Threads, id)
+}
+
+// Branch returns the ID branch id has after the sync.
+func (r IDRemap) Branch(id uint) uint {
+	return remapID(r.Branches, id)
+}
+
+// Note returns the ID note id has after the sync.
+func (r IDRemap) Note(id uint) uint {
+	return remapID(r.Notes, id)
+}
+
+func remapID(m map[uint]uint, id uint) uint {
+	if newID, ok := m[id]; ok {
+		return newID
+	}
+	return id
+}
+
+// localIDs records the IDs SyncData rewrites in the local data, so a failed sync can put them back.
+type localIDs struct {
+	ptrs []*uint
+	vals []uint
+}
+
+func (l *localIDs) set(p *uint, v uint) {
+	l.ptrs = append(l.ptrs, p)
+	l.vals = append(l.vals, *p)
+	*p = v
+}
+
+func (l *localIDs) restore() {
+	for i := len(l.ptrs) - 1; i >= 0; i-- {
+		*l.ptrs[i] = l.vals[i]
+	}
+}
+
+// SyncResult is what a sync changed, for patching the local data without reloading everything.
+// The entities are the rows written by the sync and the parents it touched, as stored, without associations.
+type SyncResult struct {
+	IDs             IDRemap
+	Threads         []*models.Thread
+	Branches        []*models.Branch
+	Notes           []*models.Note
+	DeletedThreads  []uint
+	DeletedBranches []uint
+	DeletedNotes    []uint
+
+	// number of threads, branches and notes written, by kind of edit
+	Created int
+	Updated int
+	Deleted int
+}
+
+// SyncData takes in local stored data and edit record, sync with database and return what changed.
+// The edits are written in a single transaction that is rolled back on error, leaving the database and the local data as they were.
+// Created entities get their IDs from the database; the IDs field of the result maps their temporary IDs to them.
 func (d *DB) SyncData(
 	threads []*models.Thread,
-	editMap map[editstack.EditKey]*editstack.Edit) ([]*models.Thread, error) {
+	editMap map[editstack.EditKey]*editstack.Edit) (SyncResult, error) {
 	// Categorize edits from the editMap
 	noteCreateIDs := make([]uint, 0)
 	notePendingIDs := make([]uint, 0)
@@ -27,6 +123,7 @@ func (d *DB) SyncData(
 	branchCreateIDs := make([]uint, 0)
 	branchPendingIDs := make([]uint, 0)
 	branchDeleteIDs := make([]uint, 0)
+	branchOrderIDs := make([]uint, 0)
 
 	for key, edit := range editMap {
 		id := key.ID
@@ -34,7 +131,8 @@ func (d *DB) SyncData(
 		// Notes
 		case editstack.CreateNote:
 			noteCreateIDs = append(noteCreateIDs, id)
-		case editstack.UpdateNote:
+		case editstack.UpdateNote, editstack.MoveNote, editstack.LinkNote:
+			// a moved note is written with its new thread and branches
 			notePendingIDs = append(notePendingIDs, id)
 		case editstack.DeleteNote:
 			noteDeleteIDs = append(noteDeleteIDs, id)
@@ -52,6 +150,10 @@ func (d *DB) SyncData(
 			branchCreateIDs = append(branchCreateIDs, id)
 		case editstack.UpdateBranch:
 			branchPendingIDs = append(branchPendingIDs, id)
+		case editstack.ReorderNotes:
+			// a reordered branch is written with the order of its notes
+			branchPendingIDs = append(branchPendingIDs, id)
+			branchOrderIDs = append(branchOrderIDs, id)
 		case editstack.DeleteBranch:
 			branchDeleteIDs = append(branchDeleteIDs, id)
 
@@ -70,6 +172,11 @@ func (d *DB) SyncData(
 	branchCreateIDs = uniqueIDs(branchCreateIDs)
 	branchPendingIDs = uniqueIDs(branchPendingIDs)
 	branchDeleteIDs = uniqueIDs(branchDeleteIDs)
+	branchOrderIDs = uniqueIDs(branchOrderIDs)
+	// temporary IDs grow with creation, so new entities get their IDs in the order they were created
+	slices.Sort(noteCreateIDs)
+	slices.Sort(branchCreateIDs)
+	slices.Sort(threadCreateIDs)
 
 	// Build maps for O(1) lookup
 	threadsMap := make(map[uint]*models.Thread)
@@ -86,112 +193,356 @@ func (d *DB) SyncData(
 		}
 	}
 
-	// Create in order: Threads -> Branches -> Notes
-	// Note: nextCreateID logic ensures assigned IDs never collide with existing DB records.
-	// SQLite preserves explicitly provided IDs, so foreign key references remain valid.
+	remap := newIDRemap()
+	result := SyncResult{
+		IDs:             remap,
+		DeletedThreads:  threadDeleteIDs,
+		DeletedBranches: branchDeleteIDs,
+		DeletedNotes:    noteDeleteIDs,
+	}
+	// the database assigns the IDs of new entities; put the local ones back if the transaction fails
+	var local localIDs
 
-	// 1. Create threads
-	for _, threadID := range threadCreateIDs {
-		if thread, exists := threadsMap[threadID]; exists {
-			if err := d.persistThread(thread, true); err != nil {
-				return nil, fmt.Errorf("failed to create thread %d: %w", thread.ID, err)
+	err := d.Conn.Transaction(func(tx *gorm.DB) error {
+		txd := &DB{Conn: tx, searchEnabled: d.searchEnabled}
+
+		// Create in order: Threads -> Branches -> Notes
+		// Each step rewrites the references to the IDs it assigned, so the next one stores real IDs.
+
+		// 1. Create threads
+		created := collectThreads(threadsMap, threadCreateIDs)
+		if err := txd.createThreads(created, remap.Threads, &local); err != nil {
+			return fmt.Errorf("failed to create %d threads: %w", len(created), err)
+		}
+		for _, branch := range branchesMap {
+			if id, ok := remap.Threads[branch.ThreadID]; ok {
+				local.set(&branch.ThreadID, id)
 			}
 		}
-	}
-
-	// 2. Create branches
-	for _, branchID := range branchCreateIDs {
-		if branch, exists := branchesMap[branchID]; exists {
-			if err := d.persistBranch(branch, true); err != nil {
-				return nil, fmt.Errorf("failed to create branch %d: %w", branch.ID, err)
+		for _, note := range notesMap {
+			if id, ok := remap.Threads[note.ThreadID]; ok {
+				local.set(&note.ThreadID, id)
 			}
 		}
-	}
+		if err := txd.indexThreads(created); err != nil {
+			return fmt.Errorf("failed to index threads: %w", err)
+		}
 
-	// 2.5. Update threads
-	for _, threadID := range threadPendingIDs {
-		if thread, exists := threadsMap[threadID]; exists {
-			if err := d.persistThread(thread, false); err != nil {
-				return nil, fmt.Errorf("failed to update thread %d: %w", thread.ID, err)
-			}
+		// 2. Create branches
+		createdBranches := collectBranches(branchesMap, branchCreateIDs)
+		if err := txd.createBranches(createdBranches, remap.Branches, &local); err != nil {
+			return fmt.Errorf("failed to create %d branches: %w", len(createdBranches), err)
+		}
+		if err := txd.indexBranches(createdBranches); err != nil {
+			return fmt.Errorf("failed to index branches: %w", err)
+		}
+
+		// 2.5. Update threads. Writing a deleted thread again restores it from the trash, with what is inside it.
+		updated, err := keepStored(txd, &models.Thread{}, collectThreads(threadsMap, threadPendingIDs),
+			func(t *models.Thread) uint { return t.ID })
+		if err != nil {
+			return fmt.Errorf("failed to find updated threads: %w", err)
+		}
+		restored, err := txd.trashedThreads(threadPendingIDs)
+		if err != nil {
+			return fmt.Errorf("failed to find restored threads: %w", err)
+		}
+		if err := txd.updateThreads(updated); err != nil {
+			return fmt.Errorf("failed to update %d threads: %w", len(updated), err)
+		}
+		if err := txd.indexThreads(updated); err != nil {
+			return fmt.Errorf("failed to index threads: %w", err)
+		}
+		if err := txd.reindexThreadContents(restored); err != nil {
+			return fmt.Errorf("failed to index restored threads: %w", err)
 		}
-	}
 
-	// 3. Create notes
-	for _, noteID := range noteCreateIDs {
-		if note, exists := notesMap[noteID]; exists {
+		// 3. Create notes
+		createdNotes := collectNotes(notesMap, noteCreateIDs)
+		for _, note := range createdNotes {
 			sanitizeNote(note)
-			if err := d.persistNote(note, true); err != nil {
-				return nil, fmt.Errorf("failed to create note %d: %w", note.ID, err)
-			}
 		}
-	}
+		if err := txd.createNotes(createdNotes, remap.Notes, &local); err != nil {
+			return fmt.Errorf("failed to create %d notes: %w", len(createdNotes), err)
+		}
+		if err := txd.recordRevisions(createdNotes); err != nil {
+			return fmt.Errorf("failed to record revisions of new notes: %w", err)
+		}
+		if err := txd.indexNotes(createdNotes); err != nil {
+			return fmt.Errorf("failed to index notes: %w", err)
+		}
 
-	// 4. Update notes
-	for _, noteID := range notePendingIDs {
-		if note, exists := notesMap[noteID]; exists {
+		// 3.5. Forks made from new branches or notes still hold their temporary IDs
+		if err := txd.resolveForks(createdBranches, remap, &local); err != nil {
+			return fmt.Errorf("failed to resolve forks: %w", err)
+		}
+
+		// 4. Update notes
+		updatedNotes, err := keepStored(txd, &models.Note{}, collectNotes(notesMap, notePendingIDs),
+			func(n *models.Note) uint { return n.ID })
+		if err != nil {
+			return fmt.Errorf("failed to find updated notes: %w", err)
+		}
+		for _, note := range updatedNotes {
 			sanitizeNote(note)
-			if err := d.persistNote(note, false); err != nil {
-				return nil, fmt.Errorf("failed to update note %d: %w", note.ID, err)
-			}
 		}
+		// keep the text that is about to be overwritten, for notes that have no history yet
+		if err := txd.recordBaselineRevisions(notePendingIDs); err != nil {
+			return fmt.Errorf("failed to record baseline revisions: %w", err)
+		}
+		if err := txd.updateNotes(updatedNotes); err != nil {
+			return fmt.Errorf("failed to update %d notes: %w", len(updatedNotes), err)
+		}
+		if err := txd.recordRevisions(updatedNotes); err != nil {
+			return fmt.Errorf("failed to record revisions: %w", err)
+		}
+		if err := txd.indexNotes(updatedNotes); err != nil {
+			return fmt.Errorf("failed to index notes: %w", err)
+		}
+
+		// 5. Update branches (e.g., adding/removing notes)
+		updatedBranches, err := keepStored(txd, &models.Branch{}, collectBranches(branchesMap, branchPendingIDs),
+			func(b *models.Branch) uint { return b.ID })
+		if err != nil {
+			return fmt.Errorf("failed to find updated branches: %w", err)
+		}
+		if err := txd.updateBranches(updatedBranches); err != nil {
+			return fmt.Errorf("failed to update %d branches: %w", len(updatedBranches), err)
+		}
+		if err := txd.indexBranches(updatedBranches); err != nil {
+			return fmt.Errorf("failed to index branches: %w", err)
+		}
+
+		// 5.5. Order the notes of reordered branches, and of new ones, which list all their notes
+		ordered := append(collectBranches(branchesMap, branchOrderIDs), createdBranches...)
+		if err := txd.orderNotes(ordered); err != nil {
+			return fmt.Errorf("failed to order the notes of %d branches: %w", len(ordered), err)
+		}
+
+		// 6. Delete in reverse order: Notes -> Branches -> Threads
+		if err := txd.deleteNotes(noteDeleteIDs); err != nil {
+			return fmt.Errorf("failed to delete %d notes: %w", len(noteDeleteIDs), err)
+		}
+		if err := txd.deleteBranches(branchDeleteIDs); err != nil {
+			return fmt.Errorf("failed to delete %d branches: %w", len(branchDeleteIDs), err)
+		}
+		if err := txd.deleteThreads(threadDeleteIDs); err != nil {
+			return fmt.Errorf("failed to delete %d threads: %w", len(threadDeleteIDs), err)
+		}
+
+		// 6.5. Drop deleted entities from the search index
+		if err := txd.unindexEntities(SearchKindNote, noteDeleteIDs); err != nil {
+			return err
+		}
+		if err := txd.unindexEntities(SearchKindBranch, branchDeleteIDs); err != nil {
+			return err
+		}
+		if err := txd.unindexThreads(threadDeleteIDs); err != nil {
+			return err
+		}
+
+		result.Created = len(created) + len(createdBranches) + len(createdNotes)
+		result.Updated = len(updated) + len(updatedBranches) + len(updatedNotes)
+		result.Deleted = len(noteDeleteIDs) + len(branchDeleteIDs) + len(threadDeleteIDs)
+
+		// 7. Read back what changed. This is part of the transaction: once committed, the edits must not be synced again.
+		return txd.reloadChanged(&result,
+			append(created, updated...),
+			append(createdBranches, updatedBranches...),
+			append(createdNotes, updatedNotes...))
+	})
+	if err != nil {
+		local.restore()
+		return SyncResult{}, err
 	}
+	return result, nil
+}
 
-	// 5. Update branches (e.g., adding/removing notes)
-	for _, branchID := range branchPendingIDs {
-		if branch, exists := branchesMap[branchID]; exists {
-			if err := d.persistBranch(branch, false); err != nil {
-				return nil, fmt.Errorf("failed to update branch %d: %w", branch.ID, err)
+// reloadChanged reads the written entities and the parents they touched into result.
+// Timestamps and hook defaults are set by the database side, so the local copies are not enough.
+func (d *DB) reloadChanged(result *SyncResult, threads []*models.Thread, branches []*models.Branch, notes []*models.Note) error {
+	threadIDs := make([]uint, 0, len(threads))
+	branchIDs := make([]uint, 0, len(branches))
+	noteIDs := make([]uint, 0, len(notes))
+	for _, t := range threads {
+		threadIDs = append(threadIDs, t.ID)
+	}
+	for _, b := range branches {
+		branchIDs = append(branchIDs, b.ID)
+		threadIDs = append(threadIDs, b.ThreadID)
+	}
+	for _, n := range notes {
+		noteIDs = append(noteIDs, n.ID)
+		threadIDs = append(threadIDs, n.ThreadID)
+		for _, b := range n.Branches {
+			if b != nil {
+				branchIDs = append(branchIDs, b.ID)
 			}
 		}
 	}
 
-	// 6. Delete in reverse order: Notes -> Branches -> Threads
-	if err := d.deleteNotes(noteDeleteIDs); err != nil {
-		return nil, err
+	if ids := uniqueIDs(threadIDs); len(ids) > 0 {
+		if err := d.Conn.Where("id IN ?", ids).Find(&result.Threads).Error; err != nil {
+			return fmt.Errorf("failed to reload threads: %w", err)
+		}
 	}
-
-	if err := d.deleteBranches(branchDeleteIDs); err != nil {
-		return nil, err
+	if ids := uniqueIDs(branchIDs); len(ids) > 0 {
+		if err := d.Conn.Where("id IN ?", ids).Find(&result.Branches).Error; err != nil {
+			return fmt.Errorf("failed to reload branches: %w", err)
+		}
 	}
-
-	if err := d.deleteThreads(threadDeleteIDs); err != nil {
-		return nil, err
+	if ids := uniqueIDs(noteIDs); len(ids) > 0 {
+		if err := d.Conn.Where("id IN ?", ids).Find(&result.Notes).Error; err != nil {
+			return fmt.Errorf("failed to reload notes: %w", err)
+		}
 	}
+	return nil
+}
 
-	// 7. Load fresh data from DB (with full preloading of hierarchy)
-	return d.loadAll()
+// createNotes inserts new notes and their branch associations.
+// The database assigns their IDs, which are recorded in remap.
+func (d *DB) createNotes(notes []*models.Note, remap map[uint]uint, local *localIDs) error {
+	if len(notes) == 0 {
+		return nil
+	}
+	tempIDs := make([]uint, len(notes))
+	for i, note := range notes {
+		tempIDs[i] = note.ID
+		local.set(&note.ID, 0)
+	}
+	// Omit to prevent auto-insert of the branches
+	if err := d.Conn.Omit("Branches").CreateInBatches(notes, syncBatchSize).Error; err != nil {
+		return err
+	}
+	for i, note := range notes {
+		remap[tempIDs[i]] = note.ID
+	}
+	return d.linkNotes(notes)
 }
 
-func (d *DB) persistNote(note *models.Note, isCreate bool) error {
-	if note == nil {
+// updateNotes writes changed notes with one upsert per batch, then replaces their branch associations.
+func (d *DB) updateNotes(notes []*models.Note) error {
+	if len(notes) == 0 {
 		return nil
 	}
-	// Topics removed: only persist note and its branch associations
-	var result *gorm.DB
-	if isCreate {
-		note.ID = 0
-		result = d.Conn.Omit("Branches").Create(note) // Omit to prevent auto-insert
-	} else {
-		result = d.Conn.Save(note)
+	now := time.Now()
+	for _, note := range notes {
+		note.UpdatedAt = now
+	}
+	err := d.Conn.Omit("Branches").
+		Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "id"}}, UpdateAll: true}).
+		CreateInBatches(notes, syncBatchSize).Error
+	if err != nil {
+		return err
+	}
+
+	ids := make([]uint, len(notes))
+	threadIDs := make([]uint, len(notes))
+	for i, note := range notes {
+		ids[i] = note.ID
+		threadIDs[i] = note.ThreadID
+	}
+	// the rows of the branches a note stays in keep their position
+	for _, note := range notes {
+		keep := make([]uint, 0, len(note.Branches))
+		for _, branch := range note.Branches {
+			if branch != nil {
+				keep = append(keep, branch.ID)
+			}
+		}
+		q := d.Conn.Where("note_id = ?", note.ID)
+		if len(keep) > 0 {
+			q = q.Where("branch_id NOT IN ?", keep)
+		}
+		if err := q.Delete(&branchNote{}).Error; err != nil {
+			return err
+		}
 	}
-	if result.Error != nil {
-		return result.Error
+	if err := d.linkNotes(notes); err != nil {
+		return err
 	}
 
-	// Handle branch associations
-	return d.Conn.Model(note).Association("Branches").Replace(note.Branches)
+	// an upsert skips the AfterUpdate hook of Note, so touch the threads and branches here
+	if err := d.Conn.Model(&models.Thread{}).Where("id IN ?", uniqueIDs(threadIDs)).
+		Update("updated_at", now).Error; err != nil {
+		return err
+	}
+	sub := d.Conn.Model(&branchNote{}).Select("branch_id").Where("note_id IN ?", ids)
+	return d.Conn.Model(&models.Branch{}).Where("id IN (?)", sub).Update("updated_at", now).Error
 }
 
-func (d *DB) deleteNotes(ids []uint) error {
-	for _, id := range ids {
-		if err := d.Conn.Delete(&models.Note{}, id).Error; err != nil {
+// linkNotes inserts the branch_notes rows of notes. Existing rows are kept.
+func (d *DB) linkNotes(notes []*models.Note) error {
+	rows := make([]branchNote, 0, len(notes))
+	for _, note := range notes {
+		for _, branch := range note.Branches {
+			if branch != nil {
+				rows = append(rows, branchNote{BranchID: branch.ID, NoteID: note.ID})
+			}
+		}
+	}
+	return d.insertBranchNotes(rows)
+}
+
+// insertBranchNotes adds rows to branch_notes, at the end of their branches in the order given. Rows that exist keep their position.
+func (d *DB) insertBranchNotes(rows []branchNote) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	branchIDs := make([]uint, len(rows))
+	for i, row := range rows {
+		branchIDs[i] = row.BranchID
+	}
+	next := make(map[uint]int)
+	for chunk := range slices.Chunk(uniqueIDs(branchIDs), loadChunkSize) {
+		var last []struct {
+			BranchID uint
+			Position int
+		}
+		err := d.Conn.Model(&branchNote{}).
+			Select("branch_id, MAX(position) AS position").
+			Where("branch_id IN ?", chunk).
+			Group("branch_id").
+			Scan(&last).Error
+		if err != nil {
 			return err
 		}
+		for _, l := range last {
+			next[l.BranchID] = l.Position + 1
+		}
+	}
+	for i := range rows {
+		rows[i].Position = next[rows[i].BranchID]
+		next[rows[i].BranchID]++
+	}
+	return d.Conn.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(rows, syncBatchSize).Error
+}
+
+// orderNotes writes the order of the notes of branches, as listed in Branch.Notes, which must hold all of them.
+func (d *DB) orderNotes(branches []*models.Branch) error {
+	for _, branch := range branches {
+		for i, note := range branch.Notes {
+			if note == nil {
+				continue
+			}
+			err := d.Conn.Model(&branchNote{}).
+				Where("branch_id = ? AND note_id = ?", branch.ID, note.ID).
+				Update("position", i).Error
+			if err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
 
+func (d *DB) deleteNotes(ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return d.Conn.Delete(&models.Note{}, ids).Error
+}
+
 func sanitizeNote(note *models.Note) {
 	if note == nil {
 		return
@@ -199,120 +550,235 @@ func sanitizeNote(note *models.Note) {
 	note.Content = strings.TrimSpace(note.Content)
 }
 
-// func collectNotes(notesMap map[uint]*models.Note, ids []uint) []*models.Note {
-// 	if len(ids) == 0 || notesMap == nil {
-// 		return nil
-// 	}
-// 	notes := make([]*models.Note, 0, len(ids))
-// 	for _, id := range ids {
-// 		if note, exists := notesMap[id]; exists && note != nil {
-// 			notes = append(notes, note)
-// 		}
-// 	}
-// 	return notes
-// }
-
-func (d *DB) persistThread(thread *models.Thread, isCreate bool) error {
-	if thread == nil {
+func collectNotes(notesMap map[uint]*models.Note, ids []uint) []*models.Note {
+	if len(ids) == 0 || notesMap == nil {
 		return nil
 	}
+	notes := make([]*models.Note, 0, len(ids))
+	for _, id := range ids {
+		if note, exists := notesMap[id]; exists && note != nil {
+			notes = append(notes, note)
+		}
+	}
+	return notes
+}
 
-	var result *gorm.DB
-	if isCreate {
-		// When creating a thread, OMIT branches to prevent auto-insert
-		// Branches are created separately via their own CreateBranch edits
-		result = d.Conn.Omit("Branches").Create(thread)
-	} else {
-		result = d.Conn.Save(thread)
-		if result.Error != nil {
-			return result.Error
+// createThreads inserts new threads. The database assigns their IDs, which are recorded in remap.
+func (d *DB) createThreads(threads []*models.Thread, remap map[uint]uint, local *localIDs) error {
+	if len(threads) == 0 {
+		return nil
+	}
+	tempIDs := make([]uint, len(threads))
+	for i, thread := range threads {
+		tempIDs[i] = thread.ID
+		local.set(&thread.ID, 0)
+	}
+	// When creating a thread, OMIT branches to prevent auto-insert
+	// Branches are created separately via their own CreateBranch edits
+	if err := d.Conn.Omit("Branches").CreateInBatches(threads, syncBatchSize).Error; err != nil {
+		return err
+	}
+	for i, thread := range threads {
+		remap[tempIDs[i]] = thread.ID
+	}
+	return nil
+}
+
+// updateThreads writes changed threads with one upsert per batch, then points the branches they list at them.
+// Branches no other thread lists keep their thread, so deleted branches can still find it.
+func (d *DB) updateThreads(threads []*models.Thread) error {
+	if len(threads) == 0 {
+		return nil
+	}
+	now := time.Now()
+	for _, thread := range threads {
+		thread.UpdatedAt = now
+	}
+	err := d.Conn.Omit("Branches").
+		Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "id"}}, UpdateAll: true}).
+		CreateInBatches(threads, syncBatchSize).Error
+	if err != nil {
+		return err
+	}
+
+	for _, thread := range threads {
+		ids := make([]uint, 0, len(thread.Branches))
+		for _, branch := range thread.Branches {
+			if branch != nil {
+				ids = append(ids, branch.ID)
+			}
+		}
+		if len(ids) == 0 {
+			continue
+		}
+		if err := d.Conn.Model(&models.Branch{}).Where("id IN ?", ids).
+			Update("thread_id", thread.ID).Error; err != nil {
+			return err
 		}
-		// Only replace branch associations when updating
-		return d.Conn.Model(thread).Association("Branches").Replace(thread.Branches)
 	}
+	return nil
+}
 
-	return result.Error
+// keepStored drops the entities whose rows are gone, such as ones purged from the trash after they were loaded,
+// which the upserts would insert again. Rows in the trash are kept: writing them again restores them.
+func keepStored[T any](d *DB, model any, entities []*T, id func(*T) uint) ([]*T, error) {
+	if len(entities) == 0 {
+		return entities, nil
+	}
+	ids := make([]uint, len(entities))
+	for i, e := range entities {
+		ids[i] = id(e)
+	}
+	stored := make(map[uint]bool, len(ids))
+	for chunk := range slices.Chunk(ids, loadChunkSize) {
+		var found []uint
+		if err := d.Conn.Unscoped().Model(model).Where("id IN ?", chunk).Pluck("id", &found).Error; err != nil {
+			return nil, err
+		}
+		for _, id := range found {
+			stored[id] = true
+		}
+	}
+	kept := make([]*T, 0, len(entities))
+	for _, e := range entities {
+		if stored[id(e)] {
+			kept = append(kept, e)
+		}
+	}
+	return kept, nil
 }
 
-// func collectThreads(threadsMap map[uint]*models.Thread, ids []uint) []*models.Thread {
-// 	if len(ids) == 0 || threadsMap == nil {
-// 		return nil
-// 	}
-// 	threads := make([]*models.Thread, 0, len(ids))
-// 	for _, id := range ids {
-// 		if t, exists := threadsMap[id]; exists && t != nil {
-// 			threads = append(threads, t)
-// 		}
-// 	}
-// 	return threads
-// }
+func collectThreads(threadsMap map[uint]*models.Thread, ids []uint) []*models.Thread {
+	if len(ids) == 0 || threadsMap == nil {
+		return nil
+	}
+	threads := make([]*models.Thread, 0, len(ids))
+	for _, id := range ids {
+		if t, exists := threadsMap[id]; exists && t != nil {
+			threads = append(threads, t)
+		}
+	}
+	return threads
+}
 
 func (d *DB) deleteThreads(ids []uint) error {
-	for _, id := range ids {
-		// Cascading delete will handle branches due to foreign key
-		// This will automatically delete the related branches.
-		if err := d.Conn.Delete(&models.Thread{}, id).Error; err != nil {
+	if len(ids) == 0 {
+		return nil
+	}
+	// Cascading delete will handle branches due to foreign key
+	// This will automatically delete the related branches.
+	return d.Conn.Delete(&models.Thread{}, ids).Error
+}
+
+// createBranches inserts new branches. The database assigns their IDs, which are recorded in remap.
+func (d *DB) createBranches(branches []*models.Branch, remap map[uint]uint, local *localIDs) error {
+	if len(branches) == 0 {
+		return nil
+	}
+	tempIDs := make([]uint, len(branches))
+	for i, branch := range branches {
+		tempIDs[i] = branch.ID
+		local.set(&branch.ID, 0)
+	}
+	// When creating a branch, OMIT notes to prevent auto-insert
+	// Notes are created separately via their own CreateNote edits
+	if err := d.Conn.Omit("Notes").CreateInBatches(branches, syncBatchSize).Error; err != nil {
+		return err
+	}
+	for i, branch := range branches {
+		remap[tempIDs[i]] = branch.ID
+	}
+	// a fork lists the stored notes it shares from the start; new notes are linked when they are created
+	rows := make([]branchNote, 0)
+	for _, branch := range branches {
+		for _, note := range branch.Notes {
+			if note != nil && !models.IsTempID(note.ID) {
+				rows = append(rows, branchNote{BranchID: branch.ID, NoteID: note.ID})
+			}
+		}
+	}
+	return d.insertBranchNotes(rows)
+}
+
+// resolveForks points forks that were made from new branches or notes at the IDs the database assigned them.
+func (d *DB) resolveForks(branches []*models.Branch, remap IDRemap, local *localIDs) error {
+	for _, branch := range branches {
+		branchID, forkedNewBranch := remap.Branches[branch.ForkBranchID]
+		noteID, forkedNewNote := remap.Notes[branch.ForkNoteID]
+		if !forkedNewBranch && !forkedNewNote {
+			continue
+		}
+		if forkedNewBranch {
+			local.set(&branch.ForkBranchID, branchID)
+		}
+		if forkedNewNote {
+			local.set(&branch.ForkNoteID, noteID)
+		}
+		err := d.Conn.Model(&models.Branch{}).Where("id = ?", branch.ID).UpdateColumns(map[string]any{
+			"fork_branch_id": branch.ForkBranchID,
+			"fork_note_id":   branch.ForkNoteID,
+		}).Error
+		if err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (d *DB) persistBranch(branch *models.Branch, isCreate bool) error {
-	if branch == nil {
+// updateBranches writes changed branches with one upsert per batch, then adds the notes they list.
+// A branch may only have some of its notes loaded, so rows are never removed here; notes leave a branch through their own Branches.
+func (d *DB) updateBranches(branches []*models.Branch) error {
+	if len(branches) == 0 {
 		return nil
 	}
+	now := time.Now()
+	for _, branch := range branches {
+		branch.UpdatedAt = now
+	}
+	err := d.Conn.Omit("Notes").
+		Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "id"}}, UpdateAll: true}).
+		CreateInBatches(branches, syncBatchSize).Error
+	if err != nil {
+		return err
+	}
 
-	var result *gorm.DB
-	if isCreate {
-		// When creating a branch, OMIT notes to prevent auto-insert
-		// Notes are created separately via their own CreateNote edits
-		result = d.Conn.Omit("Notes").Create(branch)
-	} else {
-		result = d.Conn.Save(branch)
-		if result.Error != nil {
-			return result.Error
+	threadIDs := make([]uint, len(branches))
+	rows := make([]branchNote, 0)
+	for i, branch := range branches {
+		threadIDs[i] = branch.ThreadID
+		for _, note := range branch.Notes {
+			if note != nil {
+				rows = append(rows, branchNote{BranchID: branch.ID, NoteID: note.ID})
+			}
 		}
-		// Only replace note associations when updating
-		return d.Conn.Model(branch).Association("Notes").Replace(branch.Notes)
+	}
+	if err := d.insertBranchNotes(rows); err != nil {
+		return err
 	}
 
-	return result.Error
+	// an upsert skips the AfterUpdate hook of Branch, so touch the threads here
+	return d.Conn.Model(&models.Thread{}).Where("id IN ?", uniqueIDs(threadIDs)).
+		Update("updated_at", now).Error
 }
 
 func (d *DB) deleteBranches(ids []uint) error {
-	for _, id := range ids {
-		if err := d.Conn.Delete(&models.Branch{}, id).Error; err != nil {
-			return err
-		}
+	if len(ids) == 0 {
+		return nil
 	}
-	return nil
+	return d.Conn.Delete(&models.Branch{}, ids).Error
 }
 
-// func collectBranches(branchesMap map[uint]*models.Branch, ids []uint) []*models.Branch {
-// 	if len(ids) == 0 || branchesMap == nil {
-// 		return nil
-// 	}
-// 	branches := make([]*models.Branch, 0, len(ids))
-// 	for _, id := range ids {
-// 		if branch, exists := branchesMap[id]; exists && branch != nil {
-// 			branches = append(branches, branch)
-// 		}
-// 	}
-// 	return branches
-// }
-
-func (d *DB) loadAll() ([]*models.Thread, error) {
-	var dbThreads []*models.Thread
-	// preload with threads and branches/notes
-	if err := d.Conn.
-		Preload("Branches.Notes.Branches").
-		Order("created_at ASC").
-		Find(&dbThreads).Error; err != nil {
-		return nil, err
-	}
-
-	return dbThreads, nil
+func collectBranches(branchesMap map[uint]*models.Branch, ids []uint) []*models.Branch {
+	if len(ids) == 0 || branchesMap == nil {
+		return nil
+	}
+	branches := make([]*models.Branch, 0, len(ids))
+	for _, id := range ids {
+		if branch, exists := branchesMap[id]; exists && branch != nil {
+			branches = append(branches, branch)
+		}
+	}
+	return branches
 }
 
 func uniqueIDs(ids []uint) []uint {
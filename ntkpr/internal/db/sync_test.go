@@ -0,0 +1,308 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	editstack "github.com/haochend413/ntkpr/internal/app/editStack"
+	"github.com/haochend413/ntkpr/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// localJournal is a thread with one branch of n notes created locally, with temporary IDs, and the edits that create them.
+func localJournal(n int) (*models.Thread, map[editstack.EditKey]*editstack.Edit) {
+	thread := &models.Thread{Name: "thread", LastEdit: time.Now()}
+	thread.ID = models.TempIDStart
+	branch := &models.Branch{Name: "branch", ThreadID: thread.ID, LastEdit: time.Now()}
+	branch.ID = models.TempIDStart
+	thread.Branches = []*models.Branch{branch}
+
+	edits := map[editstack.EditKey]*editstack.Edit{
+		{EntityType: editstack.EntityThread, ID: thread.ID}: {ID: thread.ID, EditType: editstack.CreateThread},
+		{EntityType: editstack.EntityBranch, ID: branch.ID}: {ID: branch.ID, EditType: editstack.CreateBranch},
+	}
+	for i := range n {
+		note := &models.Note{Content: fmt.Sprintf("note %d", i), ThreadID: thread.ID, LastEdit: time.Now()}
+		note.ID = models.TempIDStart + uint(i)
+		note.Branches = []*models.Branch{branch}
+		branch.Notes = append(branch.Notes, note)
+		edits[editstack.EditKey{EntityType: editstack.EntityNote, ID: note.ID}] = &editstack.Edit{ID: note.ID, EditType: editstack.CreateNote}
+	}
+	return thread, edits
+}
+
+// noteEdits are edits of one type for every note of thread.
+func noteEdits(thread *models.Thread, editType editstack.EditType) map[editstack.EditKey]*editstack.Edit {
+	edits := make(map[editstack.EditKey]*editstack.Edit)
+	for _, note := range thread.Branches[0].Notes {
+		edits[editstack.EditKey{EntityType: editstack.EntityNote, ID: note.ID}] = &editstack.Edit{ID: note.ID, EditType: editType}
+	}
+	return edits
+}
+
+func count(t testing.TB, d *DB, model any) int64 {
+	t.Helper()
+	var n int64
+	if err := d.Conn.Unscoped().Model(model).Count(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestSyncDataRollsBack(t *testing.T) {
+	d := testDB(t)
+	thread, edits := localJournal(3)
+	branch := thread.Branches[0]
+
+	// the notes fail to insert, after the thread and branch are written
+	boom := errors.New("boom")
+	err := d.Conn.Callback().Create().Before("gorm:create").Register("test:fail", func(tx *gorm.DB) {
+		if tx.Statement.Table == "notes" {
+			tx.AddError(boom)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.SyncData([]*models.Thread{thread}, edits); !errors.Is(err, boom) {
+		t.Fatalf("got %v, want the failure of the notes", err)
+	}
+
+	for _, model := range []any{&models.Thread{}, &models.Branch{}, &models.Note{}, &branchNote{}} {
+		if n := count(t, d, model); n != 0 {
+			t.Errorf("%T: %d rows left after the rollback", model, n)
+		}
+	}
+	if !models.IsTempID(thread.ID) || !models.IsTempID(branch.ID) || !models.IsTempID(branch.ThreadID) {
+		t.Errorf("thread %d, branch %d of thread %d, want their temporary IDs back", thread.ID, branch.ID, branch.ThreadID)
+	}
+	for _, note := range branch.Notes {
+		if !models.IsTempID(note.ID) || note.ThreadID != thread.ID {
+			t.Errorf("note %d of thread %d, want its temporary ID in thread %d", note.ID, note.ThreadID, thread.ID)
+		}
+	}
+
+	// the same edits go through once the failure is gone
+	if err := d.Conn.Callback().Create().Remove("test:fail"); err != nil {
+		t.Fatal(err)
+	}
+	result, err := d.SyncData([]*models.Thread{thread}, edits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Created != 5 || count(t, d, &branchNote{}) != 3 {
+		t.Errorf("created %d entities and %d links, want 5 and 3", result.Created, count(t, d, &branchNote{}))
+	}
+	for _, note := range branch.Notes {
+		if models.IsTempID(note.ID) || note.ThreadID != thread.ID {
+			t.Errorf("note %d of thread %d, want a stored ID in thread %d", note.ID, note.ThreadID, thread.ID)
+		}
+	}
+}
+
+// Notes purged while they are loaded, and edited, are not written back by the upsert.
+func TestSyncDataSkipsPurged(t *testing.T) {
+	d := testDB(t)
+	thread, edits := localJournal(2)
+	if _, err := d.SyncData([]*models.Thread{thread}, edits); err != nil {
+		t.Fatal(err)
+	}
+	purged := thread.Branches[0].Notes[0]
+	exec(t, d, fmt.Sprintf(`UPDATE notes SET deleted_at = datetime('now', '-1 day') WHERE id = %d`, purged.ID))
+	if _, err := d.PurgeTrash(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, note := range thread.Branches[0].Notes {
+		note.Content += " edited"
+	}
+	result, err := d.SyncData([]*models.Thread{thread}, noteEdits(thread, editstack.UpdateNote))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Updated != 1 {
+		t.Errorf("updated %d notes, want the one still stored", result.Updated)
+	}
+	if n := count(t, d, &models.Note{}); n != 1 {
+		t.Errorf("%d notes stored, want 1", n)
+	}
+	var links, revisions int64
+	d.Conn.Model(&branchNote{}).Where("note_id = ?", purged.ID).Count(&links)
+	d.Conn.Model(&models.NoteRevision{}).Where("note_id = ?", purged.ID).Count(&revisions)
+	if links != 0 || revisions != 0 {
+		t.Errorf("purged note has %d links and %d revisions again", links, revisions)
+	}
+}
+
+// syncPerRow writes edits one row at a time outside of a transaction, like SyncData did before batching.
+// It is only kept to compare with.
+func syncPerRow(d *DB, thread *models.Thread, edits map[editstack.EditKey]*editstack.Edit) error {
+	for key, edit := range edits {
+		if key.EntityType != editstack.EntityNote {
+			continue
+		}
+		note := findNote(thread, key.ID)
+		switch edit.EditType {
+		case editstack.CreateNote:
+			note.ID = 0
+			if err := d.Conn.Omit("Branches").Create(note).Error; err != nil {
+				return err
+			}
+			for _, b := range note.Branches {
+				if err := d.Conn.Create(&branchNote{BranchID: b.ID, NoteID: note.ID}).Error; err != nil {
+					return err
+				}
+			}
+		case editstack.UpdateNote:
+			if err := d.Conn.Omit("Branches").Save(note).Error; err != nil {
+				return err
+			}
+		}
+		if err := d.Conn.Create(&models.NoteRevision{NoteID: note.ID, Content: note.Content}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func findNote(thread *models.Thread, id uint) *models.Note {
+	for _, note := range thread.Branches[0].Notes {
+		if note.ID == id {
+			return note
+		}
+	}
+	return nil
+}
+
+// BenchmarkSyncData writes 1000 new notes, then 1000 edited ones, batched in a transaction and one row at a time.
+func BenchmarkSyncData(b *testing.B) {
+	const n = 1000
+	syncs := []struct {
+		name string
+		sync func(d *DB, thread *models.Thread, edits map[editstack.EditKey]*editstack.Edit) error
+	}{
+		{"batched", func(d *DB, thread *models.Thread, edits map[editstack.EditKey]*editstack.Edit) error {
+			_, err := d.SyncData([]*models.Thread{thread}, edits)
+			return err
+		}},
+		{"per-row", syncPerRow},
+	}
+	for _, s := range syncs {
+		// a stored thread and branch to add the notes to
+		setup := func(b *testing.B) (*DB, *models.Thread) {
+			d, err := NewDB(filepath.Join(b.TempDir(), "ntkpr.db"))
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.Cleanup(func() { d.Close() })
+			d.Conn.Logger = logger.Discard
+			thread, edits := localJournal(0)
+			if _, err := d.SyncData([]*models.Thread{thread}, edits); err != nil {
+				b.Fatal(err)
+			}
+			return d, thread
+		}
+		addNotes := func(thread *models.Thread) map[editstack.EditKey]*editstack.Edit {
+			branch := thread.Branches[0]
+			branch.Notes = branch.Notes[:0]
+			for i := range n {
+				note := &models.Note{Content: fmt.Sprintf("note %d", i), ThreadID: thread.ID, LastEdit: time.Now()}
+				note.ID = models.TempIDStart + uint(i)
+				note.Branches = []*models.Branch{branch}
+				branch.Notes = append(branch.Notes, note)
+			}
+			return noteEdits(thread, editstack.CreateNote)
+		}
+
+		b.Run(s.name+"/create", func(b *testing.B) {
+			d, thread := setup(b)
+			for b.Loop() {
+				if err := s.sync(d, thread, addNotes(thread)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(s.name+"/update", func(b *testing.B) {
+			d, thread := setup(b)
+			if err := s.sync(d, thread, addNotes(thread)); err != nil {
+				b.Fatal(err)
+			}
+			edits := noteEdits(thread, editstack.UpdateNote)
+			for b.Loop() {
+				for _, note := range thread.Branches[0].Notes {
+					note.Content += "."
+				}
+				if err := s.sync(d, thread, edits); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// positions lists the notes of a branch in the order of branch_notes.position.
+func positions(t *testing.T, d *DB, branchID uint) []uint {
+	t.Helper()
+	var ids []uint
+	if err := d.Conn.Model(&branchNote{}).Where("branch_id = ?", branchID).Order("position").Pluck("note_id", &ids).Error; err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+// A note listed by several branches has a position in each, and reordering one branch leaves the others as they are.
+func TestSyncDataOrdersNotesPerBranch(t *testing.T) {
+	d := testDB(t)
+	thread, edits := localJournal(3)
+	if _, err := d.SyncData([]*models.Thread{thread}, edits); err != nil {
+		t.Fatal(err)
+	}
+	first := thread.Branches[0]
+	n1, n2, n3 := first.Notes[0], first.Notes[1], first.Notes[2]
+
+	// a new branch lists notes 3 and 1, in this order
+	second := &models.Branch{Name: "second", ThreadID: thread.ID, LastEdit: time.Now()}
+	second.ID = models.TempIDStart
+	second.Notes = []*models.Note{n3, n1}
+	thread.Branches = append(thread.Branches, second)
+	edits = map[editstack.EditKey]*editstack.Edit{
+		{EntityType: editstack.EntityBranch, ID: second.ID}: {ID: second.ID, EditType: editstack.CreateBranch},
+	}
+	for _, n := range second.Notes {
+		n.Branches = append(n.Branches, second)
+		edits[editstack.EditKey{EntityType: editstack.EntityNote, ID: n.ID}] = &editstack.Edit{ID: n.ID, EditType: editstack.LinkNote}
+	}
+	if _, err := d.SyncData([]*models.Thread{thread}, edits); err != nil {
+		t.Fatal(err)
+	}
+
+	reorder := func(branch *models.Branch, notes ...*models.Note) {
+		t.Helper()
+		branch.Notes = notes
+		edits := map[editstack.EditKey]*editstack.Edit{
+			{EntityType: editstack.EntityBranch, ID: branch.ID}: {ID: branch.ID, EditType: editstack.ReorderNotes},
+		}
+		if _, err := d.SyncData([]*models.Thread{thread}, edits); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(when string, want1, want2 []uint) {
+		t.Helper()
+		if got := positions(t, d, first.ID); !slices.Equal(got, want1) {
+			t.Errorf("%s, branch 1 lists %v, want %v", when, got, want1)
+		}
+		if got := positions(t, d, second.ID); !slices.Equal(got, want2) {
+			t.Errorf("%s, branch 2 lists %v, want %v", when, got, want2)
+		}
+	}
+	check("created", []uint{1, 2, 3}, []uint{3, 1})
+	reorder(first, n3, n1, n2)
+	check("branch 1 reordered", []uint{3, 1, 2}, []uint{3, 1})
+	reorder(second, n1, n3)
+	check("branch 2 reordered", []uint{3, 1, 2}, []uint{1, 3})
+}
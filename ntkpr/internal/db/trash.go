@@ -0,0 +1,253 @@
+package db
+
+// The trash is made of the threads, branches and notes SyncData deleted: deletes are soft, they set deleted_at and keep the row.
+// A trashed entity is restored by writing it again, which clears deleted_at, see App.RestoreFromTrash.
+// Purging deletes the rows for good, together with what only they hold.
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/haochend413/ntkpr/internal/models"
+	"gorm.io/gorm"
+)
+
+// ErrNotInTrash is returned when purging an entity that is not deleted.
+var ErrNotInTrash = errors.New("not in the trash")
+
+// TrashItem is a deleted thread, branch or note.
+type TrashItem struct {
+	Kind      string // SearchKindThread, SearchKindBranch or SearchKindNote
+	ID        uint
+	ThreadID  uint   // the thread of a branch or note, the ID of a thread
+	Title     string // the name of a thread or branch, the content of a note
+	DeletedAt time.Time
+}
+
+// LoadTrash returns the deleted threads, branches and notes, the last deleted first.
+func (d *DB) LoadTrash() ([]TrashItem, error) {
+	var threads []models.Thread
+	if err := d.Conn.Unscoped().Where("deleted_at IS NOT NULL").Find(&threads).Error; err != nil {
+		return nil, fmt.Errorf("load deleted threads: %w", err)
+	}
+	var branches []models.Branch
+	if err := d.Conn.Unscoped().Where("deleted_at IS NOT NULL").Find(&branches).Error; err != nil {
+		return nil, fmt.Errorf("load deleted branches: %w", err)
+	}
+	var notes []models.Note
+	if err := d.Conn.Unscoped().Where("deleted_at IS NOT NULL").Find(&notes).Error; err != nil {
+		return nil, fmt.Errorf("load deleted notes: %w", err)
+	}
+
+	items := make([]TrashItem, 0, len(threads)+len(branches)+len(notes))
+	for _, t := range threads {
+		items = append(items, TrashItem{SearchKindThread, t.ID, t.ID, t.Name, t.DeletedAt.Time})
+	}
+	for _, b := range branches {
+		items = append(items, TrashItem{SearchKindBranch, b.ID, b.ThreadID, b.Name, b.DeletedAt.Time})
+	}
+	for _, n := range notes {
+		items = append(items, TrashItem{SearchKindNote, n.ID, n.ThreadID, n.Content, n.DeletedAt.Time})
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].DeletedAt.After(items[j].DeletedAt)
+	})
+	return items, nil
+}
+
+// LoadTrashedThread loads a thread by ID, deleted or not, without its branches.
+func (d *DB) LoadTrashedThread(id uint) (*models.Thread, error) {
+	var thread models.Thread
+	if err := d.Conn.Unscoped().First(&thread, id).Error; err != nil {
+		return nil, fmt.Errorf("load thread %d: %w", id, err)
+	}
+	return &thread, nil
+}
+
+// LoadTrashedBranch loads a branch by ID, deleted or not, without its notes.
+func (d *DB) LoadTrashedBranch(id uint) (*models.Branch, error) {
+	var branch models.Branch
+	if err := d.Conn.Unscoped().First(&branch, id).Error; err != nil {
+		return nil, fmt.Errorf("load branch %d: %w", id, err)
+	}
+	return &branch, nil
+}
+
+// LoadTrashedNote loads a note by ID, deleted or not, with the branches that list it, deleted or not.
+func (d *DB) LoadTrashedNote(id uint) (*models.Note, error) {
+	var note models.Note
+	err := d.Conn.Unscoped().
+		Preload("Branches", func(tx *gorm.DB) *gorm.DB { return tx.Unscoped().Order("id ASC") }).
+		First(&note, id).Error
+	if err != nil {
+		return nil, fmt.Errorf("load note %d: %w", id, err)
+	}
+	return &note, nil
+}
+
+// PurgeTrashItem deletes a thread, branch or note in the trash for good, see purgeThreads, purgeBranches and purgeNotes.
+func (d *DB) PurgeTrashItem(kind string, id uint) error {
+	return d.Conn.Transaction(func(tx *gorm.DB) error {
+		txd := &DB{Conn: tx, searchEnabled: d.searchEnabled}
+		var model any
+		var purge func([]uint) error
+		switch kind {
+		case SearchKindThread:
+			model, purge = &models.Thread{}, txd.purgeThreads
+		case SearchKindBranch:
+			model, purge = &models.Branch{}, txd.purgeBranches
+		case SearchKindNote:
+			model, purge = &models.Note{}, txd.purgeNotes
+		default:
+			return fmt.Errorf("unknown kind %q", kind)
+		}
+		var count int64
+		if err := tx.Unscoped().Model(model).Where("id = ? AND deleted_at IS NOT NULL", id).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return fmt.Errorf("%s %d: %w", kind, id, ErrNotInTrash)
+		}
+		if err := purge([]uint{id}); err != nil {
+			return fmt.Errorf("purge %s %d: %w", kind, id, err)
+		}
+		return nil
+	})
+}
+
+// PurgeTrash deletes everything that was deleted before cutoff for good, and returns how many trash items that was.
+func (d *DB) PurgeTrash(cutoff time.Time) (int, error) {
+	purged := 0
+	err := d.Conn.Transaction(func(tx *gorm.DB) error {
+		txd := &DB{Conn: tx, searchEnabled: d.searchEnabled}
+		steps := []struct {
+			kind  string
+			model any
+			purge func([]uint) error
+		}{
+			{SearchKindThread, &models.Thread{}, txd.purgeThreads},
+			{SearchKindBranch, &models.Branch{}, txd.purgeBranches},
+			{SearchKindNote, &models.Note{}, txd.purgeNotes},
+		}
+		for _, step := range steps {
+			var ids []uint
+			err := tx.Unscoped().Model(step.model).
+				Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).
+				Pluck("id", &ids).Error
+			if err != nil {
+				return err
+			}
+			if err := step.purge(ids); err != nil {
+				return fmt.Errorf("purge %d %ss: %w", len(ids), step.kind, err)
+			}
+			purged += len(ids)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return purged, nil
+}
+
+// purgeThreads deletes threads for good, with all their branches and notes.
+func (d *DB) purgeThreads(ids []uint) error {
+	for chunk := range slices.Chunk(ids, loadChunkSize) {
+		var branchIDs, noteIDs []uint
+		if err := d.Conn.Unscoped().Model(&models.Branch{}).Where("thread_id IN ?", chunk).Pluck("id", &branchIDs).Error; err != nil {
+			return err
+		}
+		if err := d.purgeBranches(branchIDs); err != nil {
+			return err
+		}
+		// notes left in no branch
+		if err := d.Conn.Unscoped().Model(&models.Note{}).Where("thread_id IN ?", chunk).Pluck("id", &noteIDs).Error; err != nil {
+			return err
+		}
+		if err := d.purgeNotes(noteIDs); err != nil {
+			return err
+		}
+		if err := d.unindexThreads(chunk); err != nil {
+			return err
+		}
+		if err := d.Conn.Unscoped().Delete(&models.Thread{}, chunk).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// purgeBranches deletes branches for good, with the trashed notes no other branch lists.
+// Live notes no other branch lists go to the trash instead, where restoring puts them in a branch of their thread.
+func (d *DB) purgeBranches(ids []uint) error {
+	for chunk := range slices.Chunk(ids, loadChunkSize) {
+		var trashed, live []uint
+		only := d.Conn.Unscoped().Model(&models.Note{}).
+			Where("id IN (SELECT note_id FROM branch_notes WHERE branch_id IN ?)", chunk).
+			Where("id NOT IN (SELECT note_id FROM branch_notes WHERE branch_id NOT IN ?)", chunk)
+		if err := only.Session(&gorm.Session{}).Where("deleted_at IS NOT NULL").Pluck("id", &trashed).Error; err != nil {
+			return err
+		}
+		if err := only.Session(&gorm.Session{}).Where("deleted_at IS NULL").Pluck("id", &live).Error; err != nil {
+			return err
+		}
+		if err := d.purgeNotes(trashed); err != nil {
+			return err
+		}
+		if len(live) > 0 {
+			if err := d.Conn.Delete(&models.Note{}, live).Error; err != nil {
+				return err
+			}
+			if err := d.unindexEntities(SearchKindNote, live); err != nil {
+				return err
+			}
+		}
+		if err := d.Conn.Where("branch_id IN ?", chunk).Delete(&branchNote{}).Error; err != nil {
+			return err
+		}
+		if err := d.unindexEntities(SearchKindBranch, chunk); err != nil {
+			return err
+		}
+		if err := d.Conn.Unscoped().Delete(&models.Branch{}, chunk).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// purgeNotes deletes notes for good, with their revisions.
+func (d *DB) purgeNotes(ids []uint) error {
+	for chunk := range slices.Chunk(ids, loadChunkSize) {
+		if err := d.Conn.Where("note_id IN ?", chunk).Delete(&branchNote{}).Error; err != nil {
+			return err
+		}
+		if err := d.Conn.Where("note_id IN ?", chunk).Delete(&models.NoteRevision{}).Error; err != nil {
+			return err
+		}
+		if err := d.unindexEntities(SearchKindNote, chunk); err != nil {
+			return err
+		}
+		if err := d.Conn.Unscoped().Delete(&models.Note{}, chunk).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// trashedThreads returns the threads among ids that are deleted.
+func (d *DB) trashedThreads(ids []uint) ([]uint, error) {
+	var trashed []uint
+	for chunk := range slices.Chunk(ids, loadChunkSize) {
+		var part []uint
+		err := d.Conn.Unscoped().Model(&models.Thread{}).
+			Where("id IN ? AND deleted_at IS NOT NULL", chunk).
+			Pluck("id", &part).Error
+		if err != nil {
+			return nil, err
+		}
+		trashed = append(trashed, part...)
+	}
+	return trashed, nil
+}
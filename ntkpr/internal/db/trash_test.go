@@ -0,0 +1,95 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/haochend413/ntkpr/internal/models"
+	"gorm.io/gorm/logger"
+)
+
+// testDB opens an empty journal in a temporary directory.
+func testDB(t *testing.T) *DB {
+	t.Helper()
+	d, err := NewDB(filepath.Join(t.TempDir(), "ntkpr.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	d.Conn.Logger = logger.Discard
+	return d
+}
+
+// exec runs statements, failing the test on the first error.
+func exec(t *testing.T, d *DB, stmts ...string) {
+	t.Helper()
+	for _, stmt := range stmts {
+		if err := d.Conn.Exec(stmt).Error; err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+}
+
+func TestPurgeBranchKeepsLiveNotes(t *testing.T) {
+	d := testDB(t)
+	// branch 1 is in the trash, branch 2 is live. Note 1 is trashed and in branch 1 only,
+	// note 2 is live and in branch 1 only, note 3 is live and in both branches.
+	exec(t, d,
+		`INSERT INTO threads (id, created_at, updated_at, name) VALUES (1, datetime('now'), datetime('now'), 't')`,
+		`INSERT INTO branches (id, created_at, updated_at, deleted_at, thread_id, name) VALUES
+			(1, datetime('now'), datetime('now'), datetime('now', '-1 day'), 1, 'old'),
+			(2, datetime('now'), datetime('now'), NULL, 1, 'kept')`,
+		`INSERT INTO notes (id, created_at, updated_at, deleted_at, thread_id, content) VALUES
+			(1, datetime('now'), datetime('now'), datetime('now', '-1 day'), 1, 'trashed'),
+			(2, datetime('now'), datetime('now'), NULL, 1, 'live'),
+			(3, datetime('now'), datetime('now'), NULL, 1, 'shared')`,
+		`INSERT INTO branch_notes (branch_id, note_id, position) VALUES (1, 1, 0), (1, 2, 1), (1, 3, 2), (2, 3, 0)`,
+	)
+
+	n, err := d.PurgeTrash(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// note 1 goes with the branch
+	if n != 1 {
+		t.Errorf("purged %d items, want the branch", n)
+	}
+
+	tests := []struct {
+		id      uint
+		exists  bool
+		trashed bool
+	}{
+		{1, false, false},
+		{2, true, true},
+		{3, true, false},
+	}
+	for _, tt := range tests {
+		var notes []models.Note
+		if err := d.Conn.Unscoped().Where("id = ?", tt.id).Find(&notes).Error; err != nil {
+			t.Fatal(err)
+		}
+		if got := len(notes) == 1; got != tt.exists {
+			t.Errorf("note %d exists: %v, want %v", tt.id, got, tt.exists)
+			continue
+		}
+		if tt.exists && notes[0].DeletedAt.Valid != tt.trashed {
+			t.Errorf("note %d in the trash: %v, want %v", tt.id, notes[0].DeletedAt.Valid, tt.trashed)
+		}
+	}
+
+	// the note moved to the trash is listed there, and is purged next time
+	items, err := d.LoadTrash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Kind != SearchKindNote || items[0].ID != 2 {
+		t.Errorf("trash %+v, want note 2", items)
+	}
+	var links int64
+	d.Conn.Model(&branchNote{}).Where("note_id = 3 AND branch_id = 2").Count(&links)
+	if links != 1 {
+		t.Errorf("note 3 lost its live branch")
+	}
+}
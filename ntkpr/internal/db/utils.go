@@ -6,14 +6,9 @@ import (
 	"path/filepath"
 
 	"github.com/haochend413/ntkpr/internal/models"
+	"github.com/haochend413/ntkpr/internal/vault"
 )
 
-/*
-This overall mech is wrong.
-We cannot do this since we assume that database willl just select the next as input.
-This works most time, but a better way is to let db do its own thing.
-*/
-
 func (d *DB) GetFirstNoteID() uint {
 	var id uint
 	err := d.Conn.Model(&models.Note{}).Select("id").Where("deleted_at IS NULL").Order("id ASC").Limit(1).Scan(&id).Error
@@ -23,32 +18,6 @@ func (d *DB) GetFirstNoteID() uint {
 	return id
 }
 
-func (d *DB) GetCreateNoteID() uint {
-	// Query the database for the maximum ID, including deleted notes
-	var maxID uint
-	if err := d.Conn.Table("notes").Select("MAX(id)").Row().Scan(&maxID); err != nil {
-		maxID = 0
-	}
-	return maxID + 1
-}
-
-func (d *DB) GetCreateBranchID() uint {
-	// Query the database for the maximum ID, including deleted notes
-	var maxID uint
-	if err := d.Conn.Table("branches").Select("MAX(id)").Row().Scan(&maxID); err != nil {
-		maxID = 0
-	}
-	return maxID + 1
-}
-func (d *DB) GetCreateThreadID() uint {
-	// Query the database for the maximum ID, including deleted notes
-	var maxID uint
-	if err := d.Conn.Table("threads").Select("MAX(id)").Row().Scan(&maxID); err != nil {
-		maxID = 0
-	}
-	return maxID + 1
-}
-
 // export the serialized data into desired position
 func (d *DB) ExportNoteToJSON(path string) error {
 	dir := filepath.Dir(path)
@@ -62,6 +31,12 @@ func (d *DB) ExportNoteToJSON(path string) error {
 	if err != nil {
 		return err
 	}
+	// private content is exported sealed, even when the session has opened it
+	for i := range notes {
+		if notes[i].Private {
+			notes[i].Content = vault.Seal(notes[i].Content)
+		}
+	}
 	data, err := json.MarshalIndent(notes, "", "  ")
 	if err := os.WriteFile(tmp, data, 0644); err != nil {
 		return err
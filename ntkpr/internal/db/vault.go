@@ -0,0 +1,232 @@
+package db
+
+// The vault holds how the key of private content is derived from the passphrase, see package vault.
+// The text of private threads, branches and notes is sealed when it is written, see models/private.go;
+// SealPrivate seals what was stored in plain text before, such as private items from before the vault, and scrubs the file.
+// Copies saved before migrations get the same.
+import (
+	"fmt"
+
+	"github.com/haochend413/ntkpr/internal/vault"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+)
+
+// vaultRow is the single row of the vault table: the key derivation parameters, and a value sealed with the key,
+// so that a wrong passphrase can be told. The passphrase and the key are never stored.
+type vaultRow struct {
+	ID         uint
+	Salt       []byte
+	Time       uint32
+	Memory     uint32
+	Threads    uint8
+	CheckValue string
+}
+
+func (vaultRow) TableName() string {
+	return "vault"
+}
+
+// HasVault reports whether a passphrase was set.
+func (d *DB) HasVault() (bool, error) {
+	var count int64
+	if err := d.Conn.Model(&vaultRow{}).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// HasPrivate reports whether there are private threads, branches or notes, deleted ones included.
+func (d *DB) HasPrivate() (bool, error) {
+	var found bool
+	err := d.Conn.Raw(`SELECT EXISTS (SELECT 1 FROM threads WHERE private)
+		OR EXISTS (SELECT 1 FROM branches WHERE private)
+		OR EXISTS (SELECT 1 FROM notes WHERE private)`).Scan(&found).Error
+	return found, err
+}
+
+// OpenVault derives the key of passphrase. The first passphrase sets up the vault;
+// later ones must be the same, or vault.ErrWrongPassphrase is returned.
+func (d *DB) OpenVault(passphrase string) (*vault.Key, error) {
+	var rows []vaultRow
+	if err := d.Conn.Limit(1).Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("read vault: %w", err)
+	}
+	if len(rows) == 1 {
+		r := rows[0]
+		key, err := vault.DeriveKey(passphrase, vault.Params{Salt: r.Salt, Time: r.Time, Memory: r.Memory, Threads: r.Threads})
+		if err != nil {
+			return nil, err
+		}
+		if err := key.Verify(r.CheckValue); err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+
+	p, err := vault.NewParams()
+	if err != nil {
+		return nil, err
+	}
+	key, err := vault.DeriveKey(passphrase, p)
+	if err != nil {
+		return nil, err
+	}
+	r := vaultRow{ID: 1, Salt: p.Salt, Time: p.Time, Memory: p.Memory, Threads: p.Threads, CheckValue: key.Check()}
+	if err := d.Conn.Create(&r).Error; err != nil {
+		return nil, fmt.Errorf("create vault: %w", err)
+	}
+	return key, nil
+}
+
+// privateText is a stored text column of a private row.
+type privateText struct {
+	table  string
+	column string
+	flags  string // the table whose private column is read by where
+	where  string // picks the rows of private entities
+}
+
+var privateTexts = []privateText{
+	{"threads", "name", "threads", "private"},
+	{"threads", "summary", "threads", "private"},
+	{"branches", "name", "branches", "private"},
+	{"branches", "summary", "branches", "private"},
+	{"notes", "content", "notes", "private"},
+	// the previous content of notes, in databases from before revisions
+	{"notes", "diff", "notes", "private"},
+	{"note_revisions", "content", "notes", "note_id IN (SELECT id FROM notes WHERE private)"},
+}
+
+// SealPrivate seals the text of private rows that is still stored in plain text, deleted rows and revisions included,
+// with the key of the session, in the database and in the copies saved before migrations.
+// It returns how many texts were sealed, none while the vault is locked.
+func (d *DB) SealPrivate() (int, error) {
+	if !vault.Unlocked() {
+		return 0, nil
+	}
+	sealed, err := sealPrivate(d.Conn)
+	if err != nil {
+		return sealed, err
+	}
+	if sealed > 0 {
+		if err := d.scrub(); err != nil {
+			return sealed, err
+		}
+	}
+	n, err := d.sealBackups()
+	return sealed + n, err
+}
+
+// sealPrivate seals the private text of conn in one transaction. Columns that its schema does not have are skipped,
+// so that it works on copies of older databases too.
+func sealPrivate(conn *gorm.DB) (int, error) {
+	sealed := 0
+	err := conn.Transaction(func(tx *gorm.DB) error {
+		m := tx.Migrator()
+		for _, p := range privateTexts {
+			if !m.HasColumn(p.table, p.column) || !m.HasColumn(p.flags, "private") {
+				continue
+			}
+			var rows []struct {
+				ID   uint
+				Text string
+			}
+			sql := fmt.Sprintf(`SELECT id, %s AS text FROM %s WHERE %s AND %s != ''`, p.column, p.table, p.where, p.column)
+			if err := tx.Raw(sql).Scan(&rows).Error; err != nil {
+				return fmt.Errorf("read private %s: %w", p.table, err)
+			}
+			update := fmt.Sprintf(`UPDATE %s SET %s = ? WHERE id = ?`, p.table, p.column)
+			for _, r := range rows {
+				if vault.IsSealed(r.Text) {
+					continue
+				}
+				if err := tx.Exec(update, vault.Seal(r.Text), r.ID).Error; err != nil {
+					return fmt.Errorf("seal private %s: %w", p.table, err)
+				}
+				sealed++
+			}
+		}
+		return nil
+	})
+	return sealed, err
+}
+
+// sealBackups seals the private text of the copies saved before migrations, see backupBeforeMigrate,
+// which hold it as the database did then.
+func (d *DB) sealBackups() (int, error) {
+	backups, err := backupsOf(d.path)
+	if err != nil {
+		return 0, fmt.Errorf("list backups: %w", err)
+	}
+	sealed := 0
+	for _, path := range backups {
+		n, err := d.sealBackup(path)
+		sealed += n
+		if err != nil {
+			return sealed, fmt.Errorf("seal backup %s: %w", path, err)
+		}
+	}
+	return sealed, nil
+}
+
+// sealBackup seals the private text of the copy at path, and gives it the vault of the database,
+// so that the same passphrase opens it once it is restored. Its search index is dropped,
+// as copies from before private items were left out of it hold their text; it is rebuilt when the copy is opened.
+func (d *DB) sealBackup(path string) (int, error) {
+	conn, err := gorm.Open(sqlite.Open(path+"?_secure_delete=on"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		return 0, err
+	}
+	sqlDB, err := conn.DB()
+	if err != nil {
+		return 0, err
+	}
+	defer sqlDB.Close()
+
+	var rows []vaultRow
+	if err := d.Conn.Find(&rows).Error; err != nil {
+		return 0, fmt.Errorf("read vault: %w", err)
+	}
+	if err := migrateVault(conn); err != nil {
+		return 0, err
+	}
+	if len(rows) > 0 {
+		if err := conn.Clauses(clause.OnConflict{DoNothing: true}).Create(&rows).Error; err != nil {
+			return 0, fmt.Errorf("copy vault: %w", err)
+		}
+	}
+
+	sealed, err := sealPrivate(conn)
+	if err != nil {
+		return sealed, err
+	}
+	dropped, err := dropSearchIndex(conn)
+	if err != nil {
+		return sealed, err
+	}
+	if sealed == 0 && !dropped {
+		return 0, nil
+	}
+	if err := conn.Exec(`VACUUM`).Error; err != nil {
+		return sealed, fmt.Errorf("vacuum: %w", err)
+	}
+	return sealed, nil
+}
+
+// scrub rewrites the database file, so that plain text from before it was sealed or unindexed is gone from it:
+// the index merges its segments, which drops the tokens of deleted rows, and VACUUM drops free pages.
+// Pages freed before secure_delete was on may still hold it otherwise.
+func (d *DB) scrub() error {
+	if d.searchEnabled {
+		if err := d.Conn.Exec(`INSERT INTO search_index(search_index) VALUES('optimize')`).Error; err != nil {
+			return fmt.Errorf("optimize search index: %w", err)
+		}
+	}
+	if err := d.Conn.Exec(`VACUUM`).Error; err != nil {
+		return fmt.Errorf("vacuum: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/haochend413/ntkpr/internal/vault"
+	"gorm.io/gorm/logger"
+)
+
+// Private text stored in plain text before the vault, in the database, its search index and the copy saved
+// when it was upgraded, is sealed once the passphrase is entered, and no file keeps it.
+func TestSealPrivateLeavesNoPlainText(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, d *DB)
+	}{
+		{
+			name: "pre-versioning",
+			setup: func(t *testing.T, d *DB) {
+				exec(t, d, preVersioningSchema,
+					`INSERT INTO notes (id, created_at, updated_at, thread_id, private, content, diff) VALUES
+						(3, datetime('now'), datetime('now'), 1, 1, 'SECRET-NOTE', 'SECRET-DIFF')`)
+			},
+		},
+		{
+			name: "version 8",
+			setup: func(t *testing.T, d *DB) {
+				migrateTo(t, d.Conn, 8)
+				exec(t, d,
+					`INSERT INTO notes (id, created_at, updated_at, thread_id, private, content) VALUES
+						(3, datetime('now'), datetime('now'), 1, 1, 'SECRET-NOTE')`,
+					`INSERT INTO note_revisions (note_id, content, created_at) VALUES (3, 'SECRET-REVISION', datetime('now'))`)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "ntkpr.db")
+			raw := &DB{Conn: openRaw(t, path)}
+			tt.setup(t, raw)
+			exec(t, raw,
+				`INSERT INTO threads (id, created_at, updated_at, name, summary, private) VALUES
+					(1, datetime('now'), datetime('now'), 'SECRET-THREAD', 'SECRET-SUMMARY', 1)`,
+				`INSERT INTO branches (id, created_at, updated_at, thread_id, name, private) VALUES
+					(1, datetime('now'), datetime('now'), 1, 'SECRET-BRANCH', 1)`,
+				`INSERT INTO notes (id, created_at, updated_at, thread_id, content) VALUES (4, datetime('now'), datetime('now'), 1, 'public note')`,
+				`INSERT INTO branch_notes (note_id, branch_id) VALUES (3, 1), (4, 1)`)
+			if hasFTS5(raw.Conn) {
+				// builds from before the vault indexed private text too
+				exec(t, raw, searchIndexSchema,
+					`INSERT INTO search_index (kind, ref_id, thread_id, body) SELECT 'note', id, thread_id, content FROM notes`,
+					`INSERT INTO search_index (kind, ref_id, thread_id, body) SELECT 'thread', id, id, summary FROM threads`)
+			}
+			raw.Close()
+
+			d, err := NewDB(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d.Conn.Logger = logger.Discard
+			defer d.Close()
+			backups, err := backupsOf(path)
+			if err != nil || len(backups) != 1 {
+				t.Fatalf("backups %v (%v), want one", backups, err)
+			}
+			if !fileContains(t, backups[0], "SECRET-NOTE") {
+				t.Fatal("the backup has no plain text to seal")
+			}
+
+			key, err := d.OpenVault("correct horse")
+			if err != nil {
+				t.Fatal(err)
+			}
+			vault.Unlock(key)
+			defer vault.Lock()
+			if n, err := d.SealPrivate(); err != nil || n == 0 {
+				t.Fatalf("SealPrivate = %d, %v", n, err)
+			}
+			if n, err := d.SealPrivate(); err != nil || n != 0 {
+				t.Errorf("sealing again = %d, %v, want nothing to do", n, err)
+			}
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range entries {
+				if fileContains(t, filepath.Join(dir, e.Name()), "SECRET-") {
+					t.Errorf("%s keeps private text in plain text", e.Name())
+				}
+			}
+			if !fileContains(t, backups[0], "public note") {
+				t.Error("the backup lost its public text")
+			}
+
+			// the backup is restored with the same passphrase
+			restored := filepath.Join(t.TempDir(), "ntkpr.db")
+			data, err := os.ReadFile(backups[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(restored, data, 0600); err != nil {
+				t.Fatal(err)
+			}
+			r, err := NewDB(restored)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			if _, err := r.OpenVault("correct horse"); err != nil {
+				t.Fatalf("the restored backup refused the passphrase: %v", err)
+			}
+			var content string
+			r.Conn.Raw(`SELECT content FROM notes WHERE id = 3`).Scan(&content)
+			if got := vault.Open(content); got != "SECRET-NOTE" {
+				t.Errorf("restored note 3 opens to %q", got)
+			}
+			if r.SearchEnabled() {
+				if got := hits(t, r, "public", ""); len(got) != 1 {
+					t.Errorf("the restored index finds %v", got)
+				}
+			}
+		})
+	}
+}
+
+func fileContains(t *testing.T, path, text string) bool {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Contains(string(data), text)
+}
@@ -0,0 +1,185 @@
+// Package fuzzy matches a pattern as a subsequence of a text and scores the match,
+// so that "mtnts" finds "meeting notes", ranked above texts where the letters are scattered.
+// Matching ignores case. Matches on consecutive characters and at word starts score higher,
+// gaps and a late first match score lower.
+package fuzzy
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	scoreMatch       = 16
+	bonusConsecutive = 16
+	bonusBoundary    = 12
+	penaltyGap       = 1 // per skipped character between two matches
+	penaltyLeading   = 1 // per character before the first match
+	maxLeading       = 15
+	unmatched        = math.MinInt32
+)
+
+// Result is a successful match: its score and the rune positions of the matched characters in the text.
+type Result struct {
+	Score     int
+	Positions []int
+}
+
+// Match finds the best scoring way to match pattern as a subsequence of text. Spaces in pattern are ignored.
+func Match(pattern, text string) (Result, bool) {
+	p := []rune(strings.ToLower(strings.Join(strings.Fields(pattern), "")))
+	t := []rune(text)
+	if len(p) == 0 || len(p) > len(t) {
+		return Result{}, false
+	}
+	lower := make([]rune, len(t))
+	for i, r := range t {
+		lower[i] = unicode.ToLower(r)
+	}
+	if !isSubsequence(p, lower) {
+		return Result{}, false
+	}
+
+	// score[j][i] is the best score of p[:j+1] with p[j] matched at t[i], from[j][i] where p[j-1] was matched.
+	n, m := len(t), len(p)
+	score := make([][]int, m)
+	from := make([][]int, m)
+	for j := range p {
+		score[j] = make([]int, n)
+		from[j] = make([]int, n)
+		for i := range t {
+			score[j][i] = unmatched
+		}
+	}
+	for i := range t {
+		if lower[i] == p[0] {
+			score[0][i] = scoreMatch + boundaryBonus(t, i) - min(i*penaltyLeading, maxLeading)
+		}
+	}
+	for j := 1; j < m; j++ {
+		// best previous match at least two characters back, with the gap penalty factored out
+		gapBest, gapFrom := unmatched, -1
+		for i := 1; i < n; i++ {
+			if k := i - 2; k >= 0 && score[j-1][k] != unmatched && score[j-1][k]+k*penaltyGap > gapBest {
+				gapBest, gapFrom = score[j-1][k]+k*penaltyGap, k
+			}
+			if lower[i] != p[j] {
+				continue
+			}
+			best, prev := unmatched, -1
+			if score[j-1][i-1] != unmatched {
+				best, prev = score[j-1][i-1]+bonusConsecutive, i-1
+			}
+			if gapFrom >= 0 && gapBest-(i-1)*penaltyGap > best {
+				best, prev = gapBest-(i-1)*penaltyGap, gapFrom
+			}
+			if prev >= 0 {
+				score[j][i] = best + scoreMatch + boundaryBonus(t, i)
+				from[j][i] = prev
+			}
+		}
+	}
+
+	end := -1
+	for i := range t {
+		if score[m-1][i] != unmatched && (end < 0 || score[m-1][i] > score[m-1][end]) {
+			end = i
+		}
+	}
+	if end < 0 {
+		return Result{}, false
+	}
+	positions := make([]int, m)
+	for j, i := m-1, end; j >= 0; j-- {
+		positions[j] = i
+		i = from[j][i]
+	}
+	return Result{Score: score[m-1][end], Positions: positions}, true
+}
+
+func isSubsequence(p, t []rune) bool {
+	j := 0
+	for i := 0; i < len(t) && j < len(p); i++ {
+		if t[i] == p[j] {
+			j++
+		}
+	}
+	return j == len(p)
+}
+
+func boundaryBonus(t []rune, i int) int {
+	if isBoundary(t, i) {
+		return bonusBoundary
+	}
+	return 0
+}
+
+// isBoundary reports whether t[i] starts a word: the start of the text, after a non-alphanumeric, or a camelCase hump.
+func isBoundary(t []rune, i int) bool {
+	if i == 0 {
+		return true
+	}
+	prev, cur := t[i-1], t[i]
+	if !unicode.IsLetter(prev) && !unicode.IsDigit(prev) {
+		return true
+	}
+	return unicode.IsLower(prev) && unicode.IsUpper(cur)
+}
+
+// Candidate is one text to rank, with Index pointing back at the caller's item.
+type Candidate struct {
+	Index int
+	Text  string
+}
+
+// Ranked is a matched candidate.
+type Ranked struct {
+	Index int
+	Result
+}
+
+// Rank matches pattern against every candidate and sorts the matches by score, best first.
+// Ties keep the candidate order.
+func Rank(pattern string, candidates []Candidate) []Ranked {
+	ranked := make([]Ranked, 0)
+	for _, c := range candidates {
+		if r, ok := Match(pattern, c.Text); ok {
+			ranked = append(ranked, Ranked{Index: c.Index, Result: r})
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].Score > ranked[j].Score
+	})
+	return ranked
+}
+
+// Snippet marks the matched characters of text with open and close, starting lead runes before the first match.
+// Runs of consecutive matches are marked as one.
+func Snippet(text string, positions []int, open, close string, lead int) string {
+	t := []rune(text)
+	start := 0
+	if len(positions) > 0 {
+		start = max(0, positions[0]-lead)
+	}
+	matched := make(map[int]bool, len(positions))
+	for _, pos := range positions {
+		matched[pos] = true
+	}
+
+	var sb strings.Builder
+	if start > 0 {
+		sb.WriteString("…")
+	}
+	for i := start; i < len(t); i++ {
+		if matched[i] && !matched[i-1] {
+			sb.WriteString(open)
+		}
+		sb.WriteRune(t[i])
+		if matched[i] && !matched[i+1] {
+			sb.WriteString(close)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,107 @@
+package fuzzy
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern, text string
+		ok            bool
+		positions     []int
+	}{
+		{"abc", "abc", true, []int{0, 1, 2}},
+		{"ABC", "abc", true, []int{0, 1, 2}},
+		{"abc", "ABC", true, []int{0, 1, 2}},
+		{"a c", "abc", true, []int{0, 2}},
+		{"über", "Über alles", true, []int{0, 1, 2, 3}},
+		// the n of notes starts a word, the one of meeting does not
+		{"mn", "meeting notes", true, []int{0, 8}},
+		{"mtnts", "meeting notes", true, []int{0, 3, 8, 10, 12}},
+		// consecutive matches beat the earlier scattered ones
+		{"note", "n o t e note", true, []int{8, 9, 10, 11}},
+		{"fb", "fooBar", true, []int{0, 3}},
+
+		{"", "abc", false, nil},
+		{"   ", "abc", false, nil},
+		{"abcd", "abc", false, nil},
+		{"ca", "abc", false, nil},
+		{"xyz", "meeting notes", false, nil},
+	}
+	for _, tt := range tests {
+		got, ok := Match(tt.pattern, tt.text)
+		if ok != tt.ok {
+			t.Errorf("Match(%q, %q) matched %v, want %v", tt.pattern, tt.text, ok, tt.ok)
+			continue
+		}
+		if ok && !slices.Equal(got.Positions, tt.positions) {
+			t.Errorf("Match(%q, %q) at %v, want %v", tt.pattern, tt.text, got.Positions, tt.positions)
+		}
+	}
+}
+
+func TestMatchScores(t *testing.T) {
+	// each pair is a better match before a worse one
+	tests := []struct {
+		pattern, better, worse string
+	}{
+		{"note", "notebook", "n_o_t_e"},
+		{"note", "notebook", "a notebook"},
+		{"note", "a note", "a n o t e"},
+		{"fb", "fooBar", "foobar"},
+		{"ab", "ab", "a---b"},
+	}
+	for _, tt := range tests {
+		better, ok1 := Match(tt.pattern, tt.better)
+		worse, ok2 := Match(tt.pattern, tt.worse)
+		if !ok1 || !ok2 {
+			t.Errorf("%q: did not match %q and %q", tt.pattern, tt.better, tt.worse)
+			continue
+		}
+		if better.Score <= worse.Score {
+			t.Errorf("%q: %q scored %d, not above %q with %d", tt.pattern, tt.better, better.Score, tt.worse, worse.Score)
+		}
+	}
+}
+
+func TestRank(t *testing.T) {
+	candidates := []Candidate{
+		{Index: 0, Text: "n_o_t_e"},
+		{Index: 1, Text: "unrelated"},
+		{Index: 2, Text: "a note"},
+		{Index: 3, Text: "notebook"},
+		{Index: 4, Text: "notebook"},
+	}
+	var order []int
+	for _, r := range Rank("note", candidates) {
+		order = append(order, r.Index)
+	}
+	// ties keep the order of the candidates
+	if want := []int{3, 4, 2, 0}; !slices.Equal(order, want) {
+		t.Errorf("ranked %v, want %v", order, want)
+	}
+	if got := Rank("zzz", candidates); len(got) != 0 {
+		t.Errorf("ranked %v, want nothing", got)
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	tests := []struct {
+		text      string
+		positions []int
+		lead      int
+		want      string
+	}{
+		{"meeting notes", []int{0, 8}, 12, "[m]eeting [n]otes"},
+		{"notebook", []int{0, 1, 2, 3}, 12, "[note]book"},
+		{"a long text here", []int{7, 8}, 2, "…g [te]xt here"},
+		{"no match", nil, 2, "no match"},
+		{"über", []int{0}, 0, "[ü]ber"},
+	}
+	for _, tt := range tests {
+		if got := Snippet(tt.text, tt.positions, "[", "]", tt.lead); got != tt.want {
+			t.Errorf("Snippet(%q, %v) = %q, want %q", tt.text, tt.positions, got, tt.want)
+		}
+	}
+}
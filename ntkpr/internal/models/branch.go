@@ -12,13 +12,18 @@ Notes should be able to co-exist in several branches.
 Branches are managed by Threads.
 */
 type Branch struct {
-	gorm.Model      // This contains ID.
-	ThreadID   uint // Foreign key for Thread.
-	Name       string
-	Summary    string
+	gorm.Model        // This contains ID.
+	ThreadID   uint   // Foreign key for Thread.
+	Name       string `gorm:"serializer:private"` // sealed when the branch is private, see private.go
+	Summary    string `gorm:"serializer:private"`
 	LastEdit   time.Time
 	Highlight  bool    `gorm:"default:false"`
 	Private    bool    `gorm:"default:false"`
 	Notes      []*Note `gorm:"many2many:branch_notes;constraint:OnDelete:CASCADE;"` // Maybe we can improve it ? Let's first keep it this way.
 	Frequency  int     `gorm:"not null;default:0"`
+	// An archived branch is kept for reference, like a branch that was merged into another one.
+	Archived bool `gorm:"default:false"`
+	// A fork shares the notes of another branch up to one of them, and goes its own way from there. Both are 0 for other branches.
+	ForkBranchID uint `gorm:"not null;default:0"` // the branch this one was forked from
+	ForkNoteID   uint `gorm:"not null;default:0"` // the last note shared with it
 }
@@ -37,11 +37,14 @@ func (b *Branch) AfterUpdate(tx *gorm.DB) error {
 		}).Error
 }
 
-// BeforeSave is a GORM hook that ensures LastEdit is set to CreatedAt if it is null.
+// BeforeSave is a GORM hook that ensures LastEdit is set to CreatedAt if it is null,
+// and counts the checkboxes of the content.
 func (n *Note) BeforeSave(tx *gorm.DB) (err error) {
 	if n.LastEdit.IsZero() {
 		n.LastEdit = n.CreatedAt
 	}
+	c := n.Checks()
+	n.ChecksDone, n.ChecksTotal = c.Done, c.Total
 	return nil
 }
 
@@ -0,0 +1,11 @@
+package models
+
+// Entities created locally get temporary IDs from TempIDStart upwards until they are synced.
+// The database assigns their real IDs on sync, and SyncData reports how they map.
+// Real IDs stay far below TempIDStart, and temporary IDs still fit in the int fields of Superlink.
+const TempIDStart uint = 1 << 30
+
+// IsTempID reports whether id is a temporary ID of an entity that was not synced yet.
+func IsTempID(id uint) bool {
+	return id >= TempIDStart
+}
@@ -3,18 +3,72 @@ package models
 import (
 	"time"
 
+	"github.com/haochend413/ntkpr/internal/checklist"
+	"github.com/haochend413/ntkpr/internal/vault"
 	"gorm.io/gorm"
 )
 
 // Note represents a note entity
 type Note struct {
 	gorm.Model
-	Content   string
-	Diff      string
+	Content   string `gorm:"serializer:private"` // sealed when the note is private, see private.go
 	LastEdit  time.Time
 	Highlight bool      `gorm:"default:false"`
 	Private   bool      `gorm:"default:false"`
 	Frequency int       `gorm:"not null;default:0"`
 	Branches  []*Branch `gorm:"many2many:branch_notes;constraint:OnDelete:CASCADE;"`
 	ThreadID  uint      // Foreign key - note belongs to a single thread
+	// A note can be a task. The fields are unset for other notes.
+	Status      TaskStatus `gorm:"not null;default:''"`
+	Due         *time.Time // the day the task is due, at midnight local time
+	CompletedAt *time.Time // when the task was last marked done
+	// The markdown checkboxes of Content, see package checklist. BeforeSave keeps them up to date, so the database can sum them.
+	ChecksDone  int `gorm:"not null;default:0"`
+	ChecksTotal int `gorm:"not null;default:0"`
+}
+
+// TaskStatus is the state of a note that is a task, or TaskNone for a note that is not.
+type TaskStatus string
+
+const (
+	TaskNone  TaskStatus = ""
+	TaskTodo  TaskStatus = "todo"
+	TaskDoing TaskStatus = "doing"
+	TaskDone  TaskStatus = "done"
+)
+
+// Next returns the status after s when cycling: none, todo, doing, done, and back to none.
+func (s TaskStatus) Next() TaskStatus {
+	switch s {
+	case TaskNone:
+		return TaskTodo
+	case TaskTodo:
+		return TaskDoing
+	case TaskDoing:
+		return TaskDone
+	}
+	return TaskNone
+}
+
+// Open reports whether s is the status of a task that is not done yet.
+func (s TaskStatus) Open() bool {
+	return s == TaskTodo || s == TaskDoing
+}
+
+// Checks counts the checkboxes of the content. Sealed content cannot be read, so it keeps the counts it was stored with.
+func (n *Note) Checks() checklist.Counts {
+	if vault.IsSealed(n.Content) {
+		return checklist.Counts{Done: n.ChecksDone, Total: n.ChecksTotal}
+	}
+	return checklist.Count(n.Content)
+}
+
+// Overdue reports whether the note is an open task whose due day ended before now.
+func (n *Note) Overdue(now time.Time) bool {
+	if !n.Status.Open() || n.Due == nil {
+		return false
+	}
+	due := n.Due.In(now.Location())
+	end := time.Date(due.Year(), due.Month(), due.Day()+1, 0, 0, 0, 0, now.Location())
+	return !now.Before(end)
 }
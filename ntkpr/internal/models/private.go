@@ -0,0 +1,49 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/haochend413/ntkpr/internal/vault"
+	"gorm.io/gorm/schema"
+)
+
+// The text of a private thread, branch or note is stored sealed, see package vault.
+// Fields tagged serializer:private are sealed when they are written, if the struct they belong to is Private,
+// and opened when they are read. While the vault is locked, sealed text is read and written as it is.
+
+func init() {
+	schema.RegisterSerializer("private", privateSerializer{})
+}
+
+type privateSerializer struct{}
+
+// Scan opens the stored text.
+func (privateSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue any) error {
+	var text string
+	switch v := dbValue.(type) {
+	case nil:
+	case string:
+		text = v
+	case []byte:
+		text = string(v)
+	default:
+		return fmt.Errorf("cannot read %T into %s", dbValue, field.Name)
+	}
+	field.ReflectValueOf(ctx, dst).SetString(vault.Open(text))
+	return nil
+}
+
+// Value seals the text of private entities. Structs without a Private field, such as NoteRevision, are written as they are.
+func (privateSerializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue any) (any, error) {
+	text, _ := fieldValue.(string)
+	private := reflect.Indirect(dst).FieldByName("Private")
+	switch {
+	case !private.IsValid():
+		return text, nil
+	case private.Bool():
+		return vault.Seal(text), nil
+	}
+	return vault.Open(text), nil
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+/*
+NoteRevision is one synced version of a note.
+A new revision is written every time a changed note is synced, so every version can be reopened later.
+We keep full content instead of patches: notes are short, and a full copy can be restored without replaying anything.
+*/
+type NoteRevision struct {
+	ID        uint   `gorm:"primarykey"`
+	NoteID    uint   `gorm:"index"`              // Foreign key for Note.
+	Content   string `gorm:"serializer:private"` // sealed for private notes, see db.recordRevisions
+	CreatedAt time.Time
+}
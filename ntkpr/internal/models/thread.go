@@ -13,8 +13,8 @@ Each thread contains many notes that could be separated to several branches.
 
 type Thread struct {
 	gorm.Model
-	Name      string
-	Summary   string
+	Name      string `gorm:"serializer:private"` // sealed when the thread is private, see private.go
+	Summary   string `gorm:"serializer:private"`
 	LastEdit  time.Time
 	Highlight bool `gorm:"default:false"`
 	Private   bool `gorm:"default:false"`
@@ -0,0 +1,312 @@
+// Package query parses search strings with filter operators, such as
+//
+//	is:highlight -is:private thread:"Work log" branch:ideas after:2025-01-01 before:2025-06-01 edited:<7d freq:>5 ~mtnts some words
+//
+// Operators filter on fields of notes, branches and threads. Words starting with ~ are matched fuzzily.
+// Everything else is free text, which is handed to the full-text index unchanged.
+//
+//   - ~WORD: WORD as a subsequence of the note content, or of the branch / thread name. Results are ranked by match score.
+//   - is:highlight, is:private: flags, negate with a leading "-".
+//   - thread:NAME, branch:NAME: case-insensitive substring of the thread / branch name. Quote names with spaces.
+//   - after:DATE, before:DATE: creation date, YYYY-MM-DD. after includes the day, before excludes it.
+//   - edited:<AGE, edited:>AGE: time since the last edit, e.g. 30m, 12h, 7d, 2w.
+//   - freq:N: edit count, with one of > >= < <= = in front of N.
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/haochend413/ntkpr/internal/models"
+)
+
+// Comparison operators of freq: and edited:.
+const (
+	OpEq = "="
+	OpGt = ">"
+	OpGe = ">="
+	OpLt = "<"
+	OpLe = "<="
+)
+
+// IntFilter compares an integer field with a fixed value.
+type IntFilter struct {
+	Op    string
+	Value int
+}
+
+// AgeFilter compares the time since an event with a fixed duration.
+type AgeFilter struct {
+	Op  string
+	Age time.Duration
+}
+
+// Query is a parsed search string. Zero fields do not filter.
+type Query struct {
+	Text      string   // free text for the full-text index
+	Fuzzy     []string // ~words, matched as subsequences
+	Highlight *bool
+	Private   *bool
+	Thread    string
+	Branch    string
+	After     time.Time
+	Before    time.Time
+	Edited    *AgeFilter
+	Freq      *IntFilter
+}
+
+// HasFilters reports whether the query has any operator besides free text.
+func (q *Query) HasFilters() bool {
+	return q.Highlight != nil || q.Private != nil || q.Thread != "" || q.Branch != "" ||
+		!q.After.IsZero() || !q.Before.IsZero() || q.Edited != nil || q.Freq != nil
+}
+
+// Parse parses a search string. Unknown key:value words are kept as free text.
+func Parse(s string) (*Query, error) {
+	q := &Query{}
+	text := make([]string, 0)
+
+	for _, tok := range tokenize(s) {
+		if strings.HasPrefix(tok, "~") {
+			if word := unquote(strings.TrimPrefix(tok, "~")); word != "" {
+				q.Fuzzy = append(q.Fuzzy, word)
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(tok, ":")
+		negate := strings.HasPrefix(key, "-")
+		key = strings.ToLower(strings.TrimPrefix(key, "-"))
+		if !ok || !isOperator(key) {
+			text = append(text, tok)
+			continue
+		}
+		value = unquote(value)
+		if value == "" {
+			return nil, fmt.Errorf("%s: missing value", key)
+		}
+		if negate && key != "is" {
+			return nil, fmt.Errorf("%s: only is: filters can be negated", key)
+		}
+
+		switch key {
+		case "is":
+			flag := !negate
+			switch strings.ToLower(value) {
+			case "highlight", "highlighted":
+				q.Highlight = &flag
+			case "private":
+				q.Private = &flag
+			default:
+				return nil, fmt.Errorf("is:%s: unknown flag, use highlight or private", value)
+			}
+		case "thread":
+			q.Thread = value
+		case "branch":
+			q.Branch = value
+		case "after", "before":
+			date, err := time.ParseInLocation("2006-01-02", value, time.Local)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%s: date must look like 2025-01-31", key, value)
+			}
+			if key == "after" {
+				q.After = date
+			} else {
+				q.Before = date
+			}
+		case "edited":
+			op, rest := splitOp(value)
+			if op == OpEq {
+				return nil, fmt.Errorf("edited:%s: use < or > in front of the age", value)
+			}
+			age, err := ParseAge(rest)
+			if err != nil {
+				return nil, fmt.Errorf("edited:%s: %w", value, err)
+			}
+			q.Edited = &AgeFilter{Op: op, Age: age}
+		case "freq":
+			op, rest := splitOp(value)
+			n, err := strconv.Atoi(rest)
+			if err != nil {
+				return nil, fmt.Errorf("freq:%s: not a number", value)
+			}
+			q.Freq = &IntFilter{Op: op, Value: n}
+		}
+	}
+
+	q.Text = strings.Join(text, " ")
+	return q, nil
+}
+
+// MatchNote reports whether a note passes the filters. thread is the note's thread, and may be nil.
+func (q *Query) MatchNote(n *models.Note, thread *models.Thread) bool {
+	if !q.matchCommon(n.Highlight, n.Private, n.CreatedAt, n.LastEdit, n.UpdatedAt, n.Frequency) {
+		return false
+	}
+	if q.Thread != "" && (thread == nil || !contains(thread.Name, q.Thread)) {
+		return false
+	}
+	if q.Branch != "" {
+		for _, b := range n.Branches {
+			if contains(b.Name, q.Branch) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// MatchBranch reports whether a branch passes the filters. thread is the branch's thread, and may be nil.
+func (q *Query) MatchBranch(b *models.Branch, thread *models.Thread) bool {
+	if !q.matchCommon(b.Highlight, b.Private, b.CreatedAt, b.LastEdit, b.UpdatedAt, b.Frequency) {
+		return false
+	}
+	if q.Thread != "" && (thread == nil || !contains(thread.Name, q.Thread)) {
+		return false
+	}
+	return q.Branch == "" || contains(b.Name, q.Branch)
+}
+
+// MatchThread reports whether a thread passes the filters. branch: matches threads that have such a branch.
+func (q *Query) MatchThread(t *models.Thread) bool {
+	if !q.matchCommon(t.Highlight, t.Private, t.CreatedAt, t.LastEdit, t.UpdatedAt, t.Frequency) {
+		return false
+	}
+	if q.Thread != "" && !contains(t.Name, q.Thread) {
+		return false
+	}
+	if q.Branch != "" {
+		for _, b := range t.Branches {
+			if contains(b.Name, q.Branch) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func (q *Query) matchCommon(highlight, private bool, created, lastEdit, updated time.Time, freq int) bool {
+	if q.Highlight != nil && *q.Highlight != highlight {
+		return false
+	}
+	if q.Private != nil && *q.Private != private {
+		return false
+	}
+	if !q.After.IsZero() && created.Before(q.After) {
+		return false
+	}
+	if !q.Before.IsZero() && !created.Before(q.Before) {
+		return false
+	}
+	if q.Edited != nil {
+		// items that were never edited count from their last update
+		if lastEdit.IsZero() {
+			lastEdit = updated
+		}
+		if !compare(q.Edited.Op, int64(time.Since(lastEdit)), int64(q.Edited.Age)) {
+			return false
+		}
+	}
+	if q.Freq != nil && !compare(q.Freq.Op, int64(freq), int64(q.Freq.Value)) {
+		return false
+	}
+	return true
+}
+
+func isOperator(key string) bool {
+	switch key {
+	case "is", "thread", "branch", "after", "before", "edited", "freq":
+		return true
+	}
+	return false
+}
+
+// tokenize splits on whitespace, keeping double quoted parts (also after a key:) together.
+// Quotes are kept, so free text phrases reach the full-text index intact.
+func tokenize(s string) []string {
+	tokens := make([]string, 0)
+	var sb strings.Builder
+	inQuote := false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+			sb.WriteRune(r)
+		case !inQuote && (r == ' ' || r == '\t' || r == '\n'):
+			if sb.Len() > 0 {
+				tokens = append(tokens, sb.String())
+				sb.Reset()
+			}
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	if sb.Len() > 0 {
+		tokens = append(tokens, sb.String())
+	}
+	return tokens
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && strings.HasPrefix(s, `"`) && strings.HasSuffix(s, `"`) {
+		return s[1 : len(s)-1]
+	}
+	return strings.Trim(s, `"`)
+}
+
+// splitOp splits a leading comparison operator off value, defaulting to OpEq.
+func splitOp(value string) (string, string) {
+	for _, op := range []string{OpGe, OpLe, OpGt, OpLt, OpEq} {
+		if strings.HasPrefix(value, op) {
+			return op, value[len(op):]
+		}
+	}
+	return OpEq, value
+}
+
+// ParseAge parses durations like 30m, 12h, 7d and 2w.
+func ParseAge(s string) (time.Duration, error) {
+	if len(s) < 2 {
+		return 0, fmt.Errorf("age must look like 30m, 12h, 7d or 2w")
+	}
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("age must look like 30m, 12h, 7d or 2w")
+	}
+	var unit time.Duration
+	switch s[len(s)-1] {
+	case 'm':
+		unit = time.Minute
+	case 'h':
+		unit = time.Hour
+	case 'd':
+		unit = 24 * time.Hour
+	case 'w':
+		unit = 7 * 24 * time.Hour
+	default:
+		return 0, fmt.Errorf("unknown unit %q, use m, h, d or w", s[len(s)-1:])
+	}
+	return time.Duration(n) * unit, nil
+}
+
+func compare(op string, a, b int64) bool {
+	switch op {
+	case OpGt:
+		return a > b
+	case OpGe:
+		return a >= b
+	case OpLt:
+		return a < b
+	case OpLe:
+		return a <= b
+	default:
+		return a == b
+	}
+}
+
+func contains(s, sub string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(sub))
+}
@@ -0,0 +1,145 @@
+package query
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/haochend413/ntkpr/internal/models"
+)
+
+func ptr(b bool) *bool { return &b }
+
+func date(s string) time.Time {
+	d, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Query
+		wantErr bool
+	}{
+		{in: "", want: Query{}},
+		{in: "plain words", want: Query{Text: "plain words"}},
+		{in: `"a phrase" word*`, want: Query{Text: `"a phrase" word*`}},
+		{in: "is:highlight", want: Query{Highlight: ptr(true)}},
+		{in: "-is:private IS:Highlighted", want: Query{Private: ptr(false), Highlight: ptr(true)}},
+		{in: `thread:"Work log" branch:ideas deploy`, want: Query{Thread: "Work log", Branch: "ideas", Text: "deploy"}},
+		{in: "after:2025-01-01 before:2025-06-01", want: Query{After: date("2025-01-01"), Before: date("2025-06-01")}},
+		{in: "edited:<7d", want: Query{Edited: &AgeFilter{Op: OpLt, Age: 7 * 24 * time.Hour}}},
+		{in: "edited:>=2w", want: Query{Edited: &AgeFilter{Op: OpGe, Age: 14 * 24 * time.Hour}}},
+		{in: "freq:>5", want: Query{Freq: &IntFilter{Op: OpGt, Value: 5}}},
+		{in: "freq:3", want: Query{Freq: &IntFilter{Op: OpEq, Value: 3}}},
+		{in: `~mtnts ~"two words" ~`, want: Query{Fuzzy: []string{"mtnts", "two words"}}},
+		{in: "http://example.com note:x", want: Query{Text: "http://example.com note:x"}},
+
+		{in: "is:", wantErr: true},
+		{in: "is:pinned", wantErr: true},
+		{in: "-thread:work", wantErr: true},
+		{in: "after:01/02/2025", wantErr: true},
+		{in: "edited:7d", wantErr: true},
+		{in: "edited:<7y", wantErr: true},
+		{in: "freq:>many", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := Parse(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("got %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{in: "30m", want: 30 * time.Minute},
+		{in: "12h", want: 12 * time.Hour},
+		{in: "7d", want: 7 * 24 * time.Hour},
+		{in: "2w", want: 14 * 24 * time.Hour},
+		{in: "0d", want: 0},
+		{in: "d", wantErr: true},
+		{in: "-1d", wantErr: true},
+		{in: "5s", wantErr: true},
+		{in: "1.5h", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseAge(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseAge(%q) = %v, %v, want %v (error %v)", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestMatchNote(t *testing.T) {
+	now := time.Now()
+	thread := &models.Thread{Name: "Work log"}
+	note := &models.Note{
+		Highlight: true,
+		Frequency: 4,
+		LastEdit:  now.Add(-2 * time.Hour),
+		Branches:  []*models.Branch{{Name: "Ideas"}, {Name: "todo"}},
+	}
+	note.CreatedAt = date("2025-03-10")
+
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{"", true},
+		{"is:highlight", true},
+		{"-is:highlight", false},
+		{"-is:private", true},
+		{"thread:work", true},
+		{"thread:home", false},
+		{"branch:IDEA", true},
+		{"branch:done", false},
+		{"after:2025-03-10", true},
+		{"after:2025-03-11", false},
+		{"before:2025-03-10", false},
+		{"before:2025-03-11", true},
+		{"edited:<1d", true},
+		{"edited:>1d", false},
+		{"freq:>=4", true},
+		{"freq:<4", false},
+		{"is:highlight thread:work freq:4", true},
+	}
+	for _, tt := range tests {
+		q, err := Parse(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := q.MatchNote(note, thread); got != tt.want {
+			t.Errorf("%q: got %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	// a note never edited counts from its last update
+	q, _ := Parse("edited:<1h")
+	fresh := &models.Note{}
+	fresh.UpdatedAt = now
+	if !q.MatchNote(fresh, nil) {
+		t.Error("a note updated now was not edited in the last hour")
+	}
+	if q, _ := Parse("thread:work"); q.MatchNote(note, nil) {
+		t.Error("thread: matched a note without a thread")
+	}
+}
@@ -0,0 +1,30 @@
+package ui
+
+// checks.go ticks the markdown checkboxes of notes from the notes table, see package checklist.
+// x ticks the first open checkbox of the note under the cursor and X unticks the last ticked one, without opening the editor.
+
+// tick ticks the first open checkbox of the note under the cursor of the notes table, or with done unset unticks the last ticked one.
+func (m *Model) tick(done bool) {
+	m.switchToNoteAtCursor(m.notesTable.Cursor())
+	if m.app.GetCurrentNoteID() == 0 || m.locked(FocusNotes) {
+		return
+	}
+	item, ok := m.app.TickCurrentNote(done, m.noteLink())
+	switch {
+	case !ok && done:
+		m.statusBar.GetTag("Action").SetValue("No open checkbox")
+	case !ok:
+		m.statusBar.GetTag("Action").SetValue("No ticked checkbox")
+	case done:
+		m.statusBar.GetTag("Action").SetValue("Ticked: " + item.Text)
+	default:
+		m.statusBar.GetTag("Action").SetValue("Unticked: " + item.Text)
+	}
+	if ok {
+		m.updateNotesTable()
+		m.updateBranchesTable()
+		m.updateThreadsTable()
+		m.updateChangelogTable()
+	}
+	m.updateStatusBar()
+}
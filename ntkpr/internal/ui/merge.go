@@ -0,0 +1,84 @@
+package ui
+
+import (
+	"fmt"
+
+	tea "charm.land/bubbletea/v2"
+	"github.com/haochend413/bubbles/v2/key"
+	"github.com/haochend413/ntkpr/internal/app"
+	"github.com/haochend413/ntkpr/internal/models"
+)
+
+// merge.go merges a branch into another branch of its thread.
+// M marks the branch under the cursor; M on another branch asks what becomes of the marked one: k keeps it, a archives it,
+// d deletes it. When both branches had a summary, the editor opens on the merged one, so it can be tidied up.
+
+// markMerge marks the branch under the cursor to be merged, or asks how to merge the marked branch into it.
+// Marking the same branch again drops it.
+func (m *Model) markMerge() {
+	m.switchToBranchAtCursor(m.branchesTable.Cursor())
+	branchID := m.app.GetCurrentBranchID()
+	if branchID == 0 {
+		return
+	}
+	switch m.mergeFrom {
+	case 0:
+		m.mergeFrom = branchID
+		m.statusBar.GetTag("Action").SetValue(fmt.Sprintf("Merging branch #%s: select a branch and press M", idLabel(branchID)))
+	case branchID:
+		m.mergeFrom = 0
+		m.statusBar.GetTag("Action").SetValue("Dropped the marked branch")
+	default:
+		m.mergePrompt = true
+		m.statusBar.GetTag("Action").SetValue(fmt.Sprintf("Merge branch #%s into #%s? k: keep it, a: archive it, d: delete it, any other key: cancel",
+			idLabel(m.mergeFrom), idLabel(branchID)))
+	}
+	m.updateStatusBar()
+}
+
+// answerMerge merges the marked branch into the active one as the key pressed at the prompt of markMerge says,
+// or cancels the merge.
+func (m *Model) answerMerge(msg tea.KeyMsg) {
+	m.mergePrompt = false
+	switch {
+	case key.Matches(msg, mergeKeys.Keep):
+		m.mergeBranch(app.MergeKeep)
+	case key.Matches(msg, mergeKeys.Archive):
+		m.mergeBranch(app.MergeArchive)
+	case key.Matches(msg, mergeKeys.Delete):
+		m.mergeBranch(app.MergeDelete)
+	default:
+		m.statusBar.GetTag("Action").SetValue("Merge cancelled")
+		m.updateStatusBar()
+	}
+}
+
+// mergeBranch merges the marked branch into the active one, and leaves it as fate says.
+func (m *Model) mergeBranch(fate app.MergeFate) {
+	from, into := m.mergeFrom, m.app.GetCurrentBranchID()
+	var fromSummary string
+	if b := m.app.GetDataMgr().FindBranchByID(from); b != nil {
+		fromSummary = b.Summary
+	}
+	intoSummary := m.app.GetCurrentBranchSummary()
+	link := &models.Superlink{ThreadID: int(m.app.GetCurrentThreadID()), BranchID: int(into)}
+
+	// the tables list everything again, so that they show the merged notes
+	m.resetSearch(FocusBranches)
+	m.resetSearch(FocusNotes)
+	if err := m.app.MergeBranch(into, from, fate, link); err != nil {
+		m.statusBar.GetTag("Action").SetValue(err.Error())
+		m.updateStatusBar()
+		return
+	}
+	m.mergeFrom = 0
+	m.syncCursors()
+	m.updateChangelogTable()
+	m.statusBar.GetTag("Action").SetValue(fmt.Sprintf("Merged branch #%s into #%s", idLabel(from), idLabel(into)))
+	m.updateStatusBar()
+	if app.MergedSummary(intoSummary, fromSummary) != intoSummary && intoSummary != "" {
+		m.EnterEdit(FocusBranches)
+		return
+	}
+	m.SetFocus(FocusBranches)
+}
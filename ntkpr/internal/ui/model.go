@@ -33,7 +33,9 @@ import (
 	"github.com/haochend413/lipgloss/v2"
 	"github.com/haochend413/ntkpr/config"
 	"github.com/haochend413/ntkpr/internal/app"
+	"github.com/haochend413/ntkpr/internal/db"
 	"github.com/haochend413/ntkpr/internal/models"
+	"github.com/haochend413/ntkpr/internal/vault"
 	"github.com/haochend413/ntkpr/state"
 	"github.com/haochend413/ntkpr/sys"
 )
@@ -49,6 +51,13 @@ const (
 	FocusChangelog
 	FocusRecent
 	FocusDiff
+	FocusHistory
+	FocusSearch
+	FocusGlobalSearch
+	FocusTrash
+	FocusTasks
+	FocusDue    // the due day prompt of the current note
+	FocusUnlock // the passphrase prompt of private items, see vault.go
 )
 
 type ViewMode int
@@ -56,6 +65,7 @@ type ViewMode int
 const (
 	ApplicationView ViewMode = iota
 	QuitConfirmView
+	JournalPromptView // unsynced edits of a previous session were found, see App.PendingJournal
 )
 
 // tickMsg is used to update the UI clock every second.
@@ -75,7 +85,14 @@ type Model struct {
 	textArea      textarea_vim.Model
 	changeTable   table.Model
 	recentTable   table.Model
+	historyTable  table.Model
+	globalTable   table.Model
+	trashTable    table.Model
+	tasksTable    table.Model
 	diffView      viewport.Model // we might need something better for this.
+	searchInput   textinput.Model
+	dueInput      textinput.Model
+	unlockInput   textinput.Model
 	statusBar     statusbar.Model
 
 	//view mode
@@ -83,9 +100,30 @@ type Model struct {
 
 	//states
 	previousFocus   FocusState
+	diffSource      FocusState // which overlay (recent / history) the diff view belongs to
+	revisions       []*models.NoteRevision
+	searchTarget    FocusState            // table searched by the search bar
+	searchQueries   map[FocusState]string // last query of each table in Search context
+	globalHits      []app.GlobalHit
+	globalReturn    FocusState // table to go back to when global search closes
+	trashItems      []db.TrashItem
+	trashReturn     FocusState // table to go back to when the trash closes
+	purgeConfirm    bool       // x was pressed in the trash, and y purges the selected item
+	tasks           []app.Task
+	tasksReturn     FocusState // table to go back to when the tasks close
+	unlockReturn    FocusState // table to go back to when the passphrase prompt closes
+	unlockFirst     string     // a new passphrase typed once, to be typed again
+	marked          *noteMark  // note picked up to be moved or added to another branch, see move.go
+	mergeFrom       uint       // branch marked to be merged into another one, see merge.go
+	mergePrompt     bool       // M was pressed on the branch to merge into, and the next key says what becomes of mergeFrom
 	focus           FocusState
 	editPrevIMEType sys.InputMethodType
 	ready           bool
+	lastInput       time.Time // last keypress, for idle syncs
+	lastSync        time.Time // last background sync, finished or failed
+	syncErr         error     // failure of the last sync
+	showSyncErr     bool      // syncErr is shown in the error panel
+	quitting        bool      // quit confirmed, waiting for the last sync
 
 	//data
 	width  int
@@ -121,10 +159,63 @@ func NewModel(application *app.App, cfg *config.Config, s *state.State) Model {
 		table.WithHeight(40),
 	)
 
+	historyColumns := []table.Column{
+		{Title: "Rev", Width: 6},
+		{Title: "Time", Width: 16},
+		{Title: "Content", Width: 50},
+	}
+
+	historyTable := table.New(
+		table.WithColumns(historyColumns),
+		table.WithFocused(true),
+		table.WithHeight(40),
+	)
+
+	globalColumns := []table.Column{
+		{Title: "Thread", Width: 30},
+		{Title: "Branch", Width: 30},
+		{Title: "Note", Width: 8},
+		{Title: "Match", Width: 70},
+	}
+
+	globalTable := table.New(
+		table.WithColumns(globalColumns),
+		table.WithFocused(true),
+		table.WithHeight(40),
+	)
+
+	trashColumns := []table.Column{
+		{Title: "Kind", Width: 6},
+		{Title: "ID", Width: 6},
+		{Title: "Deleted", Width: 16},
+		{Title: "Title", Width: 70},
+	}
+
+	trashTable := table.New(
+		table.WithColumns(trashColumns),
+		table.WithFocused(true),
+		table.WithHeight(40),
+	)
+
+	tasksColumns := []table.Column{
+		{Title: "Status", Width: 6},
+		{Title: "Due", Width: 18},
+		{Title: "Thread", Width: 20},
+		{Title: "Branch", Width: 20},
+		{Title: "Note", Width: 60},
+	}
+
+	tasksTable := table.New(
+		table.WithColumns(tasksColumns),
+		table.WithFocused(true),
+		table.WithHeight(40),
+	)
+
 	noteColumns := []table.Column{
 		{Title: "ID", Width: 4},
 		{Title: "Time", Width: 16},
 		{Title: "Content", Width: 40},
+		{Title: "Done", Width: 5},
 		{Title: "Flags", Width: 6},
 	}
 
@@ -138,6 +229,7 @@ func NewModel(application *app.App, cfg *config.Config, s *state.State) Model {
 		{Title: "Time", Width: 16},
 		{Title: "Name", Width: 40},
 		{Title: "#Ns", Width: 2},
+		{Title: "Done", Width: 5},
 		{Title: "Flags", Width: 6},
 	}
 
@@ -151,6 +243,7 @@ func NewModel(application *app.App, cfg *config.Config, s *state.State) Model {
 		{Title: "Time", Width: 16},
 		{Title: "Name", Width: 40},
 		{Title: "#Bs", Width: 2},
+		{Title: "Done", Width: 5},
 		{Title: "Flags", Width: 6},
 	}
 
@@ -220,6 +313,17 @@ func NewModel(application *app.App, cfg *config.Config, s *state.State) Model {
 	diffView.SetWidth(50)
 	diffView.SetHeight(10)
 	diffView.SoftWrap = true
+	searchInput := textinput.New()
+	searchInput.Placeholder = `words, "a phrase", prefix* or ~fuzzy`
+	searchInput.SetWidth(50)
+	dueInput := textinput.New()
+	dueInput.Placeholder = "2025-01-31, today, tomorrow or 3d; empty clears it"
+	dueInput.SetWidth(50)
+	unlockInput := textinput.New()
+	unlockInput.EchoMode = textinput.EchoPassword
+	unlockInput.Placeholder = "enter unlocks, esc keeps private items locked"
+	unlockInput.SetWidth(50)
+
 	// This needs further improving.
 	changeColumns := []table.Column{
 		{Title: "ID", Width: 4},
@@ -241,17 +345,29 @@ func NewModel(application *app.App, cfg *config.Config, s *state.State) Model {
 		branchesTable:   branchTable,
 		notesTable:      noteTable,
 		recentTable:     recentTable,
+		historyTable:    historyTable,
+		globalTable:     globalTable,
+		trashTable:      trashTable,
+		tasksTable:      tasksTable,
 		textArea:        textArea,
 		diffView:        diffView,
+		searchInput:     searchInput,
+		dueInput:        dueInput,
+		unlockInput:     unlockInput,
+		searchQueries:   make(map[FocusState]string),
 		viewMode:        ApplicationView,
 		statusBar:       sb,
 		changeTable:     changeTable,
 		focus:           FocusThreads,
+		diffSource:      FocusRecent,
 		editPrevIMEType: sys.InputMethodEnglish, // default to be english
+		lastInput:       time.Now(),
+		lastSync:        time.Now(),
 	}
 
 	//set states
-	// m.DistributeState(s)
+	m.app.SetSavedSearches(cfg.SavedSearches)
+	m.DistributeState(&s.UI)
 	// m.updateTopicsTable()
 	m.updateThreadsTable()
 	m.updateBranchesTable()
@@ -259,6 +375,15 @@ func NewModel(application *app.App, cfg *config.Config, s *state.State) Model {
 	m.updateRecentTable()
 	m.updateStatusBar()
 
+	// ask about a journal left by a session that did not end cleanly before anything else
+	if m.app.PendingJournal() > 0 {
+		m.viewMode = JournalPromptView
+	}
+	// private text is shown once the passphrase is entered
+	if m.app.NeedsUnlock() {
+		m.openUnlock()
+	}
+
 	return m
 }
 
@@ -285,19 +410,22 @@ func (m *Model) updateThreadsTable() {
 	rows := make([]table.Row, len(threads))
 	for i, thread := range threads {
 
-		name := thread.Name
+		name := vault.Show(thread.Name)
 		if len(name) > 38 {
 			name = name[:35] + "..."
 		}
+		if snippet := m.app.GetSnippet(db.SearchKindThread, thread.ID); snippet != "" {
+			name = renderSnippet(snippet)
+		}
 		idStr := fmt.Sprintf("%d", thread.ID)
 		timeStr := thread.CreatedAt.Format("06-01-02 15:04")
-		if thread.ID == 0 { // Pending note
+		if models.IsTempID(thread.ID) { // Pending, not synced yet
 			idStr = "P" // Indicate pending
 			timeStr = time.Now().Format("06-01-02 15:04")
 		}
 
 		bsStrRaw := "0"
-		bsStrRaw = strconv.Itoa(len(thread.Branches))
+		bsStrRaw = strconv.Itoa(m.app.GetDataMgr().BranchCount(thread))
 
 		flagStrRaw := ""
 		if thread.Highlight {
@@ -313,6 +441,7 @@ func (m *Model) updateThreadsTable() {
 			timeStr,
 			name,
 			bsStrRaw,
+			m.app.GetDataMgr().ThreadChecks(thread).String(),
 			flagStrRaw,
 		}
 	}
@@ -328,19 +457,22 @@ func (m *Model) updateBranchesTable() {
 	rows := make([]table.Row, len(branches))
 	for i, branch := range branches {
 
-		name := branch.Name
+		name := vault.Show(branch.Name)
 		if len(name) > 38 {
 			name = name[:35] + "..."
 		}
+		if snippet := m.app.GetSnippet(db.SearchKindBranch, branch.ID); snippet != "" {
+			name = renderSnippet(snippet)
+		}
 		idStr := fmt.Sprintf("%d", branch.ID)
 		timeStr := branch.CreatedAt.Format("06-01-02 15:04")
-		if branch.ID == 0 { // Pending note
+		if models.IsTempID(branch.ID) { // Pending, not synced yet
 			idStr = "P" // Indicate pending
 			timeStr = time.Now().Format("06-01-02 15:04")
 		}
 
 		nsStrRaw := "0"
-		nsStrRaw = strconv.Itoa(len(branch.Notes))
+		nsStrRaw = strconv.Itoa(m.app.GetDataMgr().NoteCount(branch))
 
 		flagStrRaw := ""
 		if branch.Highlight {
@@ -349,6 +481,12 @@ func (m *Model) updateBranchesTable() {
 		if branch.Private {
 			flagStrRaw += "P"
 		}
+		if branch.ForkBranchID != 0 {
+			flagStrRaw += "F"
+		}
+		if branch.Archived {
+			flagStrRaw += "A"
+		}
 
 		// This needs further tuning.
 		rows[i] = table.Row{
@@ -356,6 +494,7 @@ func (m *Model) updateBranchesTable() {
 			timeStr,
 			name,
 			nsStrRaw,
+			m.app.GetDataMgr().BranchChecks(branch).String(),
 			flagStrRaw,
 		}
 	}
@@ -366,17 +505,21 @@ func (m *Model) updateBranchesTable() {
 func (m *Model) updateNotesTable() {
 	var selectedNotes []*models.Note
 	selectedNotes = m.app.GetActiveNoteList()
+	now := time.Now()
 
 	rows := make([]table.Row, len(selectedNotes))
 	for i, note := range selectedNotes {
 
-		content := note.Content
+		content := vault.Show(note.Content)
 		if len(content) > 38 {
 			content = content[:35] + "..."
 		}
+		if snippet := m.app.GetSnippet(db.SearchKindNote, note.ID); snippet != "" {
+			content = renderSnippet(snippet)
+		}
 		idStr := fmt.Sprintf("%d", note.ID)
 		timeStr := note.CreatedAt.Format("06-01-02 15:04")
-		if note.ID == 0 { // Pending note
+		if models.IsTempID(note.ID) { // Pending, not synced yet
 			idStr = "P" // Indicate pending
 			timeStr = time.Now().Format("06-01-02 15:04")
 		}
@@ -388,17 +531,48 @@ func (m *Model) updateNotesTable() {
 		if note.Private {
 			flagStrRaw += "P"
 		}
+		flagStrRaw += taskMark(note, now)
 
 		rows[i] = table.Row{
 			idStr,
 			timeStr,
 			content,
+			note.Checks().String(),
 			flagStrRaw,
 		}
 	}
 	m.notesTable.SetRows(rows)
 }
 
+// updateGlobalTable renders the hits of the last global search.
+func (m *Model) updateGlobalTable() {
+	rows := make([]table.Row, len(m.globalHits))
+	for i, hit := range m.globalHits {
+		threadName := vault.Show(hit.Thread.Name)
+		if len(threadName) > 48 {
+			threadName = threadName[:45] + "..."
+		}
+		branchName := "-"
+		if hit.Branch != nil {
+			branchName = vault.Show(hit.Branch.Name)
+			if len(branchName) > 48 {
+				branchName = branchName[:45] + "..."
+			}
+		}
+		noteStr := "-"
+		if hit.Note != nil {
+			noteStr = fmt.Sprintf("#%d", hit.Note.ID)
+		}
+		rows[i] = table.Row{
+			threadName,
+			branchName,
+			noteStr,
+			renderSnippet(hit.Snippet),
+		}
+	}
+	m.globalTable.SetRows(rows)
+}
+
 // This is wrong, to be modified
 func (m *Model) updateChangelogTable() {
 	editMap := m.app.GetEditMap()
@@ -429,10 +603,16 @@ func (m *Model) updateChangelogTable() {
 			editTypeName = "Update"
 		case 10:
 			editTypeName = "Delete"
+		case 11:
+			editTypeName = "Move"
+		case 12:
+			editTypeName = "Link"
+		case 13:
+			editTypeName = "Reorder"
 		}
 
 		entityType := key.EntityType
-		idStr := fmt.Sprintf("%d", key.ID)
+		idStr := idLabel(key.ID)
 		timeStr := time.Now().Format("06-01-02 15:04")
 		description := fmt.Sprintf("%s %s", editTypeName, entityType)
 
@@ -459,7 +639,44 @@ func (m *Model) updateDiffArea(link models.Superlink) {
 		))
 		return
 	}
-	m.diffView.SetContent(note.Diff)
+	m.diffView.SetContent(m.app.GetNoteDiff(note))
+}
+
+// updateHistoryTable reloads the revisions of the current note into the history table.
+func (m *Model) updateHistoryTable() {
+	m.revisions = m.app.GetCurrentNoteRevisions()
+	current := m.app.GetCurrentNoteContent()
+
+	rows := make([]table.Row, len(m.revisions))
+	for i, r := range m.revisions {
+		content := vault.Show(r.Content)
+		if len(content) > 48 {
+			content = content[:45] + "..."
+		}
+		// mark the revision that matches what the note holds right now
+		revStr := fmt.Sprintf("%d", r.ID)
+		if vault.Show(r.Content) == current {
+			revStr = "*" + revStr
+		}
+		rows[i] = table.Row{
+			revStr,
+			r.CreatedAt.Format("06-01-02 15:04"),
+			content,
+		}
+	}
+	m.historyTable.SetRows(rows)
+}
+
+// updateRevisionArea shows the revision under the history cursor in the diff view.
+func (m *Model) updateRevisionArea() {
+	cursor := m.historyTable.Cursor()
+	if cursor < 0 || cursor >= len(m.revisions) {
+		m.diffView.SetContent("No synced revisions for this note yet.")
+		return
+	}
+	r := m.revisions[cursor]
+	m.diffView.SetContent(fmt.Sprintf("Revision %d · %s\n\n%s", r.ID, r.CreatedAt.Format("2006-01-02 15:04:05"), vault.Show(r.Content)))
+	m.diffView.GotoTop()
 }
 
 func (m *Model) updateRecentTable() {
@@ -478,7 +695,7 @@ func (m *Model) updateRecentTable() {
 		if link.ThreadID > 0 {
 			thread := m.app.GetDataMgr().FindThreadByID(uint(link.ThreadID))
 			if thread != nil {
-				threadName = thread.Name
+				threadName = vault.Show(thread.Name)
 				if len(threadName) > 48 {
 					threadName = threadName[:45] + "..."
 				}
@@ -499,7 +716,7 @@ func (m *Model) updateRecentTable() {
 		if link.BranchID > 0 {
 			branch := m.app.GetDataMgr().FindBranchByID(uint(link.BranchID))
 			if branch != nil {
-				branchName = branch.Name
+				branchName = vault.Show(branch.Name)
 				if len(branchName) > 48 {
 					branchName = branchName[:45] + "..."
 				}
@@ -520,7 +737,7 @@ func (m *Model) updateRecentTable() {
 		if link.NoteID > 0 {
 			note := m.app.GetDataMgr().FindNoteByID(uint(link.NoteID))
 			if note != nil {
-				noteContent = note.Content
+				noteContent = vault.Show(note.Content)
 				if len(noteContent) > 68 {
 					noteContent = noteContent[:65] + "..."
 				}
@@ -548,12 +765,20 @@ func (m *Model) updateRecentTable() {
 }
 
 func (m *Model) printSync() {
-	if m.app.Synced {
-		m.statusBar.GetTag("Synced").SetValue("Synced")
-		m.statusBar.GetTag("Synced").SetColors(colorPtr("232"), colorPtr("118"))
-	} else {
-		m.statusBar.GetTag("Synced").SetValue("Unsynced")
-		m.statusBar.GetTag("Synced").SetColors(colorPtr("232"), colorPtr("208"))
+	tag := m.statusBar.GetTag("Synced")
+	switch {
+	case m.app.Syncing():
+		tag.SetValue("Syncing...")
+		tag.SetColors(colorPtr("232"), colorPtr("226"))
+	case m.syncErr != nil:
+		tag.SetValue("Sync failed")
+		tag.SetColors(colorPtr("232"), colorPtr("196"))
+	case m.app.Synced:
+		tag.SetValue("Synced")
+		tag.SetColors(colorPtr("232"), colorPtr("118"))
+	default:
+		tag.SetValue("Unsynced")
+		tag.SetColors(colorPtr("232"), colorPtr("208"))
 	}
 }
 
@@ -590,19 +815,25 @@ func (m *Model) updateStatusBar() {
 	switch m.focus {
 	case FocusThreads:
 		focusName = "Threads"
-		m.statusBar.GetTag("ID").SetValue("#" + strconv.Itoa(int(m.app.GetCurrentThreadID())))
+		m.statusBar.GetTag("ID").SetValue("#" + idLabel(m.app.GetCurrentThreadID()))
 		m.statusBar.GetTag("LastUpdated").SetValue(formatTimeAgo(m.app.GetCurrentThreadLastEdit()))
 		m.statusBar.GetTag("Frequency").SetValue(strconv.Itoa(m.app.GetCurrentThreadFrequency()) + " edits")
 
 	case FocusBranches:
 		focusName = "Branches"
-		m.statusBar.GetTag("ID").SetValue("#" + strconv.Itoa(int(m.app.GetCurrentBranchID())))
+		m.statusBar.GetTag("ID").SetValue("#" + idLabel(m.app.GetCurrentBranchID()))
 		m.statusBar.GetTag("LastUpdated").SetValue(formatTimeAgo(m.app.GetCurrentBranchLastEdit()))
 		m.statusBar.GetTag("Frequency").SetValue(strconv.Itoa(m.app.GetCurrentBranchFrequency()) + " edits")
+		if name, branchID, noteID := m.app.GetCurrentBranchFork(); branchID != 0 {
+			if name == "" {
+				name = "#" + idLabel(branchID)
+			}
+			focusName += fmt.Sprintf(" · forked from %s at #%s", name, idLabel(noteID))
+		}
 
 	case FocusNotes:
 		focusName = "Notes"
-		m.statusBar.GetTag("ID").SetValue("#" + strconv.Itoa(int(m.app.GetCurrentNoteID())))
+		m.statusBar.GetTag("ID").SetValue("#" + idLabel(m.app.GetCurrentNoteID()))
 		m.statusBar.GetTag("LastUpdated").SetValue(formatTimeAgo(m.app.GetCurrentNoteLastEdit()))
 		m.statusBar.GetTag("Frequency").SetValue(strconv.Itoa(m.app.GetCurrentNoteFrequency()) + " edits")
 
@@ -611,9 +842,32 @@ func (m *Model) updateStatusBar() {
 		m.statusBar.GetTag("LastUpdated").SetValue("Editing...")
 	case FocusChangelog:
 		focusName = "Changelog"
+	case FocusSearch:
+		focusName = "Search"
+	case FocusGlobalSearch:
+		focusName = "Global search"
+	case FocusTrash:
+		focusName = "Trash"
+	case FocusTasks:
+		focusName = "Tasks"
+	case FocusDue:
+		focusName = "Due day"
+	case FocusUnlock:
+		focusName = "Unlock"
+	}
+	if m.isSearching(m.focus) {
+		focusName += " · " + m.app.ContextName(searchKind(m.focus))
 	}
 
 	m.statusBar.GetTag("filter").SetValue(focusName)
 	m.printSync()
 	m.statusBar.GetTag("Time").SetValue(time.Now().Format("15:04:05"))
 }
+
+// idLabel shows an ID, or "P" for a pending entity whose ID is temporary until the next sync.
+func idLabel(id uint) string {
+	if models.IsTempID(id) {
+		return "P"
+	}
+	return strconv.FormatUint(uint64(id), 10)
+}
@@ -0,0 +1,77 @@
+package ui
+
+import (
+	"fmt"
+
+	"github.com/haochend413/ntkpr/internal/models"
+)
+
+// move.go moves notes to other branches and adds them to more branches of their thread.
+// m marks the note under the cursor to be moved, b to be added to another branch; p then puts it into the active branch.
+
+// noteMark is a note picked up with m or b, and where it was.
+type noteMark struct {
+	threadID uint
+	branchID uint
+	noteID   uint
+	link     bool // add the note to the target branch instead of moving it there
+}
+
+// markNote picks up the note under the cursor. Marking the same note again drops it.
+func (m *Model) markNote(link bool) {
+	m.switchToNoteAtCursor(m.notesTable.Cursor())
+	noteID := m.app.GetCurrentNoteID()
+	if noteID == 0 {
+		return
+	}
+	if m.marked != nil && m.marked.noteID == noteID && m.marked.link == link {
+		m.marked = nil
+		m.statusBar.GetTag("Action").SetValue("Dropped the marked note")
+		m.updateStatusBar()
+		return
+	}
+	m.marked = &noteMark{
+		threadID: m.app.GetCurrentThreadID(),
+		branchID: m.app.GetCurrentBranchID(),
+		noteID:   noteID,
+		link:     link,
+	}
+	verb := "Moving"
+	if link {
+		verb = "Adding"
+	}
+	m.statusBar.GetTag("Action").SetValue(fmt.Sprintf("%s note #%s: select a branch and press p", verb, idLabel(noteID)))
+	m.updateStatusBar()
+}
+
+// putNote moves or adds the marked note into the active branch, and shows it there.
+func (m *Model) putNote() {
+	if m.marked == nil {
+		m.statusBar.GetTag("Action").SetValue("No note marked, press m or b on a note first")
+		m.updateStatusBar()
+		return
+	}
+	mark := *m.marked
+	to := m.app.GetCurrentBranchID()
+	link := &models.Superlink{ThreadID: int(mark.threadID), BranchID: int(mark.branchID), NoteID: int(mark.noteID)}
+
+	// the tables list everything again, so that they show the note where it went
+	m.resetSearch(FocusThreads)
+	m.resetSearch(FocusBranches)
+	m.resetSearch(FocusNotes)
+	apply, verb := m.app.MoveNote, "Moved"
+	if mark.link {
+		apply, verb = m.app.LinkNote, "Added"
+	}
+	if err := apply(mark.threadID, mark.branchID, mark.noteID, to, link); err != nil {
+		m.statusBar.GetTag("Action").SetValue(err.Error())
+		m.updateStatusBar()
+		return
+	}
+	m.marked = nil
+	m.syncCursors()
+	m.updateChangelogTable()
+	m.SetFocus(FocusNotes)
+	m.statusBar.GetTag("Action").SetValue(fmt.Sprintf("%s note #%s to branch #%s", verb, idLabel(mark.noteID), idLabel(to)))
+	m.updateStatusBar()
+}
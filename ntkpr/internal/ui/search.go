@@ -0,0 +1,271 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	tea "charm.land/bubbletea/v2"
+	"github.com/haochend413/bubbles/v2/table"
+	"github.com/haochend413/ntkpr/internal/app"
+	"github.com/haochend413/ntkpr/internal/app/context"
+	"github.com/haochend413/ntkpr/internal/db"
+	"github.com/haochend413/ntkpr/internal/ui/styles"
+)
+
+// search.go handles the search bar of the three tables, their saved searches, and global search.
+// A search switches the focused table into its Search context; "c" cycles through the saved searches and "A" brings it back to Default.
+// Global search lists hits from every thread in an overlay, and Enter jumps to the selected one.
+
+// searchKind returns the kind of entity listed by a table, or "" for other windows.
+func searchKind(focus FocusState) string {
+	switch focus {
+	case FocusThreads:
+		return db.SearchKindThread
+	case FocusBranches:
+		return db.SearchKindBranch
+	case FocusNotes:
+		return db.SearchKindNote
+	}
+	return ""
+}
+
+// isSearching reports whether the table at focus shows query results, from Search or a saved search.
+func (m *Model) isSearching(focus FocusState) bool {
+	kind := searchKind(focus)
+	return kind != "" && m.app.GetContext(kind).IsQuery()
+}
+
+// saveCursor stores the cursor of a table in its current context, before the context changes.
+func (m *Model) saveCursor(focus FocusState) {
+	switch focus {
+	case FocusThreads:
+		m.app.SetCursor(db.SearchKindThread, uint(max(0, m.threadsTable.Cursor())))
+	case FocusBranches:
+		m.app.SetCursor(db.SearchKindBranch, uint(max(0, m.branchesTable.Cursor())))
+	case FocusNotes:
+		m.app.SetCursor(db.SearchKindNote, uint(max(0, m.notesTable.Cursor())))
+	}
+}
+
+// nextContext moves the table at focus to its next context: Default, then each saved search for that table.
+// The cursor the table had in that context is restored.
+func (m *Model) nextContext(focus FocusState) {
+	kind := searchKind(focus)
+	if kind == "" {
+		return
+	}
+	contexts := m.app.ContextsFor(kind)
+	if len(contexts) < 2 && m.app.GetContext(kind) == context.Default {
+		m.statusBar.GetTag("Action").SetValue("No saved searches")
+		return
+	}
+	next := contexts[0]
+	current := m.app.GetContext(kind)
+	for i, c := range contexts {
+		if c == current {
+			next = contexts[(i+1)%len(contexts)]
+			break
+		}
+	}
+
+	m.saveCursor(focus)
+	cursor, err := m.app.SwitchContext(kind, next)
+	if err != nil {
+		m.statusBar.GetTag("Action").SetValue("Bad query: " + err.Error())
+		return
+	}
+	delete(m.searchQueries, focus)
+	m.refreshTableAt(focus, int(cursor))
+	m.statusBar.GetTag("Action").SetValue("Context: " + m.app.ContextName(kind))
+}
+
+// openSearch shows the search bar for target, which is a table or FocusGlobalSearch.
+func (m *Model) openSearch(target FocusState) tea.Cmd {
+	if target != FocusGlobalSearch && searchKind(target) == "" {
+		return nil
+	}
+	m.searchTarget = target
+	m.searchInput.SetValue(m.searchQueries[target])
+	m.searchInput.CursorEnd()
+	m.blurAllTables()
+	m.focus = FocusSearch
+	return m.searchInput.Focus()
+}
+
+// closeSearch hides the search bar and returns to the searched table.
+func (m *Model) closeSearch() {
+	m.searchInput.Blur()
+	if m.searchTarget == FocusGlobalSearch && len(m.globalHits) == 0 {
+		m.SetFocus(m.globalReturn)
+		return
+	}
+	m.SetFocus(m.searchTarget)
+}
+
+// submitSearch runs the query in the search bar. An empty query resets the table to Default.
+// The bar stays open when the query does not parse.
+func (m *Model) submitSearch() {
+	query := strings.TrimSpace(m.searchInput.Value())
+	target := m.searchTarget
+	if target == FocusGlobalSearch {
+		var hits []app.GlobalHit
+		if query != "" {
+			var err error
+			if hits, err = m.app.GlobalSearch(query); err != nil {
+				m.statusBar.GetTag("Action").SetValue("Bad query: " + err.Error())
+				return
+			}
+		}
+		m.searchQueries[target] = query
+		m.globalHits = hits
+		m.updateGlobalTable()
+		m.globalTable.SetCursor(0)
+		m.closeSearch()
+		m.statusBar.GetTag("Action").SetValue(fmt.Sprintf("Global search: %d hits", len(m.globalHits)))
+		return
+	}
+	if query == "" {
+		m.resetSearch(target)
+		m.closeSearch()
+		return
+	}
+
+	m.saveCursor(target)
+	if err := m.app.Search(searchKind(target), query); err != nil {
+		m.statusBar.GetTag("Action").SetValue("Bad query: " + err.Error())
+		return
+	}
+	m.searchQueries[target] = query
+	m.refreshTableAt(target, 0)
+	m.closeSearch()
+	m.statusBar.GetTag("Action").SetValue("Search: " + query)
+}
+
+// resetSearch switches a table back to its Default context, keeping the active item selected.
+func (m *Model) resetSearch(focus FocusState) {
+	if !m.isSearching(focus) {
+		return
+	}
+	m.saveCursor(focus)
+	delete(m.searchQueries, focus)
+	m.app.ResetContext(searchKind(focus))
+	m.refreshTableAt(focus, max(0, m.app.ActiveRow(searchKind(focus))))
+}
+
+// refreshTableAt re-renders a table, selects the item at cursor and cascades the selection to the tables below it.
+func (m *Model) refreshTableAt(focus FocusState, cursor int) {
+	switch focus {
+	case FocusThreads:
+		m.updateThreadsTable()
+		m.threadsTable.SetCursor(cursor)
+		m.switchToThreadAtCursor(m.threadsTable.Cursor())
+		m.refreshTableAt(FocusBranches, 0)
+	case FocusBranches:
+		m.updateBranchesTable()
+		m.branchesTable.SetCursor(cursor)
+		m.switchToBranchAtCursor(m.branchesTable.Cursor())
+		m.refreshTableAt(FocusNotes, 0)
+	case FocusNotes:
+		m.updateNotesTable()
+		m.notesTable.SetCursor(cursor)
+		m.switchToNoteAtCursor(m.notesTable.Cursor())
+	}
+}
+
+// jumpToGlobalHit selects the thread, branch and note of the hit under the global search cursor.
+func (m *Model) jumpToGlobalHit() {
+	cursor := m.globalTable.Cursor()
+	if cursor < 0 || cursor >= len(m.globalHits) {
+		return
+	}
+	hit := m.globalHits[cursor]
+
+	var branchID, noteID uint
+	target := FocusThreads
+	if hit.Branch != nil {
+		branchID = hit.Branch.ID
+		target = FocusBranches
+	}
+	if hit.Note != nil {
+		noteID = hit.Note.ID
+		target = FocusNotes
+	}
+	m.jumpTo(hit.Thread.ID, branchID, noteID)
+	m.SetFocus(target)
+}
+
+// jumpTo makes the given thread, branch and note active and moves the table cursors onto them.
+// Table searches are reset first, because the cursors follow the full lists.
+func (m *Model) jumpTo(threadID, branchID, noteID uint) {
+	m.resetSearch(FocusThreads)
+	m.resetSearch(FocusBranches)
+	m.resetSearch(FocusNotes)
+
+	dm := m.app.GetDataMgr()
+	dm.SwitchActiveThreadByID(threadID)
+	dm.SwitchActiveBranchByID(branchID)
+	dm.SwitchActiveNoteByID(noteID)
+
+	m.syncCursors()
+}
+
+// syncCursors re-renders the three tables and moves their cursors onto the active items.
+// A table that does not list its active item keeps its cursor, clamped to its rows.
+func (m *Model) syncCursors() {
+	m.updateThreadsTable()
+	m.updateBranchesTable()
+	m.updateNotesTable()
+	tables := []struct {
+		kind string
+		t    *table.Model
+	}{
+		{db.SearchKindThread, &m.threadsTable},
+		{db.SearchKindBranch, &m.branchesTable},
+		{db.SearchKindNote, &m.notesTable},
+	}
+	for _, x := range tables {
+		if row := m.app.ActiveRow(x.kind); row >= 0 {
+			x.t.SetCursor(row)
+		} else if n := len(x.t.Rows()); n > 0 {
+			x.t.SetCursor(min(x.t.Cursor(), n-1))
+		}
+	}
+}
+
+// reorderTable cycles the sort order of the table at focus, or flips its direction, keeping the active item selected.
+func (m *Model) reorderTable(focus FocusState, flip bool) {
+	kind := searchKind(focus)
+	if kind == "" {
+		return
+	}
+	var o context.SortOrder
+	if flip {
+		o = m.app.ToggleSortDirection(kind)
+	} else {
+		o = m.app.CycleSortOrder(kind)
+	}
+	m.syncCursors()
+	m.statusBar.GetTag("Action").SetValue("Order: " + o.String())
+}
+
+// renderSnippet turns a search snippet into a single table cell with the matches styled.
+func renderSnippet(snippet string) string {
+	snippet = strings.ReplaceAll(snippet, "\n", " ")
+	var sb strings.Builder
+	for {
+		start := strings.Index(snippet, db.MatchStart)
+		if start < 0 {
+			break
+		}
+		end := strings.Index(snippet[start:], db.MatchEnd)
+		if end < 0 {
+			break
+		}
+		end += start
+		sb.WriteString(snippet[:start])
+		sb.WriteString(styles.MatchStyle.Render(snippet[start+len(db.MatchStart) : end]))
+		snippet = snippet[end+len(db.MatchEnd):]
+	}
+	sb.WriteString(snippet)
+	return strings.NewReplacer(db.MatchStart, "", db.MatchEnd, "").Replace(sb.String())
+}
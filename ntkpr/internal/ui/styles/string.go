@@ -24,4 +24,6 @@ var (
 
 	HighlightFlagStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("190"))
 	PrivateflagStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("013"))
+	MatchStyle         = lipgloss.NewStyle().Foreground(lipgloss.Color("208")).Bold(true)
+	OverdueStyle       = lipgloss.NewStyle().Foreground(lipgloss.Color("196")).Bold(true)
 )
@@ -0,0 +1,97 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	tea "charm.land/bubbletea/v2"
+	"github.com/haochend413/lipgloss/v2"
+	"github.com/haochend413/ntkpr/internal/app"
+	"github.com/haochend413/ntkpr/internal/ui/styles"
+)
+
+// sync.go runs syncs without blocking the UI: on ctrl+q, every AutoSync.Interval seconds,
+// after AutoSync.Idle seconds without a keypress, and on leaving edit mode.
+// The database is written in a tea.Cmd, and the result comes back as a syncDoneMsg.
+// Failures open an error panel, which esc or enter dismisses.
+
+// syncDoneMsg reports that the database part of a sync has finished.
+type syncDoneMsg struct {
+	job    *app.SyncJob
+	manual bool // started by ctrl+q or quit, not by AutoSync
+}
+
+// startSync starts a sync, or returns nil when there is nothing to sync or a sync is running.
+// Manual syncs say so in the status bar.
+func (m *Model) startSync(manual bool) tea.Cmd {
+	job, err := m.app.StartSync()
+	if manual {
+		switch {
+		case errors.Is(err, app.ErrSyncRunning):
+			m.statusBar.GetTag("Action").SetValue("A sync is already running ...")
+		case job == nil:
+			m.statusBar.GetTag("Action").SetValue("Nothing to sync")
+		default:
+			m.statusBar.GetTag("Action").SetValue("Started Syncing ...")
+		}
+	}
+	m.printSync()
+	if err != nil || job == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		job.Run()
+		return syncDoneMsg{job: job, manual: manual}
+	}
+}
+
+// finishSync patches the result of a sync into the UI, and reports whether it succeeded.
+func (m *Model) finishSync(msg syncDoneMsg) bool {
+	m.lastSync = time.Now()
+	if err := m.app.FinishSync(msg.job); err != nil {
+		// background syncs retry on their own, only open the panel for a new failure
+		if msg.manual || m.syncErr == nil {
+			m.showSyncErr = true
+		}
+		m.syncErr = err
+		m.statusBar.GetTag("Action").SetValue("Sync failed: " + err.Error())
+		m.updateStatusBar()
+		return false
+	}
+	m.syncErr = nil
+	m.showSyncErr = false
+	// created items got their real IDs, which can move them in the tables
+	m.syncCursors()
+	m.updateChangelogTable()
+	res := msg.job.Result()
+	m.statusBar.GetTag("Action").SetValue(fmt.Sprintf("Synced: %d created, %d updated, %d deleted", res.Created, res.Updated, res.Deleted))
+	m.updateStatusBar()
+	return true
+}
+
+// autoSync starts a sync when one of the AutoSync triggers is due.
+func (m *Model) autoSync(now time.Time) tea.Cmd {
+	if m.Config == nil || m.viewMode != ApplicationView || m.app.Synced || m.app.Syncing() {
+		return nil
+	}
+	cfg := m.Config.AutoSync
+	interval := time.Duration(cfg.Interval) * time.Second
+	idle := time.Duration(cfg.Idle) * time.Second
+	switch {
+	case cfg.Interval > 0 && now.Sub(m.lastSync) >= interval:
+		return m.startSync(false)
+	// a failed sync waits a full idle period before the next try
+	case cfg.Idle > 0 && now.Sub(m.lastInput) >= idle && now.Sub(m.lastSync) >= idle:
+		return m.startSync(false)
+	}
+	return nil
+}
+
+// renderSyncErrorBox renders the panel with the failure of the last sync.
+func (m Model) renderSyncErrorBox() string {
+	width := max(20, min(80, m.width-8))
+	text := lipgloss.NewStyle().Width(width).Render(m.syncErr.Error()) +
+		"\n\nNothing was written. Your edits are kept for the next sync.\nesc / enter: dismiss"
+	return styles.FocusedStyle.BorderTitle("Sync failed").Render(text)
+}
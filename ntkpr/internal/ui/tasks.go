@@ -0,0 +1,209 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	tea "charm.land/bubbletea/v2"
+	"github.com/haochend413/bubbles/v2/table"
+	"github.com/haochend413/ntkpr/internal/app"
+	"github.com/haochend413/ntkpr/internal/models"
+	"github.com/haochend413/ntkpr/internal/ui/styles"
+	"github.com/haochend413/ntkpr/internal/vault"
+)
+
+// tasks.go handles notes used as tasks. In the notes table, s cycles the status of the note under the cursor
+// and d asks for its due day. t opens the open tasks of every thread in an overlay, the first due first,
+// with overdue ones marked; enter jumps to the selected task, s cycles its status, esc goes back.
+
+// dueLayout is how due days are shown and typed.
+const dueLayout = "2006-01-02"
+
+// taskMark shows the status of a note in the flags of the notes table, "" for a note that is not a task.
+// An overdue task gets a "!".
+func taskMark(n *models.Note, now time.Time) string {
+	var mark string
+	switch n.Status {
+	case models.TaskTodo:
+		mark = "[ ]"
+	case models.TaskDoing:
+		mark = "[~]"
+	case models.TaskDone:
+		mark = "[x]"
+	default:
+		return ""
+	}
+	if n.Overdue(now) {
+		mark += "!"
+	}
+	return mark
+}
+
+// cycleStatus moves the note under the cursor of the notes table to its next task status.
+func (m *Model) cycleStatus() {
+	m.switchToNoteAtCursor(m.notesTable.Cursor())
+	if m.app.GetCurrentNoteID() == 0 {
+		return
+	}
+	status := m.app.CycleCurrentNoteStatus(m.noteLink())
+	m.updateNotesTable()
+	m.updateChangelogTable()
+	m.statusBar.GetTag("Action").SetValue("Status: " + statusName(status))
+	m.updateStatusBar()
+}
+
+// noteLink links an edit to the current note.
+func (m *Model) noteLink() *models.Superlink {
+	return &models.Superlink{ThreadID: int(m.app.GetCurrentThreadID()), BranchID: int(m.app.GetCurrentBranchID()), NoteID: int(m.app.GetCurrentNoteID())}
+}
+
+// statusName names a task status for the status bar.
+func statusName(s models.TaskStatus) string {
+	if s == models.TaskNone {
+		return "not a task"
+	}
+	return string(s)
+}
+
+// openDue shows the due day prompt for the note under the cursor of the notes table, filled in with its due day.
+func (m *Model) openDue() tea.Cmd {
+	m.switchToNoteAtCursor(m.notesTable.Cursor())
+	if m.app.GetCurrentNoteID() == 0 {
+		return nil
+	}
+	m.dueInput.SetValue("")
+	if due := m.app.GetCurrentNoteDue(); due != nil {
+		m.dueInput.SetValue(due.Format(dueLayout))
+	}
+	m.dueInput.CursorEnd()
+	m.blurAllTables()
+	m.focus = FocusDue
+	return m.dueInput.Focus()
+}
+
+// submitDue sets the due day typed in the prompt, or clears it when the prompt is empty.
+// The prompt stays open when the day does not parse.
+func (m *Model) submitDue() {
+	due, err := app.ParseDue(m.dueInput.Value(), time.Now())
+	if err != nil {
+		m.statusBar.GetTag("Action").SetValue(err.Error())
+		return
+	}
+	m.app.SetCurrentNoteDue(due, m.noteLink())
+	m.closeDue()
+	m.updateNotesTable()
+	m.updateChangelogTable()
+	if due == nil {
+		m.statusBar.GetTag("Action").SetValue("Due day cleared")
+	} else {
+		m.statusBar.GetTag("Action").SetValue("Due " + due.Format(dueLayout))
+	}
+	m.updateStatusBar()
+}
+
+// closeDue hides the due day prompt and returns to the notes table.
+func (m *Model) closeDue() {
+	m.dueInput.Blur()
+	m.SetFocus(FocusNotes)
+}
+
+// openTasks reads the open tasks and shows them over the table at focus.
+func (m *Model) openTasks() {
+	if m.focus != FocusTasks {
+		m.tasksReturn = m.focus
+	}
+	tasks, err := m.app.Tasks()
+	if err != nil {
+		m.statusBar.GetTag("Action").SetValue("Error reading the tasks: " + err.Error())
+		m.updateStatusBar()
+		return
+	}
+	m.tasks = tasks
+	m.updateTasksTable()
+	m.tasksTable.SetCursor(min(m.tasksTable.Cursor(), max(0, len(tasks)-1)))
+	m.SetFocus(FocusTasks)
+	overdue := 0
+	for _, t := range tasks {
+		if t.Overdue {
+			overdue++
+		}
+	}
+	m.statusBar.GetTag("Action").SetValue(fmt.Sprintf("Tasks: %d open, %d overdue", len(tasks), overdue))
+	m.updateStatusBar()
+}
+
+// updateTasksTable renders the open tasks.
+func (m *Model) updateTasksTable() {
+	rows := make([]table.Row, len(m.tasks))
+	for i, t := range m.tasks {
+		due := "-"
+		if t.Note.Due != nil {
+			due = t.Note.Due.Format(dueLayout)
+		}
+		if t.Overdue {
+			due = styles.OverdueStyle.Render(due + " overdue")
+		}
+		branchName := "-"
+		if t.Branch != nil {
+			branchName = vault.Show(t.Branch.Name)
+		}
+		content := strings.ReplaceAll(vault.Show(t.Note.Content), "\n", " ")
+		if r := []rune(content); len(r) > 80 {
+			content = string(r[:77]) + "..."
+		}
+		rows[i] = table.Row{
+			string(t.Note.Status),
+			due,
+			vault.Show(t.Thread.Name),
+			branchName,
+			content,
+		}
+	}
+	m.tasksTable.SetRows(rows)
+}
+
+// jumpToTask selects the thread, branch and note of the task under the cursor.
+func (m *Model) jumpToTask() {
+	cursor := m.tasksTable.Cursor()
+	if cursor < 0 || cursor >= len(m.tasks) {
+		return
+	}
+	t := m.tasks[cursor]
+	if t.Branch == nil {
+		return
+	}
+	m.jumpTo(t.Thread.ID, t.Branch.ID, t.Note.ID)
+	m.SetFocus(FocusNotes)
+}
+
+// cycleTaskStatus moves the task under the cursor to its next status. A task that is done leaves the list.
+func (m *Model) cycleTaskStatus() {
+	cursor := m.tasksTable.Cursor()
+	if cursor < 0 || cursor >= len(m.tasks) {
+		return
+	}
+	t := m.tasks[cursor]
+	if t.Branch == nil {
+		return
+	}
+	m.jumpTo(t.Thread.ID, t.Branch.ID, t.Note.ID)
+	if m.app.GetCurrentNoteID() != t.Note.ID {
+		m.statusBar.GetTag("Action").SetValue(fmt.Sprintf("Note #%s is gone", idLabel(t.Note.ID)))
+		m.updateStatusBar()
+		return
+	}
+	status := m.app.CycleCurrentNoteStatus(m.noteLink())
+	m.updateNotesTable()
+	m.updateChangelogTable()
+	m.openTasks()
+	m.statusBar.GetTag("Action").SetValue(fmt.Sprintf("Note #%s: %s", idLabel(t.Note.ID), statusName(status)))
+	m.updateStatusBar()
+}
+
+func (m Model) renderTasksTableBox() string {
+	m.tasksTable.SetStyles(styles.FocusedTableStyle)
+	return styles.FocusedStyle.
+		BorderTitle(fmt.Sprintf("Tasks (%d)", len(m.tasks))).
+		Render(m.tasksTable.View())
+}
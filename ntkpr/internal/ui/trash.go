@@ -0,0 +1,117 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/haochend413/bubbles/v2/table"
+	"github.com/haochend413/ntkpr/internal/db"
+	"github.com/haochend413/ntkpr/internal/ui/styles"
+	"github.com/haochend413/ntkpr/internal/vault"
+)
+
+// trash.go shows the deleted threads, branches and notes in an overlay, opened with T.
+// enter or r restores the item under the cursor, x purges it for good after a y, esc goes back.
+
+// openTrash reads the trash and shows it over the table at focus.
+func (m *Model) openTrash() {
+	if m.focus != FocusTrash {
+		m.trashReturn = m.focus
+	}
+	items, err := m.app.Trash()
+	if err != nil {
+		m.statusBar.GetTag("Action").SetValue("Error reading the trash: " + err.Error())
+		m.updateStatusBar()
+		return
+	}
+	m.trashItems = items
+	m.purgeConfirm = false
+	m.updateTrashTable()
+	m.trashTable.SetCursor(min(m.trashTable.Cursor(), max(0, len(items)-1)))
+	m.SetFocus(FocusTrash)
+	m.statusBar.GetTag("Action").SetValue(fmt.Sprintf("Trash: %d items", len(items)))
+	m.updateStatusBar()
+}
+
+// updateTrashTable renders the items of the trash.
+func (m *Model) updateTrashTable() {
+	rows := make([]table.Row, len(m.trashItems))
+	for i, item := range m.trashItems {
+		title := strings.ReplaceAll(vault.Show(item.Title), "\n", " ")
+		if r := []rune(title); len(r) > 80 {
+			title = string(r[:77]) + "..."
+		}
+		rows[i] = table.Row{
+			item.Kind,
+			fmt.Sprintf("%d", item.ID),
+			item.DeletedAt.Format("2006-01-02 15:04"),
+			title,
+		}
+	}
+	m.trashTable.SetRows(rows)
+}
+
+// restoreTrashItem puts the item under the cursor back and shows it in the tables.
+func (m *Model) restoreTrashItem() {
+	cursor := m.trashTable.Cursor()
+	if cursor < 0 || cursor >= len(m.trashItems) {
+		return
+	}
+	item := m.trashItems[cursor]
+	// the tables list everything again, so that they show the restored item
+	m.resetSearch(FocusThreads)
+	m.resetSearch(FocusBranches)
+	m.resetSearch(FocusNotes)
+	if err := m.app.RestoreFromTrash(item); err != nil {
+		m.statusBar.GetTag("Action").SetValue(err.Error())
+		m.updateStatusBar()
+		return
+	}
+	m.syncCursors()
+	m.updateChangelogTable()
+	m.SetFocus(tableFocus(item.Kind))
+	m.statusBar.GetTag("Action").SetValue(fmt.Sprintf("Restored %s #%d", item.Kind, item.ID))
+	m.updateStatusBar()
+}
+
+// purgeTrashItem deletes the item under the cursor for good. The first call asks for a y, see purgeConfirm.
+func (m *Model) purgeTrashItem() {
+	cursor := m.trashTable.Cursor()
+	if cursor < 0 || cursor >= len(m.trashItems) {
+		return
+	}
+	item := m.trashItems[cursor]
+	if !m.purgeConfirm {
+		m.purgeConfirm = true
+		m.statusBar.GetTag("Action").SetValue(fmt.Sprintf("Purge %s #%d for good? y: purge, any other key: keep", item.Kind, item.ID))
+		m.updateStatusBar()
+		return
+	}
+	m.purgeConfirm = false
+	if err := m.app.PurgeFromTrash(item); err != nil {
+		m.statusBar.GetTag("Action").SetValue(err.Error())
+		m.updateStatusBar()
+		return
+	}
+	m.openTrash()
+	m.statusBar.GetTag("Action").SetValue(fmt.Sprintf("Purged %s #%d", item.Kind, item.ID))
+	m.updateStatusBar()
+}
+
+// tableFocus returns the table that lists entities of kind, the reverse of searchKind.
+func tableFocus(kind string) FocusState {
+	switch kind {
+	case db.SearchKindThread:
+		return FocusThreads
+	case db.SearchKindBranch:
+		return FocusBranches
+	}
+	return FocusNotes
+}
+
+func (m Model) renderTrashTableBox() string {
+	m.trashTable.SetStyles(styles.FocusedTableStyle)
+	return styles.FocusedStyle.
+		BorderTitle(fmt.Sprintf("Trash (%d)", len(m.trashItems))).
+		Render(m.trashTable.View())
+}
@@ -0,0 +1,22 @@
+package ui
+
+// undo takes back the last action with ctrl+z, or makes the last undone one again with ctrl+y,
+// and moves the tables onto the entity it touched.
+func (m *Model) undo(redo bool) {
+	apply, verb := m.app.Undo, "Undid: "
+	if redo {
+		apply, verb = m.app.Redo, "Redid: "
+	}
+	action, err := apply()
+	if err != nil {
+		m.statusBar.GetTag("Action").SetValue(err.Error())
+		m.updateStatusBar()
+		return
+	}
+	m.syncCursors()
+	m.SetFocus(m.focus)
+	m.updateChangelogTable()
+	m.updateRecentTable()
+	m.statusBar.GetTag("Action").SetValue(verb + action)
+	m.updateStatusBar()
+}
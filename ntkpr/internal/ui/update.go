@@ -1,6 +1,7 @@
 package ui
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/atotto/clipboard"
@@ -11,6 +12,7 @@ import (
 
 	// "github.com/haochend413/bubbles/table"
 	"github.com/haochend413/bubbles/v2/table"
+	"github.com/haochend413/ntkpr/internal/db"
 	"github.com/haochend413/ntkpr/internal/models"
 	"github.com/haochend413/ntkpr/sys"
 	// "github.com/haochend413/bubbles/key"
@@ -27,6 +29,9 @@ type globalKeyMap struct {
 	SwitchFocusWindow key.Binding
 	SyncWithDB        key.Binding
 	GetHelp           key.Binding
+	ReplayJournal     key.Binding
+	DiscardJournal    key.Binding
+	DismissError      key.Binding
 }
 
 var globalKeys = globalKeyMap{
@@ -36,6 +41,9 @@ var globalKeys = globalKeyMap{
 	SwitchFocusWindow: key.NewBinding(key.WithKeys("tab")),
 	SyncWithDB:        key.NewBinding(key.WithKeys("ctrl+q")),
 	GetHelp:           key.NewBinding(key.WithKeys("H")),
+	ReplayJournal:     key.NewBinding(key.WithKeys("r")),
+	DiscardJournal:    key.NewBinding(key.WithKeys("d")),
+	DismissError:      key.NewBinding(key.WithKeys("esc", "enter")),
 }
 
 // Table focus keys (for threads, branches, notes tables)
@@ -49,8 +57,31 @@ type tableKeyMap struct {
 	GoToEdit      key.Binding // Go directly to edit mode
 	ViewChangelog key.Binding // View changelog
 	ViewRecent    key.Binding // Toggle recent edits view
+	ViewHistory   key.Binding // Toggle revision history of the current note
+	Search        key.Binding // Open the search bar for the current table
+	ClearSearch   key.Binding // Go back from search results to the full list
+	GlobalSearch  key.Binding // Search every thread and branch
+	NextContext   key.Binding // Cycle through Default and the saved searches of the current table
+	SortOrder     key.Binding // Cycle the sort order of the current table
+	SortDirection key.Binding // Flip the current table between ascending and descending
 	UpTable       key.Binding // Move to table above (non-circular)
 	DownTable     key.Binding // Move to table below (non-circular)
+	Undo          key.Binding // Undo the last action
+	Redo          key.Binding // Redo the last undone action
+	ViewTrash     key.Binding // Open the trash
+	MarkMove      key.Binding // Mark the current note to be moved to another branch
+	MarkLink      key.Binding // Mark the current note to be added to another branch
+	PutNote       key.Binding // Move or add the marked note into the current branch
+	Fork          key.Binding // Fork the current branch at the current note
+	MergeBranch   key.Binding // Mark the current branch to be merged, or merge the marked branch into it
+	MoveNoteUp    key.Binding // Move the current note up in its branch
+	MoveNoteDown  key.Binding // Move the current note down in its branch
+	CycleStatus   key.Binding // Cycle the task status of the current note
+	Tick          key.Binding // Tick the first open checkbox of the current note
+	Untick        key.Binding // Untick the last ticked checkbox of the current note
+	SetDue        key.Binding // Set the due day of the current note
+	ViewTasks     key.Binding // Open the open tasks of every thread
+	Unlock        key.Binding // Enter the passphrase of private items
 }
 
 var tableKeys = tableKeyMap{
@@ -63,8 +94,31 @@ var tableKeys = tableKeyMap{
 	GoToEdit:      key.NewBinding(key.WithKeys("e", "ctrl+e")),
 	ViewChangelog: key.NewBinding(key.WithKeys("ctrl+l")),
 	ViewRecent:    key.NewBinding(key.WithKeys("R")),
+	ViewHistory:   key.NewBinding(key.WithKeys("v")),
+	Search:        key.NewBinding(key.WithKeys("/", "S")),
+	ClearSearch:   key.NewBinding(key.WithKeys("A")),
+	GlobalSearch:  key.NewBinding(key.WithKeys("ctrl+f")),
+	NextContext:   key.NewBinding(key.WithKeys("c")),
+	SortOrder:     key.NewBinding(key.WithKeys("o")),
+	SortDirection: key.NewBinding(key.WithKeys("O")),
 	UpTable:       key.NewBinding(key.WithKeys("l", "left")),
 	DownTable:     key.NewBinding(key.WithKeys("h", "right")),
+	Undo:          key.NewBinding(key.WithKeys("ctrl+z")),
+	Redo:          key.NewBinding(key.WithKeys("ctrl+y")),
+	ViewTrash:     key.NewBinding(key.WithKeys("T")),
+	MarkMove:      key.NewBinding(key.WithKeys("m")),
+	MarkLink:      key.NewBinding(key.WithKeys("b")),
+	PutNote:       key.NewBinding(key.WithKeys("p")),
+	Fork:          key.NewBinding(key.WithKeys("f")),
+	MergeBranch:   key.NewBinding(key.WithKeys("M")),
+	MoveNoteUp:    key.NewBinding(key.WithKeys("K")),
+	MoveNoteDown:  key.NewBinding(key.WithKeys("J")),
+	CycleStatus:   key.NewBinding(key.WithKeys("s")),
+	Tick:          key.NewBinding(key.WithKeys("x")),
+	Untick:        key.NewBinding(key.WithKeys("X")),
+	SetDue:        key.NewBinding(key.WithKeys("d")),
+	ViewTasks:     key.NewBinding(key.WithKeys("t")),
+	Unlock:        key.NewBinding(key.WithKeys("U")),
 }
 
 type recentKeyMap struct {
@@ -75,6 +129,56 @@ var recentKeys = recentKeyMap{
 	GotoDiff: key.NewBinding(key.WithKeys("D")),
 }
 
+// History and diff keys
+type historyKeyMap struct {
+	Restore key.Binding // Restore the selected revision into the current note
+	Older   key.Binding // Show the next older revision in the diff view
+	Newer   key.Binding // Show the next newer revision in the diff view
+}
+
+var historyKeys = historyKeyMap{
+	Restore: key.NewBinding(key.WithKeys("ctrl+r")),
+	Older:   key.NewBinding(key.WithKeys("[")),
+	Newer:   key.NewBinding(key.WithKeys("]")),
+}
+
+// Trash keys
+type trashKeyMap struct {
+	Restore      key.Binding // Put the selected item back
+	Purge        key.Binding // Delete the selected item for good, after ConfirmPurge
+	ConfirmPurge key.Binding
+}
+
+var trashKeys = trashKeyMap{
+	Restore:      key.NewBinding(key.WithKeys("enter", "r")),
+	Purge:        key.NewBinding(key.WithKeys("x")),
+	ConfirmPurge: key.NewBinding(key.WithKeys("y")),
+}
+
+// Merge prompt keys, see markMerge
+type mergeKeyMap struct {
+	Keep    key.Binding // Leave the merged branch as it was
+	Archive key.Binding // Archive the merged branch
+	Delete  key.Binding // Delete the merged branch
+}
+
+var mergeKeys = mergeKeyMap{
+	Keep:    key.NewBinding(key.WithKeys("k")),
+	Archive: key.NewBinding(key.WithKeys("a")),
+	Delete:  key.NewBinding(key.WithKeys("d")),
+}
+
+// Search bar keys
+type searchKeyMap struct {
+	Submit key.Binding
+	Cancel key.Binding
+}
+
+var searchKeys = searchKeyMap{
+	Submit: key.NewBinding(key.WithKeys("enter")),
+	Cancel: key.NewBinding(key.WithKeys("esc")),
+}
+
 // Edit focus keys
 type editKeyMap struct {
 	SaveAndReturn key.Binding
@@ -117,6 +221,26 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.app.SetCurrentThreadLastEdit()
 				m.app.IncrementCurrentThreadFrequency(nil)
 			}
+			if m.Config != nil && m.Config.AutoSync.OnExitEdit {
+				return m, m.startSync(false)
+			}
+		}
+		return m, nil
+	case syncDoneMsg:
+		ok := m.finishSync(msg)
+		if m.quitting {
+			if !ok {
+				// stay, so the edits are not lost with the failed sync
+				m.quitting = false
+				m.viewMode = ApplicationView
+				m.showSyncErr = true
+				return m, nil
+			}
+			// quit was confirmed while this sync ran, sync what came after it and leave
+			if cmd := m.startSync(true); cmd != nil {
+				return m, cmd
+			}
+			return m, tea.Quit
 		}
 		return m, nil
 	case tickMsg:
@@ -142,7 +266,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 
 		m.statusBar.GetTag("LastUpdated").SetValue(formatTimeAgo(lastUpdated))
-		return m, tick()
+		return m, tea.Batch(tick(), m.autoSync(time.Time(msg)))
 
 	case tea.WindowSizeMsg:
 		m.width = msg.Width
@@ -165,8 +289,9 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		timeWidth := max(8, int(float64(tableWidth)*0.22))
 		flagWidth := max(4, int(float64(tableWidth)*0.15))
 		CountWidth := max(4, int(float64(tableWidth)*0.07))
-		nameWidth := max(10, int(float64(tableWidth)*0.51))
-		contentWidth := max(10, int(float64(tableWidth)*0.58))
+		checksWidth := max(5, int(float64(tableWidth)*0.07))
+		nameWidth := max(10, int(float64(tableWidth)*0.44))
+		contentWidth := max(10, int(float64(tableWidth)*0.51))
 
 		// Separate column definitions for threads, branches (Name), and notes (Content)
 		threadColumns := []table.Column{
@@ -174,6 +299,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			{Title: "Time", Width: timeWidth},
 			{Title: "Name", Width: nameWidth},
 			{Title: "#Bs", Width: CountWidth},
+			{Title: "Done", Width: checksWidth},
 			{Title: "Flags", Width: flagWidth},
 		}
 
@@ -182,6 +308,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			{Title: "Time", Width: timeWidth},
 			{Title: "Name", Width: nameWidth},
 			{Title: "#Ns", Width: CountWidth},
+			{Title: "Done", Width: checksWidth},
 			{Title: "Flags", Width: flagWidth},
 		}
 
@@ -189,6 +316,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			{Title: "ID", Width: idWidth},
 			{Title: "Time", Width: timeWidth},
 			{Title: "Content", Width: contentWidth},
+			{Title: "Done", Width: checksWidth},
 			{Title: "Flags", Width: flagWidth},
 		}
 
@@ -214,6 +342,42 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.notesTable.SetWidth(tableWidth)
 		m.recentTable.SetColumns(recentColumns)
 		m.recentTable.SetWidth(recentTableWidth)
+
+		historyColumns := []table.Column{
+			{Title: "Rev", Width: max(6, int(float64(m.width)*0.05))},
+			{Title: "Time", Width: max(16, int(float64(m.width)*0.12))},
+			{Title: "Content", Width: max(20, int(float64(m.width)*0.31))},
+		}
+		m.historyTable.SetColumns(historyColumns)
+		m.historyTable.SetWidth(recentTableWidth)
+
+		globalColumns := []table.Column{
+			{Title: "Thread", Width: max(20, int(float64(m.width)*0.12))},
+			{Title: "Branch", Width: max(20, int(float64(m.width)*0.10))},
+			{Title: "Note", Width: max(6, int(float64(m.width)*0.05))},
+			{Title: "Match", Width: max(20, int(float64(m.width)*0.27))},
+		}
+		m.globalTable.SetColumns(globalColumns)
+		m.globalTable.SetWidth(recentTableWidth)
+
+		trashColumns := []table.Column{
+			{Title: "Kind", Width: max(6, int(float64(m.width)*0.05))},
+			{Title: "ID", Width: max(4, int(float64(m.width)*0.04))},
+			{Title: "Deleted", Width: max(16, int(float64(m.width)*0.10))},
+			{Title: "Title", Width: max(20, int(float64(m.width)*0.35))},
+		}
+		m.trashTable.SetColumns(trashColumns)
+		m.trashTable.SetWidth(recentTableWidth)
+
+		tasksColumns := []table.Column{
+			{Title: "Status", Width: max(6, int(float64(m.width)*0.04))},
+			{Title: "Due", Width: max(18, int(float64(m.width)*0.10))},
+			{Title: "Thread", Width: max(12, int(float64(m.width)*0.08))},
+			{Title: "Branch", Width: max(12, int(float64(m.width)*0.08))},
+			{Title: "Note", Width: max(20, int(float64(m.width)*0.24))},
+		}
+		m.tasksTable.SetColumns(tasksColumns)
+		m.tasksTable.SetWidth(recentTableWidth)
 		m.diffView.SetWidth(recentTableWidth / 2)
 
 		// Height calculations
@@ -228,6 +392,10 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.branchesTable.SetHeight(standard_branch_height)
 		m.notesTable.SetHeight(standard_notes_height + 2)
 		m.recentTable.SetHeight(standard_notes_height)
+		m.historyTable.SetHeight(standard_notes_height)
+		m.globalTable.SetHeight(standard_notes_height)
+		m.trashTable.SetHeight(standard_notes_height)
+		m.tasksTable.SetHeight(standard_notes_height)
 		m.diffView.SetHeight(standard_notes_height)
 
 		// Textarea takes most of right side
@@ -248,6 +416,14 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.changeTable.SetHeight(changeTableHeight)
 
 	case tea.KeyMsg:
+		m.lastInput = time.Now()
+		if m.showSyncErr && m.viewMode == ApplicationView {
+			// the error panel takes the keys until it is dismissed
+			if key.Matches(msg, globalKeys.DismissError) {
+				m.showSyncErr = false
+			}
+			return m, nil
+		}
 		// update statusbar
 		m.statusBar.GetTag("Action").SetValue("Keypress: " + msg.String())
 		// Handle global keys first
@@ -261,17 +437,40 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				return m, nil
 
 			case key.Matches(msg, globalKeys.SyncWithDB):
-				m.statusBar.GetTag("Action").SetValue("Started Syncing ...")
-				m.updateStatusBar()
-				m.app.SyncWithDatabase()
-				m.statusBar.GetTag("Action").SetValue("Updating UI ...")
-				m.updateStatusBar()
-				m.updateThreadsTable()
-				m.updateBranchesTable()
-				m.updateNotesTable()
-				m.updateChangelogTable()
-				m.statusBar.GetTag("Action").SetValue("Synced with database!")
+				cmd := m.startSync(true)
 				m.updateStatusBar()
+				return m, cmd
+
+			case searchKind(m.focus) != "" && key.Matches(msg, tableKeys.Undo, tableKeys.Redo):
+				m.undo(key.Matches(msg, tableKeys.Redo))
+				return m, nil
+
+			case searchKind(m.focus) != "" && key.Matches(msg, tableKeys.ViewTrash):
+				m.openTrash()
+				return m, nil
+
+			case searchKind(m.focus) != "" && key.Matches(msg, tableKeys.ViewTasks):
+				m.openTasks()
+				return m, nil
+
+			case searchKind(m.focus) != "" && key.Matches(msg, tableKeys.Unlock):
+				cmd1 := m.openUnlock()
+				return m, cmd1
+
+			case m.focus == FocusTrash && m.purgeConfirm:
+				// the purge prompt takes the next key, see purgeTrashItem
+				if key.Matches(msg, trashKeys.ConfirmPurge) {
+					m.purgeTrashItem()
+				} else {
+					m.purgeConfirm = false
+					m.statusBar.GetTag("Action").SetValue("Purge cancelled")
+					m.updateStatusBar()
+				}
+				return m, nil
+
+			case m.focus == FocusBranches && m.mergePrompt:
+				// the merge prompt takes the next key, see markMerge
+				m.answerMerge(msg)
 				return m, nil
 
 			case key.Matches(msg, globalKeys.SwitchFocusWindow):
@@ -295,61 +494,20 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				case key.Matches(msg, tableKeys.Select):
 					cursor := m.threadsTable.Cursor()
 					m.switchToThreadAtCursor(cursor)
-					m.updateBranchesTable()
-					m.branchesTable.SetCursor(0)
-					m.updateNotesTable()
-					m.notesTable.SetCursor(0)
+					m.refreshTableAt(FocusBranches, 0)
 					m.SetFocus(FocusBranches)
 					return m, nil
 
 				case key.Matches(msg, tableKeys.CreateNew):
-					m.app.CreateNewThread(nil)
-					m.updateThreadsTable()
-					lastIdx := len(m.app.GetThreadList()) - 1
-					if lastIdx >= 0 {
-						m.threadsTable.SetCursor(lastIdx)
-					}
-					cursor := m.threadsTable.Cursor()
-					m.switchToThreadAtCursor(cursor)
-					m.updateBranchesTable()
-					m.branchesTable.SetCursor(0)
-					m.updateNotesTable()
-					m.notesTable.SetCursor(0)
+					m.resetSearch(FocusThreads)
+					id := m.app.CreateNewThread(nil)
+					m.refreshTableAt(FocusThreads, max(0, m.app.RowOf(db.SearchKindThread, id)))
 					m.SetFocus(FocusThreads)
 					return m, nil
 
 				case key.Matches(msg, tableKeys.Delete):
 					m.app.DeleteCurrentThread(nil)
-					m.updateThreadsTable()
-					m.updateBranchesTable()
-					m.updateNotesTable()
-					threadRows := m.threadsTable.Rows()
-					branchRows := m.branchesTable.Rows()
-					noteRows := m.notesTable.Rows()
-					prevThreadCursor := m.threadsTable.Cursor()
-					prevBranchCursor := m.branchesTable.Cursor()
-					prevNoteCursor := m.notesTable.Cursor()
-					if len(threadRows) > 0 {
-						if prevThreadCursor < len(threadRows) {
-							m.threadsTable.SetCursor(prevThreadCursor)
-						} else {
-							m.threadsTable.SetCursor(len(threadRows) - 1)
-						}
-					}
-					if len(branchRows) > 0 {
-						if prevBranchCursor < len(branchRows) {
-							m.branchesTable.SetCursor(prevBranchCursor)
-						} else {
-							m.branchesTable.SetCursor(len(branchRows) - 1)
-						}
-					}
-					if len(noteRows) > 0 {
-						if prevNoteCursor < len(noteRows) {
-							m.notesTable.SetCursor(prevNoteCursor)
-						} else {
-							m.notesTable.SetCursor(len(noteRows) - 1)
-						}
-					}
+					m.syncCursors()
 					m.SetFocus(FocusThreads)
 					return m, nil
 
@@ -361,6 +519,33 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					m.SetFocus(FocusBranches)
 					return m, nil
 
+				case key.Matches(msg, tableKeys.Search):
+					cmd1 := m.openSearch(m.focus)
+					return m, cmd1
+
+				case key.Matches(msg, tableKeys.GlobalSearch):
+					m.globalReturn = m.focus
+					cmd1 := m.openSearch(FocusGlobalSearch)
+					return m, cmd1
+
+				case key.Matches(msg, tableKeys.ClearSearch):
+					m.resetSearch(m.focus)
+					m.SetFocus(m.focus)
+					return m, nil
+
+				case key.Matches(msg, tableKeys.NextContext):
+					m.nextContext(m.focus)
+					m.SetFocus(m.focus)
+					return m, nil
+
+				case key.Matches(msg, tableKeys.SortOrder):
+					m.reorderTable(m.focus, false)
+					return m, nil
+
+				case key.Matches(msg, tableKeys.SortDirection):
+					m.reorderTable(m.focus, true)
+					return m, nil
+
 				case key.Matches(msg, tableKeys.ViewRecent):
 					m.SetFocus(FocusRecent)
 					return m, nil
@@ -371,8 +556,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				case key.Matches(msg, tableKeys.Select):
 					cursor := m.branchesTable.Cursor()
 					m.switchToBranchAtCursor(cursor)
-					m.updateNotesTable()
-					m.notesTable.SetCursor(0)
+					m.refreshTableAt(FocusNotes, 0)
 					m.SetFocus(FocusNotes)
 					return m, nil
 
@@ -381,50 +565,15 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					return m, nil
 
 				case key.Matches(msg, tableKeys.CreateNew):
-					m.app.CreateNewBranch(nil)
-					m.updateBranchesTable()
-					lastIdx := len(m.app.GetActiveBranchList()) - 1
-					if lastIdx >= 0 {
-						m.branchesTable.SetCursor(lastIdx)
-					}
-					cursor := m.branchesTable.Cursor()
-					m.switchToBranchAtCursor(cursor)
-					m.updateNotesTable()
-					m.notesTable.SetCursor(0)
+					m.resetSearch(FocusBranches)
+					id := m.app.CreateNewBranch(nil)
+					m.refreshTableAt(FocusBranches, max(0, m.app.RowOf(db.SearchKindBranch, id)))
 					m.SetFocus(FocusBranches)
 					return m, nil
 
 				case key.Matches(msg, tableKeys.Delete):
 					m.app.DeleteCurrentBranch(nil)
-					m.updateBranchesTable()
-					m.updateNotesTable()
-					threadRows := m.threadsTable.Rows()
-					branchRows := m.branchesTable.Rows()
-					noteRows := m.notesTable.Rows()
-					prevThreadCursor := m.threadsTable.Cursor()
-					prevBranchCursor := m.branchesTable.Cursor()
-					prevNoteCursor := m.notesTable.Cursor()
-					if len(threadRows) > 0 {
-						if prevThreadCursor < len(threadRows) {
-							m.threadsTable.SetCursor(prevThreadCursor)
-						} else {
-							m.threadsTable.SetCursor(len(threadRows) - 1)
-						}
-					}
-					if len(branchRows) > 0 {
-						if prevBranchCursor < len(branchRows) {
-							m.branchesTable.SetCursor(prevBranchCursor)
-						} else {
-							m.branchesTable.SetCursor(len(branchRows) - 1)
-						}
-					}
-					if len(noteRows) > 0 {
-						if prevNoteCursor < len(noteRows) {
-							m.notesTable.SetCursor(prevNoteCursor)
-						} else {
-							m.notesTable.SetCursor(len(noteRows) - 1)
-						}
-					}
+					m.syncCursors()
 					m.SetFocus(FocusBranches)
 					return m, nil
 
@@ -432,6 +581,15 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					m.EnterEdit(FocusBranches)
 					return m, nil
 
+				case key.Matches(msg, tableKeys.PutNote):
+					m.switchToBranchAtCursor(m.branchesTable.Cursor())
+					m.putNote()
+					return m, nil
+
+				case key.Matches(msg, tableKeys.MergeBranch):
+					m.markMerge()
+					return m, nil
+
 				case key.Matches(msg, tableKeys.UpTable):
 					m.SetFocus(FocusThreads)
 					return m, nil
@@ -440,6 +598,33 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					m.SetFocus(FocusNotes)
 					return m, nil
 
+				case key.Matches(msg, tableKeys.Search):
+					cmd1 := m.openSearch(m.focus)
+					return m, cmd1
+
+				case key.Matches(msg, tableKeys.GlobalSearch):
+					m.globalReturn = m.focus
+					cmd1 := m.openSearch(FocusGlobalSearch)
+					return m, cmd1
+
+				case key.Matches(msg, tableKeys.ClearSearch):
+					m.resetSearch(m.focus)
+					m.SetFocus(m.focus)
+					return m, nil
+
+				case key.Matches(msg, tableKeys.NextContext):
+					m.nextContext(m.focus)
+					m.SetFocus(m.focus)
+					return m, nil
+
+				case key.Matches(msg, tableKeys.SortOrder):
+					m.reorderTable(m.focus, false)
+					return m, nil
+
+				case key.Matches(msg, tableKeys.SortDirection):
+					m.reorderTable(m.focus, true)
+					return m, nil
+
 				case key.Matches(msg, tableKeys.ViewRecent):
 					m.SetFocus(FocusRecent)
 					return m, nil
@@ -459,49 +644,15 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					return m, nil
 
 				case key.Matches(msg, tableKeys.CreateNew):
-
-					m.app.CreateNewNote(nil) // let's not track create for now.
-					m.updateNotesTable()
-					lastIdx := len(m.app.GetActiveNoteList()) - 1
-					if lastIdx >= 0 {
-						m.notesTable.SetCursor(lastIdx)
-					}
-					cursor := m.notesTable.Cursor()
-					m.switchToNoteAtCursor(cursor)
+					m.resetSearch(FocusNotes)
+					id := m.app.CreateNewNote(nil) // let's not track create for now.
+					m.refreshTableAt(FocusNotes, max(0, m.app.RowOf(db.SearchKindNote, id)))
 					m.SetFocus(FocusNotes)
-					m.updateNotesTable()
 					return m, nil
 
 				case key.Matches(msg, tableKeys.Delete):
 					m.app.DeleteCurrentNote(nil) // also not delete
-					m.updateNotesTable()
-					threadRows := m.threadsTable.Rows()
-					branchRows := m.branchesTable.Rows()
-					noteRows := m.notesTable.Rows()
-					prevThreadCursor := m.threadsTable.Cursor()
-					prevBranchCursor := m.branchesTable.Cursor()
-					prevNoteCursor := m.notesTable.Cursor()
-					if len(threadRows) > 0 {
-						if prevThreadCursor < len(threadRows) {
-							m.threadsTable.SetCursor(prevThreadCursor)
-						} else {
-							m.threadsTable.SetCursor(len(threadRows) - 1)
-						}
-					}
-					if len(branchRows) > 0 {
-						if prevBranchCursor < len(branchRows) {
-							m.branchesTable.SetCursor(prevBranchCursor)
-						} else {
-							m.branchesTable.SetCursor(len(branchRows) - 1)
-						}
-					}
-					if len(noteRows) > 0 {
-						if prevNoteCursor < len(noteRows) {
-							m.notesTable.SetCursor(prevNoteCursor)
-						} else {
-							m.notesTable.SetCursor(len(noteRows) - 1)
-						}
-					}
+					m.syncCursors()
 					m.SetFocus(FocusNotes)
 					return m, nil
 
@@ -511,10 +662,70 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					return m, nil
 
 				case key.Matches(msg, tableKeys.Privatize):
+					if m.app.Locked() {
+						cmd1 := m.openUnlock()
+						m.statusBar.GetTag("Action").SetValue("Locked: enter the passphrase to make notes private or not")
+						m.updateStatusBar()
+						return m, cmd1
+					}
 					m.app.ToggleCurrentNotePrivate(&curr_spl)
 					m.updateNotesTable()
 					return m, nil
 
+				case key.Matches(msg, tableKeys.MarkMove), key.Matches(msg, tableKeys.MarkLink):
+					m.markNote(key.Matches(msg, tableKeys.MarkLink))
+					return m, nil
+
+				case key.Matches(msg, tableKeys.PutNote):
+					m.putNote()
+					return m, nil
+
+				case key.Matches(msg, tableKeys.Fork):
+					m.switchToNoteAtCursor(m.notesTable.Cursor())
+					m.resetSearch(FocusBranches)
+					m.resetSearch(FocusNotes)
+					noteID := m.app.GetCurrentNoteID()
+					if m.app.ForkCurrentBranch(&curr_spl) == 0 {
+						return m, nil
+					}
+					m.syncCursors()
+					m.updateChangelogTable()
+					m.SetFocus(FocusNotes)
+					m.statusBar.GetTag("Action").SetValue("Forked the branch at note #" + idLabel(noteID))
+					m.updateStatusBar()
+					return m, nil
+
+				case key.Matches(msg, tableKeys.CycleStatus):
+					m.cycleStatus()
+					return m, nil
+
+				case key.Matches(msg, tableKeys.Tick), key.Matches(msg, tableKeys.Untick):
+					m.tick(key.Matches(msg, tableKeys.Tick))
+					return m, nil
+
+				case key.Matches(msg, tableKeys.SetDue):
+					cmd1 := m.openDue()
+					return m, cmd1
+
+				case key.Matches(msg, tableKeys.MoveNoteUp), key.Matches(msg, tableKeys.MoveNoteDown):
+					m.switchToNoteAtCursor(m.notesTable.Cursor())
+					up := key.Matches(msg, tableKeys.MoveNoteUp)
+					if err := m.app.MoveCurrentNote(up, &curr_spl); err != nil {
+						m.statusBar.GetTag("Action").SetValue(err.Error())
+						m.updateStatusBar()
+						return m, nil
+					}
+					m.syncCursors()
+					m.updateChangelogTable()
+					m.SetFocus(FocusNotes)
+					direction := "down"
+					if up {
+						direction = "up"
+					}
+					m.statusBar.GetTag("Action").SetValue(fmt.Sprintf("Moved note #%s %s, Order: Manual", idLabel(m.app.GetCurrentNoteID()), direction))
+					m.updateStatusBar()
+					return m, nil
+
 				case key.Matches(msg, tableKeys.GoToEdit):
 					cursor := m.notesTable.Cursor()
 					m.switchToNoteAtCursor(cursor)
@@ -526,11 +737,49 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					m.SetFocus(FocusChangelog)
 					return m, nil
 
+				case key.Matches(msg, tableKeys.ViewHistory):
+					cursor := m.notesTable.Cursor()
+					m.switchToNoteAtCursor(cursor)
+					m.updateHistoryTable()
+					m.historyTable.SetCursor(0)
+					m.updateRevisionArea()
+					m.diffSource = FocusHistory
+					m.SetFocus(FocusHistory)
+					return m, nil
+
 				case key.Matches(msg, tableKeys.UpTable):
 					m.SetFocus(FocusBranches)
 					return m, nil
 
+				case key.Matches(msg, tableKeys.Search):
+					cmd1 := m.openSearch(m.focus)
+					return m, cmd1
+
+				case key.Matches(msg, tableKeys.GlobalSearch):
+					m.globalReturn = m.focus
+					cmd1 := m.openSearch(FocusGlobalSearch)
+					return m, cmd1
+
+				case key.Matches(msg, tableKeys.ClearSearch):
+					m.resetSearch(m.focus)
+					m.SetFocus(m.focus)
+					return m, nil
+
+				case key.Matches(msg, tableKeys.NextContext):
+					m.nextContext(m.focus)
+					m.SetFocus(m.focus)
+					return m, nil
+
+				case key.Matches(msg, tableKeys.SortOrder):
+					m.reorderTable(m.focus, false)
+					return m, nil
+
+				case key.Matches(msg, tableKeys.SortDirection):
+					m.reorderTable(m.focus, true)
+					return m, nil
+
 				case key.Matches(msg, tableKeys.ViewRecent):
+					m.diffSource = FocusRecent
 					m.SetFocus(FocusRecent)
 					noteEdits := m.app.GetNoteEditStack()
 					cursor := m.recentTable.Cursor()
@@ -556,17 +805,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 					idx := len(noteEdits) - 1 - cursor
 					noteEdit := noteEdits[idx]
-					m.app.GetDataMgr().SwitchActiveThreadByID(uint(noteEdit.Link.ThreadID))
-					m.app.GetDataMgr().SwitchActiveBranchByID(uint(noteEdit.Link.BranchID))
-					m.app.GetDataMgr().SwitchActiveNoteByID(uint(noteEdit.Link.NoteID))
-					// re-render
-					m.updateThreadsTable()
-					m.updateBranchesTable()
-					m.updateNotesTable()
-
-					m.threadsTable.SetCursor(m.app.GetDataMgr().GetActiveThreadPtr())
-					m.branchesTable.SetCursor(m.app.GetDataMgr().GetActiveBranchPtr())
-					m.notesTable.SetCursor(m.app.GetDataMgr().GetActiveNotePtr())
+					m.jumpTo(uint(noteEdit.Link.ThreadID), uint(noteEdit.Link.BranchID), uint(noteEdit.Link.NoteID))
 
 					m.updateStatusBar()
 					// switch focus
@@ -584,11 +823,116 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					return m, nil
 				}
 
+			case FocusHistory:
+				switch {
+				case key.Matches(msg, tableKeys.ViewHistory), key.Matches(msg, tableKeys.Back):
+					m.SetFocus(FocusNotes)
+					return m, nil
+				case key.Matches(msg, recentKeys.GotoDiff):
+					m.SetFocus(FocusDiff)
+					return m, nil
+				case key.Matches(msg, historyKeys.Restore):
+					cmd1 := m.restoreSelectedRevision(curr_spl)
+					return m, cmd1
+				}
+
 			case FocusDiff:
 				switch {
 				case key.Matches(msg, tableKeys.ViewRecent):
+					m.diffSource = FocusRecent
 					m.SetFocus(FocusRecent)
 					return m, nil
+				case key.Matches(msg, tableKeys.ViewHistory), key.Matches(msg, tableKeys.Back):
+					if m.diffSource == FocusHistory {
+						m.SetFocus(FocusHistory)
+						return m, nil
+					}
+				case key.Matches(msg, historyKeys.Older):
+					if m.diffSource == FocusHistory && m.historyTable.Cursor() < len(m.revisions)-1 {
+						m.historyTable.SetCursor(m.historyTable.Cursor() + 1)
+						m.updateRevisionArea()
+					}
+					return m, nil
+				case key.Matches(msg, historyKeys.Newer):
+					if m.diffSource == FocusHistory && m.historyTable.Cursor() > 0 {
+						m.historyTable.SetCursor(m.historyTable.Cursor() - 1)
+						m.updateRevisionArea()
+					}
+					return m, nil
+				case key.Matches(msg, historyKeys.Restore):
+					if m.diffSource == FocusHistory {
+						cmd1 := m.restoreSelectedRevision(curr_spl)
+						return m, cmd1
+					}
+				}
+
+			case FocusSearch:
+				switch {
+				case key.Matches(msg, searchKeys.Submit):
+					m.submitSearch()
+					return m, nil
+				case key.Matches(msg, searchKeys.Cancel):
+					m.closeSearch()
+					return m, nil
+				}
+
+			case FocusGlobalSearch:
+				switch {
+				case key.Matches(msg, tableKeys.Select):
+					m.jumpToGlobalHit()
+					return m, nil
+				case key.Matches(msg, tableKeys.Back):
+					m.SetFocus(m.globalReturn)
+					return m, nil
+				case key.Matches(msg, tableKeys.Search):
+					cmd1 := m.openSearch(FocusGlobalSearch)
+					return m, cmd1
+				}
+
+			case FocusDue:
+				switch {
+				case key.Matches(msg, searchKeys.Submit):
+					m.submitDue()
+					return m, nil
+				case key.Matches(msg, searchKeys.Cancel):
+					m.closeDue()
+					return m, nil
+				}
+
+			case FocusUnlock:
+				switch {
+				case key.Matches(msg, searchKeys.Submit):
+					m.submitUnlock()
+					return m, nil
+				case key.Matches(msg, searchKeys.Cancel):
+					m.closeUnlock()
+					return m, nil
+				}
+
+			case FocusTasks:
+				switch {
+				case key.Matches(msg, tableKeys.Select):
+					m.jumpToTask()
+					return m, nil
+				case key.Matches(msg, tableKeys.CycleStatus):
+					m.cycleTaskStatus()
+					return m, nil
+				case key.Matches(msg, tableKeys.Back):
+					m.SetFocus(m.tasksReturn)
+					return m, nil
+				}
+
+			case FocusTrash:
+				switch {
+				case key.Matches(msg, trashKeys.Restore):
+					m.restoreTrashItem()
+					return m, nil
+				case key.Matches(msg, trashKeys.Purge):
+					m.purgeTrashItem()
+					return m, nil
+				case key.Matches(msg, tableKeys.Back):
+					m.SetFocus(m.trashReturn)
+					return m, nil
 				}
 
 			case FocusChangelog:
@@ -616,13 +960,46 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case QuitConfirmView:
 			switch {
 			case key.Matches(msg, globalKeys.ConfirmQuit):
-				m.app.SyncWithDatabase()
+				if m.quitting {
+					return m, nil
+				}
+				m.quitting = true
+				if m.app.Syncing() {
+					// wait for the background sync, see syncDoneMsg
+					return m, nil
+				}
+				if cmd := m.startSync(true); cmd != nil {
+					return m, cmd
+				}
 				return m, tea.Quit
 			case key.Matches(msg, globalKeys.RejectQuit):
+				if m.quitting {
+					return m, nil
+				}
 				// put m viewmode back
 				m.viewMode = ApplicationView
 				return m, nil
 			}
+		case JournalPromptView:
+			switch {
+			case key.Matches(msg, globalKeys.ReplayJournal):
+				n := m.app.ReplayJournal()
+				m.viewMode = ApplicationView
+				m.syncCursors()
+				m.SetFocus(m.focus)
+				m.updateChangelogTable()
+				m.updateRecentTable()
+				m.statusBar.GetTag("Action").SetValue(fmt.Sprintf("Replayed %d unsynced edits", n))
+				m.updateStatusBar()
+				return m, nil
+			case key.Matches(msg, globalKeys.DiscardJournal):
+				m.app.DiscardJournal()
+				m.viewMode = ApplicationView
+				return m, nil
+			case key.Matches(msg, globalKeys.QuitApp):
+				// the journal stays for the next start
+				return m, tea.Quit
+			}
 		}
 
 	case table.MoveSelectMsg:
@@ -631,27 +1008,27 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case FocusThreads:
 			cursor := m.threadsTable.Cursor()
 			m.switchToThreadAtCursor(cursor)
-			m.updateBranchesTable()
-			// Reset branch cursor to 0 when thread changes
-			m.branchesTable.SetCursor(0)
-			m.updateNotesTable()
-			// Reset note cursor to 0 when branch changes
-			m.notesTable.SetCursor(0)
+			// Reset branch and note cursors to 0 when thread changes
+			m.refreshTableAt(FocusBranches, 0)
 			m.textArea.SetValue(m.app.GetCurrentThreadSummary())
 			m.textArea.UpdateWordCount()
 			m.updateStatusBar()
 		case FocusBranches:
 			cursor := m.branchesTable.Cursor()
 			m.switchToBranchAtCursor(cursor)
-			m.updateNotesTable()
 			// Reset note cursor to 0 when branch changes
-			m.notesTable.SetCursor(0)
+			m.refreshTableAt(FocusNotes, 0)
 			m.textArea.SetValue(m.app.GetCurrentBranchSummary())
 			m.textArea.UpdateWordCount()
 			m.updateStatusBar()
 		case FocusNotes:
 			cursor := m.notesTable.Cursor()
 			m.switchToNoteAtCursor(cursor)
+			// long branches are loaded a page at a time, load the next one at the last row
+			if cursor >= len(m.notesTable.Rows())-1 && m.app.GetDataMgr().LoadMoreNotes() > 0 {
+				m.updateNotesTable()
+				m.notesTable.SetCursor(max(0, m.app.ActiveRow(db.SearchKindNote)))
+			}
 			m.textArea.SetValue(m.app.GetCurrentNoteContent())
 			m.textArea.UpdateWordCount()
 			m.updateStatusBar()
@@ -668,7 +1045,8 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 			// fetch noteID
 			m.updateDiffArea(noteEdit.Link)
-
+		case FocusHistory:
+			m.updateRevisionArea()
 		}
 	}
 
@@ -692,9 +1070,30 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case FocusRecent:
 		m.recentTable, cmd = m.recentTable.Update(msg)
 		cmds = append(cmds, cmd)
+	case FocusHistory:
+		m.historyTable, cmd = m.historyTable.Update(msg)
+		cmds = append(cmds, cmd)
 	case FocusDiff:
 		m.diffView, cmd = m.diffView.Update(msg)
 		cmds = append(cmds, cmd)
+	case FocusSearch:
+		m.searchInput, cmd = m.searchInput.Update(msg)
+		cmds = append(cmds, cmd)
+	case FocusGlobalSearch:
+		m.globalTable, cmd = m.globalTable.Update(msg)
+		cmds = append(cmds, cmd)
+	case FocusTrash:
+		m.trashTable, cmd = m.trashTable.Update(msg)
+		cmds = append(cmds, cmd)
+	case FocusTasks:
+		m.tasksTable, cmd = m.tasksTable.Update(msg)
+		cmds = append(cmds, cmd)
+	case FocusDue:
+		m.dueInput, cmd = m.dueInput.Update(msg)
+		cmds = append(cmds, cmd)
+	case FocusUnlock:
+		m.unlockInput, cmd = m.unlockInput.Update(msg)
+		cmds = append(cmds, cmd)
 	}
 
 	return m, tea.Batch(cmds...)
@@ -739,6 +1138,14 @@ func (m *Model) SetFocus(focus FocusState) {
 		m.changeTable.Focus()
 	case FocusRecent:
 		m.recentTable.Focus()
+	case FocusHistory:
+		m.historyTable.Focus()
+	case FocusGlobalSearch:
+		m.globalTable.Focus()
+	case FocusTrash:
+		m.trashTable.Focus()
+	case FocusTasks:
+		m.tasksTable.Focus()
 	}
 	m.updateStatusBar()
 }
@@ -749,6 +1156,10 @@ func (m *Model) EnterEdit(from FocusState) {
 	// fmt.Printf(m.editPrevInputMethodID)
 	// id, _ := sys.InputMethodID(m.editPrevIMEType)
 	// sys.SwitchInputMethod(id) // bring back to previous method
+	// locked text cannot be edited
+	if m.locked(from) {
+		return
+	}
 	m.previousFocus = from
 	switch from {
 	case FocusThreads:
@@ -805,6 +1216,34 @@ func (m *Model) ExitEdit(save bool, curr_spl models.Superlink) tea.Cmd {
 	}
 }
 
+// restoreSelectedRevision reverts the current note to the revision under the history cursor.
+func (m *Model) restoreSelectedRevision(curr_spl models.Superlink) tea.Cmd {
+	cursor := m.historyTable.Cursor()
+	if cursor < 0 || cursor >= len(m.revisions) {
+		return nil
+	}
+	revision := m.revisions[cursor]
+	if err := m.app.RevertCurrentNote(revision.ID, &curr_spl); err != nil {
+		m.statusBar.GetTag("Action").SetValue("Restore failed: " + err.Error())
+		return nil
+	}
+
+	m.updateNotesTable()
+	m.updateRecentTable()
+	m.updateHistoryTable()
+	m.historyTable.SetCursor(cursor)
+	m.updateRevisionArea()
+	m.statusBar.GetTag("Action").SetValue(fmt.Sprintf("Restored revision %d", revision.ID))
+	m.updateStatusBar()
+
+	return func() tea.Msg {
+		return SaveItemMsg{
+			Type:    "note",
+			Updated: true,
+		}
+	}
+}
+
 func (m *Model) blurAllTables() {
 	m.threadsTable.Blur()
 	m.branchesTable.Blur()
@@ -824,6 +1263,14 @@ func (m *Model) focusCurrentTable() {
 		m.changeTable.Focus()
 	case FocusRecent:
 		m.recentTable.Focus()
+	case FocusHistory:
+		m.historyTable.Focus()
+	case FocusGlobalSearch:
+		m.globalTable.Focus()
+	case FocusTrash:
+		m.trashTable.Focus()
+	case FocusTasks:
+		m.tasksTable.Focus()
 	}
 }
 
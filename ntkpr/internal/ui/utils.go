@@ -1,19 +1,36 @@
 package ui
 
 import (
+	"github.com/haochend413/ntkpr/internal/db"
 	"github.com/haochend413/ntkpr/state"
 )
 
 // Distribute state in json on startup
+// Contexts and cursors are restored by the app; here the tables are moved onto them, top to bottom,
+// so each table lists the children of the item restored above it.
+// Scroll offsets are not applied: tables scroll to their cursor anyway.
 func (m *Model) DistributeState(s *state.UIState) {
-	// TODO: Implement state restoration for new three-table layout
-	// This needs to be redesigned for the new hierarchy navigation
+	m.updateThreadsTable()
+	m.threadsTable.SetCursor(int(m.app.GetCursor(db.SearchKindThread)))
+	m.switchToThreadAtCursor(m.threadsTable.Cursor())
+
+	m.updateBranchesTable()
+	m.branchesTable.SetCursor(int(m.app.GetCursor(db.SearchKindBranch)))
+	m.switchToBranchAtCursor(m.branchesTable.Cursor())
+
+	m.updateNotesTable()
+	m.notesTable.SetCursor(int(m.app.GetCursor(db.SearchKindNote)))
+	m.switchToNoteAtCursor(m.notesTable.Cursor())
 }
 
 // Collect end state on termination
 func (m Model) CollectState() *state.State {
-	s := &state.State{}
-	// TODO: Implement state collection for new three-table layout
+	m.saveCursor(FocusThreads)
+	m.saveCursor(FocusBranches)
+	m.saveCursor(FocusNotes)
+
+	s := state.DefaultState()
+	s.App = m.app.CollectState()
 	return s
 }
 
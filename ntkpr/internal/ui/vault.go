@@ -0,0 +1,110 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+
+	tea "charm.land/bubbletea/v2"
+	"github.com/haochend413/ntkpr/internal/vault"
+)
+
+// vault.go asks for the passphrase of private text, see package vault. It is asked once per session, at startup when
+// there is private text, or when U is pressed or a locked item is edited. Until then locked text is shown as vault.Placeholder.
+// The first passphrase sets up the vault, so it is typed twice.
+
+// openUnlock shows the passphrase prompt over the table at focus.
+func (m *Model) openUnlock() tea.Cmd {
+	if !m.app.Locked() {
+		m.statusBar.GetTag("Action").SetValue("Unlocked already")
+		m.updateStatusBar()
+		return nil
+	}
+	if m.focus != FocusUnlock {
+		m.unlockReturn = m.focus
+	}
+	m.unlockFirst = ""
+	m.unlockInput.SetValue("")
+	m.blurAllTables()
+	m.focus = FocusUnlock
+	m.updateStatusBar()
+	return m.unlockInput.Focus()
+}
+
+// unlockTitle names what the passphrase prompt asks for.
+func (m *Model) unlockTitle() string {
+	switch {
+	case m.unlockFirst != "":
+		return "Repeat the new passphrase"
+	case !m.app.HasVault():
+		return "New passphrase for private items"
+	}
+	return "Passphrase of private items"
+}
+
+// submitUnlock unlocks the vault with the passphrase typed in the prompt. A new passphrase is asked twice.
+// The prompt stays open when the passphrase is wrong.
+func (m *Model) submitUnlock() {
+	passphrase := m.unlockInput.Value()
+	m.unlockInput.SetValue("")
+	switch {
+	case passphrase == "":
+		m.statusBar.GetTag("Action").SetValue("Empty passphrase")
+		m.updateStatusBar()
+		return
+	case m.unlockFirst == "" && !m.app.HasVault():
+		m.unlockFirst = passphrase
+		m.updateStatusBar()
+		return
+	case m.unlockFirst != "" && passphrase != m.unlockFirst:
+		m.unlockFirst = ""
+		m.statusBar.GetTag("Action").SetValue("Passphrases differ")
+		m.updateStatusBar()
+		return
+	}
+
+	sealed, err := m.app.Unlock(passphrase)
+	if errors.Is(err, vault.ErrWrongPassphrase) {
+		m.statusBar.GetTag("Action").SetValue("Wrong passphrase")
+		m.updateStatusBar()
+		return
+	}
+	m.closeUnlock()
+	if m.app.Locked() {
+		m.statusBar.GetTag("Action").SetValue("Cannot unlock: " + err.Error())
+		m.updateStatusBar()
+		return
+	}
+	m.updateThreadsTable()
+	m.updateBranchesTable()
+	m.updateNotesTable()
+	m.updateChangelogTable()
+	m.updateRecentTable()
+	switch {
+	case err != nil:
+		m.statusBar.GetTag("Action").SetValue("Unlocked, but sealing private items failed: " + err.Error())
+	case sealed > 0:
+		m.statusBar.GetTag("Action").SetValue(fmt.Sprintf("Unlocked, sealed %d private texts", sealed))
+	default:
+		m.statusBar.GetTag("Action").SetValue("Unlocked")
+	}
+	m.updateStatusBar()
+}
+
+// closeUnlock hides the passphrase prompt and returns to the table it was opened from.
+func (m *Model) closeUnlock() {
+	m.unlockFirst = ""
+	m.unlockInput.SetValue("")
+	m.unlockInput.Blur()
+	m.SetFocus(m.unlockReturn)
+}
+
+// locked reports whether the item under the cursor of the table at focus stays sealed, and asks for the passphrase if so.
+func (m *Model) locked(focus FocusState) bool {
+	if !m.app.CurrentLocked(searchKind(focus)) {
+		return false
+	}
+	m.openUnlock()
+	m.statusBar.GetTag("Action").SetValue("Locked: enter the passphrase to change private items")
+	m.updateStatusBar()
+	return true
+}
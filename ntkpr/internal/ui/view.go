@@ -1,22 +1,36 @@
 package ui
 
 import (
+	"fmt"
+
 	tea "charm.land/bubbletea/v2"
 	"github.com/haochend413/lipgloss/v2"
 
 	// "charm.land/lipgloss/v2"
 
 	// "github.com/charmbracelet/lipgloss"
+	"github.com/haochend413/ntkpr/internal/app/context"
 	"github.com/haochend413/ntkpr/internal/ui/styles"
 )
 
 // define modular view functions
 func (m Model) quitConfirmView() tea.View {
+	if m.quitting {
+		v := tea.NewView("Syncing before quitting ...")
+		v.AltScreen = true
+		return v
+	}
 	v := tea.NewView("You sure you wanna quit ntkpr? This will reset recent table since this feature is not yet supported.\nTo quit, type y.\nTo go back, type n or esc.")
 	v.AltScreen = true
 	return v
 }
 
+func (m Model) journalPromptView() tea.View {
+	v := tea.NewView(fmt.Sprintf("Found %d unsynced edits from a session of ntkpr that did not end cleanly.\nTo replay them, type r. They are synced with the next sync.\nTo throw them away, type d.", m.app.PendingJournal()))
+	v.AltScreen = true
+	return v
+}
+
 func (m Model) appView() tea.View {
 	if !m.ready {
 		v := tea.NewView("Initializing...")
@@ -103,9 +117,9 @@ func (m Model) appView() tea.View {
 	} else {
 		// Global/table help derived from tableKeys and globalKeys
 		help = styles.HelpStyle.Render(
-			"Tab: tables • Enter: select • Esc: back/cancel • e: edit • n: new • r: recent edits • " +
-				"k/j: move to upper/lower item • l/h: move to upper/lower table • c-d: delete • c-h: highlight • c-p: private • c-l: changelog • " +
-				"c-s: save • c-q: sync • c-c: quit",
+			"Tab: tables • Enter: select • Esc: back/cancel • e: edit • n: new • R: recent edits • v: history • /: search • A: all items • c-f: global search • " +
+				"k/j: move to upper/lower item • l/h: move to upper/lower table • c-d: delete • c-z/c-y: undo/redo • c-h: highlight • c-p: private • c-l: changelog • T: trash • m/b then p: move/add note to branch • f: fork branch at note • M then M: merge branches • K/J: move note up/down • s: task status • d: due day • t: tasks • x/X: tick/untick checkbox • U: unlock private items • " +
+				"v then c-r: restore revision • [/]: older/newer revision • c-s: save • c-q: sync • c-c: quit",
 		)
 	}
 
@@ -126,12 +140,15 @@ func (m Model) appView() tea.View {
 	var compositor *lipgloss.Compositor
 	var output string
 
-	// Keep recent+diff overlay visible while in either recent or diff focus.
-	if m.focus == FocusRecent || m.focus == FocusDiff {
-		// Recent overlay content: recent table + diff area side by side
-		recentTableBox := m.renderRecentTableBox()
+	// Keep recent+diff (or history+diff) overlay visible while in either table or diff focus.
+	if m.focus == FocusRecent || m.focus == FocusHistory || m.focus == FocusDiff {
+		// Overlay content: recent / history table + diff area side by side
+		leftTableBox := m.renderRecentTableBox()
+		if m.focus == FocusHistory || (m.focus == FocusDiff && m.diffSource == FocusHistory) {
+			leftTableBox = m.renderHistoryTableBox()
+		}
 		diffTextAreaBox := m.renderdiffArea()
-		overlayContent := lipgloss.JoinHorizontal(lipgloss.Top, recentTableBox, diffTextAreaBox)
+		overlayContent := lipgloss.JoinHorizontal(lipgloss.Top, leftTableBox, diffTextAreaBox)
 
 		recentLayer := lipgloss.NewLayer(overlayContent).
 			X((m.width - lipgloss.Width(overlayContent)) / 2).
@@ -140,12 +157,76 @@ func (m Model) appView() tea.View {
 		// Create compositor with both layers
 		compositor = lipgloss.NewCompositor(baseLayer, recentLayer)
 		output = compositor.Render()
+	} else if m.focus == FocusGlobalSearch {
+		globalBox := m.renderGlobalTableBox()
+		globalLayer := lipgloss.NewLayer(globalBox).
+			X((m.width - lipgloss.Width(globalBox)) / 2).
+			Y((m.height - lipgloss.Height(globalBox)) / 2).
+			Z(1)
+		compositor = lipgloss.NewCompositor(baseLayer, globalLayer)
+		output = compositor.Render()
+	} else if m.focus == FocusTrash {
+		trashBox := m.renderTrashTableBox()
+		trashLayer := lipgloss.NewLayer(trashBox).
+			X((m.width - lipgloss.Width(trashBox)) / 2).
+			Y((m.height - lipgloss.Height(trashBox)) / 2).
+			Z(1)
+		compositor = lipgloss.NewCompositor(baseLayer, trashLayer)
+		output = compositor.Render()
+	} else if m.focus == FocusTasks {
+		tasksBox := m.renderTasksTableBox()
+		tasksLayer := lipgloss.NewLayer(tasksBox).
+			X((m.width - lipgloss.Width(tasksBox)) / 2).
+			Y((m.height - lipgloss.Height(tasksBox)) / 2).
+			Z(1)
+		compositor = lipgloss.NewCompositor(baseLayer, tasksLayer)
+		output = compositor.Render()
+	} else if m.focus == FocusDue {
+		dueBox := styles.FocusedStyle.
+			BorderTitle("Due day of note #" + idLabel(m.app.GetCurrentNoteID())).
+			Render(m.dueInput.View())
+		dueLayer := lipgloss.NewLayer(dueBox).
+			X((m.width - lipgloss.Width(dueBox)) / 2).
+			Y(m.height / 3).
+			Z(1)
+		compositor = lipgloss.NewCompositor(baseLayer, dueLayer)
+		output = compositor.Render()
+	} else if m.focus == FocusUnlock {
+		unlockBox := styles.FocusedStyle.
+			BorderTitle(m.unlockTitle()).
+			Render(m.unlockInput.View())
+		unlockLayer := lipgloss.NewLayer(unlockBox).
+			X((m.width - lipgloss.Width(unlockBox)) / 2).
+			Y(m.height / 3).
+			Z(1)
+		compositor = lipgloss.NewCompositor(baseLayer, unlockLayer)
+		output = compositor.Render()
+	} else if m.focus == FocusSearch {
+		searchBox := styles.FocusedStyle.
+			BorderTitle(m.searchTitle()).
+			Render(m.searchInput.View())
+		searchLayer := lipgloss.NewLayer(searchBox).
+			X((m.width - lipgloss.Width(searchBox)) / 2).
+			Y(m.height / 3).
+			Z(1)
+		compositor = lipgloss.NewCompositor(baseLayer, searchLayer)
+		output = compositor.Render()
 	} else {
 		// No recent focus, just show base layer
 		compositor = lipgloss.NewCompositor(baseLayer)
 		output = compositor.Render()
 	}
 
+	// A failed sync stays on top of everything until it is dismissed
+	if m.showSyncErr && m.syncErr != nil {
+		errBox := m.renderSyncErrorBox()
+		errLayer := lipgloss.NewLayer(errBox).
+			X((m.width - lipgloss.Width(errBox)) / 2).
+			Y((m.height - lipgloss.Height(errBox)) / 2).
+			Z(2)
+		output = lipgloss.NewCompositor(lipgloss.NewLayer(output).Z(0), errLayer).Render()
+	}
+
 	// Create view with composited output
 	v := tea.NewView(output)
 	v.AltScreen = true
@@ -160,6 +241,8 @@ func (m Model) View() tea.View {
 		v = m.appView()
 	case QuitConfirmView:
 		v = m.quitConfirmView()
+	case JournalPromptView:
+		v = m.journalPromptView()
 	default:
 		v = m.appView()
 	}
@@ -169,30 +252,30 @@ func (m Model) View() tea.View {
 func (m Model) renderThreadsTableBox() string {
 	if m.focus == FocusThreads {
 		m.threadsTable.SetStyles(styles.FocusedTableStyle)
-		return styles.FocusedStyle.BorderTitle("[1]-Threads").Render(m.threadsTable.View())
+		return styles.FocusedStyle.BorderTitle(m.tableTitle(FocusThreads, "[1]-Threads")).Render(m.threadsTable.View())
 	} else {
 		m.threadsTable.SetStyles(styles.BaseTableStyle)
-		return styles.BaseStyle.BorderTitle("Threads").Render(m.threadsTable.View())
+		return styles.BaseStyle.BorderTitle(m.tableTitle(FocusThreads, "Threads")).Render(m.threadsTable.View())
 	}
 }
 
 func (m Model) renderBranchesTableBox() string {
 	if m.focus == FocusBranches {
 		m.branchesTable.SetStyles(styles.FocusedTableStyle)
-		return styles.FocusedStyle.BorderTitle("[2]-Branches").Render(m.branchesTable.View())
+		return styles.FocusedStyle.BorderTitle(m.tableTitle(FocusBranches, "[2]-Branches")).Render(m.branchesTable.View())
 	} else {
 		m.branchesTable.SetStyles(styles.BaseTableStyle)
-		return styles.BaseStyle.BorderTitle("Branches").Render(m.branchesTable.View())
+		return styles.BaseStyle.BorderTitle(m.tableTitle(FocusBranches, "Branches")).Render(m.branchesTable.View())
 	}
 }
 
 func (m Model) renderNotesTableBox() string {
 	if m.focus == FocusNotes {
 		m.notesTable.SetStyles(styles.FocusedTableStyle)
-		return styles.FocusedStyle.BorderTitle("[3]-Notes").Render(m.notesTable.View())
+		return styles.FocusedStyle.BorderTitle(m.tableTitle(FocusNotes, "[3]-Notes")).Render(m.notesTable.View())
 	} else {
 		m.notesTable.SetStyles(styles.BaseTableStyle)
-		return styles.BaseStyle.BorderTitle("Notes").Render(m.notesTable.View())
+		return styles.BaseStyle.BorderTitle(m.tableTitle(FocusNotes, "Notes")).Render(m.notesTable.View())
 	}
 }
 
@@ -220,9 +303,57 @@ func (m Model) renderRecentTableBox() string {
 		Render(m.recentTable.View())
 }
 
+func (m Model) renderHistoryTableBox() string {
+	if m.focus == FocusHistory {
+		m.historyTable.SetStyles(styles.FocusedTableStyle)
+		return styles.FocusedStyle.
+			BorderTitle("History").
+			Render(m.historyTable.View())
+	}
+
+	m.historyTable.SetStyles(styles.BaseTableStyle)
+	return styles.BaseStyle.
+		BorderTitle("History").
+		Render(m.historyTable.View())
+}
+
 func (m Model) renderdiffArea() string {
 	if m.focus == FocusDiff {
 		return styles.FocusedStyle.BorderTitle("Diff").Render(m.diffView.View())
 	}
 	return styles.BaseStyle.BorderTitle("Diff").Render(m.diffView.View())
 }
+
+// tableTitle adds the sort order, when it is not the default one, and the active search query or saved search name to the title of a table box.
+func (m Model) tableTitle(focus FocusState, title string) string {
+	if o := m.app.GetSortOrder(searchKind(focus)); o != (context.SortOrder{}) {
+		title += " [" + o.String() + "]"
+	}
+	if !m.isSearching(focus) {
+		return title
+	}
+	if q, ok := m.searchQueries[focus]; ok && m.app.GetContext(searchKind(focus)) == context.Search {
+		return title + " (Search: " + q + ")"
+	}
+	return title + " (" + m.app.ContextName(searchKind(focus)) + ")"
+}
+
+// searchTitle names the list the search bar is searching.
+func (m Model) searchTitle() string {
+	switch m.searchTarget {
+	case FocusThreads:
+		return "Search Threads"
+	case FocusBranches:
+		return "Search Branches"
+	case FocusNotes:
+		return "Search Notes"
+	}
+	return "Global Search"
+}
+
+func (m Model) renderGlobalTableBox() string {
+	m.globalTable.SetStyles(styles.FocusedTableStyle)
+	return styles.FocusedStyle.
+		BorderTitle("Global Search: " + m.searchQueries[FocusGlobalSearch]).
+		Render(m.globalTable.View())
+}
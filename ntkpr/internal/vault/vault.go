@@ -0,0 +1,172 @@
+// Package vault encrypts the text of private threads, branches and notes at rest.
+// The key is derived from a passphrase with Argon2id, and text is sealed with XChaCha20-Poly1305.
+// A sealed text is a string of its own, see IsSealed, so it is stored in the same columns as plain text.
+//
+// The key of the session is set once by Unlock. Until then sealed text stays sealed: it is read and written as it is,
+// and shown as Placeholder.
+package vault
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Placeholder is shown instead of sealed text while the vault is locked.
+const Placeholder = "[locked]"
+
+// sealedPrefix starts every sealed text. The version is the format of what follows: base64 of the nonce and the ciphertext.
+const sealedPrefix = "$ntkpr$sealed$v1$"
+
+// checkText is sealed with a new key, so that a wrong passphrase can be told from a right one.
+const checkText = "ntkpr vault"
+
+// ErrWrongPassphrase is returned when a passphrase does not open the vault.
+var ErrWrongPassphrase = errors.New("wrong passphrase")
+
+// Params are how a key is derived from a passphrase. They are stored with the vault, so they can change for new vaults.
+type Params struct {
+	Salt    []byte
+	Time    uint32
+	Memory  uint32 // in KiB
+	Threads uint8
+}
+
+// NewParams returns the parameters of a new vault, with a random salt.
+func NewParams() (Params, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return Params{}, err
+	}
+	return Params{Salt: salt, Time: 3, Memory: 64 * 1024, Threads: 4}, nil
+}
+
+// Key seals and opens text.
+type Key struct {
+	aead cipher.AEAD
+}
+
+// DeriveKey derives the key of a passphrase.
+func DeriveKey(passphrase string, p Params) (*Key, error) {
+	raw := argon2.IDKey([]byte(passphrase), p.Salt, p.Time, p.Memory, p.Threads, chacha20poly1305.KeySize)
+	aead, err := chacha20poly1305.NewX(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &Key{aead: aead}, nil
+}
+
+// Seal encrypts text with a random nonce.
+func (k *Key) Seal(text string) string {
+	nonce := make([]byte, k.aead.NonceSize(), k.aead.NonceSize()+len(text)+k.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		// crypto/rand does not fail on supported platforms
+		panic("vault: no randomness: " + err.Error())
+	}
+	sealed := k.aead.Seal(nonce, nonce, []byte(text), nil)
+	return sealedPrefix + base64.RawStdEncoding.EncodeToString(sealed)
+}
+
+// Open decrypts sealed text.
+func (k *Key) Open(sealed string) (string, error) {
+	if !IsSealed(sealed) {
+		return "", errors.New("not sealed")
+	}
+	raw, err := base64.RawStdEncoding.DecodeString(sealed[len(sealedPrefix):])
+	if err != nil {
+		return "", err
+	}
+	if len(raw) < k.aead.NonceSize() {
+		return "", errors.New("sealed text too short")
+	}
+	nonce, ciphertext := raw[:k.aead.NonceSize()], raw[k.aead.NonceSize():]
+	text, err := k.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(text), nil
+}
+
+// Check returns the check value of a new vault, to be stored with its Params.
+func (k *Key) Check() string {
+	return k.Seal(checkText)
+}
+
+// Verify returns ErrWrongPassphrase unless the key opens the check value of its vault.
+func (k *Key) Verify(check string) error {
+	if text, err := k.Open(check); err != nil || text != checkText {
+		return ErrWrongPassphrase
+	}
+	return nil
+}
+
+// IsSealed reports whether text was sealed.
+func IsSealed(text string) bool {
+	return strings.HasPrefix(text, sealedPrefix)
+}
+
+// The key of the session, nil while locked.
+var (
+	mu  sync.RWMutex
+	key *Key
+)
+
+// Unlock makes k the key of the session.
+func Unlock(k *Key) {
+	mu.Lock()
+	defer mu.Unlock()
+	key = k
+}
+
+// Lock forgets the key of the session.
+func Lock() {
+	mu.Lock()
+	defer mu.Unlock()
+	key = nil
+}
+
+// Unlocked reports whether the session has a key.
+func Unlocked() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return key != nil
+}
+
+// Seal seals text with the key of the session. Text that is sealed already, or cannot be sealed while locked, is returned as it is.
+func Seal(text string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	if key == nil || text == "" || IsSealed(text) {
+		return text
+	}
+	return key.Seal(text)
+}
+
+// Open opens sealed text with the key of the session. Text that is not sealed, or cannot be opened, is returned as it is.
+func Open(text string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	if key == nil || !IsSealed(text) {
+		return text
+	}
+	opened, err := key.Open(text)
+	if err != nil {
+		return text
+	}
+	return opened
+}
+
+// Show returns text to be shown: opened when it can be, Placeholder when it stays sealed.
+func Show(text string) string {
+	text = Open(text)
+	if IsSealed(text) {
+		return Placeholder
+	}
+	return text
+}
@@ -0,0 +1,171 @@
+package vault
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// testParams derive keys fast; real vaults use NewParams.
+func testParams(salt string) Params {
+	return Params{Salt: []byte(salt), Time: 1, Memory: 64, Threads: 1}
+}
+
+func testKey(t *testing.T, passphrase, salt string) *Key {
+	t.Helper()
+	k, err := DeriveKey(passphrase, testParams(salt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestSealOpen(t *testing.T) {
+	k := testKey(t, "correct horse", "salt-0123456789a")
+	tests := []string{
+		"",
+		"a note",
+		"- [ ] multi\n- [x] line\r\n",
+		"ünïcødé 日本語 🙂",
+		strings.Repeat("long ", 10000),
+		sealedPrefix + "looks sealed but is not",
+	}
+	for _, text := range tests {
+		sealed := k.Seal(text)
+		if !IsSealed(sealed) {
+			t.Errorf("Seal(%.20q) is not sealed", text)
+		}
+		if text != "" && strings.Contains(sealed, text) {
+			t.Errorf("Seal(%.20q) contains the text", text)
+		}
+		if again := k.Seal(text); again == sealed {
+			t.Errorf("Seal(%.20q) twice gave the same result, want a new nonce", text)
+		}
+		opened, err := k.Open(sealed)
+		if err != nil || opened != text {
+			t.Errorf("Open(Seal(%.20q)) = %.20q, %v", text, opened, err)
+		}
+	}
+}
+
+func TestWrongPassphrase(t *testing.T) {
+	right := testKey(t, "correct horse", "salt-0123456789a")
+	check := right.Check()
+	sealed := right.Seal("secret")
+
+	tests := []struct {
+		name string
+		key  *Key
+		ok   bool
+	}{
+		{"same passphrase", testKey(t, "correct horse", "salt-0123456789a"), true},
+		{"other passphrase", testKey(t, "battery staple", "salt-0123456789a"), false},
+		{"other case", testKey(t, "Correct horse", "salt-0123456789a"), false},
+		{"empty passphrase", testKey(t, "", "salt-0123456789a"), false},
+		{"other salt", testKey(t, "correct horse", "salt-0123456789b"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.key.Verify(check)
+			if tt.ok && err != nil {
+				t.Errorf("Verify: %v", err)
+			}
+			if !tt.ok && !errors.Is(err, ErrWrongPassphrase) {
+				t.Errorf("Verify = %v, want ErrWrongPassphrase", err)
+			}
+			opened, err := tt.key.Open(sealed)
+			if tt.ok != (err == nil) || (tt.ok && opened != "secret") {
+				t.Errorf("Open = %q, %v", opened, err)
+			}
+		})
+	}
+}
+
+func TestOpenBroken(t *testing.T) {
+	k := testKey(t, "correct horse", "salt-0123456789a")
+	sealed := k.Seal("secret")
+	body := sealed[len(sealedPrefix):]
+	flipped := []byte(body)
+	flipped[len(flipped)/2] ^= 'A' ^ 'B'
+
+	tests := map[string]string{
+		"not sealed": "secret",
+		"tampered":   sealedPrefix + string(flipped),
+		"truncated":  sealed[:len(sealed)-4],
+		"too short":  sealedPrefix + "AAAA",
+		"not base64": sealedPrefix + "!!!!",
+	}
+	for name, text := range tests {
+		if opened, err := k.Open(text); err == nil {
+			t.Errorf("%s: opened %q", name, opened)
+		}
+	}
+}
+
+func TestNewParams(t *testing.T) {
+	a, err := NewParams()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := NewParams()
+	if len(a.Salt) != 16 || bytes.Equal(a.Salt, b.Salt) {
+		t.Errorf("salts %x and %x, want 16 random bytes each", a.Salt, b.Salt)
+	}
+}
+
+func TestSession(t *testing.T) {
+	k := testKey(t, "correct horse", "salt-0123456789a")
+	sealed := k.Seal("secret")
+	defer Lock()
+
+	Lock()
+	if Unlocked() {
+		t.Fatal("unlocked after Lock")
+	}
+	if got := Seal("plain"); got != "plain" {
+		t.Errorf("locked Seal = %q, want the text as it is", got)
+	}
+	if got := Open(sealed); got != sealed {
+		t.Errorf("locked Open = %q, want the sealed text", got)
+	}
+	if got := Show(sealed); got != Placeholder {
+		t.Errorf("locked Show = %q, want %q", got, Placeholder)
+	}
+	if got := Show("plain"); got != "plain" {
+		t.Errorf("locked Show(plain) = %q", got)
+	}
+
+	Unlock(k)
+	if !Unlocked() {
+		t.Fatal("locked after Unlock")
+	}
+	tests := []struct {
+		name, in, open, show string
+	}{
+		{"sealed", sealed, "secret", "secret"},
+		{"plain", "plain", "plain", "plain"},
+		{"other key", testKey(t, "battery staple", "salt-0123456789a").Seal("x"), "", Placeholder},
+	}
+	for _, tt := range tests {
+		open := Open(tt.in)
+		if tt.open == "" {
+			tt.open = tt.in // text that cannot be opened stays as it is
+		}
+		if open != tt.open {
+			t.Errorf("%s: Open = %q, want %q", tt.name, open, tt.open)
+		}
+		if show := Show(tt.in); show != tt.show {
+			t.Errorf("%s: Show = %q, want %q", tt.name, show, tt.show)
+		}
+	}
+	if got := Seal(""); got != "" {
+		t.Errorf("Seal(\"\") = %q, want it empty", got)
+	}
+	if got := Seal(sealed); got != sealed {
+		t.Error("Seal sealed a sealed text again")
+	}
+	if got := Open(Seal("plain")); got != "plain" {
+		t.Errorf("Open(Seal) = %q", got)
+	}
+}
@@ -2,5 +2,5 @@ cd ..
 if [ -f ./bin/ntkpr ]; then
     rm ./bin/ntkpr
 fi
-go build -o ./bin/ntkpr
+go build -tags sqlite_fts5 -o ./bin/ntkpr
 ./bin/ntkpr
\ No newline at end of file
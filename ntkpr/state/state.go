@@ -24,6 +24,16 @@ type AppState struct {
 	ThreadCursors     map[context.ContextPtr]uint `json:"thread_cursors"` // cursor positions per context
 	BranchCursors     map[context.ContextPtr]uint `json:"branch_cursors"`
 	NoteCursors       map[context.ContextPtr]uint `json:"note_cursors"`
+	ThreadOrder       context.SortOrder           `json:"thread_order"` // sort order per table
+	BranchOrder       context.SortOrder           `json:"branch_order"`
+	NoteOrder         context.SortOrder           `json:"note_order"`
+	// Saved searches are kept by name, their contexts are numbered in config order which may change
+	LastThreadSearch   string          `json:"lastThreadSearch,omitempty"` // saved search the table was left in
+	LastBranchSearch   string          `json:"lastBranchSearch,omitempty"`
+	LastNoteSearch     string          `json:"lastNoteSearch,omitempty"`
+	ThreadSavedCursors map[string]uint `json:"thread_saved_cursors,omitempty"` // cursor positions per saved search
+	BranchSavedCursors map[string]uint `json:"branch_saved_cursors,omitempty"`
+	NoteSavedCursors   map[string]uint `json:"note_saved_cursors,omitempty"`
 }
 
 type State struct {